@@ -1,11 +1,15 @@
 package api
 
 import (
-	"encoding/json"
+	"context"
+	"encoding/xml"
 	"errors"
 	"log/slog"
 	"main/database"
+	"main/logging"
+	"main/tracing"
 	"net/http"
+	"strconv"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -14,29 +18,135 @@ import (
 )
 
 type Response[T any] struct {
-	Message string `json:"message,omitempty"`
-	Data    T      `json:"data,omitempty"`
+	XMLName xml.Name `json:"-" xml:"response" swaggerignore:"true"`
+	Message string   `json:"message,omitempty" xml:"message,omitempty"`
+	Data    T        `json:"data,omitempty" xml:"data,omitempty"`
+	// Next is the cursor of the following page on paginated listings.
+	Next string `json:"next,omitempty" xml:"next,omitempty"`
 }
 
 var ErrInvalidUserParams = errors.New("please provide a valid FirstName, LastName and Bio for the user")
 var ErrUserNotFound = errors.New("the user with the specified ID does not exist")
 var ErrInvalidUpdateUserParams = errors.New("please provide name and bio for the user")
+var ErrInvalidUserID = errors.New("please provide a valid user ID")
+var ErrInvalidPagination = errors.New("please provide a positive limit and a cursor returned by a previous page")
+var ErrInvalidPatchParams = errors.New("please provide a valid FirstName, LastName or Bio to change")
+var ErrInsufficientStorage = errors.New("there is no room left to store the user")
+var ErrWritesUnavailable = errors.New("the server cannot change users at the moment, please retry")
+var ErrWriteTimeout = errors.New("the change could not be confirmed in time, check the user before retrying")
+var ErrReadsUnavailable = errors.New("the server cannot read users consistently at the moment, please retry")
 
 // WithRequiredStructEnabled: opt-in to new behavior that will become the default behavior in v11+
 var validate = validator.New(validator.WithRequiredStructEnabled())
 
-func NewHandler(db *database.InMemoryDB) http.Handler {
+func NewHandler(db *database.InMemoryDB, opts ...Option) http.Handler {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	writer := o.writer
+	if writer == nil {
+		writer = db
+	}
+
 	router := chi.NewRouter()
 
+	if o.metrics != nil {
+		router.Use(o.metrics.Middleware)
+	}
 	router.Use(middleware.Recoverer)
 	router.Use(middleware.RequestID)
-	router.Use(middleware.Logger)
+	if o.logRequests {
+		logger := o.accessLogger
+		if logger == nil {
+			logger = slog.Default()
+		}
+		router.Use(logging.AccessLog(logger, o.accessLog))
+	}
+	router.Use(tracing.Middleware)
+	if o.compressMinSize >= 0 {
+		router.Use(compress(o.compressMinSize))
+	}
+	if o.requestTimeout > 0 {
+		router.Use(middleware.Timeout(o.requestTimeout))
+	}
+
+	if o.health != nil {
+		router.Get("/healthz", handleLiveness(o.health))
+		router.Get("/readyz", handleReadiness(o.health))
+	}
+
+	if o.metrics != nil {
+		router.Method(http.MethodGet, "/metrics", o.metrics.Handler())
+	}
+
+	if o.replication != nil {
+		router.Handle("/replication/*", o.replication)
+	}
+
+	if o.cluster != nil {
+		router.Handle("/cluster/*", o.cluster)
+	}
+
+	router.Group(func(router chi.Router) {
+		if o.rateLimiter != nil {
+			router.Use(rateLimit(o.rateLimiter, o.rateLimitKeys, o.rateLimitTenants))
+		}
+
+		// GraphQL and JSON-RPC also read with POST, on followers their
+		// handlers tell writes apart themselves
+		if o.graphql != nil {
+			router.Handle("/graphql", o.graphql)
+		}
+		if o.rpc != nil {
+			router.Handle("/rpc", o.rpc)
+		}
+
+		router.Group(func(router chi.Router) {
+			if o.leader != nil {
+				router.Use(followerWrites(o.leader, o.forwardWrites))
+			}
+			if o.reader != nil {
+				router.Use(syncReads(o.reader))
+			}
 
-	router.Post("/api/users", handleCreateUser(db))
-	router.Get("/api/users", handleGetUsers(db))
-	router.Get("/api/users/{id}", handleGetUser(db))
-	router.Delete("/api/users/{id}", handleDeleteUser(db))
-	router.Put("/api/users/{id}", handleUpdateUser(db))
+			// these routes negotiate their own formats
+			router.Get("/api/users/changes", handleWatchUsers(db, o.shutdown))
+			router.Get("/api/users/export", handleExportUsers(db))
+
+			router.Group(func(router chi.Router) {
+				router.Use(acceptable)
+
+				// routes responding with users accept a sparse fieldset
+				users := router.With(sparseFields)
+				if o.idempotency != nil {
+					users.With(idempotent(o.idempotency)).Post("/api/users", handleCreateUser(writer))
+				} else {
+					users.Post("/api/users", handleCreateUser(writer))
+				}
+				users.Get("/api/users", handleGetUsers(db))
+				users.Get("/api/users/{id}", handleGetUser(db))
+				users.Put("/api/users/{id}", handleUpdateUser(writer, o.allowUpsert))
+				users.Patch("/api/users/{id}", handlePatchUser(writer))
+				users.Get("/api/users/{id}/followers", handleGetFollowers(db))
+				users.Get("/api/users/{id}/following", handleGetFollowing(db))
+				users.Get("/api/users/{id}/mutuals", handleGetMutualFollows(db))
+				users.Get("/api/users/{id}/path/{target}", handleGetFollowPath(db))
+
+				router.Post("/api/users/import", handleImportUsers(db, writer))
+				router.Delete("/api/users/{id}", handleDeleteUser(writer))
+				router.Put("/api/users/{id}/following/{target}", handleFollowUser(writer))
+				router.Delete("/api/users/{id}/following/{target}", handleUnfollowUser(writer))
+
+				router.Get("/api/stats", handleGetStats(db))
+
+				for _, route := range o.collections {
+					route(router)
+				}
+			})
+		})
+	})
 
 	router.Get("/swagger/*", httpSwagger.Handler(
 		httpSwagger.URL("/swagger/doc.json"),
@@ -50,52 +160,134 @@ func NewHandler(db *database.InMemoryDB) http.Handler {
 //	@Summary		Get a user by ID
 //	@Description	Get a user by ID
 //	@Tags			Users
-//	@Accept			json
-//	@Produce		json
-//	@Param			id	path		string	true	"User ID"
-//	@Success		200	{object}	Response[database.DBUser]{data=database.DBUser}
-//	@Failure		404	{object}	Response[any]{message=string}
+//	@Accept			json,xml,application/msgpack,application/cbor
+//	@Produce		json,xml,application/msgpack,application/cbor
+//	@Param			id					path		string	true	"User ID"
+//	@Param			If-None-Match		header		string	false	"Answer 304 when the user still has one of these ETags"
+//	@Param			If-Modified-Since	header		string	false	"Answer 304 when the user did not change since this date, ignored with If-None-Match"
+//	@Param			fields				query		string	false	"Comma-separated fields to return, among id, user, user.first_name, user.last_name and user.biography"
+//	@Success		200					{object}	Response[database.DBUser]{data=database.DBUser}
+//	@Success		304					"The user did not change"
+//	@Failure		400					{object}	Response[any]{message=string}
+//	@Failure		404					{object}	Response[any]{message=string}
+//	@Failure		406					{object}	Response[any]{message=string}
+//	@Failure		429					{object}	Response[any]{message=string}
+//	@Failure		503					{object}	Response[any]{message=string}
 //	@Router			/users/{id} [get]
 func handleGetUser(db *database.InMemoryDB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := chi.URLParam(r, "id")
 
-		user, exists := db.FindByID(id)
+		// read before the user, so the version is never newer than the
+		// content
+		version, _ := db.UserVersion(id)
+		modified, _ := db.ModTime(id)
+		if checkModified(w, r, version, modified) {
+			return
+		}
+
+		user, exists := db.FindByID(r.Context(), id)
 		if !exists {
-			sendJSON(
+			send(
 				w,
+				r,
 				Response[any]{Message: ErrUserNotFound.Error()},
 				http.StatusNotFound,
 			)
 			return
 		}
 
-		sendJSON(
-			w,
-			Response[database.DBUser]{Data: user},
-			http.StatusOK,
-		)
+		sendUser(w, r, user, http.StatusOK)
 	}
 }
 
 // GetUsers godoc
 //
 //	@Summary		Get all users
-//	@Description	Get all users
+//	@Description	Get all users in insertion order, optionally one page at a time
 //	@Tags			Users
-//	@Accept			json
-//	@Produce		json
-//	@Success		200	{object}	Response[[]database.DBUser]{data=[]database.DBUser}
+//	@Accept			json,xml,application/msgpack,application/cbor
+//	@Produce		json,xml,application/msgpack,application/cbor
+//	@Param			limit				query		int		false	"Maximum number of users to return"
+//	@Param			cursor				query		string	false	"The next cursor of the previous page"
+//	@Param			If-None-Match		header		string	false	"Answer 304 when the users still have one of these ETags"
+//	@Param			If-Modified-Since	header		string	false	"Answer 304 when no user changed since this date, ignored with If-None-Match"
+//	@Param			fields				query		string	false	"Comma-separated fields to return, among id, user, user.first_name, user.last_name and user.biography"
+//	@Param			first_name			query		string	false	"Only users with this first name, ignoring case"
+//	@Param			last_name			query		string	false	"Only users with this last name, ignoring case"
+//	@Param			q					query		string	false	"Only users whose names or biography contain this text, ignoring case"
+//	@Success		200					{object}	Response[[]database.DBUser]{data=[]database.DBUser}
+//	@Success		304					"No user changed"
+//	@Failure		400					{object}	Response[any]{message=string}
+//	@Failure		406					{object}	Response[any]{message=string}
+//	@Failure		429					{object}	Response[any]{message=string}
+//	@Failure		503					{object}	Response[any]{message=string}
 //	@Router			/users [get]
 func handleGetUsers(db *database.InMemoryDB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		users := db.FindAll()
+		opts := database.ListOptions{
+			Cursor: r.URL.Query().Get("cursor"),
+			Filter: userFilter(r).Match,
+		}
 
-		sendJSON(
-			w,
-			Response[[]database.DBUser]{Data: users},
-			http.StatusOK,
-		)
+		if limit := r.URL.Query().Get("limit"); limit != "" {
+			n, err := strconv.Atoi(limit)
+			if err != nil || n < 1 {
+				send(
+					w,
+					r,
+					Response[any]{Message: ErrInvalidPagination.Error()},
+					http.StatusBadRequest,
+				)
+				return
+			}
+			opts.Limit = n
+		}
+
+		// read before the page, so the version is never newer than the
+		// content
+		if checkModified(w, r, db.Version(), db.LastModified()) {
+			return
+		}
+
+		// JSON is encoded as the users are scanned, the other formats need
+		// the whole page
+		if c, ok := codecs.negotiate(r.Header.Get("Accept")); ok && c.mediaType != jsonCodec.mediaType {
+			page, err := db.List(r.Context(), opts)
+			if err != nil {
+				send(
+					w,
+					r,
+					Response[any]{Message: ErrInvalidPagination.Error()},
+					http.StatusBadRequest,
+				)
+				return
+			}
+
+			sendPage(w, r, page)
+			return
+		}
+
+		err := streamUsers(r.Context(), w, fieldsFrom(r.Context()), func(fn func(database.DBUser) bool) (string, error) {
+			return db.ScanPage(r.Context(), opts, fn)
+		})
+		switch {
+		case errors.Is(err, database.ErrInvalidCursor):
+			send(
+				w,
+				r,
+				Response[any]{Message: ErrInvalidPagination.Error()},
+				http.StatusBadRequest,
+			)
+		case err != nil:
+			slog.ErrorContext(r.Context(), "could not list the users", "error", err)
+			send(
+				w,
+				r,
+				Response[any]{Message: "internal server error"},
+				http.StatusInternalServerError,
+			)
+		}
 	}
 }
 
@@ -104,27 +296,51 @@ func handleGetUsers(db *database.InMemoryDB) http.HandlerFunc {
 //	@Summary		Create a user
 //	@Description	Create a user
 //	@Tags			Users
-//	@Accept			json
-//	@Produce		json
-//	@Param			body	body		database.User	true	"User details"
-//	@Success		201		{object}	Response[database.DBUser]{data=database.DBUser}
-//	@Failure		400		{object}	Response[any]{message=string}
+//	@Accept			json,xml,application/msgpack,application/cbor
+//	@Produce		json,xml,application/msgpack,application/cbor
+//	@Param			body			body		database.User	true	"User details"
+//	@Param			Idempotency-Key	header		string			false	"Replays the response of an earlier request with the same key and body"
+//	@Param			fields			query		string			false	"Comma-separated fields to return, among id, user, user.first_name, user.last_name and user.biography"
+//	@Success		201				{object}	Response[database.DBUser]{data=database.DBUser}
+//	@Failure		400				{object}	Response[any]{message=string}
+//	@Failure		406				{object}	Response[any]{message=string}
+//	@Failure		409				{object}	Response[any]{message=string}
+//	@Failure		413				{object}	Response[any]{message=string}
+//	@Failure		415				{object}	Response[any]{message=string}
+//	@Failure		422				{object}	Response[any]{message=string}
+//	@Failure		429				{object}	Response[any]{message=string}
+//	@Failure		500				{object}	Response[any]{message=string}
+//	@Failure		503				{object}	Response[any]{message=string}
+//	@Failure		504				{object}	Response[any]{message=string}
+//	@Failure		507				{object}	Response[any]{message=string}
 //	@Router			/users [post]
-func handleCreateUser(db *database.InMemoryDB) http.HandlerFunc {
+func handleCreateUser(writer database.Writer) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var body database.User
-		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-			sendJSON(
+		err := decode(r, &body)
+		if errors.Is(err, ErrUnsupportedMediaType) {
+			send(
 				w,
+				r,
+				Response[any]{Message: ErrUnsupportedMediaType.Error()},
+				http.StatusUnsupportedMediaType,
+			)
+			return
+		}
+		if err != nil {
+			send(
+				w,
+				r,
 				Response[any]{Message: "could not decode the request"},
 				http.StatusBadRequest,
 			)
 			return
 		}
 
-		if err := validate.Struct(&body); err != nil {
-			sendJSON(
+		if err := validateStruct(r.Context(), &body); err != nil {
+			send(
 				w,
+				r,
 				Response[any]{Message: ErrInvalidUserParams.Error()},
 				http.StatusBadRequest,
 			)
@@ -137,13 +353,25 @@ func handleCreateUser(db *database.InMemoryDB) http.HandlerFunc {
 			Biography: body.Biography,
 		}
 
-		dbUser := db.Insert(user)
+		dbUser, err := writer.Insert(r.Context(), user)
+		if sendUnavailable(w, r, err) {
+			return
+		}
+		if errors.Is(err, database.ErrStorageFull) {
+			send(
+				w,
+				r,
+				Response[any]{Message: ErrInsufficientStorage.Error()},
+				http.StatusInsufficientStorage,
+			)
+			return
+		}
+		if err != nil {
+			sendWriteError(w, r, err)
+			return
+		}
 
-		sendJSON(
-			w,
-			Response[database.DBUser]{Data: dbUser},
-			http.StatusCreated,
-		)
+		sendUser(w, r, dbUser, http.StatusCreated)
 	}
 }
 
@@ -152,27 +380,62 @@ func handleCreateUser(db *database.InMemoryDB) http.HandlerFunc {
 //	@Summary		Delete a user by ID
 //	@Description	Delete a user by ID
 //	@Tags			Users
-//	@Accept			json
-//	@Produce		json
+//	@Accept			json,xml,application/msgpack,application/cbor
+//	@Produce		json,xml,application/msgpack,application/cbor
 //	@Param			id	path		string	true	"User ID"
 //	@Success		200	{object}	Response[database.DBUser]{data=database.DBUser}
+//	@Failure		400	{object}	Response[any]{message=string}
 //	@Failure		404	{object}	Response[any]{message=string}
+//	@Failure		406	{object}	Response[any]{message=string}
+//	@Failure		409	{object}	Response[any]{message=string}
+//	@Failure		429	{object}	Response[any]{message=string}
+//	@Failure		500	{object}	Response[any]{message=string}
+//	@Failure		503	{object}	Response[any]{message=string}
+//	@Failure		504	{object}	Response[any]{message=string}
 //	@Router			/users/{id} [delete]
-func handleDeleteUser(db *database.InMemoryDB) http.HandlerFunc {
+func handleDeleteUser(writer database.Writer) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := chi.URLParam(r, "id")
 
-		user, err := db.Delete(id)
-		if err != nil {
-			sendJSON(
+		user, err := writer.Delete(r.Context(), id)
+		if sendUnavailable(w, r, err) {
+			return
+		}
+		if errors.Is(err, database.ErrUserHasFollows) {
+			send(
+				w,
+				r,
+				Response[any]{Message: ErrUserHasFollows.Error()},
+				http.StatusConflict,
+			)
+			return
+		}
+		if errors.Is(err, database.ErrInvalidID) {
+			send(
 				w,
+				r,
+				Response[any]{Message: ErrInvalidUserID.Error()},
+				http.StatusBadRequest,
+			)
+			return
+		}
+		if errors.Is(err, database.ErrUserDoesNotExist) {
+			send(
+				w,
+				r,
 				Response[any]{Message: ErrUserNotFound.Error()},
 				http.StatusNotFound,
 			)
+			return
+		}
+		if err != nil {
+			sendWriteError(w, r, err)
+			return
 		}
 
-		sendJSON(
+		send(
 			w,
+			r,
 			Response[database.DBUser]{Data: user},
 			http.StatusOK,
 		)
@@ -182,71 +445,263 @@ func handleDeleteUser(db *database.InMemoryDB) http.HandlerFunc {
 // UpdateUser godoc
 //
 //	@Summary		Update a user by ID
-//	@Description	Update a user by ID
+//	@Description	Update a user by ID. When the server allows upserts, a user that does not exist is created with the given ID instead.
 //	@Tags			Users
-//	@Accept			json
-//	@Produce		json
+//	@Accept			json,xml,application/msgpack,application/cbor
+//	@Produce		json,xml,application/msgpack,application/cbor
 //	@Param			id		path		string			true	"User ID"
 //	@Param			body	body		database.User	true	"User details"
+//	@Param			fields	query		string			false	"Comma-separated fields to return, among id, user, user.first_name, user.last_name and user.biography"
 //	@Success		200		{object}	Response[database.DBUser]{data=database.DBUser}
+//	@Success		201		{object}	Response[database.DBUser]{data=database.DBUser}
 //	@Failure		400		{object}	Response[any]{message=string}
 //	@Failure		404		{object}	Response[any]{message=string}
+//	@Failure		406		{object}	Response[any]{message=string}
+//	@Failure		415		{object}	Response[any]{message=string}
+//	@Failure		429		{object}	Response[any]{message=string}
+//	@Failure		500		{object}	Response[any]{message=string}
+//	@Failure		503		{object}	Response[any]{message=string}
+//	@Failure		504		{object}	Response[any]{message=string}
+//	@Failure		507		{object}	Response[any]{message=string}
 //	@Router			/users/{id} [put]
-func handleUpdateUser(db *database.InMemoryDB) http.HandlerFunc {
+func handleUpdateUser(writer database.Writer, allowUpsert bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := chi.URLParam(r, "id")
 
+		if _, err := database.ParseID(id); err != nil {
+			send(
+				w,
+				r,
+				Response[any]{Message: ErrInvalidUserID.Error()},
+				http.StatusBadRequest,
+			)
+			return
+		}
+
 		var body database.User
-		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-			sendJSON(
+		err := decode(r, &body)
+		if errors.Is(err, ErrUnsupportedMediaType) {
+			send(
 				w,
+				r,
+				Response[any]{Message: ErrUnsupportedMediaType.Error()},
+				http.StatusUnsupportedMediaType,
+			)
+			return
+		}
+		if err != nil {
+			send(
+				w,
+				r,
 				Response[any]{Message: "could not decode the request"},
 				http.StatusBadRequest,
 			)
 			return
 		}
 
-		if err := validate.Struct(&body); err != nil {
-			sendJSON(
+		if err := validateStruct(r.Context(), &body); err != nil {
+			send(
 				w,
+				r,
 				Response[any]{Message: ErrInvalidUpdateUserParams.Error()},
 				http.StatusBadRequest,
 			)
 			return
 		}
 
-		user, err := db.Update(id, body)
+		var (
+			user    database.DBUser
+			created bool
+		)
+		if allowUpsert {
+			user, created, err = writer.Upsert(r.Context(), id, body)
+		} else {
+			user, err = writer.Update(r.Context(), id, body)
+		}
+
+		if sendUnavailable(w, r, err) {
+			return
+		}
+
+		if errors.Is(err, database.ErrStorageFull) {
+			send(
+				w,
+				r,
+				Response[any]{Message: ErrInsufficientStorage.Error()},
+				http.StatusInsufficientStorage,
+			)
+			return
+		}
+		if errors.Is(err, database.ErrUserDoesNotExist) {
+			send(
+				w,
+				r,
+				Response[any]{Message: ErrUserNotFound.Error()},
+				http.StatusNotFound,
+			)
+			return
+		}
+		if err != nil {
+			sendWriteError(w, r, err)
+			return
+		}
+
+		status := http.StatusOK
+		if created {
+			status = http.StatusCreated
+		}
+
+		sendUser(w, r, user, status)
+	}
+}
+
+// PatchUser godoc
+//
+//	@Summary		Partially update a user by ID
+//	@Description	Change only the fields present in the body
+//	@Tags			Users
+//	@Accept			json,xml,application/msgpack,application/cbor
+//	@Produce		json,xml,application/msgpack,application/cbor
+//	@Param			id		path		string				true	"User ID"
+//	@Param			body	body		database.UserPatch	true	"Fields to change"
+//	@Param			fields	query		string				false	"Comma-separated fields to return, among id, user, user.first_name, user.last_name and user.biography"
+//	@Success		200		{object}	Response[database.DBUser]{data=database.DBUser}
+//	@Failure		400		{object}	Response[any]{message=string}
+//	@Failure		404		{object}	Response[any]{message=string}
+//	@Failure		406		{object}	Response[any]{message=string}
+//	@Failure		415		{object}	Response[any]{message=string}
+//	@Failure		429		{object}	Response[any]{message=string}
+//	@Failure		500		{object}	Response[any]{message=string}
+//	@Failure		503		{object}	Response[any]{message=string}
+//	@Failure		504		{object}	Response[any]{message=string}
+//	@Failure		507		{object}	Response[any]{message=string}
+//	@Router			/users/{id} [patch]
+func handlePatchUser(writer database.Writer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+
+		if _, err := database.ParseID(id); err != nil {
+			send(
+				w,
+				r,
+				Response[any]{Message: ErrInvalidUserID.Error()},
+				http.StatusBadRequest,
+			)
+			return
+		}
+
+		var body database.UserPatch
+		err := decode(r, &body)
+		if errors.Is(err, ErrUnsupportedMediaType) {
+			send(
+				w,
+				r,
+				Response[any]{Message: ErrUnsupportedMediaType.Error()},
+				http.StatusUnsupportedMediaType,
+			)
+			return
+		}
 		if err != nil {
-			sendJSON(
+			send(
 				w,
-				Response[database.DBUser]{Message: ErrUserNotFound.Error()},
+				r,
+				Response[any]{Message: "could not decode the request"},
+				http.StatusBadRequest,
+			)
+			return
+		}
+
+		if err := validateStruct(r.Context(), &body); err != nil {
+			send(
+				w,
+				r,
+				Response[any]{Message: ErrInvalidPatchParams.Error()},
+				http.StatusBadRequest,
+			)
+			return
+		}
+
+		user, err := writer.Patch(r.Context(), id, body)
+		if sendUnavailable(w, r, err) {
+			return
+		}
+		if errors.Is(err, database.ErrStorageFull) {
+			send(
+				w,
+				r,
+				Response[any]{Message: ErrInsufficientStorage.Error()},
+				http.StatusInsufficientStorage,
+			)
+			return
+		}
+		if errors.Is(err, database.ErrUserDoesNotExist) {
+			send(
+				w,
+				r,
+				Response[any]{Message: ErrUserNotFound.Error()},
 				http.StatusNotFound,
 			)
 			return
 		}
+		if err != nil {
+			sendWriteError(w, r, err)
+			return
+		}
 
-		sendJSON(
-			w,
-			Response[database.DBUser]{Data: user},
-			http.StatusOK,
-		)
+		sendUser(w, r, user, http.StatusOK)
 	}
 }
 
-func sendJSON[T any](w http.ResponseWriter, resp Response[T], status int) {
-	w.Header().Set("Content-Type", "application/json")
+// sendUnavailable answers 503 when err tells the writer cannot take writes
+// at the moment, and reports whether it did.
+func sendUnavailable(w http.ResponseWriter, r *http.Request, err error) bool {
+	if !errors.Is(err, database.ErrUnavailable) {
+		return false
+	}
+
+	w.Header().Set("Retry-After", "1")
+	send(
+		w,
+		r,
+		Response[any]{Message: ErrWritesUnavailable.Error()},
+		http.StatusServiceUnavailable,
+	)
+
+	return true
+}
+
+// sendWriteError answers the writer errors no handler expects: 504 when the
+// change was not confirmed before the request deadline, in which case it may
+// still be applied, 503 when the request was canceled and 500 otherwise.
+func sendWriteError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		send(w, r, Response[any]{Message: ErrWriteTimeout.Error()}, http.StatusGatewayTimeout)
+	case errors.Is(err, context.Canceled):
+		send(w, r, Response[any]{Message: ErrWritesUnavailable.Error()}, http.StatusServiceUnavailable)
+	default:
+		slog.ErrorContext(r.Context(), "could not change the user", "error", err)
+		send(w, r, Response[any]{Message: "internal server error"}, http.StatusInternalServerError)
+	}
+}
 
-	data, err := json.Marshal(resp)
+// write encodes resp with c. A response that cannot be encoded is replaced
+// by a JSON server error.
+func write(w http.ResponseWriter, c codec, resp any, status int) {
+	data, err := c.marshal(resp)
 	if err != nil {
-		slog.Error("could not marshal the response", "error", err)
-		sendJSON(
+		slog.Error("could not marshal the response", "error", err, "media_type", c.mediaType)
+		write(
 			w,
+			jsonCodec,
 			Response[any]{Message: "internal server error"},
 			http.StatusInternalServerError,
 		)
 		return
 	}
 
+	w.Header().Set("Content-Type", c.mediaType)
+	w.Header().Add("Vary", "Accept")
 	w.WriteHeader(status)
 	if _, err := w.Write(data); err != nil {
 		slog.Error("could not write the response", "error", err)
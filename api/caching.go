@@ -0,0 +1,93 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheControl lets clients keep responses but revalidate them on every
+// use, responses vary by API key so shared caches must not store them.
+const cacheControl = "private, no-cache"
+
+// checkModified sets the caching headers of a response at version, last
+// modified at modified, and reports whether the client's copy is still
+// fresh, in which case it already answered 304. If-None-Match is checked
+// first, If-Modified-Since only has a resolution of one second and is a
+// fallback for clients without the ETag. A zero version disables caching.
+func checkModified(w http.ResponseWriter, r *http.Request, version uint64, modified time.Time) bool {
+	if version == 0 {
+		return false
+	}
+
+	etag := entityTag(r, version)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", cacheControl)
+
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		matched, ok := matchEntityTag(match, etag)
+		if !ok {
+			return false
+		}
+		// the copy of the client may be compressed, which changes its tag
+		w.Header().Set("ETag", matched)
+	} else {
+		since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+		if err != nil {
+			return false
+		}
+
+		// HTTP dates have a one second resolution
+		if modified.Truncate(time.Second).After(since) {
+			return false
+		}
+	}
+
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(http.StatusNotModified)
+
+	return true
+}
+
+// entityTag is a strong ETag for the representation of version in the
+// format negotiated by the request. The compression middleware appends the
+// content encoding to it.
+func entityTag(r *http.Request, version uint64) string {
+	c, ok := codecs.negotiate(r.Header.Get("Accept"))
+	if !ok {
+		c = jsonCodec
+	}
+	_, format, _ := strings.Cut(c.mediaType, "/")
+
+	return `"` + strconv.FormatUint(version, 10) + "-" + format + `"`
+}
+
+// encodedEntityTag is etag for a body compressed with encoding.
+func encodedEntityTag(etag string, encoding string) string {
+	return strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+}
+
+// matchEntityTag returns the tag of the If-None-Match header value match
+// standing for etag, in any content encoding, with the weak comparison
+// If-None-Match calls for.
+func matchEntityTag(match string, etag string) (string, bool) {
+	for _, tag := range strings.Split(match, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return etag, true
+		}
+
+		if strings.TrimPrefix(tag, "W/") == etag {
+			return etag, true
+		}
+		for _, enc := range encoders {
+			if encoded := encodedEntityTag(etag, enc.name); strings.TrimPrefix(tag, "W/") == encoded {
+				return encoded, true
+			}
+		}
+	}
+
+	return "", false
+}
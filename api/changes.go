@@ -0,0 +1,101 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"main/database"
+	"net/http"
+	"time"
+)
+
+// heartbeatInterval keeps idle change streams from being closed by proxies.
+const heartbeatInterval = 15 * time.Second
+
+// WatchUsers godoc
+//
+//	@Summary		Stream user changes
+//	@Description	Stream every created, updated, deleted or evicted user as server-sent events, the event data is a database.Change
+//	@Tags			Users
+//	@Produce		text/event-stream
+//	@Success		200	{object}	database.Change
+//	@Failure		429	{object}	Response[any]{message=string}
+//	@Router			/users/changes [get]
+func handleWatchUsers(db *database.InMemoryDB, shutdown <-chan struct{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+
+		// the stream outlives the server write timeout by design
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil && err != http.ErrNotSupported {
+			slog.Error("could not disable the write deadline", "error", err)
+		}
+
+		changes, cancel := db.Subscribe()
+		defer cancel()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		if err := rc.Flush(); err != nil {
+			slog.Error("could not flush the change stream", "error", err)
+			return
+		}
+
+		heartbeat := time.NewTicker(heartbeatInterval)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-shutdown:
+				// the client reconnects, to another instance if need be
+				return
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+					return
+				}
+			case change, ok := <-changes:
+				if !ok {
+					// the subscriber fell behind, the client has to reconnect
+					return
+				}
+
+				data, err := json.Marshal(change)
+				if err != nil {
+					slog.Error("could not marshal the change", "error", err)
+					return
+				}
+
+				if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", change.Seq, change.Type, data); err != nil {
+					return
+				}
+			}
+
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// GetStats godoc
+//
+//	@Summary		Get store statistics
+//	@Description	Get the number of users, their estimated size and the latest change sequence number
+//	@Tags			Stats
+//	@Produce		json,xml,application/msgpack,application/cbor
+//	@Success		200	{object}	Response[database.Stats]{data=database.Stats}
+//	@Failure		406	{object}	Response[any]{message=string}
+//	@Failure		429	{object}	Response[any]{message=string}
+//	@Router			/stats [get]
+func handleGetStats(db *database.InMemoryDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		send(
+			w,
+			r,
+			Response[database.Stats]{Data: db.Stats()},
+			http.StatusOK,
+		)
+	}
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"main/database"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChanges(t *testing.T) {
+	t.Run("stream created, updated and deleted users", func(t *testing.T) {
+		db := database.NewInMemoryDB()
+		server := httptest.NewServer(NewHandler(db, WithRequestLogging(false)))
+		defer server.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/users/changes", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := server.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		assertStatusCode(t, http.StatusOK, res.StatusCode)
+		if contentType := res.Header.Get("Content-Type"); contentType != "text/event-stream" {
+			t.Fatalf("expected the content type to be text/event-stream, got %q", contentType)
+		}
+
+		// the subscription exists once the headers were sent
+		user, err := db.Insert(ctx, database.User{FirstName: "John", LastName: "Doe", Biography: "A simple guy who loves to write code."})
+		if err != nil {
+			t.Fatal(err)
+		}
+		user.User.LastName = "Smith"
+		if _, err := db.Update(ctx, user.ID.String(), user.User); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Delete(ctx, user.ID.String()); err != nil {
+			t.Fatal(err)
+		}
+
+		var (
+			events  []string
+			changes []database.Change
+		)
+		scanner := bufio.NewScanner(res.Body)
+		for len(changes) < 3 && scanner.Scan() {
+			line := scanner.Text()
+			if event, ok := strings.CutPrefix(line, "event: "); ok {
+				events = append(events, event)
+			}
+			if data, ok := strings.CutPrefix(line, "data: "); ok {
+				var change database.Change
+				if err := json.Unmarshal([]byte(data), &change); err != nil {
+					t.Fatalf("could not decode the change: %v", err)
+				}
+				changes = append(changes, change)
+			}
+		}
+
+		want := []database.ChangeType{database.ChangeCreated, database.ChangeUpdated, database.ChangeDeleted}
+		if len(changes) != len(want) {
+			t.Fatalf("expected %d changes, got %d", len(want), len(changes))
+		}
+		for i, change := range changes {
+			if change.Type != want[i] || events[i] != string(want[i]) {
+				t.Errorf("expected change %d to be %q, got %q (event %q)", i, want[i], change.Type, events[i])
+			}
+			if change.Seq != uint64(i+1) {
+				t.Errorf("expected change %d to have sequence %d, got %d", i, i+1, change.Seq)
+			}
+			if change.User.ID != user.ID {
+				t.Errorf("expected change %d to be about %s, got %s", i, user.ID, change.User.ID)
+			}
+		}
+	})
+
+	t.Run("end the stream on shutdown", func(t *testing.T) {
+		shutdown := make(chan struct{})
+		server := httptest.NewServer(NewHandler(database.NewInMemoryDB(), WithRequestLogging(false), WithShutdown(shutdown)))
+		defer server.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/users/changes", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := server.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		close(shutdown)
+
+		// the body ends before the request times out
+		if _, err := io.Copy(io.Discard, res.Body); err != nil {
+			t.Fatalf("expected the stream to end, got %v", err)
+		}
+	})
+}
+
+func TestStats(t *testing.T) {
+	db := database.NewInMemoryDB()
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if _, err := db.Insert(ctx, database.User{FirstName: "John", LastName: "Doe", Biography: "A simple guy who loves to write code."}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "/api/stats", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := makeRequest(db, req)
+
+	response, err := parseResponse[database.Stats](rec)
+	if err != nil {
+		t.Fatalf("could not parse the response: %v", err)
+	}
+
+	assertStatusCode(t, http.StatusOK, rec.Code)
+
+	if response.Data.Users != 3 || response.Data.Changes != 3 || response.Data.Bytes != db.Bytes() {
+		t.Errorf("expected 3 users, 3 changes and %d bytes, got %+v", db.Bytes(), response.Data)
+	}
+}
@@ -0,0 +1,212 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"main/database"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var ErrRecordNotFound = errors.New("the record with the specified ID does not exist")
+var ErrInvalidRecordID = errors.New("please provide a valid record ID")
+var ErrInsufficientRecordStorage = errors.New("there is no room left to store the record")
+
+// WithCollection serves c under /api/<path>, with the same formats and
+// rate limit as the users:
+//
+//   - POST /api/<path> creates a record from the body
+//   - GET /api/<path> lists the records a page at a time, like the users
+//   - GET, PUT and DELETE /api/<path>/{id} read, replace and remove one
+//
+// Values are checked by the validation of the collection, see
+// database.WithValidation.
+func WithCollection[T any](path string, c *database.Collection[T]) Option {
+	return func(o *options) {
+		o.collections = append(o.collections, func(router chi.Router) {
+			routeCollection(router, "/api/"+path, c)
+		})
+	}
+}
+
+func routeCollection[T any](router chi.Router, path string, c *database.Collection[T]) {
+	router.Post(path, handleCreateRecord(c))
+	router.Get(path, handleGetRecords(c))
+	router.Get(path+"/{id}", handleGetRecord(c))
+	router.Put(path+"/{id}", handleUpdateRecord(c))
+	router.Delete(path+"/{id}", handleDeleteRecord(c))
+}
+
+func handleCreateRecord[T any](c *database.Collection[T]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		value, ok := decodeValue[T](w, r)
+		if !ok {
+			return
+		}
+
+		record, err := c.Insert(r.Context(), value)
+		if err != nil {
+			sendRecordError(w, r, err)
+			return
+		}
+
+		send(w, r, Response[database.Record[T]]{Data: record}, http.StatusCreated)
+	}
+}
+
+func handleGetRecords[T any](c *database.Collection[T]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := database.Query[T]{Cursor: r.URL.Query().Get("cursor")}
+
+		if limit := r.URL.Query().Get("limit"); limit != "" {
+			n, err := strconv.Atoi(limit)
+			if err != nil || n < 1 {
+				send(
+					w,
+					r,
+					Response[any]{Message: ErrInvalidPagination.Error()},
+					http.StatusBadRequest,
+				)
+				return
+			}
+			q.Limit = n
+		}
+
+		// read before the page, so the version is never newer than the
+		// content
+		if checkModified(w, r, c.Version(), c.LastModified()) {
+			return
+		}
+
+		page, err := c.List(r.Context(), q)
+		if err != nil {
+			send(
+				w,
+				r,
+				Response[any]{Message: ErrInvalidPagination.Error()},
+				http.StatusBadRequest,
+			)
+			return
+		}
+
+		send(
+			w,
+			r,
+			Response[[]database.Record[T]]{Data: page.Records, Next: page.Next},
+			http.StatusOK,
+		)
+	}
+}
+
+func handleGetRecord[T any](c *database.Collection[T]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+
+		// read before the record, so the version is never newer than the
+		// content
+		version, _ := c.RecordVersion(id)
+		modified, _ := c.ModTime(id)
+		if checkModified(w, r, version, modified) {
+			return
+		}
+
+		record, exists := c.FindByID(r.Context(), id)
+		if !exists {
+			send(
+				w,
+				r,
+				Response[any]{Message: ErrRecordNotFound.Error()},
+				http.StatusNotFound,
+			)
+			return
+		}
+
+		send(w, r, Response[database.Record[T]]{Data: record}, http.StatusOK)
+	}
+}
+
+func handleUpdateRecord[T any](c *database.Collection[T]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+
+		if _, err := database.ParseID(id); err != nil {
+			send(
+				w,
+				r,
+				Response[any]{Message: ErrInvalidRecordID.Error()},
+				http.StatusBadRequest,
+			)
+			return
+		}
+
+		value, ok := decodeValue[T](w, r)
+		if !ok {
+			return
+		}
+
+		record, err := c.Update(r.Context(), id, value)
+		if err != nil {
+			sendRecordError(w, r, err)
+			return
+		}
+
+		send(w, r, Response[database.Record[T]]{Data: record}, http.StatusOK)
+	}
+}
+
+func handleDeleteRecord[T any](c *database.Collection[T]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		record, err := c.Delete(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			sendRecordError(w, r, err)
+			return
+		}
+
+		send(w, r, Response[database.Record[T]]{Data: record}, http.StatusOK)
+	}
+}
+
+// decodeValue decodes the body of r, answering the request itself when it
+// cannot.
+func decodeValue[T any](w http.ResponseWriter, r *http.Request) (T, bool) {
+	var value T
+	err := decode(r, &value)
+	if errors.Is(err, ErrUnsupportedMediaType) {
+		send(
+			w,
+			r,
+			Response[any]{Message: ErrUnsupportedMediaType.Error()},
+			http.StatusUnsupportedMediaType,
+		)
+		return value, false
+	}
+	if err != nil {
+		send(
+			w,
+			r,
+			Response[any]{Message: "could not decode the request"},
+			http.StatusBadRequest,
+		)
+		return value, false
+	}
+
+	return value, true
+}
+
+func sendRecordError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, database.ErrInvalidValue):
+		send(w, r, Response[any]{Message: err.Error()}, http.StatusBadRequest)
+	case errors.Is(err, database.ErrInvalidID):
+		send(w, r, Response[any]{Message: ErrInvalidRecordID.Error()}, http.StatusBadRequest)
+	case errors.Is(err, database.ErrNotFound):
+		send(w, r, Response[any]{Message: ErrRecordNotFound.Error()}, http.StatusNotFound)
+	case errors.Is(err, database.ErrStorageFull):
+		send(w, r, Response[any]{Message: ErrInsufficientRecordStorage.Error()}, http.StatusInsufficientStorage)
+	default:
+		slog.ErrorContext(r.Context(), "could not write the record", "error", err)
+		send(w, r, Response[any]{Message: "internal server error"}, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"errors"
+	"main/database"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type note struct {
+	Text string `json:"text"`
+}
+
+func TestCollectionRoutes(t *testing.T) {
+	const URL = "/api/notes"
+
+	setup := func() (http.Handler, *database.Collection[note]) {
+		notes := database.NewCollection("notes", database.WithValidation(func(n note) error {
+			if n.Text == "" {
+				return errors.New("the text is required")
+			}
+			return nil
+		}))
+
+		return NewHandler(setupDB(), WithCollection("notes", notes)), notes
+	}
+
+	do := func(t *testing.T, handler http.Handler, method, url string, body any) *httptest.ResponseRecorder {
+		t.Helper()
+
+		request, err := createRequest(method, url, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, request)
+
+		return rec
+	}
+
+	t.Run("create, read, update and delete a record", func(t *testing.T) {
+		handler, _ := setup()
+
+		rec := do(t, handler, http.MethodPost, URL, note{Text: "hello"})
+		assertStatusCode(t, http.StatusCreated, rec.Code)
+		created, err := parseResponse[database.Record[note]](rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if created.Data.ID.IsEmpty() || created.Data.Value.Text != "hello" {
+			t.Fatalf("expected the created note, got %+v", created.Data)
+		}
+		recordURL := URL + "/" + created.Data.ID.String()
+
+		rec = do(t, handler, http.MethodPut, recordURL, note{Text: "bye"})
+		assertStatusCode(t, http.StatusOK, rec.Code)
+
+		rec = do(t, handler, http.MethodGet, recordURL, nil)
+		assertStatusCode(t, http.StatusOK, rec.Code)
+		found, err := parseResponse[database.Record[note]](rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found.Data.ID != created.Data.ID || found.Data.Value.Text != "bye" {
+			t.Fatalf("expected the updated note, got %+v", found.Data)
+		}
+
+		rec = do(t, handler, http.MethodDelete, recordURL, nil)
+		assertStatusCode(t, http.StatusOK, rec.Code)
+
+		rec = do(t, handler, http.MethodGet, recordURL, nil)
+		assertStatusCode(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("list records a page at a time", func(t *testing.T) {
+		handler, _ := setup()
+		for _, text := range []string{"one", "two", "three"} {
+			do(t, handler, http.MethodPost, URL, note{Text: text})
+		}
+
+		rec := do(t, handler, http.MethodGet, URL+"?limit=2", nil)
+		assertStatusCode(t, http.StatusOK, rec.Code)
+		page, err := parseResponse[[]database.Record[note]](rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Data) != 2 || page.Next == "" {
+			t.Fatalf("expected a first page of 2 notes, got %+v", page)
+		}
+
+		rec = do(t, handler, http.MethodGet, URL+"?limit=2&cursor="+page.Next, nil)
+		page, err = parseResponse[[]database.Record[note]](rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Data) != 1 || page.Data[0].Value.Text != "three" || page.Next != "" {
+			t.Fatalf("expected a last page with the third note, got %+v", page)
+		}
+
+		rec = do(t, handler, http.MethodGet, URL+"?cursor=nope", nil)
+		assertStatusCode(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("reject invalid values", func(t *testing.T) {
+		handler, notes := setup()
+
+		rec := do(t, handler, http.MethodPost, URL, note{})
+		assertStatusCode(t, http.StatusBadRequest, rec.Code)
+
+		if notes.Count() != 0 {
+			t.Fatalf("expected no note to be stored, got %d", notes.Count())
+		}
+	})
+
+	t.Run("answer 404 for missing records", func(t *testing.T) {
+		handler, _ := setup()
+		id := database.ID{}.NewID().String()
+
+		assertStatusCode(t, http.StatusNotFound, do(t, handler, http.MethodPut, URL+"/"+id, note{Text: "hi"}).Code)
+		assertStatusCode(t, http.StatusNotFound, do(t, handler, http.MethodDelete, URL+"/"+id, nil).Code)
+		assertStatusCode(t, http.StatusBadRequest, do(t, handler, http.MethodPut, URL+"/nope", note{Text: "hi"}).Code)
+	})
+}
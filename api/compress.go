@@ -0,0 +1,262 @@
+package api
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// encoder compresses a response body for a Content-Encoding.
+type encoder struct {
+	name string
+	pool *sync.Pool
+}
+
+type compressor interface {
+	io.WriteCloser
+	Reset(io.Writer)
+	Flush() error
+}
+
+// zstdWriter adapts the zstd encoder, whose Reset does not match.
+type zstdWriter struct {
+	*zstd.Encoder
+}
+
+func (z zstdWriter) Reset(w io.Writer) {
+	z.Encoder.Reset(w)
+}
+
+// encoders are in order of preference when the client accepts several
+// with the same q-value.
+var encoders = []encoder{
+	{name: "zstd", pool: &sync.Pool{New: func() any {
+		// an encoder without concurrency keeps the memory per response low
+		z, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(zstd.SpeedDefault))
+		return zstdWriter{z}
+	}}},
+	{name: "br", pool: &sync.Pool{New: func() any {
+		return brotli.NewWriterLevel(nil, brotli.DefaultCompression)
+	}}},
+	{name: "gzip", pool: &sync.Pool{New: func() any {
+		return gzip.NewWriter(nil)
+	}}},
+}
+
+// negotiateEncoding picks the preferred encoder of the Accept-Encoding
+// header, false when the body has to be sent as is.
+func negotiateEncoding(acceptEncoding string) (encoder, bool) {
+	q := make(map[string]float64)
+	for _, value := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(value), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		weight := 1.0
+		if raw, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if weight, err = strconv.ParseFloat(raw, 64); err != nil {
+				continue
+			}
+		}
+		q[name] = weight
+	}
+
+	var (
+		best   encoder
+		bestQ  float64
+		chosen bool
+	)
+	for _, enc := range encoders {
+		weight, ok := q[enc.name]
+		if !ok {
+			weight, ok = q["*"]
+		}
+		if ok && weight > bestQ {
+			best, bestQ, chosen = enc, weight, true
+		}
+	}
+
+	return best, chosen
+}
+
+// compress encodes response bodies of at least minSize bytes with the
+// encoding preferred by the client. Smaller bodies are not worth the CPU
+// and event streams are left alone so every event is delivered at once.
+func compress(minSize int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			enc, ok := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			if !ok || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: w, encoder: enc, minSize: minSize}
+			defer cw.Close()
+
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// compressWriter buffers the start of the body until it knows whether it
+// is large enough to compress.
+type compressWriter struct {
+	http.ResponseWriter
+	encoder encoder
+	minSize int
+
+	status      int
+	wroteHeader bool
+	// decided is set once the body is either compressed or passed through
+	decided    bool
+	buf        []byte
+	compressor compressor
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = status
+
+	if !compressible(status, cw.Header()) {
+		cw.passThrough()
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if cw.decided {
+		if cw.compressor != nil {
+			return cw.compressor.Write(p)
+		}
+		return cw.ResponseWriter.Write(p)
+	}
+
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= cw.minSize {
+		if err := cw.startCompression(); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Flush commits to compression, a flushed body is a stream and likely
+// large.
+func (cw *compressWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if !cw.decided {
+		if err := cw.startCompression(); err != nil {
+			return
+		}
+	}
+
+	if cw.compressor != nil {
+		if err := cw.compressor.Flush(); err != nil {
+			return
+		}
+	}
+
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Close sends what is still buffered, uncompressed when it stayed below
+// the threshold.
+func (cw *compressWriter) Close() error {
+	if !cw.wroteHeader {
+		// the handler wrote nothing, not even a status
+		return nil
+	}
+
+	if !cw.decided {
+		cw.passThrough()
+		return nil
+	}
+
+	if cw.compressor == nil {
+		return nil
+	}
+
+	err := cw.compressor.Close()
+	cw.compressor.Reset(nil)
+	cw.encoder.pool.Put(cw.compressor)
+	cw.compressor = nil
+
+	return err
+}
+
+func (cw *compressWriter) passThrough() {
+	cw.decided = true
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	if len(cw.buf) > 0 {
+		// the status is sent, a failed write can only be noticed by the client
+		_, _ = cw.ResponseWriter.Write(cw.buf)
+		cw.buf = nil
+	}
+}
+
+func (cw *compressWriter) startCompression() error {
+	cw.decided = true
+
+	header := cw.Header()
+	header.Set("Content-Encoding", cw.encoder.name)
+	// the compressed body is another representation
+	if etag := header.Get("ETag"); etag != "" {
+		header.Set("ETag", encodedEntityTag(etag, cw.encoder.name))
+	}
+	header.Del("Content-Length")
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	cw.compressor = cw.encoder.pool.Get().(compressor)
+	cw.compressor.Reset(cw.ResponseWriter)
+
+	buf := cw.buf
+	cw.buf = nil
+	_, err := cw.compressor.Write(buf)
+
+	return err
+}
+
+// compressible reports whether a response may be compressed at all.
+func compressible(status int, header http.Header) bool {
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		return false
+	}
+
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	return mediaType != "text/event-stream"
+}
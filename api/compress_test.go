@@ -0,0 +1,267 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"main/database"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "br"},
+		{"gzip, br, zstd", "zstd"},
+		{"zstd;q=0.5, gzip", "gzip"},
+		{"*", "zstd"},
+		{"*, zstd;q=0", "br"},
+		{"gzip;q=0", ""},
+	}
+
+	for _, tt := range tests {
+		enc, ok := negotiateEncoding(tt.acceptEncoding)
+		if ok != (tt.want != "") || enc.name != tt.want {
+			t.Errorf("expected %q to negotiate %q, got %q", tt.acceptEncoding, tt.want, enc.name)
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"br":   func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+		"zstd": func(r io.Reader) (io.Reader, error) {
+			d, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		},
+	}
+
+	db := database.NewInMemoryDB()
+	for i := 0; i < 50; i++ {
+		if _, err := db.Insert(context.Background(), users[i%len(users)]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, decode := range decoders {
+		t.Run(name, func(t *testing.T) {
+			request, err := http.NewRequest(http.MethodGet, "/api/users", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			request.Header.Set("Accept-Encoding", name)
+
+			rec := makeRequest(db, request)
+
+			assertStatusCode(t, http.StatusOK, rec.Code)
+			if encoding := rec.Header().Get("Content-Encoding"); encoding != name {
+				t.Fatalf("expected the content encoding to be %q, got %q", name, encoding)
+			}
+
+			body, err := decode(rec.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var response Response[[]database.DBUser]
+			if err := json.NewDecoder(body).Decode(&response); err != nil {
+				t.Fatalf("could not decode the response: %v", err)
+			}
+			if len(response.Data) != 50 {
+				t.Fatalf("expected %d users, got %d", 50, len(response.Data))
+			}
+		})
+	}
+
+	t.Run("small bodies are not compressed", func(t *testing.T) {
+		request, err := http.NewRequest(http.MethodGet, "/api/users/"+database.ID{}.NewID().String(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.Header.Set("Accept-Encoding", "gzip")
+
+		rec := makeRequest(db, request)
+
+		assertStatusCode(t, http.StatusNotFound, rec.Code)
+		if encoding := rec.Header().Get("Content-Encoding"); encoding != "" {
+			t.Fatalf("expected no content encoding, got %q", encoding)
+		}
+
+		response, err := parseResponse[any](rec)
+		if err != nil {
+			t.Fatalf("could not parse the response: %v", err)
+		}
+		assertErrorMessage(t, ErrUserNotFound.Error(), response.Message)
+	})
+
+	t.Run("compression can be disabled", func(t *testing.T) {
+		request, err := http.NewRequest(http.MethodGet, "/api/users", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.Header.Set("Accept-Encoding", "gzip")
+
+		rec := httptest.NewRecorder()
+		NewHandler(db, WithCompression(-1)).ServeHTTP(rec, request)
+
+		if encoding := rec.Header().Get("Content-Encoding"); encoding != "" {
+			t.Fatalf("expected no content encoding, got %q", encoding)
+		}
+	})
+}
+
+func TestConditionalRequests(t *testing.T) {
+	makeConditional := func(db *database.InMemoryDB, url string, since string) *httptest.ResponseRecorder {
+		request, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if since != "" {
+			request.Header.Set("If-Modified-Since", since)
+		}
+
+		return makeRequest(db, request)
+	}
+
+	t.Run("get a user", func(t *testing.T) {
+		db := database.NewInMemoryDB()
+		user, err := db.Insert(context.Background(), users[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		url := "/api/users/" + user.ID.String()
+
+		rec := makeConditional(db, url, "")
+		assertStatusCode(t, http.StatusOK, rec.Code)
+
+		lastModified := rec.Header().Get("Last-Modified")
+		if lastModified == "" || rec.Header().Get("Cache-Control") != cacheControl {
+			t.Fatalf("expected caching headers, got %v", rec.Header())
+		}
+
+		rec = makeConditional(db, url, lastModified)
+		assertStatusCode(t, http.StatusNotModified, rec.Code)
+		if rec.Body.Len() != 0 {
+			t.Fatalf("expected no body, got %q", rec.Body.String())
+		}
+
+		// a copy older than the user is sent again
+		past := time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat)
+		rec = makeConditional(db, url, past)
+		assertStatusCode(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("list users", func(t *testing.T) {
+		db := setupDB()
+
+		rec := makeConditional(db, "/api/users", "")
+		assertStatusCode(t, http.StatusOK, rec.Code)
+		lastModified := rec.Header().Get("Last-Modified")
+
+		rec = makeConditional(db, "/api/users", lastModified)
+		assertStatusCode(t, http.StatusNotModified, rec.Code)
+
+		future := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+		rec = makeConditional(db, "/api/users?limit=1", future)
+		assertStatusCode(t, http.StatusNotModified, rec.Code)
+	})
+
+	t.Run("an empty store is not cached", func(t *testing.T) {
+		rec := makeConditional(database.NewInMemoryDB(), "/api/users", time.Now().UTC().Format(http.TimeFormat))
+
+		assertStatusCode(t, http.StatusOK, rec.Code)
+		if lastModified := rec.Header().Get("Last-Modified"); lastModified != "" {
+			t.Fatalf("expected no Last-Modified header, got %q", lastModified)
+		}
+	})
+
+	revalidate := func(db *database.InMemoryDB, url string, header http.Header) *httptest.ResponseRecorder {
+		request, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, values := range header {
+			request.Header[name] = values
+		}
+
+		return makeRequest(db, request)
+	}
+
+	t.Run("revalidate with the ETag", func(t *testing.T) {
+		db := database.NewInMemoryDB()
+		user, err := db.Insert(context.Background(), users[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		url := "/api/users/" + user.ID.String()
+
+		rec := revalidate(db, url, nil)
+		etag := rec.Header().Get("ETag")
+		lastModified := rec.Header().Get("Last-Modified")
+		if etag == "" || strings.HasPrefix(etag, "W/") {
+			t.Fatalf("expected a strong ETag, got %q", etag)
+		}
+
+		rec = revalidate(db, url, http.Header{"If-None-Match": {`"other", ` + etag}})
+		assertStatusCode(t, http.StatusNotModified, rec.Code)
+		if rec.Header().Get("ETag") != etag {
+			t.Fatalf("expected the ETag %q, got %q", etag, rec.Header().Get("ETag"))
+		}
+
+		// a change within the same second is only seen by the ETag
+		if _, err := db.Update(context.Background(), user.ID.String(), users[1]); err != nil {
+			t.Fatal(err)
+		}
+		rec = revalidate(db, url, http.Header{"If-None-Match": {etag}, "If-Modified-Since": {lastModified}})
+		assertStatusCode(t, http.StatusOK, rec.Code)
+		if rec.Header().Get("ETag") == etag {
+			t.Fatalf("expected the ETag to change with the user")
+		}
+	})
+
+	t.Run("tag every representation", func(t *testing.T) {
+		db := setupDB()
+		for i := 0; i < 50; i++ {
+			if _, err := db.Insert(context.Background(), users[i%len(users)]); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		jsonTag := revalidate(db, "/api/users", nil).Header().Get("ETag")
+		xmlTag := revalidate(db, "/api/users", http.Header{"Accept": {"application/xml"}}).Header().Get("ETag")
+		rec := revalidate(db, "/api/users", http.Header{"Accept-Encoding": {"gzip"}})
+		gzipTag := rec.Header().Get("ETag")
+		if rec.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("expected a compressed response, got %v", rec.Header())
+		}
+		if jsonTag == xmlTag || jsonTag == gzipTag || xmlTag == gzipTag {
+			t.Fatalf("expected distinct ETags, got %q, %q and %q", jsonTag, xmlTag, gzipTag)
+		}
+
+		rec = revalidate(db, "/api/users", http.Header{"Accept-Encoding": {"gzip"}, "If-None-Match": {gzipTag}})
+		assertStatusCode(t, http.StatusNotModified, rec.Code)
+		if rec.Header().Get("ETag") != gzipTag {
+			t.Fatalf("expected the ETag %q, got %q", gzipTag, rec.Header().Get("ETag"))
+		}
+
+		rec = revalidate(db, "/api/users", http.Header{"Accept": {"application/xml"}, "If-None-Match": {jsonTag}})
+		assertStatusCode(t, http.StatusOK, rec.Code)
+	})
+}
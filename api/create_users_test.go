@@ -1,8 +1,11 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"main/database"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -179,4 +182,114 @@ func TestCreateUser(t *testing.T) {
 
 		assertErrorMessage(t, ErrInvalidUserParams.Error(), response.Message)
 	})
+
+	t.Run("database is full", func(t *testing.T) {
+		db := database.NewInMemoryDB(database.WithMaxUsers(1))
+		db.Insert(context.Background(), requestBody)
+
+		req, err := createRequest(http.MethodPost, URL, requestBody)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := makeRequest(db, req)
+
+		response, err := parseResponse[any](rec)
+		if err != nil {
+			t.Fatalf("could not parse the response: %v", err)
+		}
+
+		assertStatusCode(t, http.StatusInsufficientStorage, rec.Code)
+
+		assertErrorMessage(t, ErrInsufficientStorage.Error(), response.Message)
+	})
+
+	t.Run("go through the writer", func(t *testing.T) {
+		db := database.NewInMemoryDB()
+
+		req, err := createRequest(http.MethodPost, URL, requestBody)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := httptest.NewRecorder()
+		NewHandler(db, WithWriter(unavailableWriter{db})).ServeHTTP(rec, req)
+
+		response, err := parseResponse[any](rec)
+		if err != nil {
+			t.Fatalf("could not parse the response: %v", err)
+		}
+
+		assertStatusCode(t, http.StatusServiceUnavailable, rec.Code)
+
+		assertErrorMessage(t, ErrWritesUnavailable.Error(), response.Message)
+
+		if n := db.Count(); n != 0 {
+			t.Fatalf("expected the database to be left alone, got %d users", n)
+		}
+	})
+
+	t.Run("map the writer errors", func(t *testing.T) {
+		tests := []struct {
+			err     error
+			status  int
+			message string
+		}{
+			{context.DeadlineExceeded, http.StatusGatewayTimeout, ErrWriteTimeout.Error()},
+			{context.Canceled, http.StatusServiceUnavailable, ErrWritesUnavailable.Error()},
+			{errors.New("disk on fire"), http.StatusInternalServerError, "internal server error"},
+		}
+
+		for _, test := range tests {
+			db := database.NewInMemoryDB()
+
+			req, err := createRequest(http.MethodPost, URL, requestBody)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rec := httptest.NewRecorder()
+			NewHandler(db, WithWriter(failingWriter{db, test.err})).ServeHTTP(rec, req)
+
+			response, err := parseResponse[any](rec)
+			if err != nil {
+				t.Fatalf("could not parse the response: %v", err)
+			}
+
+			assertStatusCode(t, test.status, rec.Code)
+
+			assertErrorMessage(t, test.message, response.Message)
+		}
+	})
+}
+
+// unavailableWriter fails inserts like a cluster node without a leader.
+type unavailableWriter struct {
+	*database.InMemoryDB
+}
+
+func (unavailableWriter) Insert(context.Context, database.User) (database.DBUser, error) {
+	return database.DBUser{}, database.ErrUnavailable
+}
+
+// failingWriter fails every write with err.
+type failingWriter struct {
+	*database.InMemoryDB
+	err error
+}
+
+func (f failingWriter) Insert(context.Context, database.User) (database.DBUser, error) {
+	return database.DBUser{}, f.err
+}
+
+func (f failingWriter) Update(context.Context, string, database.User) (database.DBUser, error) {
+	return database.DBUser{}, f.err
+}
+
+func (f failingWriter) Patch(context.Context, string, database.UserPatch) (database.DBUser, error) {
+	return database.DBUser{}, f.err
+}
+
+func (f failingWriter) Delete(context.Context, string) (database.DBUser, error) {
+	return database.DBUser{}, f.err
 }
@@ -1,8 +1,11 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"main/database"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -12,7 +15,7 @@ func TestDeleteUser(t *testing.T) {
 	t.Run("delete a user successfully", func(t *testing.T) {
 		db := setupDB()
 
-		users := db.FindAll()
+		users := db.FindAll(context.Background())
 
 		request, err := createRequest(
 			http.MethodDelete,
@@ -58,4 +61,25 @@ func TestDeleteUser(t *testing.T) {
 
 		assertErrorMessage(t, ErrUserNotFound.Error(), response.Message)
 	})
+
+	t.Run("answer 500 when the writer fails unexpectedly", func(t *testing.T) {
+		db := setupDB()
+
+		req, err := createRequest(http.MethodDelete, URL+db.FindAll(context.Background())[0].ID.String(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := httptest.NewRecorder()
+		NewHandler(db, WithWriter(failingWriter{db, errors.New("disk on fire")})).ServeHTTP(rec, req)
+
+		response, err := parseResponse[any](rec)
+		if err != nil {
+			t.Fatalf("could not parse the response: %v", err)
+		}
+
+		assertStatusCode(t, http.StatusInternalServerError, rec.Code)
+
+		assertErrorMessage(t, "internal server error", response.Message)
+	})
 }
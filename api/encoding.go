@@ -0,0 +1,244 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+var ErrNotAcceptable = errors.New("please accept one of application/json, application/xml, application/msgpack or application/cbor")
+var ErrUnsupportedMediaType = errors.New("please send the body as application/json, application/xml, application/msgpack or application/cbor")
+
+// codec encodes responses and decodes requests of a media type.
+type codec struct {
+	mediaType string
+	// aliases are other media types accepted for the same format.
+	aliases []string
+	marshal func(v any) ([]byte, error)
+	decode  func(r io.Reader, v any) error
+}
+
+var jsonCodec = codec{
+	mediaType: "application/json",
+	marshal:   json.Marshal,
+	decode: func(r io.Reader, v any) error {
+		return json.NewDecoder(r).Decode(v)
+	},
+}
+
+var xmlCodec = codec{
+	mediaType: "application/xml",
+	aliases:   []string{"text/xml"},
+	marshal: func(v any) ([]byte, error) {
+		data, err := xml.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(xml.Header), data...), nil
+	},
+	decode: func(r io.Reader, v any) error {
+		return xml.NewDecoder(r).Decode(v)
+	},
+}
+
+// msgpackCodec and cborCodec use the json tags so every format has the
+// same field names.
+var msgpackCodec = codec{
+	mediaType: "application/msgpack",
+	aliases:   []string{"application/x-msgpack", "application/vnd.msgpack"},
+	marshal: func(v any) ([]byte, error) {
+		var buf bytes.Buffer
+		encoder := msgpack.NewEncoder(&buf)
+		encoder.SetCustomStructTag("json")
+		encoder.UseCompactInts(true)
+		if err := encoder.Encode(v); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	},
+	decode: func(r io.Reader, v any) error {
+		decoder := msgpack.NewDecoder(r)
+		decoder.SetCustomStructTag("json")
+		return decoder.Decode(v)
+	},
+}
+
+var cborEncMode, _ = cbor.EncOptions{TextMarshaler: cbor.TextMarshalerTextString}.EncMode()
+var cborDecMode, _ = cbor.DecOptions{TextUnmarshaler: cbor.TextUnmarshalerTextString}.DecMode()
+
+var cborCodec = codec{
+	mediaType: "application/cbor",
+	marshal:   cborEncMode.Marshal,
+	decode: func(r io.Reader, v any) error {
+		return cborDecMode.NewDecoder(r).Decode(v)
+	},
+}
+
+// codecs is the registry of supported formats, the first one is the
+// default when the client does not ask for any.
+var codecs = newRegistry(jsonCodec, xmlCodec, msgpackCodec, cborCodec)
+
+type registry struct {
+	ordered []codec
+	byType  map[string]codec
+}
+
+func newRegistry(list ...codec) *registry {
+	reg := &registry{ordered: list, byType: make(map[string]codec)}
+	for _, c := range list {
+		reg.byType[c.mediaType] = c
+		for _, alias := range c.aliases {
+			reg.byType[alias] = c
+		}
+	}
+
+	return reg
+}
+
+// forContentType returns the codec decoding a request body, JSON when the
+// Content-Type header is missing.
+func (reg *registry) forContentType(contentType string) (codec, bool) {
+	if contentType == "" {
+		return reg.ordered[0], true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return codec{}, false
+	}
+
+	c, ok := reg.byType[mediaType]
+	return c, ok
+}
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// negotiate picks the codec preferred by the Accept header, honouring
+// q-values and wildcards. A missing header accepts the default codec.
+func (reg *registry) negotiate(accept string) (codec, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return reg.ordered[0], true
+	}
+
+	var ranges []acceptRange
+	for _, value := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if raw, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(raw, 64); err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+
+		ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+	}
+
+	// more specific ranges win ties, e.g. "application/xml" over "*/*"
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].q != ranges[j].q {
+			return ranges[i].q > ranges[j].q
+		}
+		return specificity(ranges[i].mediaType) > specificity(ranges[j].mediaType)
+	})
+
+	for _, r := range ranges {
+		if r.q == 0 {
+			break
+		}
+
+		if c, ok := reg.byType[r.mediaType]; ok {
+			if !reg.excluded(ranges, c) {
+				return c, true
+			}
+			continue
+		}
+
+		for _, c := range reg.ordered {
+			if matches(r.mediaType, c.mediaType) && !reg.excluded(ranges, c) {
+				return c, true
+			}
+		}
+	}
+
+	return codec{}, false
+}
+
+// excluded reports whether the client refused c explicitly with q=0.
+func (reg *registry) excluded(ranges []acceptRange, c codec) bool {
+	for _, r := range ranges {
+		if r.q == 0 && reg.byType[r.mediaType].mediaType == c.mediaType {
+			return true
+		}
+	}
+
+	return false
+}
+
+func specificity(mediaType string) int {
+	switch {
+	case mediaType == "*/*":
+		return 0
+	case strings.HasSuffix(mediaType, "/*"):
+		return 1
+	default:
+		return 2
+	}
+}
+
+func matches(pattern string, mediaType string) bool {
+	if pattern == "*/*" {
+		return true
+	}
+
+	if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+		return strings.HasPrefix(mediaType, prefix+"/")
+	}
+
+	return pattern == mediaType
+}
+
+// acceptable rejects requests with 406 before doing any work when none of
+// the response formats is acceptable.
+func acceptable(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := codecs.negotiate(r.Header.Get("Accept")); !ok {
+			write(
+				w,
+				jsonCodec,
+				Response[any]{Message: ErrNotAcceptable.Error()},
+				http.StatusNotAcceptable,
+			)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// send encodes resp in the format negotiated from the Accept header,
+// falling back to JSON.
+func send[T any](w http.ResponseWriter, r *http.Request, resp Response[T], status int) {
+	c, ok := codecs.negotiate(r.Header.Get("Accept"))
+	if !ok {
+		c = jsonCodec
+	}
+
+	write(w, c, resp, status)
+}
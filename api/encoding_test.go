@@ -0,0 +1,147 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"main/database"
+	"net/http"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", "application/json"},
+		{"*/*", "application/json"},
+		{"application/xml", "application/xml"},
+		{"text/xml", "application/xml"},
+		{"application/x-msgpack", "application/msgpack"},
+		{"application/json;q=0.5, application/cbor", "application/cbor"},
+		{"application/cbor;q=0.2, application/msgpack;q=0.8", "application/msgpack"},
+		{"*/*;q=0.1, application/xml", "application/xml"},
+		{"application/*", "application/json"},
+		{"application/json;q=0, */*", "application/xml"},
+		{"text/html, application/cbor;q=0.1", "application/cbor"},
+		{"text/html", ""},
+		{"application/json;q=0", ""},
+	}
+
+	for _, tt := range tests {
+		c, ok := codecs.negotiate(tt.accept)
+		if tt.want == "" {
+			if ok {
+				t.Errorf("expected %q to accept nothing, got %q", tt.accept, c.mediaType)
+			}
+			continue
+		}
+
+		if !ok || c.mediaType != tt.want {
+			t.Errorf("expected %q to negotiate %q, got %q", tt.accept, tt.want, c.mediaType)
+		}
+	}
+}
+
+func TestEncodings(t *testing.T) {
+	for _, c := range codecs.ordered {
+		t.Run(c.mediaType, func(t *testing.T) {
+			db := database.NewInMemoryDB()
+
+			body, err := c.marshal(users[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			request, err := http.NewRequest(http.MethodPost, "/api/users", bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			request.Header.Set("Content-Type", c.mediaType)
+			request.Header.Set("Accept", c.mediaType)
+
+			rec := makeRequest(db, request)
+
+			assertStatusCode(t, http.StatusCreated, rec.Code)
+			if contentType := rec.Header().Get("Content-Type"); contentType != c.mediaType {
+				t.Fatalf("expected the content type to be %q, got %q", c.mediaType, contentType)
+			}
+
+			var created Response[database.DBUser]
+			if err := c.decode(rec.Body, &created); err != nil {
+				t.Fatalf("could not decode the response: %v", err)
+			}
+			assertUser(t, database.DBUser{User: users[0]}, created.Data)
+
+			stored, exists := db.FindByID(context.Background(), created.Data.ID.String())
+			if !exists || stored != created.Data {
+				t.Fatalf("expected %v to be stored, got %v", created.Data, stored)
+			}
+
+			request, err = http.NewRequest(http.MethodGet, "/api/users/"+database.ID{}.NewID().String(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			request.Header.Set("Accept", c.mediaType)
+
+			rec = makeRequest(db, request)
+
+			assertStatusCode(t, http.StatusNotFound, rec.Code)
+
+			var notFound Response[any]
+			if err := c.decode(rec.Body, &notFound); err != nil {
+				t.Fatalf("could not decode the response: %v", err)
+			}
+			assertErrorMessage(t, ErrUserNotFound.Error(), notFound.Message)
+		})
+	}
+
+	t.Run("list users as xml", func(t *testing.T) {
+		request, err := http.NewRequest(http.MethodGet, "/api/users", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.Header.Set("Accept", "application/xml")
+
+		rec := makeRequest(setupDB(), request)
+
+		assertStatusCode(t, http.StatusOK, rec.Code)
+
+		var response Response[[]database.DBUser]
+		if err := xmlCodec.decode(rec.Body, &response); err != nil {
+			t.Fatalf("could not decode the response: %v", err)
+		}
+		if len(response.Data) != len(users) {
+			t.Fatalf("expected %d users, got %d", len(users), len(response.Data))
+		}
+	})
+
+	t.Run("reject unacceptable formats", func(t *testing.T) {
+		request, err := http.NewRequest(http.MethodGet, "/api/users", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.Header.Set("Accept", "text/html")
+
+		rec := makeRequest(setupDB(), request)
+
+		assertStatusCode(t, http.StatusNotAcceptable, rec.Code)
+	})
+
+	t.Run("reject unsupported bodies", func(t *testing.T) {
+		db := database.NewInMemoryDB()
+
+		request, err := http.NewRequest(http.MethodPost, "/api/users", bytes.NewBufferString("John Doe"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.Header.Set("Content-Type", "text/plain")
+
+		rec := makeRequest(db, request)
+
+		assertStatusCode(t, http.StatusUnsupportedMediaType, rec.Code)
+		if db.Count() != 0 {
+			t.Fatalf("expected no user to be created")
+		}
+	})
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"main/database"
+	"net/http"
+	"strings"
+)
+
+var ErrInvalidFields = errors.New("please select fields among id, user, user.first_name, user.last_name and user.biography")
+
+// fieldSet is a sparse fieldset selected with ?fields=id,user.first_name.
+// The zero value selects every field.
+type fieldSet struct {
+	sparse    bool
+	id        bool
+	firstName bool
+	lastName  bool
+	biography bool
+}
+
+func parseFields(value string) (fieldSet, error) {
+	if value == "" {
+		return fieldSet{}, nil
+	}
+
+	fields := fieldSet{sparse: true}
+	for _, name := range strings.Split(value, ",") {
+		switch strings.TrimSpace(name) {
+		case "id":
+			fields.id = true
+		case "user":
+			fields.firstName, fields.lastName, fields.biography = true, true, true
+		case "user.first_name":
+			fields.firstName = true
+		case "user.last_name":
+			fields.lastName = true
+		case "user.biography":
+			fields.biography = true
+		default:
+			return fieldSet{}, fmt.Errorf("%w: unknown field %q", ErrInvalidFields, name)
+		}
+	}
+
+	return fields, nil
+}
+
+// partialUser is a database.DBUser without the fields left out of a
+// fieldSet, it keeps the same names in every format.
+type partialUser struct {
+	ID   *database.ID   `json:"id,omitempty" xml:"id,omitempty"`
+	User *partialFields `json:"user,omitempty" xml:"user,omitempty"`
+}
+
+type partialFields struct {
+	FirstName *string `json:"first_name,omitempty" xml:"first_name,omitempty"`
+	LastName  *string `json:"last_name,omitempty" xml:"last_name,omitempty"`
+	Biography *string `json:"biography,omitempty" xml:"biography,omitempty"`
+}
+
+func (f fieldSet) project(user database.DBUser) partialUser {
+	var projected partialUser
+	if f.id {
+		projected.ID = &user.ID
+	}
+
+	if f.firstName || f.lastName || f.biography {
+		projected.User = &partialFields{}
+		if f.firstName {
+			projected.User.FirstName = &user.User.FirstName
+		}
+		if f.lastName {
+			projected.User.LastName = &user.User.LastName
+		}
+		if f.biography {
+			projected.User.Biography = &user.User.Biography
+		}
+	}
+
+	return projected
+}
+
+type fieldsKey struct{}
+
+// sparseFields validates the fields query parameter before the handler
+// runs, so a write is never applied only to fail on its response.
+func sparseFields(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fields, err := parseFields(r.URL.Query().Get("fields"))
+		if err != nil {
+			send(
+				w,
+				r,
+				Response[any]{Message: err.Error()},
+				http.StatusBadRequest,
+			)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), fieldsKey{}, fields)))
+	})
+}
+
+// fieldsFrom returns the fieldset validated by sparseFields, every field
+// when there is none.
+func fieldsFrom(ctx context.Context) fieldSet {
+	fields, _ := ctx.Value(fieldsKey{}).(fieldSet)
+	return fields
+}
+
+// sendUser sends user with only the fields selected by the request.
+func sendUser(w http.ResponseWriter, r *http.Request, user database.DBUser, status int) {
+	if fields := fieldsFrom(r.Context()); fields.sparse {
+		send(w, r, Response[partialUser]{Data: fields.project(user)}, status)
+		return
+	}
+
+	send(w, r, Response[database.DBUser]{Data: user}, status)
+}
+
+func sendPage(w http.ResponseWriter, r *http.Request, page database.Page) {
+	fields := fieldsFrom(r.Context())
+	if !fields.sparse {
+		send(w, r, Response[[]database.DBUser]{Data: page.Users, Next: page.Next}, http.StatusOK)
+		return
+	}
+
+	projected := make([]partialUser, 0, len(page.Users))
+	for _, user := range page.Users {
+		projected = append(projected, fields.project(user))
+	}
+
+	send(w, r, Response[[]partialUser]{Data: projected, Next: page.Next}, http.StatusOK)
+}
+
+// userFilter reads the first_name, last_name and q query parameters.
+func userFilter(r *http.Request) database.UserFilter {
+	query := r.URL.Query()
+
+	return database.UserFilter{
+		FirstName: query.Get("first_name"),
+		LastName:  query.Get("last_name"),
+		Contains:  query.Get("q"),
+	}
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"main/database"
+	"net/http"
+	"testing"
+)
+
+func TestSparseFields(t *testing.T) {
+	// decodeRaw keeps the fields as sent, to tell missing ones from empty ones
+	decodeRaw := func(t *testing.T, body []byte) Response[json.RawMessage] {
+		t.Helper()
+
+		var response Response[json.RawMessage]
+		if err := json.Unmarshal(body, &response); err != nil {
+			t.Fatalf("could not decode the response: %v", err)
+		}
+
+		return response
+	}
+
+	t.Run("get a user with some fields", func(t *testing.T) {
+		db := database.NewInMemoryDB()
+		user, err := db.Insert(context.Background(), users[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		request, err := http.NewRequest(http.MethodGet, "/api/users/"+user.ID.String()+"?fields=id,user.first_name", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := makeRequest(db, request)
+
+		assertStatusCode(t, http.StatusOK, rec.Code)
+
+		response := decodeRaw(t, rec.Body.Bytes())
+		id, _ := json.Marshal(user.ID)
+		want := `{"id":` + string(id) + `,"user":{"first_name":"John"}}`
+		if string(response.Data) != want {
+			t.Fatalf("expected %s, got %s", want, response.Data)
+		}
+	})
+
+	t.Run("user selects every user field", func(t *testing.T) {
+		db := setupDB()
+
+		request, err := http.NewRequest(http.MethodGet, "/api/users?fields=user", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := makeRequest(db, request)
+
+		response := decodeRaw(t, rec.Body.Bytes())
+
+		var page []struct {
+			ID   *string       `json:"id"`
+			User database.User `json:"user"`
+		}
+		if err := json.Unmarshal(response.Data, &page); err != nil {
+			t.Fatalf("could not decode the page: %v", err)
+		}
+		for i, user := range page {
+			if user.ID != nil || user.User != users[i] {
+				t.Errorf("expected only the user fields of %v, got %v", users[i], user)
+			}
+		}
+	})
+
+	t.Run("project a filtered page", func(t *testing.T) {
+		db := database.NewInMemoryDB()
+		for _, name := range []string{"John", "Jane", "John", "Jack", "John"} {
+			user := users[0]
+			user.FirstName = name
+			if _, err := db.Insert(context.Background(), user); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var (
+			names  []string
+			cursor string
+			pages  int
+		)
+		for {
+			request, err := http.NewRequest(http.MethodGet, "/api/users?fields=user.first_name&first_name=john&limit=2&cursor="+cursor, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rec := makeRequest(db, request)
+			assertStatusCode(t, http.StatusOK, rec.Code)
+
+			response := decodeRaw(t, rec.Body.Bytes())
+
+			var page []map[string]map[string]string
+			if err := json.Unmarshal(response.Data, &page); err != nil {
+				t.Fatalf("could not decode the page: %v", err)
+			}
+			for _, user := range page {
+				if len(user) != 1 || len(user["user"]) != 1 {
+					t.Fatalf("expected only the first name, got %v", user)
+				}
+				names = append(names, user["user"]["first_name"])
+			}
+
+			pages++
+			if cursor = response.Next; cursor == "" {
+				break
+			}
+		}
+
+		if len(names) != 3 || pages != 2 {
+			t.Fatalf("expected 3 users over 2 pages, got %v over %d pages", names, pages)
+		}
+		for _, name := range names {
+			if name != "John" {
+				t.Fatalf("expected only John, got %v", names)
+			}
+		}
+	})
+
+	t.Run("filter on any text", func(t *testing.T) {
+		db := setupDB()
+
+		request, err := http.NewRequest(http.MethodGet, "/api/users?q=NICE+LADY", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := makeRequest(db, request)
+
+		response, err := parseResponse[[]database.DBUser](rec)
+		if err != nil {
+			t.Fatalf("could not parse the response: %v", err)
+		}
+		if len(response.Data) != 1 || response.Data[0].User != users[1] {
+			t.Fatalf("expected only %v, got %v", users[1], response.Data)
+		}
+	})
+
+	t.Run("create a user returning its id", func(t *testing.T) {
+		db := database.NewInMemoryDB()
+
+		request, err := createRequest(http.MethodPost, "/api/users?fields=id", users[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := makeRequest(db, request)
+
+		assertStatusCode(t, http.StatusCreated, rec.Code)
+
+		response := decodeRaw(t, rec.Body.Bytes())
+
+		var created map[string]database.ID
+		if err := json.Unmarshal(response.Data, &created); err != nil {
+			t.Fatalf("could not decode the user: %v", err)
+		}
+		if _, exists := db.FindByID(context.Background(), created["id"].String()); !exists || len(created) != 1 {
+			t.Fatalf("expected only the id of the created user, got %v", created)
+		}
+	})
+
+	t.Run("reject unknown fields before writing", func(t *testing.T) {
+		db := database.NewInMemoryDB()
+
+		request, err := createRequest(http.MethodPost, "/api/users?fields=id,user.password", users[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := makeRequest(db, request)
+
+		assertStatusCode(t, http.StatusBadRequest, rec.Code)
+		if db.Count() != 0 {
+			t.Fatalf("expected no user to be created")
+		}
+	})
+}
@@ -0,0 +1,222 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"main/database"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var ErrCannotFollowSelf = errors.New("a user cannot follow themselves")
+var ErrNotFollowing = errors.New("the user does not follow the other user")
+var ErrNoFollowPath = errors.New("the other user cannot be reached by following users")
+var ErrUserHasFollows = errors.New("the user follows or is followed by other users, unfollow them first")
+
+// FollowUser godoc
+//
+//	@Summary		Follow a user
+//	@Description	Make a user follow another. Following a user twice changes nothing.
+//	@Tags			Follows
+//	@Produce		json,xml,application/msgpack,application/cbor
+//	@Param			id		path	string	true	"Follower ID"
+//	@Param			target	path	string	true	"ID of the user to follow"
+//	@Success		204		"The user follows the other"
+//	@Failure		400		{object}	Response[any]{message=string}
+//	@Failure		404		{object}	Response[any]{message=string}
+//	@Failure		406		{object}	Response[any]{message=string}
+//	@Failure		429		{object}	Response[any]{message=string}
+//	@Failure		503		{object}	Response[any]{message=string}
+//	@Router			/users/{id}/following/{target} [put]
+func handleFollowUser(writer database.Writer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, err := writer.Follow(r.Context(), chi.URLParam(r, "id"), chi.URLParam(r, "target"))
+		if err != nil {
+			sendFollowError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// UnfollowUser godoc
+//
+//	@Summary		Unfollow a user
+//	@Description	Make a user stop following another
+//	@Tags			Follows
+//	@Produce		json,xml,application/msgpack,application/cbor
+//	@Param			id		path	string	true	"Follower ID"
+//	@Param			target	path	string	true	"ID of the followed user"
+//	@Success		204		"The user no longer follows the other"
+//	@Failure		400		{object}	Response[any]{message=string}
+//	@Failure		404		{object}	Response[any]{message=string}
+//	@Failure		406		{object}	Response[any]{message=string}
+//	@Failure		429		{object}	Response[any]{message=string}
+//	@Failure		503		{object}	Response[any]{message=string}
+//	@Router			/users/{id}/following/{target} [delete]
+func handleUnfollowUser(writer database.Writer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := writer.Unfollow(r.Context(), chi.URLParam(r, "id"), chi.URLParam(r, "target"))
+		if err != nil {
+			sendFollowError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// GetFollowers godoc
+//
+//	@Summary		Get the followers of a user
+//	@Description	Get the users following a user in the order they followed it, optionally one page at a time
+//	@Tags			Follows
+//	@Produce		json,xml,application/msgpack,application/cbor
+//	@Param			id			path		string	true	"User ID"
+//	@Param			limit		query		int		false	"Maximum number of users to return"
+//	@Param			cursor		query		string	false	"The next cursor of the previous page"
+//	@Param			fields		query		string	false	"Comma-separated fields to return, among id, user, user.first_name, user.last_name and user.biography"
+//	@Param			first_name	query		string	false	"Only users with this first name, ignoring case"
+//	@Param			last_name	query		string	false	"Only users with this last name, ignoring case"
+//	@Param			q			query		string	false	"Only users whose names or biography contain this text, ignoring case"
+//	@Success		200			{object}	Response[[]database.DBUser]{data=[]database.DBUser}
+//	@Failure		400			{object}	Response[any]{message=string}
+//	@Failure		404			{object}	Response[any]{message=string}
+//	@Failure		406			{object}	Response[any]{message=string}
+//	@Failure		429			{object}	Response[any]{message=string}
+//	@Router			/users/{id}/followers [get]
+func handleGetFollowers(db *database.InMemoryDB) http.HandlerFunc {
+	return handleListFollows(db.Followers)
+}
+
+// GetFollowing godoc
+//
+//	@Summary		Get the users a user follows
+//	@Description	Get the users a user follows in the order it followed them, optionally one page at a time
+//	@Tags			Follows
+//	@Produce		json,xml,application/msgpack,application/cbor
+//	@Param			id			path		string	true	"User ID"
+//	@Param			limit		query		int		false	"Maximum number of users to return"
+//	@Param			cursor		query		string	false	"The next cursor of the previous page"
+//	@Param			fields		query		string	false	"Comma-separated fields to return, among id, user, user.first_name, user.last_name and user.biography"
+//	@Param			first_name	query		string	false	"Only users with this first name, ignoring case"
+//	@Param			last_name	query		string	false	"Only users with this last name, ignoring case"
+//	@Param			q			query		string	false	"Only users whose names or biography contain this text, ignoring case"
+//	@Success		200			{object}	Response[[]database.DBUser]{data=[]database.DBUser}
+//	@Failure		400			{object}	Response[any]{message=string}
+//	@Failure		404			{object}	Response[any]{message=string}
+//	@Failure		406			{object}	Response[any]{message=string}
+//	@Failure		429			{object}	Response[any]{message=string}
+//	@Router			/users/{id}/following [get]
+func handleGetFollowing(db *database.InMemoryDB) http.HandlerFunc {
+	return handleListFollows(db.Following)
+}
+
+// GetMutualFollows godoc
+//
+//	@Summary		Get the mutual follows of a user
+//	@Description	Get the users a user follows who follow it back, in the order it followed them, optionally one page at a time
+//	@Tags			Follows
+//	@Produce		json,xml,application/msgpack,application/cbor
+//	@Param			id			path		string	true	"User ID"
+//	@Param			limit		query		int		false	"Maximum number of users to return"
+//	@Param			cursor		query		string	false	"The next cursor of the previous page"
+//	@Param			fields		query		string	false	"Comma-separated fields to return, among id, user, user.first_name, user.last_name and user.biography"
+//	@Param			first_name	query		string	false	"Only users with this first name, ignoring case"
+//	@Param			last_name	query		string	false	"Only users with this last name, ignoring case"
+//	@Param			q			query		string	false	"Only users whose names or biography contain this text, ignoring case"
+//	@Success		200			{object}	Response[[]database.DBUser]{data=[]database.DBUser}
+//	@Failure		400			{object}	Response[any]{message=string}
+//	@Failure		404			{object}	Response[any]{message=string}
+//	@Failure		406			{object}	Response[any]{message=string}
+//	@Failure		429			{object}	Response[any]{message=string}
+//	@Router			/users/{id}/mutuals [get]
+func handleGetMutualFollows(db *database.InMemoryDB) http.HandlerFunc {
+	return handleListFollows(db.MutualFollows)
+}
+
+// GetFollowPath godoc
+//
+//	@Summary		Get the shortest path between two users
+//	@Description	Get the fewest users leading from a user to another by following, both included
+//	@Tags			Follows
+//	@Produce		json,xml,application/msgpack,application/cbor
+//	@Param			id		path		string	true	"ID of the first user"
+//	@Param			target	path		string	true	"ID of the user to reach"
+//	@Param			fields	query		string	false	"Comma-separated fields to return, among id, user, user.first_name, user.last_name and user.biography"
+//	@Success		200		{object}	Response[[]database.DBUser]{data=[]database.DBUser}
+//	@Failure		400		{object}	Response[any]{message=string}
+//	@Failure		404		{object}	Response[any]{message=string}
+//	@Failure		406		{object}	Response[any]{message=string}
+//	@Failure		429		{object}	Response[any]{message=string}
+//	@Router			/users/{id}/path/{target} [get]
+func handleGetFollowPath(db *database.InMemoryDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path, err := db.ShortestPath(r.Context(), chi.URLParam(r, "id"), chi.URLParam(r, "target"))
+		if err != nil {
+			sendFollowError(w, r, err)
+			return
+		}
+
+		sendPage(w, r, database.Page{Users: path})
+	}
+}
+
+func handleListFollows(list func(ctx context.Context, id string, opts database.ListOptions) (database.Page, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		opts := database.ListOptions{
+			Cursor: r.URL.Query().Get("cursor"),
+			Filter: userFilter(r).Match,
+		}
+
+		if limit := r.URL.Query().Get("limit"); limit != "" {
+			n, err := strconv.Atoi(limit)
+			if err != nil || n < 1 {
+				send(
+					w,
+					r,
+					Response[any]{Message: ErrInvalidPagination.Error()},
+					http.StatusBadRequest,
+				)
+				return
+			}
+			opts.Limit = n
+		}
+
+		page, err := list(r.Context(), chi.URLParam(r, "id"), opts)
+		if err != nil {
+			sendFollowError(w, r, err)
+			return
+		}
+
+		sendPage(w, r, page)
+	}
+}
+
+func sendFollowError(w http.ResponseWriter, r *http.Request, err error) {
+	if sendUnavailable(w, r, err) {
+		return
+	}
+
+	switch {
+	case errors.Is(err, database.ErrInvalidID):
+		send(w, r, Response[any]{Message: ErrInvalidUserID.Error()}, http.StatusBadRequest)
+	case errors.Is(err, database.ErrInvalidCursor):
+		send(w, r, Response[any]{Message: ErrInvalidPagination.Error()}, http.StatusBadRequest)
+	case errors.Is(err, database.ErrSelfFollow):
+		send(w, r, Response[any]{Message: ErrCannotFollowSelf.Error()}, http.StatusBadRequest)
+	case errors.Is(err, database.ErrUserDoesNotExist):
+		send(w, r, Response[any]{Message: ErrUserNotFound.Error()}, http.StatusNotFound)
+	case errors.Is(err, database.ErrNotFollowing):
+		send(w, r, Response[any]{Message: ErrNotFollowing.Error()}, http.StatusNotFound)
+	case errors.Is(err, database.ErrNoPath):
+		send(w, r, Response[any]{Message: ErrNoFollowPath.Error()}, http.StatusNotFound)
+	default:
+		slog.ErrorContext(r.Context(), "could not query the follows", "error", err)
+		send(w, r, Response[any]{Message: "internal server error"}, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"context"
+	"main/database"
+	"net/http"
+	"testing"
+)
+
+func TestFollows(t *testing.T) {
+	const URL = "/api/users/"
+
+	request := func(t *testing.T, db *database.InMemoryDB, method, url string) *http.Response {
+		t.Helper()
+
+		req, err := createRequest(method, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return makeRequest(db, req).Result()
+	}
+
+	t.Run("follow and list the followers of a user", func(t *testing.T) {
+		db := setupDB()
+		users := db.FindAll(context.Background())
+		john, jane := users[0].ID.String(), users[1].ID.String()
+
+		resp := request(t, db, http.MethodPut, URL+jane+"/following/"+john)
+		assertStatusCode(t, http.StatusNoContent, resp.StatusCode)
+
+		req, _ := createRequest(http.MethodGet, URL+john+"/followers", nil)
+		rec := makeRequest(db, req)
+		assertStatusCode(t, http.StatusOK, rec.Code)
+
+		response, err := parseResponse[[]database.DBUser](rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(response.Data) != 1 || response.Data[0].ID.String() != jane {
+			t.Fatalf("expected the follower %s, got %v", jane, response.Data)
+		}
+
+		resp = request(t, db, http.MethodDelete, URL+jane+"/following/"+john)
+		assertStatusCode(t, http.StatusNoContent, resp.StatusCode)
+
+		resp = request(t, db, http.MethodDelete, URL+jane+"/following/"+john)
+		assertStatusCode(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("reject following missing users and oneself", func(t *testing.T) {
+		db := setupDB()
+		john := db.FindAll(context.Background())[0].ID.String()
+
+		resp := request(t, db, http.MethodPut, URL+john+"/following/"+database.ID{}.NewID().String())
+		assertStatusCode(t, http.StatusNotFound, resp.StatusCode)
+
+		resp = request(t, db, http.MethodPut, URL+john+"/following/"+john)
+		assertStatusCode(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp = request(t, db, http.MethodGet, URL+"nope/followers")
+		assertStatusCode(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("find the path between users", func(t *testing.T) {
+		db := setupDB()
+		users := db.FindAll(context.Background())
+		john, jane := users[0].ID.String(), users[1].ID.String()
+
+		resp := request(t, db, http.MethodGet, URL+john+"/path/"+jane)
+		assertStatusCode(t, http.StatusNotFound, resp.StatusCode)
+
+		request(t, db, http.MethodPut, URL+john+"/following/"+jane)
+
+		req, _ := createRequest(http.MethodGet, URL+john+"/path/"+jane, nil)
+		rec := makeRequest(db, req)
+		assertStatusCode(t, http.StatusOK, rec.Code)
+
+		response, err := parseResponse[[]database.DBUser](rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(response.Data) != 2 || response.Data[0].ID.String() != john || response.Data[1].ID.String() != jane {
+			t.Fatalf("expected the path from %s to %s, got %v", john, jane, response.Data)
+		}
+	})
+
+	t.Run("refuse deleting followed users when restricted", func(t *testing.T) {
+		db := database.NewInMemoryDB(database.WithDeletePolicy(database.DeleteRestrict))
+		john, _ := db.Insert(context.Background(), database.User{FirstName: "John"})
+		jane, _ := db.Insert(context.Background(), database.User{FirstName: "Jane"})
+		db.Follow(context.Background(), john.ID.String(), jane.ID.String())
+
+		resp := request(t, db, http.MethodDelete, URL+jane.ID.String())
+		assertStatusCode(t, http.StatusConflict, resp.StatusCode)
+	})
+}
@@ -1,8 +1,12 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
 	"main/database"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -52,7 +56,7 @@ func TestGetUsers(t *testing.T) {
 	t.Run("get user by ID", func(t *testing.T) {
 		db := setupDB()
 
-		dbUsers := db.FindAll()
+		dbUsers := db.FindAll(context.Background())
 
 		request, err := createRequest(
 			http.MethodGet,
@@ -98,4 +102,177 @@ func TestGetUsers(t *testing.T) {
 
 		assertErrorMessage(t, ErrUserNotFound.Error(), response.Message)
 	})
+
+	t.Run("get users page by page", func(t *testing.T) {
+		db := setupDB()
+
+		request, err := createRequest(http.MethodGet, URL+"?limit=1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := makeRequest(db, request)
+
+		first, err := parseResponse[[]database.DBUser](rec)
+		if err != nil {
+			t.Fatalf("could not parse the response: %v", err)
+		}
+
+		assertStatusCode(t, http.StatusOK, rec.Code)
+
+		if len(first.Data) != 1 || first.Next == "" {
+			t.Fatalf("expected 1 user and a cursor, got %d users and cursor %q", len(first.Data), first.Next)
+		}
+
+		request, err = createRequest(http.MethodGet, URL+"?limit=1&cursor="+first.Next, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec = makeRequest(db, request)
+
+		second, err := parseResponse[[]database.DBUser](rec)
+		if err != nil {
+			t.Fatalf("could not parse the response: %v", err)
+		}
+
+		if len(second.Data) != 1 || second.Next != "" {
+			t.Fatalf("expected the last user and no cursor, got %d users and cursor %q", len(second.Data), second.Next)
+		}
+
+		if second.Data[0].ID == first.Data[0].ID {
+			t.Fatalf("expected the pages to hold different users")
+		}
+	})
+
+	t.Run("get users with an invalid limit", func(t *testing.T) {
+		db := setupDB()
+
+		request, err := createRequest(http.MethodGet, URL+"?limit=-1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := makeRequest(db, request)
+
+		response, err := parseResponse[any](rec)
+		if err != nil {
+			t.Fatalf("could not parse the response: %v", err)
+		}
+
+		assertStatusCode(t, http.StatusBadRequest, rec.Code)
+
+		assertErrorMessage(t, ErrInvalidPagination.Error(), response.Message)
+	})
+
+	t.Run("get users with an invalid cursor", func(t *testing.T) {
+		db := setupDB()
+
+		request, err := createRequest(http.MethodGet, URL+"?cursor=nope", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := makeRequest(db, request)
+
+		response, err := parseResponse[any](rec)
+		if err != nil {
+			t.Fatalf("could not parse the response: %v", err)
+		}
+
+		assertStatusCode(t, http.StatusBadRequest, rec.Code)
+
+		assertErrorMessage(t, ErrInvalidPagination.Error(), response.Message)
+	})
+
+	t.Run("stream the same JSON as a buffered response", func(t *testing.T) {
+		db := setupDB()
+		for i := 0; i < 500; i++ {
+			db.Insert(context.Background(), users[i%len(users)])
+		}
+		dbUsers, _ := db.List(context.Background(), database.ListOptions{})
+
+		fields := fieldSet{sparse: true, id: true, firstName: true}
+		projected := make([]partialUser, 0, len(dbUsers.Users))
+		for _, user := range dbUsers.Users {
+			projected = append(projected, fields.project(user))
+		}
+
+		tests := []struct {
+			query string
+			want  any
+		}{
+			{"", Response[[]database.DBUser]{Data: dbUsers.Users}},
+			{"?limit=3", Response[[]database.DBUser]{Data: dbUsers.Users[:3], Next: "3"}},
+			{"?limit=3&cursor=3", Response[[]database.DBUser]{Data: dbUsers.Users[3:6], Next: "6"}},
+			{"?fields=id,user.first_name", Response[[]partialUser]{Data: projected}},
+			{"?first_name=nobody", Response[[]database.DBUser]{}},
+		}
+
+		for _, tt := range tests {
+			request, err := createRequest(http.MethodGet, URL+tt.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rec := makeRequest(db, request)
+			assertStatusCode(t, http.StatusOK, rec.Code)
+
+			want, err := json.Marshal(tt.want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := rec.Body.String(); got != string(want) {
+				t.Fatalf("expected %s to answer\n%s\ngot\n%s", tt.query, want, got)
+			}
+			if contentType := rec.Header().Get("Content-Type"); contentType != "application/json" {
+				t.Fatalf("expected a JSON response, got %q", contentType)
+			}
+		}
+	})
+	t.Run("cut the stream short on errors", func(t *testing.T) {
+		user := database.DBUser{ID: database.ID{}.NewID(), User: users[0]}
+
+		rec := httptest.NewRecorder()
+		err := streamUsers(context.Background(), rec, fieldSet{}, func(fn func(database.DBUser) bool) (string, error) {
+			fn(user)
+			return "", context.Canceled
+		})
+		if err != nil {
+			t.Fatalf("expected the error to be logged once streaming, got %v", err)
+		}
+
+		if body := rec.Body.String(); json.Valid(rec.Body.Bytes()) || !strings.HasPrefix(body, `{"data":[{`) || strings.HasSuffix(body, "]}") {
+			t.Fatalf("expected the array to be left open, got %s", body)
+		}
+	})
+
+	t.Run("sync the reader before reading", func(t *testing.T) {
+		db := setupDB()
+
+		for _, tt := range []struct {
+			reader syncFunc
+			status int
+		}{
+			{func(context.Context) error { return nil }, http.StatusOK},
+			{func(context.Context) error { return database.ErrUnavailable }, http.StatusServiceUnavailable},
+		} {
+			req, err := createRequest(http.MethodGet, URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rec := httptest.NewRecorder()
+			NewHandler(db, WithReader(tt.reader)).ServeHTTP(rec, req)
+
+			assertStatusCode(t, tt.status, rec.Code)
+		}
+	})
+}
+
+// syncFunc is a database.Reader syncing with itself.
+type syncFunc func(ctx context.Context) error
+
+func (f syncFunc) Sync(ctx context.Context) error {
+	return f(ctx)
 }
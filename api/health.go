@@ -0,0 +1,28 @@
+package api
+
+import (
+	"main/health"
+	"net/http"
+)
+
+func handleLiveness(registry *health.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sendReport(w, registry.Liveness(r.Context()))
+	}
+}
+
+func handleReadiness(registry *health.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sendReport(w, registry.Readiness(r.Context()))
+	}
+}
+
+func sendReport(w http.ResponseWriter, report health.Report) {
+	status := http.StatusOK
+	if report.Status != health.StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+
+	// probes only speak JSON, and the report's maps have no XML form
+	write(w, jsonCodec, Response[health.Report]{Data: report}, status)
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"main/database"
+	"main/health"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	t.Run("liveness", func(t *testing.T) {
+		registry := health.NewRegistry(time.Second)
+
+		rec := makeHealthRequest(registry, "/healthz")
+
+		response, err := parseResponse[health.Report](rec)
+		if err != nil {
+			t.Fatalf("could not parse the response: %v", err)
+		}
+
+		assertStatusCode(t, http.StatusOK, rec.Code)
+
+		if response.Data.Status != health.StatusOK {
+			t.Errorf("expected status %q, got %q", health.StatusOK, response.Data.Status)
+		}
+	})
+
+	t.Run("readiness reports the database", func(t *testing.T) {
+		db := setupDB()
+		registry := health.NewRegistry(time.Second)
+		registry.RegisterReadiness("database", db.Check)
+
+		rec := makeHealthRequest(registry, "/readyz")
+
+		response, err := parseResponse[health.Report](rec)
+		if err != nil {
+			t.Fatalf("could not parse the response: %v", err)
+		}
+
+		assertStatusCode(t, http.StatusOK, rec.Code)
+
+		if response.Data.Components["database"].Status != health.StatusOK {
+			t.Errorf("expected the database to be %q, got %q", health.StatusOK, response.Data.Components["database"].Status)
+		}
+	})
+
+	t.Run("readiness fails when a check fails", func(t *testing.T) {
+		registry := health.NewRegistry(time.Second)
+		registry.RegisterReadiness("worker", func(context.Context) error {
+			return errors.New("worker stopped")
+		})
+
+		rec := makeHealthRequest(registry, "/readyz")
+
+		assertStatusCode(t, http.StatusServiceUnavailable, rec.Code)
+	})
+
+	t.Run("readiness fails during shutdown", func(t *testing.T) {
+		registry := health.NewRegistry(time.Second)
+		registry.Shutdown()
+
+		rec := makeHealthRequest(registry, "/readyz")
+
+		assertStatusCode(t, http.StatusServiceUnavailable, rec.Code)
+	})
+}
+
+func makeHealthRequest(registry *health.Registry, url string) *httptest.ResponseRecorder {
+	router := NewHandler(database.NewInMemoryDB(), WithHealth(registry))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+
+	return rec
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"main/database"
@@ -83,8 +84,8 @@ func parseResponse[T any](response *httptest.ResponseRecorder) (Response[T], err
 
 func setupDB() *database.InMemoryDB {
 	db := database.NewInMemoryDB()
-	db.Insert(users[0])
-	db.Insert(users[1])
+	db.Insert(context.Background(), users[0])
+	db.Insert(context.Background(), users[1])
 
 	return db
 }
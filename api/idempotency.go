@@ -0,0 +1,142 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"main/idempotency"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+var ErrIdempotencyKeyReused = errors.New("the idempotency key was already used with a different request")
+var ErrIdempotencyKeyInProgress = errors.New("a request with the same idempotency key is still being processed")
+var ErrIdempotentBodyTooLarge = fmt.Errorf("please send at most %d bytes with an idempotency key", maxIdempotentBodySize)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	replayedHeader       = "Idempotent-Replayed"
+	// maxIdempotentBodySize bounds the bodies read in full to be
+	// fingerprinted.
+	maxIdempotentBodySize = 1 << 20
+)
+
+// unreplayedHeaders describe the request answered rather than the stored
+// response, replays keep the values set for the retry.
+var unreplayedHeaders = map[string]bool{
+	"Ratelimit-Limit":     true,
+	"Ratelimit-Remaining": true,
+	"Ratelimit-Reset":     true,
+	"Retry-After":         true,
+	"X-Request-Id":        true,
+	"Date":                true,
+}
+
+// idempotent replays the stored response of requests retried with the same
+// Idempotency-Key header and body. Keys are scoped to the client so two
+// clients cannot see each other's responses.
+func idempotent(store *idempotency.Store) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(idempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key = clientKey(r) + ":" + key
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBodySize))
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				send(
+					w,
+					r,
+					Response[any]{Message: ErrIdempotentBodyTooLarge.Error()},
+					http.StatusRequestEntityTooLarge,
+				)
+				return
+			}
+			if err != nil {
+				send(
+					w,
+					r,
+					Response[any]{Message: "could not read the request"},
+					http.StatusBadRequest,
+				)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			state, stored := store.Begin(key, idempotency.NewFingerprint(r.Method, r.URL.Path, body))
+
+			switch state {
+			case idempotency.StateReplay:
+				for name, values := range stored.Header {
+					if !unreplayedHeaders[http.CanonicalHeaderKey(name)] {
+						w.Header()[name] = values
+					}
+				}
+				w.Header().Set(replayedHeader, "true")
+				w.WriteHeader(stored.Status)
+				if _, err := w.Write(stored.Body); err != nil {
+					slog.Error("could not write the response", "error", err)
+				}
+				return
+			case idempotency.StateMismatch:
+				send(
+					w,
+					r,
+					Response[any]{Message: ErrIdempotencyKeyReused.Error()},
+					http.StatusUnprocessableEntity,
+				)
+				return
+			case idempotency.StateInProgress:
+				send(
+					w,
+					r,
+					Response[any]{Message: ErrIdempotencyKeyInProgress.Error()},
+					http.StatusConflict,
+				)
+				return
+			}
+
+			var buf bytes.Buffer
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			ww.Tee(&buf)
+
+			defer func() {
+				if p := recover(); p != nil {
+					store.Release(key)
+					panic(p)
+				}
+
+				status := ww.Status()
+				if status == 0 {
+					status = http.StatusOK
+				}
+
+				// server errors are not cached so the client can retry them
+				if status >= http.StatusInternalServerError {
+					store.Release(key)
+					return
+				}
+
+				// the body is stored before compression, which is
+				// negotiated again on replay
+				header := w.Header().Clone()
+				header.Del("Content-Encoding")
+
+				store.Complete(key, idempotency.Response{
+					Status: status,
+					Header: header,
+					Body:   buf.Bytes(),
+				})
+			}()
+
+			next.ServeHTTP(ww, r)
+		})
+	}
+}
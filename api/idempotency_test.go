@@ -0,0 +1,134 @@
+package api
+
+import (
+	"main/database"
+	"main/idempotency"
+	"main/ratelimit"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotency(t *testing.T) {
+	const URL = "/api/users"
+
+	requestBody := database.User{
+		FirstName: "John",
+		LastName:  "Doe",
+		Biography: "A regular guy who loves to code in Go and JavaScript",
+	}
+
+	send := func(handler http.Handler, key string, body any) *httptest.ResponseRecorder {
+		req, err := createRequest(http.MethodPost, URL, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(idempotencyKeyHeader, key)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	t.Run("retries replay the first response", func(t *testing.T) {
+		db := database.NewInMemoryDB()
+		handler := NewHandler(db, WithIdempotency(idempotency.NewStore(time.Hour)))
+
+		first := send(handler, "key-1", requestBody)
+		firstBody := first.Body.String()
+		second := send(handler, "key-1", requestBody)
+
+		assertStatusCode(t, http.StatusCreated, second.Code)
+
+		if second.Body.String() != firstBody {
+			t.Errorf("expected the response to be %s, got %s", firstBody, second.Body.String())
+		}
+
+		if second.Header().Get(replayedHeader) != "true" {
+			t.Errorf("expected the response to be marked as replayed")
+		}
+
+		if db.Count() != 1 {
+			t.Fatalf("expected %d user, got %d", 1, db.Count())
+		}
+	})
+
+	t.Run("replays keep the rate limit headers of the retry", func(t *testing.T) {
+		db := database.NewInMemoryDB()
+		handler := NewHandler(db,
+			WithIdempotency(idempotency.NewStore(time.Hour)),
+			WithRateLimit(ratelimit.New(ratelimit.Config{
+				Read:       ratelimit.Limit{Rate: 1, Burst: 10},
+				Write:      ratelimit.Limit{Rate: 1, Burst: 10},
+				MaxClients: 10,
+			})),
+		)
+
+		first := send(handler, "key-1", requestBody)
+		second := send(handler, "key-1", requestBody)
+
+		if second.Header().Get(replayedHeader) != "true" {
+			t.Fatalf("expected the response to be replayed")
+		}
+		remaining := second.Header().Values("RateLimit-Remaining")
+		if len(remaining) != 1 || remaining[0] == first.Header().Get("RateLimit-Remaining") {
+			t.Errorf("expected the remaining requests of the retry, got %v", remaining)
+		}
+	})
+
+	t.Run("different body with the same key", func(t *testing.T) {
+		db := database.NewInMemoryDB()
+		handler := NewHandler(db, WithIdempotency(idempotency.NewStore(time.Hour)))
+
+		send(handler, "key-1", requestBody)
+
+		other := requestBody
+		other.FirstName = "Jane"
+		rec := send(handler, "key-1", other)
+
+		response, err := parseResponse[any](rec)
+		if err != nil {
+			t.Fatalf("could not parse the response: %v", err)
+		}
+
+		assertStatusCode(t, http.StatusUnprocessableEntity, rec.Code)
+
+		assertErrorMessage(t, ErrIdempotencyKeyReused.Error(), response.Message)
+	})
+
+	t.Run("different keys create different users", func(t *testing.T) {
+		db := database.NewInMemoryDB()
+		handler := NewHandler(db, WithIdempotency(idempotency.NewStore(time.Hour)))
+
+		send(handler, "key-1", requestBody)
+		send(handler, "key-2", requestBody)
+
+		if db.Count() != 2 {
+			t.Fatalf("expected %d users, got %d", 2, db.Count())
+		}
+	})
+	t.Run("reject large bodies", func(t *testing.T) {
+		db := database.NewInMemoryDB()
+		handler := NewHandler(db, WithIdempotency(idempotency.NewStore(time.Hour)))
+
+		large := requestBody
+		large.Biography = strings.Repeat("a", maxIdempotentBodySize)
+		rec := send(handler, "key-1", large)
+
+		response, err := parseResponse[any](rec)
+		if err != nil {
+			t.Fatalf("could not parse the response: %v", err)
+		}
+
+		assertStatusCode(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+		assertErrorMessage(t, ErrIdempotentBodyTooLarge.Error(), response.Message)
+
+		if db.Count() != 0 {
+			t.Fatalf("expected %d users, got %d", 0, db.Count())
+		}
+	})
+}
@@ -0,0 +1,222 @@
+package api
+
+import (
+	"log/slog"
+	"main/database"
+	"main/health"
+	"main/idempotency"
+	"main/logging"
+	"main/metrics"
+	"main/ratelimit"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type Option func(*options)
+
+type options struct {
+	requestTimeout time.Duration
+	logRequests    bool
+	accessLogger   *slog.Logger
+	accessLog      logging.AccessLogOptions
+	health         *health.Registry
+	metrics        *metrics.Metrics
+	rateLimiter    *ratelimit.Limiter
+	// rateLimitKeys and rateLimitTenants are limited apart from their IP
+	rateLimitKeys    map[string]struct{}
+	rateLimitTenants map[string]struct{}
+	idempotency      *idempotency.Store
+	allowUpsert      bool
+	// writer applies the writes of the handlers, the database if nil
+	writer database.Writer
+	// reader is synced before the reads, which see the database as is if
+	// it is nil
+	reader      database.Reader
+	graphql     http.Handler
+	rpc         http.Handler
+	replication http.Handler
+	cluster     http.Handler
+	// leader is set on followers, which forward their writes to it unless
+	// forwardWrites is false
+	leader        *url.URL
+	forwardWrites bool
+	// compressMinSize is the smallest body compressed, negative disables
+	// compression
+	compressMinSize int
+	// collections register the routes added by WithCollection
+	collections []func(chi.Router)
+	// shutdown ends the change streams once closed
+	shutdown <-chan struct{}
+}
+
+func defaultOptions() options {
+	return options{
+		logRequests:     true,
+		compressMinSize: 1024,
+		accessLog: logging.AccessLogOptions{
+			Level:             slog.LevelInfo,
+			SuccessSampleRate: 1,
+		},
+	}
+}
+
+// WithRequestTimeout cancels the request context after d. Zero disables it.
+func WithRequestTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.requestTimeout = d
+	}
+}
+
+// WithRequestLogging toggles the access log.
+func WithRequestLogging(enabled bool) Option {
+	return func(o *options) {
+		o.logRequests = enabled
+	}
+}
+
+// WithAccessLog writes the access log to logger instead of slog.Default.
+func WithAccessLog(logger *slog.Logger, opts logging.AccessLogOptions) Option {
+	return func(o *options) {
+		o.accessLogger = logger
+		o.accessLog = opts
+	}
+}
+
+// WithHealth serves /healthz and /readyz from the checks in registry.
+func WithHealth(registry *health.Registry) Option {
+	return func(o *options) {
+		o.health = registry
+	}
+}
+
+// WithMetrics records request metrics and serves them on /metrics.
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(o *options) {
+		o.metrics = m
+	}
+}
+
+// WithRateLimit limits how often each client can call the /api routes.
+func WithRateLimit(limiter *ratelimit.Limiter) Option {
+	return func(o *options) {
+		o.rateLimiter = limiter
+	}
+}
+
+// WithRateLimitClients limits the given API keys and tenants on their own.
+// The headers are not authenticated otherwise, so any other caller is
+// limited by IP address.
+func WithRateLimitClients(apiKeys, tenants []string) Option {
+	return func(o *options) {
+		o.rateLimitKeys = stringSet(apiKeys)
+		o.rateLimitTenants = stringSet(tenants)
+	}
+}
+
+func stringSet(values []string) map[string]struct{} {
+	s := make(map[string]struct{}, len(values))
+	for _, value := range values {
+		s[value] = struct{}{}
+	}
+
+	return s
+}
+
+// WithIdempotency replays POST /api/users responses for retried requests
+// carrying the same Idempotency-Key header.
+func WithIdempotency(store *idempotency.Store) Option {
+	return func(o *options) {
+		o.idempotency = store
+	}
+}
+
+// WithUpsert makes PUT /api/users/{id} create users that do not exist under
+// the given ID instead of failing with 404.
+func WithUpsert(enabled bool) Option {
+	return func(o *options) {
+		o.allowUpsert = enabled
+	}
+}
+
+// WithCompression compresses response bodies of at least minSize bytes
+// with gzip, zstd or brotli, as accepted by the client. A negative minSize
+// disables compression.
+func WithCompression(minSize int) Option {
+	return func(o *options) {
+		o.compressMinSize = minSize
+	}
+}
+
+// WithGraphQL serves handler on /graphql, behind the same rate limit as the
+// /api routes.
+func WithGraphQL(handler http.Handler) Option {
+	return func(o *options) {
+		o.graphql = handler
+	}
+}
+
+// WithRPC serves handler on /rpc, behind the same rate limit as the /api
+// routes.
+func WithRPC(handler http.Handler) Option {
+	return func(o *options) {
+		o.rpc = handler
+	}
+}
+
+// WithReplication serves the replication endpoints of a leader, see
+// package replication.
+func WithReplication(handler http.Handler) Option {
+	return func(o *options) {
+		o.replication = handler
+	}
+}
+
+// WithCluster serves the admin endpoints of a cluster node, see
+// cluster.Node.Handler.
+func WithCluster(handler http.Handler) Option {
+	return func(o *options) {
+		o.cluster = handler
+	}
+}
+
+// WithWriter makes the handlers change the users through writer instead
+// of the database, e.g. a cluster node committing them to its cluster.
+// Reads are still served by the database, see WithReader.
+func WithWriter(writer database.Writer) Option {
+	return func(o *options) {
+		o.writer = writer
+	}
+}
+
+// WithReader syncs reader before every GET of the REST API, e.g. a cluster
+// node making the reads of its database linearizable. Reads fail with 503
+// while reader cannot sync.
+func WithReader(reader database.Reader) Option {
+	return func(o *options) {
+		o.reader = reader
+	}
+}
+
+// WithShutdown ends the change streams once done is closed. They never end
+// on their own, so http.Server.Shutdown would wait for them until its
+// deadline, see http.Server.RegisterOnShutdown.
+func WithShutdown(done <-chan struct{}) Option {
+	return func(o *options) {
+		o.shutdown = done
+	}
+}
+
+// WithFollower makes the instance a read-only follower of leader. Requests
+// that could change the store are proxied to leader when forward is true,
+// and rejected with 421 otherwise. The GraphQL and JSON-RPC endpoints are
+// left alone, as they also read with POST: give their handlers their own
+// follower option.
+func WithFollower(leader *url.URL, forward bool) Option {
+	return func(o *options) {
+		o.leader = leader
+		o.forwardWrites = forward
+	}
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"main/database"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPatchUser(t *testing.T) {
+	const URL = "/api/users/"
+
+	t.Run("patch a user", func(t *testing.T) {
+		db := setupDB()
+
+		users := db.FindAll(context.Background())
+
+		lastName := "Smith"
+
+		req, err := createRequest(http.MethodPatch, URL+users[0].ID.String(), database.UserPatch{LastName: &lastName})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := makeRequest(db, req)
+
+		response, err := parseResponse[database.DBUser](rec)
+		if err != nil {
+			t.Fatalf("could not parse the response: %v", err)
+		}
+
+		assertStatusCode(t, http.StatusOK, rec.Code)
+
+		expected := users[0]
+		expected.User.LastName = lastName
+
+		assertUser(t, expected, response.Data)
+	})
+
+	t.Run("patch a user with invalid data", func(t *testing.T) {
+		db := setupDB()
+
+		users := db.FindAll(context.Background())
+
+		biography := "too short"
+
+		req, err := createRequest(http.MethodPatch, URL+users[0].ID.String(), database.UserPatch{Biography: &biography})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := makeRequest(db, req)
+
+		response, err := parseResponse[any](rec)
+		if err != nil {
+			t.Fatalf("could not parse the response: %v", err)
+		}
+
+		assertStatusCode(t, http.StatusBadRequest, rec.Code)
+
+		assertErrorMessage(t, ErrInvalidPatchParams.Error(), response.Message)
+	})
+
+	t.Run("patch a user with a malformed id", func(t *testing.T) {
+		db := setupDB()
+
+		req, err := createRequest(http.MethodPatch, URL+"not-an-id", database.UserPatch{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := makeRequest(db, req)
+
+		response, err := parseResponse[any](rec)
+		if err != nil {
+			t.Fatalf("could not parse the response: %v", err)
+		}
+
+		assertStatusCode(t, http.StatusBadRequest, rec.Code)
+
+		assertErrorMessage(t, ErrInvalidUserID.Error(), response.Message)
+	})
+
+	t.Run("patch a user that does not exist", func(t *testing.T) {
+		db := setupDB()
+
+		req, err := createRequest(http.MethodPatch, URL+database.ID{}.NewID().String(), database.UserPatch{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := makeRequest(db, req)
+
+		response, err := parseResponse[any](rec)
+		if err != nil {
+			t.Fatalf("could not parse the response: %v", err)
+		}
+
+		assertStatusCode(t, http.StatusNotFound, rec.Code)
+
+		assertErrorMessage(t, ErrUserNotFound.Error(), response.Message)
+	})
+
+	t.Run("answer 500 when the writer fails unexpectedly", func(t *testing.T) {
+		db := setupDB()
+
+		req, err := createRequest(http.MethodPatch, URL+db.FindAll(context.Background())[0].ID.String(), database.UserPatch{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := httptest.NewRecorder()
+		NewHandler(db, WithWriter(failingWriter{db, errors.New("disk on fire")})).ServeHTTP(rec, req)
+
+		response, err := parseResponse[any](rec)
+		if err != nil {
+			t.Fatalf("could not parse the response: %v", err)
+		}
+
+		assertStatusCode(t, http.StatusInternalServerError, rec.Code)
+
+		assertErrorMessage(t, "internal server error", response.Message)
+	})
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"errors"
+	"main/ratelimit"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var ErrRateLimited = errors.New("too many requests, please retry later")
+
+const (
+	apiKeyHeader = "X-API-Key"
+	tenantHeader = "X-Tenant-ID"
+)
+
+// rateLimit rejects requests once the client has used up its bucket for
+// the class of the request, reporting the remaining quota in the
+// RateLimit-* headers.
+func rateLimit(limiter *ratelimit.Limiter, apiKeys, tenants map[string]struct{}) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			result := limiter.Allow(limitKey(r, limiter, apiKeys, tenants), requestClass(r))
+
+			header := w.Header()
+			header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+			header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			header.Set("RateLimit-Reset", seconds(result.Reset))
+
+			if !result.Allowed {
+				header.Set("Retry-After", seconds(result.RetryAfter))
+				send(
+					w,
+					r,
+					Response[any]{Message: ErrRateLimited.Error()},
+					http.StatusTooManyRequests,
+				)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientKey identifies the caller by API key, then tenant, then IP address.
+func clientKey(r *http.Request) string {
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		return "key:" + key
+	}
+
+	if tenant := r.Header.Get(tenantHeader); tenant != "" {
+		return "tenant:" + tenant
+	}
+
+	return "ip:" + remoteIP(r)
+}
+
+// limitKey is clientKey for the rate limit. Only the configured keys and
+// tenants are trusted, so that callers cannot make up a fresh bucket for
+// every request, and an IP address can only present a few of them.
+func limitKey(r *http.Request, limiter *ratelimit.Limiter, apiKeys, tenants map[string]struct{}) string {
+	ip := "ip:" + remoteIP(r)
+
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		if _, ok := apiKeys[key]; ok {
+			return limiter.Client(ip, "key:"+key)
+		}
+	}
+
+	if tenant := r.Header.Get(tenantHeader); tenant != "" {
+		if _, ok := tenants[tenant]; ok {
+			return limiter.Client(ip, "tenant:"+tenant)
+		}
+	}
+
+	return ip
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+func requestClass(r *http.Request) ratelimit.Class {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return ratelimit.ClassRead
+	default:
+		return ratelimit.ClassWrite
+	}
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"main/database"
+	"main/ratelimit"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRateLimit(t *testing.T) {
+	const URL = "/api/users"
+
+	newHandler := func() http.Handler {
+		return NewHandler(database.NewInMemoryDB(), WithRateLimit(ratelimit.New(ratelimit.Config{
+			Read:           ratelimit.Limit{Rate: 1, Burst: 1},
+			Write:          ratelimit.Limit{Rate: 1, Burst: 1},
+			MaxClients:     10,
+			MaxKeysPerAddr: 2,
+		})), WithRateLimitClients([]string{"first", "second", "third"}, []string{"acme"}))
+	}
+
+	get := func(handler http.Handler, header, value string) int {
+		req := httptest.NewRequest(http.MethodGet, URL, nil)
+		req.Header.Set(header, value)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	t.Run("rejects clients over their limit", func(t *testing.T) {
+		handler := newHandler()
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, URL, nil))
+
+		assertStatusCode(t, http.StatusOK, rec.Code)
+
+		if rec.Header().Get("RateLimit-Remaining") != "0" {
+			t.Errorf("expected no remaining requests, got %q", rec.Header().Get("RateLimit-Remaining"))
+		}
+
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, URL, nil))
+
+		response, err := parseResponse[any](rec)
+		if err != nil {
+			t.Fatalf("could not parse the response: %v", err)
+		}
+
+		assertStatusCode(t, http.StatusTooManyRequests, rec.Code)
+
+		assertErrorMessage(t, ErrRateLimited.Error(), response.Message)
+
+		if rec.Header().Get("Retry-After") != "1" {
+			t.Errorf("expected to retry after %q, got %q", "1", rec.Header().Get("Retry-After"))
+		}
+	})
+
+	t.Run("clients are keyed by API key", func(t *testing.T) {
+		handler := newHandler()
+
+		for _, key := range []string{"first", "second"} {
+			assertStatusCode(t, http.StatusOK, get(handler, apiKeyHeader, key))
+		}
+	})
+
+	t.Run("clients are keyed by tenant", func(t *testing.T) {
+		handler := newHandler()
+
+		assertStatusCode(t, http.StatusOK, get(handler, tenantHeader, "acme"))
+		assertStatusCode(t, http.StatusOK, get(handler, apiKeyHeader, "first"))
+		assertStatusCode(t, http.StatusTooManyRequests, get(handler, tenantHeader, "acme"))
+	})
+
+	t.Run("unknown keys and tenants are keyed by IP", func(t *testing.T) {
+		handler := newHandler()
+
+		assertStatusCode(t, http.StatusOK, get(handler, apiKeyHeader, "made-up"))
+		assertStatusCode(t, http.StatusTooManyRequests, get(handler, apiKeyHeader, "another"))
+		assertStatusCode(t, http.StatusTooManyRequests, get(handler, tenantHeader, "other"))
+	})
+
+	t.Run("keys per IP are bounded", func(t *testing.T) {
+		handler := newHandler()
+
+		assertStatusCode(t, http.StatusOK, get(handler, apiKeyHeader, "first"))
+		assertStatusCode(t, http.StatusOK, get(handler, apiKeyHeader, "second"))
+		// the third key shares the bucket of the IP
+		assertStatusCode(t, http.StatusOK, get(handler, apiKeyHeader, "third"))
+		assertStatusCode(t, http.StatusTooManyRequests, get(handler, tenantHeader, "acme"))
+	})
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"main/database"
+	"net/http"
+)
+
+// syncReads syncs reader before the GET and HEAD requests, so they read
+// the database as of their arrival at least.
+func syncReads(reader database.Reader) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			err := reader.Sync(r.Context())
+			switch {
+			case err == nil:
+				next.ServeHTTP(w, r)
+			case errors.Is(err, database.ErrUnavailable):
+				w.Header().Set("Retry-After", "1")
+				send(w, r, Response[any]{Message: ErrReadsUnavailable.Error()}, http.StatusServiceUnavailable)
+			case errors.Is(err, context.DeadlineExceeded):
+				send(w, r, Response[any]{Message: ErrReadsUnavailable.Error()}, http.StatusGatewayTimeout)
+			case errors.Is(err, context.Canceled):
+				send(w, r, Response[any]{Message: ErrReadsUnavailable.Error()}, http.StatusServiceUnavailable)
+			default:
+				slog.ErrorContext(r.Context(), "could not sync the reads", "error", err)
+				send(w, r, Response[any]{Message: "internal server error"}, http.StatusInternalServerError)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+)
+
+var ErrReadOnlyFollower = errors.New("this instance is a read-only follower, please send writes to the leader")
+
+// LeaderProxy forwards requests to the leader at leader, for followers to
+// send their writes on.
+func LeaderProxy(leader *url.URL) http.Handler {
+	return &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(leader)
+			r.SetXForwarded()
+		},
+	}
+}
+
+// followerWrites sends the requests that could change the store to the
+// leader, or rejects them with 421 when forward is false, so followers only
+// change through replication.
+func followerWrites(leader *url.URL, forward bool) func(http.Handler) http.Handler {
+	proxy := LeaderProxy(leader)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !forward {
+				send(
+					w,
+					r,
+					Response[any]{Message: ErrReadOnlyFollower.Error()},
+					http.StatusMisdirectedRequest,
+				)
+				return
+			}
+
+			proxy.ServeHTTP(w, r)
+		})
+	}
+}
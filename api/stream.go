@@ -0,0 +1,97 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"main/database"
+	"net/http"
+)
+
+// streamBuffer is how much of a streamed response is buffered before it
+// is written out.
+const streamBuffer = 32 << 10
+
+// streamUsers answers a listing as JSON, encoding the users one at a time
+// as they are scanned instead of building the whole response in memory.
+// The body is the same as send writes for a Response[T] with Data and
+// Next.
+//
+// Errors found before the first user, like a bad cursor, are returned so
+// the caller can still answer them. Once the response started, errors can
+// only be logged, and the body is cut short without closing the array so
+// that clients cannot take it for a complete listing.
+func streamUsers(ctx context.Context, w http.ResponseWriter, fields fieldSet, scan func(fn func(user database.DBUser) bool) (next string, err error)) error {
+	var (
+		buf    *bufio.Writer
+		encErr error
+	)
+
+	start := func() {
+		w.Header().Set("Content-Type", jsonCodec.mediaType)
+		w.Header().Add("Vary", "Accept")
+		w.WriteHeader(http.StatusOK)
+		buf = bufio.NewWriterSize(w, streamBuffer)
+	}
+
+	next, err := scan(func(user database.DBUser) bool {
+		var v any = user
+		if fields.sparse {
+			v = fields.project(user)
+		}
+
+		data, err := json.Marshal(v)
+		if err != nil {
+			encErr = err
+			return false
+		}
+
+		if buf == nil {
+			start()
+			buf.WriteString(`{"data":[`)
+		} else {
+			buf.WriteByte(',')
+		}
+
+		// the buffer keeps the first write error, which Flush returns
+		buf.Write(data)
+		return true
+	})
+	if err == nil {
+		err = encErr
+	}
+	if buf == nil && err != nil {
+		return err
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "could not stream the response", "error", err)
+		if err := buf.Flush(); err != nil {
+			slog.ErrorContext(ctx, "could not stream the response", "error", err)
+		}
+		return nil
+	}
+
+	// an empty slice of users is left out, like omitempty does
+	if buf == nil {
+		start()
+		buf.WriteString(`{`)
+	} else {
+		buf.WriteString(`]`)
+		if next != "" {
+			buf.WriteString(`,`)
+		}
+	}
+	if next != "" {
+		data, _ := json.Marshal(next)
+		buf.WriteString(`"next":`)
+		buf.Write(data)
+	}
+	buf.WriteString(`}`)
+
+	if err := buf.Flush(); err != nil {
+		slog.ErrorContext(ctx, "could not stream the response", "error", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var tracer = otel.Tracer("main/api")
+
+// decode reads the body in the format named by the Content-Type header.
+func decode(r *http.Request, v any) error {
+	_, span := tracer.Start(r.Context(), "decode request")
+	defer span.End()
+
+	c, ok := codecs.forContentType(r.Header.Get("Content-Type"))
+	if !ok {
+		span.RecordError(ErrUnsupportedMediaType)
+		span.SetStatus(codes.Error, ErrUnsupportedMediaType.Error())
+		return ErrUnsupportedMediaType
+	}
+
+	if err := c.decode(r.Body, v); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func validateStruct(ctx context.Context, v any) error {
+	_, span := tracer.Start(ctx, "validate")
+	defer span.End()
+
+	if err := validate.StructCtx(ctx, v); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,384 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"main/database"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidTransferFormat = errors.New("please use the csv or ndjson format")
+
+const (
+	mediaTypeCSV    = "text/csv"
+	mediaTypeNDJSON = "application/x-ndjson"
+)
+
+// exportFlushSize is how many users are written between two flushes of the
+// export.
+const exportFlushSize = 500
+
+// maxImportErrors bounds the row errors kept in the import report, the
+// remaining ones are only counted.
+const maxImportErrors = 100
+
+// maxNDJSONLine is the longest NDJSON row accepted.
+const maxNDJSONLine = 1 << 20
+
+var csvHeader = []string{"id", "first_name", "last_name", "biography"}
+
+// transferFormat picks csv or ndjson from the format query parameter, then
+// from the given header, defaulting to ndjson.
+func transferFormat(r *http.Request, header string) (string, error) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		switch format {
+		case "csv", "ndjson":
+			return format, nil
+		default:
+			return "", ErrInvalidTransferFormat
+		}
+	}
+
+	for _, value := range strings.Split(r.Header.Get(header), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+
+		switch mediaType {
+		case mediaTypeCSV:
+			return "csv", nil
+		case mediaTypeNDJSON:
+			return "ndjson", nil
+		}
+	}
+
+	return "ndjson", nil
+}
+
+// ExportUsers godoc
+//
+//	@Summary		Export all users
+//	@Description	Stream every user in insertion order as NDJSON, one database.DBUser per line, or as CSV with an id,first_name,last_name,biography header. The format is taken from the format parameter, then from the Accept header.
+//	@Tags			Users
+//	@Produce		application/x-ndjson
+//	@Produce		text/csv
+//	@Param			format	query		string	false	"csv or ndjson"	Enums(csv, ndjson)
+//	@Success		200		{string}	string
+//	@Failure		400		{object}	Response[any]{message=string}
+//	@Failure		429		{object}	Response[any]{message=string}
+//	@Router			/users/export [get]
+func handleExportUsers(db *database.InMemoryDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format, err := transferFormat(r, "Accept")
+		if err != nil {
+			send(
+				w,
+				r,
+				Response[any]{Message: err.Error()},
+				http.StatusBadRequest,
+			)
+			return
+		}
+
+		var write func(database.DBUser) error
+		flush := func() error { return nil }
+
+		switch format {
+		case "csv":
+			w.Header().Set("Content-Type", mediaTypeCSV)
+			writer := csv.NewWriter(w)
+			write = func(user database.DBUser) error {
+				return writer.Write([]string{user.ID.String(), user.User.FirstName, user.User.LastName, user.User.Biography})
+			}
+			flush = func() error {
+				writer.Flush()
+				return writer.Error()
+			}
+
+			if err := writer.Write(csvHeader); err != nil {
+				slog.Error("could not write the export", "error", err)
+				return
+			}
+		default:
+			w.Header().Set("Content-Type", mediaTypeNDJSON)
+			encoder := json.NewEncoder(w)
+			write = func(user database.DBUser) error {
+				return encoder.Encode(user)
+			}
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "users."+format))
+
+		// a single scan of one version keeps the export consistent with
+		// concurrent writes without holding the lock or every user at once
+		var written int
+		var writeErr error
+		err = db.Scan(r.Context(), database.ListOptions{}, func(user database.DBUser, _ database.Cursor) bool {
+			if writeErr = write(user); writeErr != nil {
+				return false
+			}
+
+			if written++; written%exportFlushSize == 0 {
+				writeErr = flush()
+			}
+			return writeErr == nil
+		})
+		if err != nil {
+			slog.Error("could not list the users", "error", err)
+			return
+		}
+		if writeErr == nil {
+			writeErr = flush()
+		}
+		if writeErr != nil {
+			slog.Error("could not write the export", "error", writeErr)
+		}
+	}
+}
+
+type RowError struct {
+	// Row is the 1-based number of the row, not counting the CSV header.
+	Row     int    `json:"row" xml:"row"`
+	Message string `json:"message" xml:"message"`
+}
+
+type ImportReport struct {
+	DryRun   bool `json:"dry_run" xml:"dry_run"`
+	Rows     int  `json:"rows" xml:"rows"`
+	Imported int  `json:"imported" xml:"imported"`
+	Failed   int  `json:"failed" xml:"failed"`
+	// Errors holds the first row errors, Failed counts all of them.
+	Errors []RowError `json:"errors" xml:"errors>error"`
+}
+
+func (report *ImportReport) fail(row int, message string) {
+	report.Failed++
+	if len(report.Errors) < maxImportErrors {
+		report.Errors = append(report.Errors, RowError{Row: row, Message: message})
+	}
+}
+
+// importRow is a user as exported, the ID is optional.
+type importRow struct {
+	ID   string
+	User database.User
+}
+
+// ImportUsers godoc
+//
+//	@Summary		Import users
+//	@Description	Stream users in the export format, validating and inserting every row. Rows with an ID keep it, the others get a new one. Invalid rows are reported and skipped. With dry_run, rows are only validated.
+//	@Tags			Users
+//	@Accept			application/x-ndjson
+//	@Accept			text/csv
+//	@Produce		json,xml,application/msgpack,application/cbor
+//	@Param			format	query		string	false	"csv or ndjson, defaults to the Content-Type"	Enums(csv, ndjson)
+//	@Param			dry_run	query		bool	false	"Validate without inserting"
+//	@Success		200		{object}	Response[ImportReport]{data=ImportReport}
+//	@Failure		400		{object}	Response[any]{message=string}
+//	@Failure		406		{object}	Response[any]{message=string}
+//	@Failure		429		{object}	Response[any]{message=string}
+//	@Router			/users/import [post]
+func handleImportUsers(db *database.InMemoryDB, writer database.Writer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format, err := transferFormat(r, "Content-Type")
+		if err != nil {
+			send(
+				w,
+				r,
+				Response[any]{Message: err.Error()},
+				http.StatusBadRequest,
+			)
+			return
+		}
+
+		dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+		report := ImportReport{DryRun: dryRun, Errors: []RowError{}}
+		// seen holds the IDs the dry run would have inserted, which later
+		// rows cannot reuse
+		seen := make(map[database.ID]struct{})
+
+		insert := func(row importRow) error {
+			// validate directly, a span per row would flood the trace
+			if err := validate.StructCtx(r.Context(), &row.User); err != nil {
+				return ErrInvalidUserParams
+			}
+
+			var id database.ID
+			if row.ID != "" {
+				if id, err = database.ParseID(row.ID); err != nil {
+					return ErrInvalidUserID
+				}
+			}
+
+			if dryRun {
+				if row.ID == "" {
+					return nil
+				}
+				if _, exists := seen[id]; exists {
+					return database.ErrUserAlreadyExists
+				}
+				if _, exists := db.FindByID(r.Context(), row.ID); exists {
+					return database.ErrUserAlreadyExists
+				}
+				seen[id] = struct{}{}
+				return nil
+			}
+
+			var err error
+			if row.ID != "" {
+				_, err = writer.InsertWithID(r.Context(), row.ID, row.User)
+			} else {
+				_, err = writer.Insert(r.Context(), row.User)
+			}
+			if errors.Is(err, database.ErrStorageFull) {
+				return ErrInsufficientStorage
+			}
+			if errors.Is(err, database.ErrUnavailable) {
+				return ErrWritesUnavailable
+			}
+
+			return err
+		}
+
+		var readErr error
+		if format == "csv" {
+			readErr = importCSV(r.Body, &report, insert)
+		} else {
+			readErr = importNDJSON(r.Body, &report, insert)
+		}
+		if readErr != nil {
+			send(
+				w,
+				r,
+				Response[any]{Message: readErr.Error()},
+				http.StatusBadRequest,
+			)
+			return
+		}
+
+		send(
+			w,
+			r,
+			Response[ImportReport]{Data: report},
+			http.StatusOK,
+		)
+	}
+}
+
+// importCSV reads the rows one by one. It only fails on a missing header
+// or an unreadable body, bad rows are recorded in report.
+func importCSV(body io.Reader, report *ImportReport, insert func(importRow) error) error {
+	reader := csv.NewReader(body)
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("could not read the csv header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range csvHeader[1:] {
+		if _, ok := columns[name]; !ok {
+			return fmt.Errorf("the csv header is missing the %s column", name)
+		}
+	}
+
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		report.Rows++
+
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			report.fail(report.Rows, parseErr.Err.Error())
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("could not read the csv: %w", err)
+		}
+
+		row := importRow{
+			ID: field(record, "id"),
+			User: database.User{
+				FirstName: field(record, "first_name"),
+				LastName:  field(record, "last_name"),
+				Biography: field(record, "biography"),
+			},
+		}
+		if err := insert(row); err != nil {
+			report.fail(report.Rows, err.Error())
+			continue
+		}
+		report.Imported++
+	}
+}
+
+// importNDJSON reads one JSON user per line, blank lines are skipped.
+func importNDJSON(body io.Reader, report *ImportReport, insert func(importRow) error) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxNDJSONLine)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		report.Rows++
+
+		var decoded struct {
+			ID   json.RawMessage `json:"id"`
+			User database.User   `json:"user"`
+		}
+		if err := json.Unmarshal(line, &decoded); err != nil {
+			report.fail(report.Rows, "could not decode the row")
+			continue
+		}
+
+		row := importRow{User: decoded.User}
+		// IDs are exported as arrays of bytes, people may write UUID strings
+		if id := string(decoded.ID); id != "" && id != "null" && id != `""` {
+			var parsed database.ID
+			if err := json.Unmarshal(decoded.ID, &parsed); err != nil {
+				report.fail(report.Rows, ErrInvalidUserID.Error())
+				continue
+			}
+			row.ID = parsed.String()
+		}
+
+		if err := insert(row); err != nil {
+			report.fail(report.Rows, err.Error())
+			continue
+		}
+		report.Imported++
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("could not read the ndjson: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,246 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"main/database"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestExportUsers(t *testing.T) {
+	t.Run("export as ndjson by default", func(t *testing.T) {
+		db := setupDB()
+
+		request, err := http.NewRequest(http.MethodGet, "/api/users/export", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := makeRequest(db, request)
+
+		assertStatusCode(t, http.StatusOK, rec.Code)
+		if contentType := rec.Header().Get("Content-Type"); contentType != mediaTypeNDJSON {
+			t.Fatalf("expected the content type to be %q, got %q", mediaTypeNDJSON, contentType)
+		}
+
+		var exported []database.DBUser
+		scanner := bufio.NewScanner(rec.Body)
+		for scanner.Scan() {
+			var user database.DBUser
+			if err := json.Unmarshal(scanner.Bytes(), &user); err != nil {
+				t.Fatalf("could not decode the line: %v", err)
+			}
+			exported = append(exported, user)
+		}
+
+		if len(exported) != len(users) {
+			t.Fatalf("expected %d users, got %d", len(users), len(exported))
+		}
+		for i, user := range exported {
+			assertUser(t, database.DBUser{User: users[i]}, user)
+		}
+	})
+
+	t.Run("export as csv from the accept header", func(t *testing.T) {
+		db := setupDB()
+
+		request, err := http.NewRequest(http.MethodGet, "/api/users/export", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.Header.Set("Accept", "text/csv")
+
+		rec := makeRequest(db, request)
+
+		assertStatusCode(t, http.StatusOK, rec.Code)
+
+		records, err := csv.NewReader(rec.Body).ReadAll()
+		if err != nil {
+			t.Fatalf("could not read the csv: %v", err)
+		}
+		if len(records) != len(users)+1 {
+			t.Fatalf("expected a header and %d rows, got %d records", len(users), len(records))
+		}
+		if strings.Join(records[0], ",") != "id,first_name,last_name,biography" {
+			t.Fatalf("unexpected header %v", records[0])
+		}
+		if records[2][1] != users[1].FirstName {
+			t.Fatalf("expected the second row to be %v, got %v", users[1], records[2])
+		}
+	})
+
+	t.Run("export more users than a flush", func(t *testing.T) {
+		db := database.NewInMemoryDB()
+		for i := 0; i < exportFlushSize+1; i++ {
+			if _, err := db.Insert(context.Background(), users[0]); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		request, err := http.NewRequest(http.MethodGet, "/api/users/export?format=ndjson", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := makeRequest(db, request)
+
+		if lines := strings.Count(rec.Body.String(), "\n"); lines != exportFlushSize+1 {
+			t.Fatalf("expected %d lines, got %d", exportFlushSize+1, lines)
+		}
+	})
+
+	t.Run("reject unknown formats", func(t *testing.T) {
+		request, err := http.NewRequest(http.MethodGet, "/api/users/export?format=xml", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := makeRequest(setupDB(), request)
+
+		assertStatusCode(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
+func TestImportUsers(t *testing.T) {
+	makeImport := func(t *testing.T, db *database.InMemoryDB, url string, contentType string, body string) ImportReport {
+		t.Helper()
+
+		request, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.Header.Set("Content-Type", contentType)
+
+		rec := makeRequest(db, request)
+		assertStatusCode(t, http.StatusOK, rec.Code)
+
+		response, err := parseResponse[ImportReport](rec)
+		if err != nil {
+			t.Fatalf("could not parse the response: %v", err)
+		}
+
+		return response.Data
+	}
+
+	id := database.ID{}.NewID().String()
+	csvBody := "id,first_name,last_name,biography\n" +
+		id + ",John,Doe,A simple guy who loves to write code.\n" +
+		",Jane,Doe,A nice lady who loves to write code.\n" +
+		",J,Doe,Too short\n" +
+		"not-an-id,Jack,Doe,A simple guy who loves to write code.\n" +
+		",Jill,Doe\n"
+
+	t.Run("import csv and report row errors", func(t *testing.T) {
+		db := database.NewInMemoryDB()
+
+		report := makeImport(t, db, "/api/users/import", "text/csv", csvBody)
+
+		if report.Rows != 5 || report.Imported != 2 || report.Failed != 3 {
+			t.Fatalf("expected 5 rows, 2 imported and 3 failed, got %+v", report)
+		}
+
+		wantRows := []int{3, 4, 5}
+		for i, rowErr := range report.Errors {
+			if rowErr.Row != wantRows[i] {
+				t.Errorf("expected error %d to be on row %d, got %d", i, wantRows[i], rowErr.Row)
+			}
+		}
+		if report.Errors[0].Message != ErrInvalidUserParams.Error() || report.Errors[1].Message != ErrInvalidUserID.Error() {
+			t.Errorf("unexpected row errors %+v", report.Errors)
+		}
+
+		if _, exists := db.FindByID(context.Background(), id); !exists {
+			t.Fatalf("expected the user to keep its id %s", id)
+		}
+		if db.Count() != 2 {
+			t.Fatalf("expected %d users, got %d", 2, db.Count())
+		}
+	})
+
+	t.Run("dry run only validates", func(t *testing.T) {
+		db := database.NewInMemoryDB()
+
+		report := makeImport(t, db, "/api/users/import?dry_run=true", "text/csv", csvBody)
+
+		if !report.DryRun || report.Imported != 2 || report.Failed != 3 {
+			t.Fatalf("expected 2 valid and 3 failed rows, got %+v", report)
+		}
+		if db.Count() != 0 {
+			t.Fatalf("expected no users to be inserted, got %d", db.Count())
+		}
+	})
+
+	t.Run("dry run reports duplicate ids", func(t *testing.T) {
+		db := database.NewInMemoryDB()
+		id := database.ID{}.NewID().String()
+
+		row := `{"id":"` + id + `","user":{"first_name":"John","last_name":"Doe","biography":"A simple guy who loves to write code."}}` + "\n"
+		dryRun := makeImport(t, db, "/api/users/import?dry_run=true", "application/x-ndjson", row+row)
+		imported := makeImport(t, database.NewInMemoryDB(), "/api/users/import", "application/x-ndjson", row+row)
+
+		if dryRun.Imported != imported.Imported || dryRun.Failed != imported.Failed {
+			t.Fatalf("expected the dry run to report %+v, got %+v", imported, dryRun)
+		}
+		if dryRun.Failed != 1 || dryRun.Errors[0].Row != 2 || dryRun.Errors[0].Message != database.ErrUserAlreadyExists.Error() {
+			t.Fatalf("expected the second row to already exist, got %+v", dryRun.Errors)
+		}
+	})
+
+	t.Run("import ndjson", func(t *testing.T) {
+		db := setupDB()
+		existing := db.FindAll(context.Background())
+
+		body := `{"user":{"first_name":"John","last_name":"Doe","biography":"A simple guy who loves to write code."}}` + "\n" +
+			"\n" +
+			`{"id":"` + existing[0].ID.String() + `","user":{"first_name":"John","last_name":"Doe","biography":"A simple guy who loves to write code."}}` + "\n" +
+			`{"user":` + "\n"
+
+		report := makeImport(t, db, "/api/users/import", "application/x-ndjson", body)
+
+		if report.Rows != 3 || report.Imported != 1 || report.Failed != 2 {
+			t.Fatalf("expected 3 rows, 1 imported and 2 failed, got %+v", report)
+		}
+		if report.Errors[0].Message != database.ErrUserAlreadyExists.Error() {
+			t.Errorf("expected the second row to already exist, got %q", report.Errors[0].Message)
+		}
+	})
+
+	t.Run("reject a csv without the required columns", func(t *testing.T) {
+		request, err := http.NewRequest(http.MethodPost, "/api/users/import?format=csv", strings.NewReader("first_name,last_name\nJohn,Doe\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := makeRequest(database.NewInMemoryDB(), request)
+
+		assertStatusCode(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("round trip an export", func(t *testing.T) {
+		source := setupDB()
+
+		for _, format := range []string{"csv", "ndjson"} {
+			request, err := http.NewRequest(http.MethodGet, "/api/users/export?format="+format, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			exported := makeRequest(source, request).Body.String()
+
+			target := database.NewInMemoryDB()
+			report := makeImport(t, target, "/api/users/import?format="+format, "", exported)
+
+			if report.Imported != len(users) || report.Failed != 0 {
+				t.Fatalf("expected every user to be imported from %s, got %+v", format, report)
+			}
+			for _, user := range source.FindAll(context.Background()) {
+				if _, exists := target.FindByID(context.Background(), user.ID.String()); !exists {
+					t.Errorf("expected %s to be imported from %s", user.ID, format)
+				}
+			}
+		}
+	})
+}
@@ -1,8 +1,11 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"main/database"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -12,7 +15,7 @@ func TestUpdateUser(t *testing.T) {
 	t.Run("update a user successfully", func(t *testing.T) {
 		db := setupDB()
 
-		users := db.FindAll()
+		users := db.FindAll(context.Background())
 
 		updatedUser := database.User{
 			FirstName: "updated first name",
@@ -40,7 +43,7 @@ func TestUpdateUser(t *testing.T) {
 	t.Run("update a user with invalid data", func(t *testing.T) {
 		db := setupDB()
 
-		users := db.FindAll()
+		users := db.FindAll(context.Background())
 
 		updatedUser := database.User{
 			FirstName: "updated first name",
@@ -89,4 +92,92 @@ func TestUpdateUser(t *testing.T) {
 
 		assertErrorMessage(t, ErrUserNotFound.Error(), response.Message)
 	})
+
+	t.Run("update a user with a malformed id", func(t *testing.T) {
+		db := setupDB()
+
+		req, err := createRequest(http.MethodPut, URL+"not-a-uuid", users[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := makeRequest(db, req)
+
+		response, err := parseResponse[any](rec)
+		if err != nil {
+			t.Fatalf("could not parse the response: %v", err)
+		}
+
+		assertStatusCode(t, http.StatusBadRequest, rec.Code)
+
+		assertErrorMessage(t, ErrInvalidUserID.Error(), response.Message)
+	})
+
+	t.Run("upsert creates a user with the given id", func(t *testing.T) {
+		db := setupDB()
+
+		id := database.ID{}.NewID()
+
+		req, err := createRequest(http.MethodPut, URL+id.String(), users[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := httptest.NewRecorder()
+		NewHandler(db, WithUpsert(true)).ServeHTTP(rec, req)
+
+		response, err := parseResponse[database.DBUser](rec)
+		if err != nil {
+			t.Fatalf("could not parse the response: %v", err)
+		}
+
+		assertStatusCode(t, http.StatusCreated, rec.Code)
+
+		if response.Data.ID != id {
+			t.Fatalf("expected the id to be %v, got %v", id, response.Data.ID)
+		}
+
+		assertUser(t, database.DBUser{ID: id, User: users[0]}, response.Data)
+	})
+
+	t.Run("upsert replaces an existing user", func(t *testing.T) {
+		db := setupDB()
+
+		dbUsers := db.FindAll(context.Background())
+
+		req, err := createRequest(http.MethodPut, URL+dbUsers[0].ID.String(), users[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := httptest.NewRecorder()
+		NewHandler(db, WithUpsert(true)).ServeHTTP(rec, req)
+
+		assertStatusCode(t, http.StatusOK, rec.Code)
+
+		if db.Count() != len(users) {
+			t.Fatalf("expected %d users, got %d", len(users), db.Count())
+		}
+	})
+
+	t.Run("answer 500 when the writer fails unexpectedly", func(t *testing.T) {
+		db := setupDB()
+
+		req, err := createRequest(http.MethodPut, URL+db.FindAll(context.Background())[0].ID.String(), users[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := httptest.NewRecorder()
+		NewHandler(db, WithWriter(failingWriter{db, errors.New("disk on fire")})).ServeHTTP(rec, req)
+
+		response, err := parseResponse[any](rec)
+		if err != nil {
+			t.Fatalf("could not parse the response: %v", err)
+		}
+
+		assertStatusCode(t, http.StatusInternalServerError, rec.Code)
+
+		assertErrorMessage(t, "internal server error", response.Message)
+	})
 }
@@ -0,0 +1,313 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"main/database"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// response mirrors the api.Response envelope.
+type response[T any] struct {
+	Message string `json:"message,omitempty"`
+	Data    T      `json:"data,omitempty"`
+	Next    string `json:"next,omitempty"`
+}
+
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	apiKey     string
+	maxRetries int
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+type Option func(*Client)
+
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithAPIKey sends key in the X-API-Key header of every request.
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// WithRetries retries idempotent calls up to n times, waiting an
+// exponentially growing, jittered delay starting at backoff.
+func WithRetries(n int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = n
+		c.backoff = backoff
+	}
+}
+
+// New returns a client for the users API served at baseURL, e.g.
+// "http://localhost:8080".
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    baseURL,
+		httpClient: http.DefaultClient,
+		maxRetries: 3,
+		backoff:    100 * time.Millisecond,
+		maxBackoff: 5 * time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Create stores a new user. It sends an Idempotency-Key so the request can
+// be retried without creating duplicates.
+func (c *Client) Create(ctx context.Context, user database.User) (database.DBUser, error) {
+	var resp response[database.DBUser]
+
+	header := http.Header{"Idempotency-Key": []string{uuid.NewString()}}
+	err := c.do(ctx, http.MethodPost, "/api/users", header, user, &resp, true)
+
+	return resp.Data, err
+}
+
+func (c *Client) Get(ctx context.Context, id string) (database.DBUser, error) {
+	var resp response[database.DBUser]
+	err := c.do(ctx, http.MethodGet, "/api/users/"+url.PathEscape(id), nil, nil, &resp, true)
+
+	return resp.Data, err
+}
+
+type Page struct {
+	Users []database.DBUser
+	Next  string
+}
+
+// ListPage fetches a single page of at most limit users, starting after
+// cursor. An empty cursor starts from the beginning.
+func (c *Client) ListPage(ctx context.Context, cursor string, limit int) (Page, error) {
+	query := url.Values{}
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+
+	path := "/api/users"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	var resp response[[]database.DBUser]
+	err := c.do(ctx, http.MethodGet, path, nil, nil, &resp, true)
+
+	return Page{Users: resp.Data, Next: resp.Next}, err
+}
+
+// List iterates over every user, fetching pageSize users at a time.
+func (c *Client) List(pageSize int) *Iterator {
+	return &Iterator{client: c, pageSize: pageSize}
+}
+
+// Update replaces the user stored under id.
+func (c *Client) Update(ctx context.Context, id string, user database.User) (database.DBUser, error) {
+	var resp response[database.DBUser]
+	err := c.do(ctx, http.MethodPut, "/api/users/"+url.PathEscape(id), nil, user, &resp, true)
+
+	return resp.Data, err
+}
+
+// Patch changes only the fields set in patch.
+func (c *Client) Patch(ctx context.Context, id string, patch database.UserPatch) (database.DBUser, error) {
+	var resp response[database.DBUser]
+	err := c.do(ctx, http.MethodPatch, "/api/users/"+url.PathEscape(id), nil, patch, &resp, false)
+
+	return resp.Data, err
+}
+
+// ImportReport mirrors the report of POST /api/users/import.
+type ImportReport struct {
+	Rows     int        `json:"rows"`
+	Imported int        `json:"imported"`
+	Failed   int        `json:"failed"`
+	Errors   []RowError `json:"errors,omitempty"`
+}
+
+// RowError is a row that could not be imported, counted from 1.
+type RowError struct {
+	Row     int    `json:"row"`
+	Message string `json:"message"`
+}
+
+// Import stores users through POST /api/users/import, keeping their IDs.
+// Users without an ID get a new one they are not told about. The rows that
+// fail are listed in the report, the others are still imported. It is not
+// retried, as a retry would report the imported users as duplicates.
+func (c *Client) Import(ctx context.Context, users []database.DBUser) (ImportReport, error) {
+	var payload bytes.Buffer
+	encoder := json.NewEncoder(&payload)
+	for _, user := range users {
+		row := struct {
+			ID   string        `json:"id,omitempty"`
+			User database.User `json:"user"`
+		}{User: user.User}
+		if user.ID != (database.ID{}) {
+			row.ID = user.ID.String()
+		}
+
+		if err := encoder.Encode(row); err != nil {
+			return ImportReport{}, fmt.Errorf("could not marshal the users: %w", err)
+		}
+	}
+
+	var resp response[ImportReport]
+	header := http.Header{"Content-Type": []string{"application/x-ndjson"}}
+	err := c.doPayload(ctx, http.MethodPost, "/api/users/import", header, payload.Bytes(), &resp, false)
+
+	return resp.Data, err
+}
+
+func (c *Client) Delete(ctx context.Context, id string) (database.DBUser, error) {
+	var resp response[database.DBUser]
+	err := c.do(ctx, http.MethodDelete, "/api/users/"+url.PathEscape(id), nil, nil, &resp, true)
+
+	return resp.Data, err
+}
+
+// do sends the request, retrying it when retryable is set and the failure
+// is transient, and decodes the response envelope into out.
+func (c *Client) do(ctx context.Context, method string, path string, header http.Header, body any, out any, retryable bool) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("could not marshal the body: %w", err)
+		}
+	}
+
+	return c.doPayload(ctx, method, path, header, payload, out, retryable)
+}
+
+// doPayload is do with a body that is already encoded.
+func (c *Client) doPayload(ctx context.Context, method string, path string, header http.Header, payload []byte, out any, retryable bool) error {
+	attempts := 1
+	if retryable {
+		attempts += c.maxRetries
+	}
+
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		var retryAfter time.Duration
+		retryAfter, err = c.send(ctx, method, path, header, payload, out)
+		if err == nil || !isTransient(err) || attempt == attempts-1 {
+			return err
+		}
+
+		if err := sleep(ctx, max(retryAfter, c.delay(attempt))); err != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+func (c *Client) send(ctx context.Context, method string, path string, header http.Header, payload []byte, out any) (time.Duration, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return 0, fmt.Errorf("could not create a request: %w", err)
+	}
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if payload != nil && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			return 0, fmt.Errorf("could not decode the response: %w", err)
+		}
+		return 0, nil
+	}
+
+	var failure response[any]
+	// the body is only used to enrich the error, a malformed one is ignored
+	_ = json.NewDecoder(res.Body).Decode(&failure)
+
+	retryAfter := time.Duration(0)
+	if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+		retryAfter = time.Duration(seconds) * time.Second
+	}
+
+	return retryAfter, &APIError{StatusCode: res.StatusCode, Message: failure.Message}
+}
+
+func (c *Client) delay(attempt int) time.Duration {
+	d := c.backoff << attempt
+	if d <= 0 || d > c.maxBackoff {
+		d = c.maxBackoff
+	}
+
+	// full jitter spreads out retries from many clients failing at once
+	return time.Duration(rand.Int64N(int64(d) + 1))
+}
+
+// isTransient reports whether a failed call may succeed if retried.
+func isTransient(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		// network errors, unless the caller gave up
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+
+	switch apiErr.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
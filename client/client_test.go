@@ -0,0 +1,269 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"main/api"
+	"main/database"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var user = database.User{
+	FirstName: "John",
+	LastName:  "Doe",
+	Biography: "A simple guy who loves to write code and play games.",
+}
+
+func newServer(t testing.TB) (*Client, *database.InMemoryDB) {
+	t.Helper()
+
+	db := database.NewInMemoryDB()
+	server := httptest.NewServer(api.NewHandler(db, api.WithRequestLogging(false)))
+	t.Cleanup(server.Close)
+
+	return New(server.URL, WithRetries(3, time.Millisecond)), db
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("create, get, update, patch and delete a user", func(t *testing.T) {
+		c, _ := newServer(t)
+
+		created, err := c.Create(ctx, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := c.Get(ctx, created.ID.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != created {
+			t.Fatalf("expected the user to be %v, got %v", created, got)
+		}
+
+		updated := user
+		updated.LastName = "Smith"
+		if got, err = c.Update(ctx, created.ID.String(), updated); err != nil {
+			t.Fatal(err)
+		}
+		if got.User != updated {
+			t.Fatalf("expected the user to be %v, got %v", updated, got.User)
+		}
+
+		firstName := "Jack"
+		if got, err = c.Patch(ctx, created.ID.String(), database.UserPatch{FirstName: &firstName}); err != nil {
+			t.Fatal(err)
+		}
+		if got.User.FirstName != firstName || got.User.LastName != "Smith" {
+			t.Fatalf("expected only the first name to change, got %v", got.User)
+		}
+
+		if _, err := c.Delete(ctx, created.ID.String()); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := c.Get(ctx, created.ID.String()); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected the error to be %v, got %v", ErrNotFound, err)
+		}
+	})
+
+	t.Run("list every user page by page", func(t *testing.T) {
+		c, db := newServer(t)
+
+		for i := 0; i < 5; i++ {
+			if _, err := db.Insert(ctx, user); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		it := c.List(2)
+
+		var count int
+		for it.Next(ctx) {
+			count++
+		}
+
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if count != 5 {
+			t.Fatalf("expected %d users, got %d", 5, count)
+		}
+	})
+
+	t.Run("list an empty database", func(t *testing.T) {
+		c, _ := newServer(t)
+
+		it := c.List(2)
+		if it.Next(ctx) {
+			t.Fatalf("expected no users")
+		}
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("validation errors are typed", func(t *testing.T) {
+		c, _ := newServer(t)
+
+		_, err := c.Create(ctx, database.User{FirstName: "J"})
+
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || !errors.Is(err, ErrBadRequest) {
+			t.Fatalf("expected the error to be %v, got %v", ErrBadRequest, err)
+		}
+		if apiErr.Message != api.ErrInvalidUserParams.Error() {
+			t.Fatalf("expected the message to be %q, got %q", api.ErrInvalidUserParams, apiErr.Message)
+		}
+	})
+
+	t.Run("import users under their ids", func(t *testing.T) {
+		c, db := newServer(t)
+
+		id := database.ID{}.NewID()
+		report, err := c.Import(ctx, []database.DBUser{
+			{ID: id, User: user},
+			{User: user},
+			{ID: id, User: user},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if report.Rows != 3 || report.Imported != 2 || report.Failed != 1 {
+			t.Fatalf("expected 3 rows, 2 imported and 1 failed, got %+v", report)
+		}
+		if report.Errors[0].Row != 3 {
+			t.Fatalf("expected the third row to fail, got %+v", report.Errors)
+		}
+		if _, exists := db.FindByID(ctx, id.String()); !exists {
+			t.Fatalf("expected the user to keep its id %s", id)
+		}
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		c, db := newServer(t)
+
+		if _, err := db.Insert(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+
+		stats, err := c.Stats(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats != db.Stats() {
+			t.Fatalf("expected the stats to be %+v, got %+v", db.Stats(), stats)
+		}
+	})
+
+	t.Run("watch changes", func(t *testing.T) {
+		c, db := newServer(t)
+
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		// the subscription starts at some point after Watch is called, so
+		// keep writing until a change comes through
+		go func() {
+			ticker := time.NewTicker(10 * time.Millisecond)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					db.Insert(ctx, user)
+				}
+			}
+		}()
+
+		errStop := errors.New("stop")
+		var got database.Change
+		err := c.Watch(ctx, func(change database.Change) error {
+			got = change
+			return errStop
+		})
+		if !errors.Is(err, errStop) {
+			t.Fatalf("expected the error to be %v, got %v", errStop, err)
+		}
+		if got.Type != database.ChangeCreated || got.User.User != user {
+			t.Fatalf("expected a created change for %v, got %+v", user, got)
+		}
+	})
+}
+
+func TestRetries(t *testing.T) {
+	ctx := context.Background()
+
+	flaky := func(failures int32, next http.Handler) (http.Handler, *atomic.Int32) {
+		var calls atomic.Int32
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) <= failures {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(w, r)
+		}), &calls
+	}
+
+	t.Run("idempotent calls are retried", func(t *testing.T) {
+		db := database.NewInMemoryDB()
+		created, err := db.Insert(ctx, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		handler, calls := flaky(2, api.NewHandler(db, api.WithRequestLogging(false)))
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		c := New(server.URL, WithRetries(3, time.Millisecond))
+
+		if _, err := c.Get(ctx, created.ID.String()); err != nil {
+			t.Fatal(err)
+		}
+		if calls.Load() != 3 {
+			t.Fatalf("expected %d calls, got %d", 3, calls.Load())
+		}
+	})
+
+	t.Run("patch is not retried", func(t *testing.T) {
+		handler, calls := flaky(1, api.NewHandler(database.NewInMemoryDB(), api.WithRequestLogging(false)))
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		c := New(server.URL, WithRetries(3, time.Millisecond))
+
+		_, err := c.Patch(ctx, database.ID{}.NewID().String(), database.UserPatch{})
+		if !errors.Is(err, ErrServer) {
+			t.Fatalf("expected the error to be %v, got %v", ErrServer, err)
+		}
+		if calls.Load() != 1 {
+			t.Fatalf("expected %d call, got %d", 1, calls.Load())
+		}
+	})
+
+	t.Run("give up after the last retry", func(t *testing.T) {
+		handler, calls := flaky(10, http.NotFoundHandler())
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		c := New(server.URL, WithRetries(2, time.Millisecond))
+
+		if _, err := c.Delete(ctx, database.ID{}.NewID().String()); !errors.Is(err, ErrServer) {
+			t.Fatalf("expected the error to be %v, got %v", ErrServer, err)
+		}
+		if calls.Load() != 3 {
+			t.Fatalf("expected %d calls, got %d", 3, calls.Load())
+		}
+	})
+}
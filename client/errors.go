@@ -0,0 +1,58 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	ErrBadRequest     = errors.New("bad request")
+	ErrNotFound       = errors.New("user not found")
+	ErrConflict       = errors.New("conflict")
+	ErrUnprocessable  = errors.New("unprocessable request")
+	ErrRateLimited    = errors.New("rate limited")
+	ErrStorageFull    = errors.New("storage full")
+	ErrServer         = errors.New("server error")
+	ErrUnexpectedCode = errors.New("unexpected status code")
+)
+
+// APIError is returned for every non-2xx response. It matches the sentinel
+// errors above with errors.Is, e.g. errors.Is(err, client.ErrNotFound).
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("users api: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+
+	return fmt.Sprintf("users api: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+func (e *APIError) Is(target error) bool {
+	return errorForStatus(e.StatusCode) == target
+}
+
+func errorForStatus(status int) error {
+	switch {
+	case status == http.StatusBadRequest:
+		return ErrBadRequest
+	case status == http.StatusNotFound:
+		return ErrNotFound
+	case status == http.StatusConflict:
+		return ErrConflict
+	case status == http.StatusUnprocessableEntity:
+		return ErrUnprocessable
+	case status == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case status == http.StatusInsufficientStorage:
+		return ErrStorageFull
+	case status >= http.StatusInternalServerError:
+		return ErrServer
+	default:
+		return ErrUnexpectedCode
+	}
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"context"
+	"main/database"
+)
+
+// Iterator walks through every user page by page:
+//
+//	it := c.List(100)
+//	for it.Next(ctx) {
+//		fmt.Println(it.User())
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type Iterator struct {
+	client   *Client
+	pageSize int
+
+	page    []database.DBUser
+	index   int
+	cursor  string
+	started bool
+	done    bool
+	err     error
+}
+
+// Next advances to the next user, fetching the next page when needed. It
+// returns false once every user was visited or an error occurred.
+func (it *Iterator) Next(ctx context.Context) bool {
+	if it.err != nil {
+		return false
+	}
+
+	it.index++
+	for it.index >= len(it.page) {
+		if it.done || (it.started && it.cursor == "") {
+			it.done = true
+			return false
+		}
+
+		page, err := it.client.ListPage(ctx, it.cursor, it.pageSize)
+		if err != nil {
+			it.err = err
+			return false
+		}
+
+		it.started = true
+		it.page, it.index, it.cursor = page.Users, 0, page.Next
+		if page.Next == "" && len(page.Users) == 0 {
+			it.done = true
+			return false
+		}
+	}
+
+	return true
+}
+
+// User returns the current user, it is only valid after Next returned true.
+func (it *Iterator) User() database.DBUser {
+	return it.page[it.index]
+}
+
+func (it *Iterator) Err() error {
+	return it.err
+}
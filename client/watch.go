@@ -0,0 +1,82 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"main/database"
+	"net/http"
+	"strings"
+)
+
+func (c *Client) Stats(ctx context.Context) (database.Stats, error) {
+	var resp response[database.Stats]
+	err := c.do(ctx, http.MethodGet, "/api/stats", nil, nil, &resp, true)
+
+	return resp.Data, err
+}
+
+// Watch calls fn with every change made to the users until ctx is done, fn
+// returns an error or the server ends the stream. A stream ended by the
+// server returns nil, the caller decides whether to reconnect.
+func (c *Client) Watch(ctx context.Context, fn func(database.Change) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/api/users/changes", nil)
+	if err != nil {
+		return fmt.Errorf("could not create a request: %w", err)
+	}
+
+	req.Header.Set("Accept", "text/event-stream")
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		var failure response[any]
+		// the body is only used to enrich the error, a malformed one is ignored
+		_ = json.NewDecoder(res.Body).Decode(&failure)
+
+		return &APIError{StatusCode: res.StatusCode, Message: failure.Message}
+	}
+
+	var data strings.Builder
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch {
+		case line == "":
+			// a blank line dispatches the event
+			if data.Len() == 0 {
+				continue
+			}
+
+			var change database.Change
+			if err := json.Unmarshal([]byte(data.String()), &change); err != nil {
+				return fmt.Errorf("could not decode the change: %w", err)
+			}
+			data.Reset()
+
+			if err := fn(change); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return scanner.Err()
+}
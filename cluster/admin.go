@@ -0,0 +1,97 @@
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/hashicorp/raft"
+)
+
+const MembersPath = "/cluster/members"
+
+// member is a member of the cluster as listed by the admin endpoints.
+type member struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+	Voter   bool   `json:"voter"`
+	Leader  bool   `json:"leader"`
+}
+
+// Handler returns the admin endpoints changing the members of the cluster.
+// GET /cluster/members lists them, PUT /cluster/members/{id} with the
+// {"address": ...} of a node adds it as a voter and DELETE
+// /cluster/members/{id} removes it. Changes must be sent to the leader, the
+// other nodes answer 503.
+func (n *Node) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+MembersPath, n.handleMembers)
+	mux.HandleFunc("PUT "+MembersPath+"/{id}", n.handleJoin)
+	mux.HandleFunc("DELETE "+MembersPath+"/{id}", n.handleLeave)
+
+	return mux
+}
+
+func (n *Node) handleMembers(w http.ResponseWriter, r *http.Request) {
+	servers, err := n.Servers()
+	if err != nil {
+		sendMembershipError(w, err)
+		return
+	}
+
+	leader := n.Leader()
+	members := make([]member, 0, len(servers))
+	for _, server := range servers {
+		members = append(members, member{
+			ID:      string(server.ID),
+			Address: string(server.Address),
+			Voter:   server.Suffrage == raft.Voter,
+			Leader:  string(server.Address) == leader,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(members); err != nil {
+		slog.Error("could not write the cluster members", "error", err)
+	}
+}
+
+func (n *Node) handleJoin(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Address string `json:"address"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Address == "" {
+		http.Error(w, "please provide the address of the node", http.StatusBadRequest)
+		return
+	}
+
+	if err := n.Join(r.Context(), r.PathValue("id"), body.Address); err != nil {
+		sendMembershipError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (n *Node) handleLeave(w http.ResponseWriter, r *http.Request) {
+	if err := n.Leave(r.Context(), r.PathValue("id")); err != nil {
+		sendMembershipError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sendMembershipError tells clients to retry the changes sent to a node
+// that does not lead the cluster, once it elected its leader.
+func sendMembershipError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrNotLeader) {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	slog.Error("could not change the cluster members", "error", err)
+	http.Error(w, "internal server error", http.StatusInternalServerError)
+}
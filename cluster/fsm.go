@@ -0,0 +1,119 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"main/database"
+	"main/health"
+
+	"github.com/hashicorp/raft"
+)
+
+type op string
+
+const (
+	opInsert op = "insert"
+	opUpsert op = "upsert"
+	opUpdate op = "update"
+	opPatch  op = "patch"
+	opDelete op = "delete"
+	// opFollow and opUnfollow carry the follower in ID.
+	opFollow   op = "follow"
+	opUnfollow op = "unfollow"
+)
+
+// command is a write as stored in the Raft log. It carries the ID of new
+// users so every node stores them under the same one.
+type command struct {
+	Op    op                 `json:"op"`
+	ID    string             `json:"id"`
+	User  database.User      `json:"user,omitempty"`
+	Patch database.UserPatch `json:"patch,omitempty"`
+	// Target is the followee of follow commands.
+	Target string `json:"target,omitempty"`
+}
+
+// result is what applying a command returned on this node.
+type result struct {
+	user    database.DBUser
+	created bool
+	err     error
+}
+
+// fsm applies the committed commands to the database, in log order on
+// every node.
+type fsm struct {
+	db *database.InMemoryDB
+	// health is told the node is not ready while it restores a snapshot,
+	// when set
+	health *health.Registry
+}
+
+func (f *fsm) Apply(log *raft.Log) any {
+	var cmd command
+	if err := json.Unmarshal(log.Data, &cmd); err != nil {
+		return result{err: fmt.Errorf("could not decode the command: %w", err)}
+	}
+
+	ctx := context.Background()
+
+	var r result
+	switch cmd.Op {
+	case opInsert:
+		r.user, r.err = f.db.InsertWithID(ctx, cmd.ID, cmd.User)
+		r.created = r.err == nil
+	case opUpsert:
+		r.user, r.created, r.err = f.db.Upsert(ctx, cmd.ID, cmd.User)
+	case opUpdate:
+		r.user, r.err = f.db.Update(ctx, cmd.ID, cmd.User)
+	case opPatch:
+		r.user, r.err = f.db.Patch(ctx, cmd.ID, cmd.Patch)
+	case opDelete:
+		r.user, r.err = f.db.Delete(ctx, cmd.ID)
+	case opFollow:
+		r.created, r.err = f.db.Follow(ctx, cmd.ID, cmd.Target)
+	case opUnfollow:
+		r.err = f.db.Unfollow(ctx, cmd.ID, cmd.Target)
+	default:
+		r.err = fmt.Errorf("unknown command %q", cmd.Op)
+	}
+
+	return r
+}
+
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	return snapshot(f.db.Snapshot(context.Background())), nil
+}
+
+func (f *fsm) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+
+	if f.health != nil {
+		f.health.SetNotReady("cluster_snapshot", "restoring a snapshot of the cluster")
+		defer f.health.SetReady("cluster_snapshot")
+	}
+
+	var s database.Snapshot
+	if err := json.NewDecoder(rc).Decode(&s); err != nil {
+		return fmt.Errorf("could not decode the snapshot: %w", err)
+	}
+
+	f.db.Restore(context.Background(), s)
+
+	return nil
+}
+
+type snapshot database.Snapshot
+
+func (s snapshot) Persist(sink raft.SnapshotSink) error {
+	if err := json.NewEncoder(sink).Encode(database.Snapshot(s)); err != nil {
+		sink.Cancel()
+		return fmt.Errorf("could not write the snapshot: %w", err)
+	}
+
+	return sink.Close()
+}
+
+func (s snapshot) Release() {}
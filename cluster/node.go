@@ -0,0 +1,288 @@
+// Package cluster replicates the users with Raft. Writes go through the
+// leader and are acknowledged once a quorum of nodes stored them, so they
+// survive the loss of a minority of nodes, and reads can be made
+// linearizable. Nodes join and leave the cluster through the admin
+// endpoints of Node.Handler.
+//
+// Every node applies the same writes in the same order to its own
+// database, which must therefore use database.EvictReject: the other
+// policies depend on the reads served by each node and would make the
+// nodes diverge.
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"main/database"
+	"main/health"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/raft"
+)
+
+// ErrNotLeader is a database.ErrUnavailable, so the APIs tell clients to
+// retry their writes.
+var ErrNotLeader = fmt.Errorf("%w: this node is not the leader of the cluster", database.ErrUnavailable)
+
+var (
+	_ database.Writer = (*Node)(nil)
+	_ database.Reader = (*Node)(nil)
+)
+
+var ErrNoLeader = errors.New("the cluster has no leader")
+
+// defaultApplyTimeout bounds how long a write waits to be committed when
+// the context has no deadline.
+const defaultApplyTimeout = 10 * time.Second
+
+// Node is a member of the cluster, serving its own copy of the users.
+type Node struct {
+	raft *raft.Raft
+	db   *database.InMemoryDB
+}
+
+type Option func(*options)
+
+type options struct {
+	config    *raft.Config
+	logs      raft.LogStore
+	stable    raft.StableStore
+	snapshots raft.SnapshotStore
+	health    *health.Registry
+}
+
+// WithHealth marks the node not ready in registry while it restores a
+// snapshot of the cluster, under the name "cluster_snapshot".
+func WithHealth(registry *health.Registry) Option {
+	return func(o *options) {
+		o.health = registry
+	}
+}
+
+// WithRaftConfig lets fn change the Raft configuration, e.g. its timeouts.
+func WithRaftConfig(fn func(*raft.Config)) Option {
+	return func(o *options) {
+		fn(o.config)
+	}
+}
+
+// WithStores keeps the Raft log, state and snapshots in the given stores
+// instead of in memory.
+func WithStores(logs raft.LogStore, stable raft.StableStore, snapshots raft.SnapshotStore) Option {
+	return func(o *options) {
+		o.logs = logs
+		o.stable = stable
+		o.snapshots = snapshots
+	}
+}
+
+// NewNode starts the node id, talking to the others over transport and
+// applying the committed writes to db. The node waits for a leader until
+// it is bootstrapped or joined to a cluster.
+func NewNode(id string, transport raft.Transport, db *database.InMemoryDB, opts ...Option) (*Node, error) {
+	config := raft.DefaultConfig()
+	config.LocalID = raft.ServerID(id)
+	config.LogLevel = "WARN"
+
+	inmem := raft.NewInmemStore()
+	o := options{
+		config:    config,
+		logs:      inmem,
+		stable:    inmem,
+		snapshots: raft.NewInmemSnapshotStore(),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	r, err := raft.NewRaft(o.config, &fsm{db: db, health: o.health}, o.logs, o.stable, o.snapshots, transport)
+	if err != nil {
+		return nil, fmt.Errorf("could not start the raft node: %w", err)
+	}
+
+	return &Node{raft: r, db: db}, nil
+}
+
+// Bootstrap makes the node the first member of a new cluster with the
+// given servers, which must include it.
+func (n *Node) Bootstrap(servers ...raft.Server) error {
+	return n.raft.BootstrapCluster(raft.Configuration{Servers: servers}).Error()
+}
+
+// Join adds the node id listening on addr to the cluster as a voter. It
+// must be called on the leader.
+func (n *Node) Join(ctx context.Context, id string, addr string) error {
+	return n.wait(ctx, n.raft.AddVoter(raft.ServerID(id), raft.ServerAddress(addr), 0, timeout(ctx)))
+}
+
+// Leave removes the node id from the cluster. It must be called on the
+// leader.
+func (n *Node) Leave(ctx context.Context, id string) error {
+	return n.wait(ctx, n.raft.RemoveServer(raft.ServerID(id), 0, timeout(ctx)))
+}
+
+// Servers returns the current members of the cluster.
+func (n *Node) Servers() ([]raft.Server, error) {
+	future := n.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return nil, err
+	}
+
+	return future.Configuration().Servers, nil
+}
+
+// IsLeader reports whether this node currently accepts writes.
+func (n *Node) IsLeader() bool {
+	return n.raft.State() == raft.Leader
+}
+
+// Leader returns the address of the current leader, empty if unknown.
+func (n *Node) Leader() string {
+	addr, _ := n.raft.LeaderWithID()
+	return string(addr)
+}
+
+// Snapshot compacts the Raft log into a snapshot of the users.
+func (n *Node) Snapshot() error {
+	return n.raft.Snapshot().Error()
+}
+
+// Shutdown stops the node, which leaves the cluster configuration as is.
+func (n *Node) Shutdown() error {
+	return n.raft.Shutdown().Error()
+}
+
+// DB returns the local copy of the users. Reading it directly may return
+// stale data, see Sync, FindByID and List for linearizable reads.
+func (n *Node) DB() *database.InMemoryDB {
+	return n.db
+}
+
+func (n *Node) Insert(ctx context.Context, user database.User) (database.DBUser, error) {
+	return n.InsertWithID(ctx, uuid.NewString(), user)
+}
+
+// InsertWithID stores user under a caller-provided ID.
+func (n *Node) InsertWithID(ctx context.Context, id string, user database.User) (database.DBUser, error) {
+	r, err := n.apply(ctx, command{Op: opInsert, ID: id, User: user})
+	return r.user, err
+}
+
+// Upsert replaces the user stored under id, creating it if it does not
+// exist. created reports which of the two happened.
+func (n *Node) Upsert(ctx context.Context, id string, user database.User) (database.DBUser, bool, error) {
+	r, err := n.apply(ctx, command{Op: opUpsert, ID: id, User: user})
+	return r.user, r.created, err
+}
+
+func (n *Node) Update(ctx context.Context, id string, user database.User) (database.DBUser, error) {
+	r, err := n.apply(ctx, command{Op: opUpdate, ID: id, User: user})
+	return r.user, err
+}
+
+// Patch changes the fields set in patch, on the user as committed, so
+// concurrent patches of different fields are not lost.
+func (n *Node) Patch(ctx context.Context, id string, patch database.UserPatch) (database.DBUser, error) {
+	r, err := n.apply(ctx, command{Op: opPatch, ID: id, Patch: patch})
+	return r.user, err
+}
+
+func (n *Node) Delete(ctx context.Context, id string) (database.DBUser, error) {
+	r, err := n.apply(ctx, command{Op: opDelete, ID: id})
+	return r.user, err
+}
+
+// Follow makes follower follow followee. created is false when follower
+// already followed followee.
+func (n *Node) Follow(ctx context.Context, follower, followee string) (created bool, err error) {
+	r, err := n.apply(ctx, command{Op: opFollow, ID: follower, Target: followee})
+	return r.created, err
+}
+
+func (n *Node) Unfollow(ctx context.Context, follower, followee string) error {
+	_, err := n.apply(ctx, command{Op: opUnfollow, ID: follower, Target: followee})
+	return err
+}
+
+// Check fails while the node knows of no leader, so it cannot take writes
+// even by forwarding them.
+func (n *Node) Check(context.Context) error {
+	if n.Leader() == "" {
+		return ErrNoLeader
+	}
+
+	return nil
+}
+
+// FindByID returns the user as of a moment between the call and its
+// return, failing on nodes that cannot confirm they lead the cluster.
+func (n *Node) FindByID(ctx context.Context, id string) (database.DBUser, bool, error) {
+	if err := n.Sync(ctx); err != nil {
+		return database.DBUser{}, false, err
+	}
+
+	user, exists := n.db.FindByID(ctx, id)
+	return user, exists, nil
+}
+
+// List is the linearizable counterpart of database.InMemoryDB.List.
+func (n *Node) List(ctx context.Context, opts database.ListOptions) (database.Page, error) {
+	if err := n.Sync(ctx); err != nil {
+		return database.Page{}, err
+	}
+
+	return n.db.List(ctx, opts)
+}
+
+// Sync waits until every write committed before the call is applied
+// locally, so the reads of DB that follow are linearizable. It commits an
+// entry itself, which a deposed leader cannot, so it fails with
+// ErrNotLeader on every node but the leader.
+func (n *Node) Sync(ctx context.Context) error {
+	return n.wait(ctx, n.raft.Barrier(timeout(ctx)))
+}
+
+func (n *Node) apply(ctx context.Context, cmd command) (result, error) {
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return result{}, err
+	}
+
+	future := n.raft.ApplyLog(raft.Log{Data: data}, timeout(ctx))
+	if err := n.wait(ctx, future); err != nil {
+		return result{}, err
+	}
+
+	r := future.Response().(result)
+	return r, r.err
+}
+
+// wait returns the error of future, or the error of ctx if it is done
+// first. In the latter case the operation may still complete.
+func (n *Node) wait(ctx context.Context, future raft.Future) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- future.Error()
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-errCh:
+		if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+			return fmt.Errorf("%w: %w", ErrNotLeader, err)
+		}
+		return err
+	}
+}
+
+func timeout(ctx context.Context) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok {
+		return time.Until(deadline)
+	}
+
+	return defaultApplyTimeout
+}
@@ -0,0 +1,406 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"main/database"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+func newUser(name string) database.User {
+	return database.User{
+		FirstName: name,
+		LastName:  "Doe",
+		Biography: "A simple guy who loves to write code and play games.",
+	}
+}
+
+// testCluster runs nodes talking over in-memory transports the test can
+// cut to simulate partitions.
+type testCluster struct {
+	t          testing.TB
+	nodes      []*Node
+	transports []*raft.InmemTransport
+}
+
+func fastConfig(c *raft.Config) {
+	c.HeartbeatTimeout = 50 * time.Millisecond
+	c.ElectionTimeout = 50 * time.Millisecond
+	c.LeaderLeaseTimeout = 50 * time.Millisecond
+	c.CommitTimeout = 5 * time.Millisecond
+	c.LogOutput = io.Discard
+	// drop the log on snapshots, so lagging nodes have to install them
+	c.TrailingLogs = 0
+}
+
+func newCluster(t testing.TB, size int) *testCluster {
+	t.Helper()
+
+	c := &testCluster{t: t}
+
+	var servers []raft.Server
+	for i := 0; i < size; i++ {
+		c.add()
+		servers = append(servers, raft.Server{
+			ID:      raft.ServerID(fmt.Sprint(i)),
+			Address: c.transports[i].LocalAddr(),
+		})
+	}
+
+	if err := c.nodes[0].Bootstrap(servers...); err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+// add starts a node connected to every other one, which is not a member
+// of the cluster until it joins.
+func (c *testCluster) add(opts ...Option) *Node {
+	c.t.Helper()
+
+	id := len(c.nodes)
+	_, transport := raft.NewInmemTransport(raft.ServerAddress(fmt.Sprint("node-", id)))
+	for _, other := range c.transports {
+		transport.Connect(other.LocalAddr(), other)
+		other.Connect(transport.LocalAddr(), transport)
+	}
+
+	node, err := NewNode(fmt.Sprint(id), transport, database.NewInMemoryDB(), append([]Option{WithRaftConfig(fastConfig)}, opts...)...)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.t.Cleanup(func() { node.Shutdown() })
+
+	c.nodes = append(c.nodes, node)
+	c.transports = append(c.transports, transport)
+
+	return node
+}
+
+// isolate cuts node i from every other node.
+func (c *testCluster) isolate(i int) {
+	for j, other := range c.transports {
+		if j != i {
+			c.transports[i].Disconnect(other.LocalAddr())
+			other.Disconnect(c.transports[i].LocalAddr())
+		}
+	}
+}
+
+func (c *testCluster) heal() {
+	for i, transport := range c.transports {
+		for j, other := range c.transports {
+			if i != j {
+				transport.Connect(other.LocalAddr(), other)
+			}
+		}
+	}
+}
+
+// leader waits for a leader among the nodes not excluded.
+func (c *testCluster) leader(excluded ...int) (int, *Node) {
+	c.t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+	nodes:
+		for i, node := range c.nodes {
+			for _, e := range excluded {
+				if i == e {
+					continue nodes
+				}
+			}
+			if node.IsLeader() {
+				return i, node
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	c.t.Fatalf("no leader was elected")
+	return 0, nil
+}
+
+// onLeader calls fn with the current leader until it succeeds. The short
+// timeouts let leadership change hands between finding the leader and
+// calling it, especially under the race detector, so fn is tried again on
+// the new leader when it fails with ErrNotLeader.
+func (c *testCluster) onLeader(fn func(leader *Node) error) {
+	c.t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, leader := c.leader()
+		err := fn(leader)
+		if err == nil {
+			return
+		}
+		if !errors.Is(err, ErrNotLeader) || time.Now().After(deadline) {
+			c.t.Fatal(err)
+		}
+	}
+}
+
+// waitFor waits until every node in nodes has a user stored under id, or
+// none if want is false.
+func waitFor(t testing.TB, id string, want bool, nodes ...*Node) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for _, node := range nodes {
+		for {
+			if _, exists := node.DB().FindByID(context.Background(), id); exists == want {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s to be stored: %v", id, want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestCluster(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("commit writes on every node", func(t *testing.T) {
+		c := newCluster(t, 3)
+		_, leader := c.leader()
+
+		user, err := leader.Insert(ctx, newUser("John"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, user.ID.String(), true, c.nodes...)
+
+		if _, err := leader.Patch(ctx, user.ID.String(), database.UserPatch{FirstName: ptr("Jack")}); err != nil {
+			t.Fatal(err)
+		}
+		got, exists, err := leader.FindByID(ctx, user.ID.String())
+		if err != nil || !exists || got.User.FirstName != "Jack" || got.User.Biography != user.User.Biography {
+			t.Fatalf("expected the patched user, got %+v, %v and %v", got, exists, err)
+		}
+
+		if _, err := leader.Delete(ctx, user.ID.String()); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, user.ID.String(), false, c.nodes...)
+
+		if _, err := leader.Update(ctx, user.ID.String(), newUser("Jane")); !errors.Is(err, database.ErrUserDoesNotExist) {
+			t.Fatalf("expected %v, got %v", database.ErrUserDoesNotExist, err)
+		}
+	})
+
+	t.Run("commit follows on every node", func(t *testing.T) {
+		c := newCluster(t, 3)
+		_, leader := c.leader()
+
+		john, err := leader.Insert(ctx, newUser("John"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		jane, err := leader.Insert(ctx, newUser("Jane"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := leader.Follow(ctx, john.ID.String(), jane.ID.String()); err != nil {
+			t.Fatal(err)
+		}
+
+		deadline := time.Now().Add(5 * time.Second)
+		for _, node := range c.nodes {
+			for {
+				page, err := node.DB().Followers(ctx, jane.ID.String(), database.ListOptions{})
+				if err == nil && len(page.Users) == 1 && page.Users[0].ID == john.ID {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("timed out waiting for the follow to be applied, got %+v and %v", page.Users, err)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+
+		if err := leader.Unfollow(ctx, john.ID.String(), jane.ID.String()); err != nil {
+			t.Fatal(err)
+		}
+		if err := leader.Unfollow(ctx, john.ID.String(), jane.ID.String()); !errors.Is(err, database.ErrNotFollowing) {
+			t.Fatalf("expected %v, got %v", database.ErrNotFollowing, err)
+		}
+	})
+
+	t.Run("reject writes and reads on followers", func(t *testing.T) {
+		c := newCluster(t, 3)
+		i, _ := c.leader()
+		follower := c.nodes[(i+1)%3]
+
+		if _, err := follower.Insert(ctx, newUser("John")); !errors.Is(err, ErrNotLeader) {
+			t.Fatalf("expected %v, got %v", ErrNotLeader, err)
+		}
+		if _, err := follower.List(ctx, database.ListOptions{}); !errors.Is(err, ErrNotLeader) {
+			t.Fatalf("expected %v, got %v", ErrNotLeader, err)
+		}
+		if follower.Leader() == "" {
+			t.Fatalf("expected the follower to know the leader")
+		}
+	})
+
+	t.Run("keep acknowledged writes when the leader is partitioned", func(t *testing.T) {
+		c := newCluster(t, 3)
+		old, leader := c.leader()
+
+		acked, err := leader.Insert(ctx, newUser("John"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.isolate(old)
+		_, next := c.leader(old)
+
+		// the majority side lost nothing and keeps taking writes
+		if _, exists, err := next.FindByID(ctx, acked.ID.String()); err != nil || !exists {
+			t.Fatalf("expected the acknowledged write to survive, got %v and %v", exists, err)
+		}
+		after, err := next.Insert(ctx, newUser("Jane"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the minority side can neither commit nor read
+		timeoutCtx, cancel := context.WithTimeout(ctx, 300*time.Millisecond)
+		defer cancel()
+		if _, err := leader.Insert(timeoutCtx, newUser("Jack")); err == nil {
+			t.Fatalf("expected the isolated leader not to commit")
+		}
+		if _, _, err := leader.FindByID(timeoutCtx, acked.ID.String()); err == nil {
+			t.Fatalf("expected the isolated leader not to serve linearizable reads")
+		}
+
+		c.heal()
+		waitFor(t, after.ID.String(), true, c.nodes...)
+	})
+
+	t.Run("catch up new members from a snapshot", func(t *testing.T) {
+		c := newCluster(t, 3)
+
+		var last string
+		for _, name := range []string{"John", "Jane", "Jack"} {
+			// a write failing as leadership is lost may still be committed,
+			// the ID makes retrying it harmless
+			last = database.ID{}.NewID().String()
+			c.onLeader(func(leader *Node) error {
+				_, err := leader.InsertWithID(ctx, last, newUser(name))
+				if errors.Is(err, database.ErrUserAlreadyExists) {
+					return nil
+				}
+				return err
+			})
+		}
+
+		// the log is only dropped on the node taking the snapshot, which has
+		// to be the one adding the new member
+		node := c.add()
+		c.onLeader(func(leader *Node) error {
+			if err := leader.Snapshot(); err != nil {
+				return err
+			}
+			return leader.Join(ctx, "3", string(c.transports[3].LocalAddr()))
+		})
+		waitFor(t, last, true, node)
+		if n := node.DB().Count(); n != 3 {
+			t.Fatalf("expected the new member to hold 3 users, got %d", n)
+		}
+		// the users are restored before the snapshot is recorded
+		deadline := time.Now().Add(5 * time.Second)
+		for node.raft.Stats()["last_snapshot_index"] == "0" {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected the new member to install a snapshot")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		var servers []raft.Server
+		c.onLeader(func(leader *Node) (err error) {
+			if err := leader.Leave(ctx, "3"); err != nil {
+				return err
+			}
+			servers, err = leader.Servers()
+			return err
+		})
+		if len(servers) != 3 {
+			t.Fatalf("expected 3 members after the new one left, got %d", len(servers))
+		}
+	})
+
+	t.Run("change the members through the admin endpoints", func(t *testing.T) {
+		c := newCluster(t, 3)
+		c.add()
+
+		// admin sends a request to the leader, and tries again on the next
+		// one while it answers 503
+		admin := func(method, path, body string) *http.Response {
+			var res *http.Response
+			c.onLeader(func(leader *Node) error {
+				server := httptest.NewServer(leader.Handler())
+				defer server.Close()
+
+				req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+				if err != nil {
+					return err
+				}
+				res, err = http.DefaultClient.Do(req)
+				if err != nil {
+					return err
+				}
+				if res.StatusCode == http.StatusServiceUnavailable {
+					res.Body.Close()
+					return ErrNotLeader
+				}
+				return nil
+			})
+			t.Cleanup(func() { res.Body.Close() })
+
+			return res
+		}
+
+		res := admin(http.MethodPut, MembersPath+"/3", `{"address":"`+string(c.transports[3].LocalAddr())+`"}`)
+		if res.StatusCode != http.StatusNoContent {
+			t.Fatalf("expected the node to join, got %d", res.StatusCode)
+		}
+
+		var members []member
+		if err := json.NewDecoder(admin(http.MethodGet, MembersPath, "").Body).Decode(&members); err != nil {
+			t.Fatal(err)
+		}
+		if len(members) != 4 || members[3].ID != "3" || !members[3].Voter {
+			t.Fatalf("expected the node to be listed as a voter, got %+v", members)
+		}
+
+		res = admin(http.MethodDelete, MembersPath+"/3", "")
+		if res.StatusCode != http.StatusNoContent {
+			t.Fatalf("expected the node to leave, got %d", res.StatusCode)
+		}
+
+		c.onLeader(func(leader *Node) error {
+			servers, err := leader.Servers()
+			if err == nil && len(servers) != 3 {
+				t.Fatalf("expected 3 members after the node left, got %d", len(servers))
+			}
+			return err
+		})
+	})
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"main/database"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// flagSet returns a flag set for a subcommand, reporting errors to stderr.
+func (e *env) flagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet("usersctl "+name, flag.ContinueOnError)
+	flags.SetOutput(e.stderr)
+
+	return flags
+}
+
+// parseArgs parses flags that may come before or after the positional
+// arguments, so both "update -last-name Doe <id>" and "update <id>
+// -last-name Doe" work, and checks there are exactly want positional ones.
+func parseArgs(flags *flag.FlagSet, args []string, want ...string) ([]string, error) {
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(positional) != len(want) {
+		if len(want) == 0 {
+			return nil, usageError(fmt.Sprintf("%s takes no arguments", flags.Name()))
+		}
+		return nil, usageError(fmt.Sprintf("%s takes %s", flags.Name(), strings.Join(want, " ")))
+	}
+
+	return positional, nil
+}
+
+func parseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, err
+			}
+			return nil, usageError(err.Error())
+		}
+		if flags.NArg() == 0 {
+			return positional, nil
+		}
+
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
+
+func listUsers(ctx context.Context, e *env, args []string) error {
+	flags := e.flagSet("list")
+	pageSize := flags.Int("page-size", 100, "number of users fetched per request")
+	if _, err := parseArgs(flags, args); err != nil {
+		return err
+	}
+
+	users := []database.DBUser{}
+	it := e.client.List(*pageSize)
+	for it.Next(ctx) {
+		users = append(users, it.User())
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+
+	return e.out.users(users)
+}
+
+func getUser(ctx context.Context, e *env, args []string) error {
+	positional, err := parseArgs(e.flagSet("get"), args, "<id>")
+	if err != nil {
+		return err
+	}
+
+	user, err := e.client.Get(ctx, positional[0])
+	if err != nil {
+		return err
+	}
+
+	return e.out.user(user)
+}
+
+func createUser(ctx context.Context, e *env, args []string) error {
+	flags := e.flagSet("create")
+	var user database.User
+	flags.StringVar(&user.FirstName, "first-name", "", "first name of the user")
+	flags.StringVar(&user.LastName, "last-name", "", "last name of the user")
+	flags.StringVar(&user.Biography, "biography", "", "biography of the user")
+	if _, err := parseArgs(flags, args); err != nil {
+		return err
+	}
+
+	created, err := e.client.Create(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	return e.out.user(created)
+}
+
+// updateUser only changes the fields given as flags.
+func updateUser(ctx context.Context, e *env, args []string) error {
+	flags := e.flagSet("update")
+	firstName := flags.String("first-name", "", "new first name of the user")
+	lastName := flags.String("last-name", "", "new last name of the user")
+	biography := flags.String("biography", "", "new biography of the user")
+	positional, err := parseArgs(flags, args, "<id>")
+	if err != nil {
+		return err
+	}
+
+	var patch database.UserPatch
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "first-name":
+			patch.FirstName = firstName
+		case "last-name":
+			patch.LastName = lastName
+		case "biography":
+			patch.Biography = biography
+		}
+	})
+	if patch == (database.UserPatch{}) {
+		return usageError("update needs at least one of -first-name, -last-name or -biography")
+	}
+
+	updated, err := e.client.Patch(ctx, positional[0], patch)
+	if err != nil {
+		return err
+	}
+
+	return e.out.user(updated)
+}
+
+func deleteUser(ctx context.Context, e *env, args []string) error {
+	positional, err := parseArgs(e.flagSet("delete"), args, "<id>")
+	if err != nil {
+		return err
+	}
+
+	deleted, err := e.client.Delete(ctx, positional[0])
+	if err != nil {
+		return err
+	}
+
+	return e.out.user(deleted)
+}
+
+// importUsers creates every user listed in a file written by export under
+// the ID it was exported with, so the users can be moved between servers.
+// Users without an ID get a new one. The users that fail are reported and
+// skipped, the others are still imported.
+func importUsers(ctx context.Context, e *env, args []string) error {
+	positional, err := parseArgs(e.flagSet("import"), args, "<file>")
+	if err != nil {
+		return err
+	}
+
+	path := positional[0]
+	var data []byte
+	if path == "-" {
+		data, err = io.ReadAll(e.stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return fmt.Errorf("could not read the users: %w", err)
+	}
+
+	var users []database.DBUser
+	switch ext := strings.ToLower(filepath.Ext(path)); {
+	case ext == ".yaml" || ext == ".yml":
+		err = yaml.Unmarshal(data, &users)
+	case ext == ".json":
+		err = json.Unmarshal(data, &users)
+	default:
+		// JSON is valid YAML, but its errors are clearer
+		if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+			err = json.Unmarshal(data, &users)
+		} else {
+			err = yaml.Unmarshal(data, &users)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("could not decode the users: %w", err)
+	}
+
+	for i := range users {
+		// known IDs let the imported users be printed
+		if users[i].ID == (database.ID{}) {
+			users[i].ID = database.ID{}.NewID()
+		}
+	}
+
+	report, err := e.client.Import(ctx, users)
+	if err != nil {
+		return fmt.Errorf("could not import the users: %w", err)
+	}
+
+	failed := make(map[int]bool, len(report.Errors))
+	for _, rowErr := range report.Errors {
+		failed[rowErr.Row] = true
+	}
+	created := make([]database.DBUser, 0, report.Imported)
+	for i, user := range users {
+		if !failed[i+1] {
+			created = append(created, user)
+		}
+	}
+
+	if len(report.Errors) > 0 {
+		// report what was imported so the failed users can be fixed and
+		// imported again
+		if len(created) > 0 {
+			_ = e.out.users(created)
+		}
+		for _, rowErr := range report.Errors {
+			fmt.Fprintf(e.stderr, "could not import user %d: %s\n", rowErr.Row, rowErr.Message)
+		}
+		return fmt.Errorf("could not import %d of %d users", report.Failed, report.Rows)
+	}
+
+	return e.out.users(created)
+}
+
+// exportUsers writes every user as JSON, or as YAML with -o yaml, to the
+// given file or to stdout.
+func exportUsers(ctx context.Context, e *env, args []string) error {
+	flags := e.flagSet("export")
+	pageSize := flags.Int("page-size", 100, "number of users fetched per request")
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 1 {
+		return usageError("usersctl export takes at most one file")
+	}
+
+	path := "-"
+	if len(positional) == 1 {
+		path = positional[0]
+	}
+
+	users := []database.DBUser{}
+	it := e.client.List(*pageSize)
+	for it.Next(ctx) {
+		users = append(users, it.User())
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+
+	out := &printer{w: e.out.w, format: e.out.format}
+	if out.format == formatTable {
+		out.format = formatJSON
+	}
+
+	if path == "-" {
+		return out.encode(users)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not create the export: %w", err)
+	}
+
+	out.w = file
+	if err := out.encode(users); err != nil {
+		file.Close()
+		return fmt.Errorf("could not write the export: %w", err)
+	}
+
+	return file.Close()
+}
+
+// tailChanges prints every change until interrupted.
+func tailChanges(ctx context.Context, e *env, args []string) error {
+	if _, err := parseArgs(e.flagSet("tail"), args); err != nil {
+		return err
+	}
+
+	err := e.client.Watch(ctx, e.out.change)
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	if err == nil {
+		return errors.New("the server closed the change stream")
+	}
+
+	return err
+}
+
+func showStats(ctx context.Context, e *env, args []string) error {
+	if _, err := parseArgs(e.flagSet("stats"), args); err != nil {
+		return err
+	}
+
+	stats, err := e.client.Stats(ctx)
+	if err != nil {
+		return err
+	}
+
+	return e.out.stats(stats)
+}
@@ -0,0 +1,140 @@
+// Command usersctl manages the users of a running server:
+//
+//	usersctl [-server url] [-api-key key] [-o table|json|yaml] <command> [arguments]
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"main/client"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+const usage = `usage: usersctl [flags] <command> [arguments]
+
+commands:
+  list [-page-size n]                                    list every user
+  get <id>                                               show a user
+  create -first-name f -last-name l -biography b         create a user
+  update <id> [-first-name f] [-last-name l] [-biography b]
+                                                         change the given fields of a user
+  delete <id>                                            delete a user
+  import <file>                                          create the users of a JSON or YAML file, - reads stdin
+  export [file]                                          write every user as JSON, or YAML with -o yaml
+  tail                                                   print changes as they happen
+  stats                                                  show the store statistics
+
+flags:
+`
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	os.Exit(run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+type command func(ctx context.Context, env *env, args []string) error
+
+// env is what every command works with.
+type env struct {
+	client *client.Client
+	stdin  io.Reader
+	stderr io.Writer
+	out    *printer
+}
+
+var commands = map[string]command{
+	"list":   listUsers,
+	"get":    getUser,
+	"create": createUser,
+	"update": updateUser,
+	"delete": deleteUser,
+	"import": importUsers,
+	"export": exportUsers,
+	"tail":   tailChanges,
+	"stats":  showStats,
+}
+
+// run returns the exit code: 0 on success, 1 when the command failed and 2
+// on usage errors.
+func run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("usersctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+
+	server := flags.String("server", envOr("USERSCTL_SERVER", "http://localhost:8080"), "base URL of the server, defaults to $USERSCTL_SERVER")
+	apiKey := flags.String("api-key", os.Getenv("USERSCTL_API_KEY"), "API key sent in the X-API-Key header, defaults to $USERSCTL_API_KEY")
+	output := flags.String("o", "table", "output format: table, json or yaml")
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	format, err := parseFormat(*output)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n", flags.Arg(0))
+		flags.Usage()
+		return 2
+	}
+
+	e := &env{
+		client: client.New(*server, client.WithAPIKey(*apiKey)),
+		stdin:  stdin,
+		stderr: stderr,
+		out:    &printer{w: stdout, format: format},
+	}
+
+	err = cmd(ctx, e, flags.Args()[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "usersctl:", err)
+
+		var usageErr usageError
+		if errors.As(err, &usageErr) {
+			return 2
+		}
+		return 1
+	}
+
+	return 0
+}
+
+// usageError is returned for bad arguments to a command.
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+func envOr(key string, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+
+	return fallback
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"main/api"
+	"main/database"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var user = database.User{
+	FirstName: "John",
+	LastName:  "Doe",
+	Biography: "A simple guy who loves to write code and play games.",
+}
+
+type result struct {
+	code   int
+	stdout string
+	stderr string
+}
+
+// syncBuffer lets a test read the output of a command still running.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+func newServer(t testing.TB) (string, *database.InMemoryDB) {
+	t.Helper()
+
+	db := database.NewInMemoryDB()
+	server := httptest.NewServer(api.NewHandler(db, api.WithRequestLogging(false)))
+	t.Cleanup(server.Close)
+
+	return server.URL, db
+}
+
+func usersctl(ctx context.Context, server string, stdin string, args ...string) result {
+	var stdout, stderr bytes.Buffer
+	code := run(ctx, append([]string{"-server", server}, args...), strings.NewReader(stdin), &stdout, &stderr)
+
+	return result{code: code, stdout: stdout.String(), stderr: stderr.String()}
+}
+
+func assertCode(t testing.TB, want int, got result) {
+	t.Helper()
+
+	if got.code != want {
+		t.Fatalf("expected exit code %d, got %d\nstdout: %s\nstderr: %s", want, got.code, got.stdout, got.stderr)
+	}
+}
+
+func TestUsersctl(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("create, get, update and delete a user", func(t *testing.T) {
+		server, db := newServer(t)
+
+		res := usersctl(ctx, server, "", "-o", "json", "create", "-first-name", user.FirstName, "-last-name", user.LastName, "-biography", user.Biography)
+		assertCode(t, 0, res)
+
+		var created database.DBUser
+		if err := json.Unmarshal([]byte(res.stdout), &created); err != nil {
+			t.Fatalf("could not decode the output: %v", err)
+		}
+		if created.User != user {
+			t.Fatalf("expected the user to be %v, got %v", user, created.User)
+		}
+
+		res = usersctl(ctx, server, "", "get", created.ID.String())
+		assertCode(t, 0, res)
+		if !strings.Contains(res.stdout, created.ID.String()) || !strings.Contains(res.stdout, "FIRST NAME") {
+			t.Fatalf("expected a table with the user, got %q", res.stdout)
+		}
+
+		res = usersctl(ctx, server, "", "-o", "yaml", "update", created.ID.String(), "-last-name", "Smith")
+		assertCode(t, 0, res)
+
+		var updated database.DBUser
+		if err := yaml.Unmarshal([]byte(res.stdout), &updated); err != nil {
+			t.Fatalf("could not decode the output: %v", err)
+		}
+		if updated.User.LastName != "Smith" || updated.User.FirstName != user.FirstName {
+			t.Fatalf("expected only the last name to change, got %v", updated.User)
+		}
+
+		res = usersctl(ctx, server, "", "delete", created.ID.String())
+		assertCode(t, 0, res)
+		if db.Count() != 0 {
+			t.Fatalf("expected the user to be deleted")
+		}
+
+		res = usersctl(ctx, server, "", "get", created.ID.String())
+		assertCode(t, 1, res)
+	})
+
+	t.Run("list every user", func(t *testing.T) {
+		server, db := newServer(t)
+		for i := 0; i < 5; i++ {
+			if _, err := db.Insert(ctx, user); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		res := usersctl(ctx, server, "", "-o", "json", "list", "-page-size", "2")
+		assertCode(t, 0, res)
+
+		var users []database.DBUser
+		if err := json.Unmarshal([]byte(res.stdout), &users); err != nil {
+			t.Fatalf("could not decode the output: %v", err)
+		}
+		if len(users) != 5 {
+			t.Fatalf("expected %d users, got %d", 5, len(users))
+		}
+
+		res = usersctl(ctx, server, "", "list")
+		assertCode(t, 0, res)
+		// a header and a row per user
+		if lines := strings.Count(res.stdout, "\n"); lines != 6 {
+			t.Fatalf("expected %d lines, got %d: %q", 6, lines, res.stdout)
+		}
+	})
+
+	t.Run("export then import", func(t *testing.T) {
+		for _, format := range []string{"json", "yaml"} {
+			t.Run(format, func(t *testing.T) {
+				source, db := newServer(t)
+				for i := 0; i < 3; i++ {
+					if _, err := db.Insert(ctx, user); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				path := filepath.Join(t.TempDir(), "users."+format)
+				res := usersctl(ctx, source, "", "-o", format, "export", path)
+				assertCode(t, 0, res)
+
+				target, imported := newServer(t)
+				res = usersctl(ctx, target, "", "import", path)
+				assertCode(t, 0, res)
+
+				if imported.Count() != 3 {
+					t.Fatalf("expected %d users, got %d", 3, imported.Count())
+				}
+				for _, exported := range db.FindAll(ctx) {
+					if _, exists := imported.FindByID(ctx, exported.ID.String()); !exists {
+						t.Errorf("expected %s to keep its id", exported.ID)
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("import from stdin", func(t *testing.T) {
+		server, db := newServer(t)
+
+		input := "- user:\n    first_name: John\n    last_name: Doe\n    biography: A simple guy who loves to write code.\n"
+		res := usersctl(ctx, server, input, "import", "-")
+		assertCode(t, 0, res)
+
+		if db.Count() != 1 {
+			t.Fatalf("expected %d user, got %d", 1, db.Count())
+		}
+	})
+
+	t.Run("import reports the invalid users", func(t *testing.T) {
+		server, db := newServer(t)
+
+		input := `[{"user": {"first_name": "John", "last_name": "Doe", "biography": "A simple guy who loves to write code."}}, {"user": {"first_name": "J"}}]`
+		res := usersctl(ctx, server, input, "import", "-")
+		assertCode(t, 1, res)
+
+		if !strings.Contains(res.stderr, "user 2") {
+			t.Fatalf("expected the error to name the user, got %q", res.stderr)
+		}
+		if db.Count() != 1 {
+			t.Fatalf("expected %d user, got %d", 1, db.Count())
+		}
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		server, db := newServer(t)
+		if _, err := db.Insert(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+
+		res := usersctl(ctx, server, "", "-o", "json", "stats")
+		assertCode(t, 0, res)
+
+		var stats database.Stats
+		if err := json.Unmarshal([]byte(res.stdout), &stats); err != nil {
+			t.Fatalf("could not decode the output: %v", err)
+		}
+		if stats != db.Stats() {
+			t.Fatalf("expected the stats to be %+v, got %+v", db.Stats(), stats)
+		}
+	})
+
+	t.Run("tail changes until interrupted", func(t *testing.T) {
+		server, db := newServer(t)
+
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		// tail subscribes some time after being started, so keep writing
+		// until it printed a change
+		var stdout syncBuffer
+		go func() {
+			ticker := time.NewTicker(10 * time.Millisecond)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					if strings.Contains(stdout.String(), `"type":"created"`) {
+						cancel()
+						return
+					}
+					db.Insert(ctx, user)
+				}
+			}
+		}()
+
+		var stderr bytes.Buffer
+		code := run(ctx, []string{"-server", server, "-o", "json", "tail"}, strings.NewReader(""), &stdout, &stderr)
+		if code != 0 {
+			t.Fatalf("expected exit code %d, got %d: %s", 0, code, stderr.String())
+		}
+
+		if !strings.Contains(stdout.String(), `"type":"created"`) {
+			t.Fatalf("expected a created change, got %q", stdout.String())
+		}
+	})
+
+	t.Run("usage errors", func(t *testing.T) {
+		server, _ := newServer(t)
+
+		tests := [][]string{
+			{},
+			{"unknown"},
+			{"get"},
+			{"get", "a", "b"},
+			{"update", database.ID{}.NewID().String()},
+			{"list", "-unknown"},
+			{"-o", "xml", "list"},
+		}
+
+		for _, args := range tests {
+			res := usersctl(ctx, server, "", args...)
+			assertCode(t, 2, res)
+		}
+	})
+}
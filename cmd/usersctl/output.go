@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"main/database"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type format string
+
+const (
+	formatTable format = "table"
+	formatJSON  format = "json"
+	formatYAML  format = "yaml"
+)
+
+func parseFormat(s string) (format, error) {
+	switch f := format(s); f {
+	case formatTable, formatJSON, formatYAML:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown output format %q", s)
+	}
+}
+
+// biographyWidth keeps table rows on a single line.
+const biographyWidth = 40
+
+type printer struct {
+	w      io.Writer
+	format format
+}
+
+// encode writes v as JSON or YAML, table falls back to JSON.
+func (p *printer) encode(v any) error {
+	if p.format == formatYAML {
+		encoder := yaml.NewEncoder(p.w)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(v); err != nil {
+			return err
+		}
+		return encoder.Close()
+	}
+
+	encoder := json.NewEncoder(p.w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(v)
+}
+
+func (p *printer) users(users []database.DBUser) error {
+	if p.format != formatTable {
+		return p.encode(users)
+	}
+
+	tw := tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tFIRST NAME\tLAST NAME\tBIOGRAPHY")
+	for _, user := range users {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", user.ID, user.User.FirstName, user.User.LastName, truncate(user.User.Biography, biographyWidth))
+	}
+
+	return tw.Flush()
+}
+
+func (p *printer) user(user database.DBUser) error {
+	if p.format != formatTable {
+		return p.encode(user)
+	}
+
+	return p.users([]database.DBUser{user})
+}
+
+// change prints a single change, in table format without a header so the
+// output of tail can be followed line by line.
+func (p *printer) change(change database.Change) error {
+	switch p.format {
+	case formatTable:
+		if change.Follow != nil {
+			_, err := fmt.Fprintf(p.w, "%d\t%s\t%s\t%s\t%s\n",
+				change.Seq,
+				change.Time.Format(time.RFC3339),
+				change.Type,
+				change.Follow.Follower,
+				change.Follow.Followee,
+			)
+			return err
+		}
+
+		_, err := fmt.Fprintf(p.w, "%d\t%s\t%s\t%s\t%s %s\n",
+			change.Seq,
+			change.Time.Format(time.RFC3339),
+			change.Type,
+			change.User.ID,
+			change.User.User.FirstName,
+			change.User.User.LastName,
+		)
+		return err
+	case formatJSON:
+		// one change per line, so the output can be piped into jq
+		return json.NewEncoder(p.w).Encode(change)
+	default:
+		// a document per change
+		if _, err := fmt.Fprintln(p.w, "---"); err != nil {
+			return err
+		}
+		return p.encode(change)
+	}
+}
+
+func (p *printer) stats(stats database.Stats) error {
+	if p.format != formatTable {
+		return p.encode(stats)
+	}
+
+	tw := tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "USERS\t%d\n", stats.Users)
+	fmt.Fprintf(tw, "BYTES\t%d\n", stats.Bytes)
+	fmt.Fprintf(tw, "CHANGES\t%d\n", stats.Changes)
+
+	return tw.Flush()
+}
+
+func truncate(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+
+	return string(runes[:width-3]) + "..."
+}
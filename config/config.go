@@ -0,0 +1,574 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is prepended to every environment variable read by Load,
+// e.g. the server-addr flag maps to USERS_SERVER_ADDR.
+const EnvPrefix = "USERS_"
+
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+type Config struct {
+	Server      Server      `yaml:"server" toml:"server"`
+	Database    Database    `yaml:"database" toml:"database"`
+	Middleware  Middleware  `yaml:"middleware" toml:"middleware"`
+	Health      Health      `yaml:"health" toml:"health"`
+	Tracing     Tracing     `yaml:"tracing" toml:"tracing"`
+	Log         Log         `yaml:"log" toml:"log"`
+	RateLimit   RateLimit   `yaml:"rate_limit" toml:"rate_limit"`
+	Idempotency Idempotency `yaml:"idempotency" toml:"idempotency"`
+	API         API         `yaml:"api" toml:"api"`
+	Replication Replication `yaml:"replication" toml:"replication"`
+	Cluster     Cluster     `yaml:"cluster" toml:"cluster"`
+}
+
+type Server struct {
+	Addr            string        `yaml:"addr" toml:"addr"`
+	ReadTimeout     time.Duration `yaml:"read_timeout" toml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout" toml:"write_timeout"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout" toml:"idle_timeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	// GRPCAddr is where the gRPC UserService listens, empty disables it.
+	GRPCAddr string `yaml:"grpc_addr" toml:"grpc_addr"`
+}
+
+type Database struct {
+	// Deprecated: InitialCapacity is still parsed so existing files load,
+	// but it has no effect.
+	InitialCapacity int    `yaml:"initial_capacity" toml:"initial_capacity"`
+	MaxUsers        int    `yaml:"max_users" toml:"max_users"`
+	MaxBytes        int64  `yaml:"max_bytes" toml:"max_bytes"`
+	EvictionPolicy  string `yaml:"eviction_policy" toml:"eviction_policy"`
+	// DeletePolicy is what happens to the follows of deleted users.
+	DeletePolicy string `yaml:"delete_policy" toml:"delete_policy"`
+}
+
+type Middleware struct {
+	RequestTimeout      time.Duration `yaml:"request_timeout" toml:"request_timeout"`
+	LogRequests         bool          `yaml:"log_requests" toml:"log_requests"`
+	AccessLogLevel      slog.Level    `yaml:"access_log_level" toml:"access_log_level"`
+	AccessLogSampleRate float64       `yaml:"access_log_sample_rate" toml:"access_log_sample_rate"`
+	CompressionMinSize  int           `yaml:"compression_min_size" toml:"compression_min_size"`
+}
+
+type Health struct {
+	CheckTimeout  time.Duration `yaml:"check_timeout" toml:"check_timeout"`
+	ShutdownDelay time.Duration `yaml:"shutdown_delay" toml:"shutdown_delay"`
+}
+
+type Tracing struct {
+	Exporter    string  `yaml:"exporter" toml:"exporter"`
+	File        string  `yaml:"file" toml:"file"`
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio"`
+}
+
+type Log struct {
+	Level        slog.Level `yaml:"level" toml:"level"`
+	Format       string     `yaml:"format" toml:"format"`
+	RedactedKeys []string   `yaml:"redacted_keys" toml:"redacted_keys"`
+}
+
+type RateLimit struct {
+	Enabled    bool    `yaml:"enabled" toml:"enabled"`
+	ReadRate   float64 `yaml:"read_rate" toml:"read_rate"`
+	ReadBurst  int     `yaml:"read_burst" toml:"read_burst"`
+	WriteRate  float64 `yaml:"write_rate" toml:"write_rate"`
+	WriteBurst int     `yaml:"write_burst" toml:"write_burst"`
+	MaxClients int     `yaml:"max_clients" toml:"max_clients"`
+	// APIKeys and Tenants are limited on their own, any other X-API-Key or
+	// X-Tenant-ID header is ignored and the client limited by IP address.
+	APIKeys []string `yaml:"api_keys" toml:"api_keys"`
+	Tenants []string `yaml:"tenants" toml:"tenants"`
+	// MaxKeysPerIP bounds how many API keys and tenants an IP address is
+	// limited under, past it the address is.
+	MaxKeysPerIP int `yaml:"max_keys_per_ip" toml:"max_keys_per_ip"`
+}
+
+type Idempotency struct {
+	KeyTTL time.Duration `yaml:"key_ttl" toml:"key_ttl"`
+	// MaxKeys and MaxBytes bound the kept responses, the least recently
+	// used are forgotten past them.
+	MaxKeys  int   `yaml:"max_keys" toml:"max_keys"`
+	MaxBytes int64 `yaml:"max_bytes" toml:"max_bytes"`
+}
+
+type API struct {
+	AllowUpsert bool `yaml:"allow_upsert" toml:"allow_upsert"`
+}
+
+type Replication struct {
+	// Role is standalone, leader or follower.
+	Role string `yaml:"role" toml:"role"`
+	// Leader is the base URL of the leader a follower replicates.
+	Leader string `yaml:"leader" toml:"leader"`
+	// LogSize is how many changes a leader keeps for followers to catch up
+	// on without a snapshot.
+	LogSize int `yaml:"log_size" toml:"log_size"`
+	// ForwardWrites makes followers proxy writes to the leader instead of
+	// rejecting them.
+	ForwardWrites bool `yaml:"forward_writes" toml:"forward_writes"`
+}
+
+// Cluster makes the instance a member of a Raft cluster, every write being
+// committed to a majority of the members before it is applied.
+type Cluster struct {
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// NodeID names the instance among the members, it must be in Peers.
+	NodeID string `yaml:"node_id" toml:"node_id"`
+	// Addr is the host:port Raft listens on. The peers dial the address
+	// given for this node in Peers.
+	Addr string `yaml:"addr" toml:"addr"`
+	// Peers lists every member as id=host:port, this one included. All the
+	// members start with the same list.
+	Peers []string `yaml:"peers" toml:"peers"`
+}
+
+func Default() Config {
+	return Config{
+		Server: Server{
+			Addr:            ":8080",
+			ReadTimeout:     10 * time.Second,
+			WriteTimeout:    10 * time.Second,
+			IdleTimeout:     time.Minute,
+			ShutdownTimeout: 15 * time.Second,
+			GRPCAddr:        ":50051",
+		},
+		Database: Database{
+			EvictionPolicy: "reject",
+			DeletePolicy:   "cascade",
+		},
+		Middleware: Middleware{
+			LogRequests:         true,
+			AccessLogLevel:      slog.LevelInfo,
+			AccessLogSampleRate: 1,
+			CompressionMinSize:  1024,
+		},
+		Health: Health{
+			CheckTimeout:  2 * time.Second,
+			ShutdownDelay: 5 * time.Second,
+		},
+		Tracing: Tracing{
+			Exporter:    "none",
+			SampleRatio: 1,
+		},
+		Log: Log{
+			Level:        slog.LevelInfo,
+			Format:       "json",
+			RedactedKeys: []string{"biography", "authorization", "api_key"},
+		},
+		RateLimit: RateLimit{
+			Enabled:      true,
+			ReadRate:     50,
+			ReadBurst:    100,
+			WriteRate:    10,
+			WriteBurst:   20,
+			MaxClients:   10000,
+			MaxKeysPerIP: 10,
+		},
+		Idempotency: Idempotency{
+			KeyTTL:   24 * time.Hour,
+			MaxKeys:  100000,
+			MaxBytes: 64 << 20,
+		},
+		Replication: Replication{
+			Role:          "standalone",
+			LogSize:       10000,
+			ForwardWrites: true,
+		},
+	}
+}
+
+// Load builds the configuration from, in increasing order of precedence,
+// the defaults, the file given by -config (or USERS_CONFIG), environment
+// variables and command-line flags. getenv is usually os.Getenv.
+func Load(args []string, getenv func(string) string) (Config, error) {
+	var path string
+
+	// the first pass only finds the config file, flags are applied again
+	// once the file and the environment have been loaded
+	scratch := Default()
+	if err := newFlagSet(&scratch, &path).Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	if path == "" {
+		path = getenv(EnvPrefix + "CONFIG")
+	}
+
+	cfg := Default()
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return Config{}, err
+		}
+	}
+
+	fs := newFlagSet(&cfg, &path)
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		value := getenv(envName(f.Name))
+		if value == "" || err != nil {
+			return
+		}
+
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("could not parse %s: %w", envName(f.Name), setErr)
+		}
+	})
+	if err != nil {
+		return Config{}, err
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+
+	return cfg, nil
+}
+
+func (c Config) Validate() error {
+	var errs []error
+
+	if c.Server.Addr == "" {
+		errs = append(errs, errors.New("server address must not be empty"))
+	}
+	if c.Server.ReadTimeout <= 0 {
+		errs = append(errs, errors.New("server read timeout must be positive"))
+	}
+	if c.Server.WriteTimeout <= 0 {
+		errs = append(errs, errors.New("server write timeout must be positive"))
+	}
+	if c.Server.IdleTimeout <= 0 {
+		errs = append(errs, errors.New("server idle timeout must be positive"))
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("server shutdown timeout must be positive"))
+	}
+	if c.Server.GRPCAddr != "" && c.Server.GRPCAddr == c.Server.Addr {
+		errs = append(errs, errors.New("server grpc addr must differ from the HTTP addr"))
+	}
+	if c.Database.InitialCapacity < 0 {
+		errs = append(errs, errors.New("database initial capacity must not be negative"))
+	}
+	if c.Database.MaxUsers < 0 {
+		errs = append(errs, errors.New("database max users must not be negative"))
+	}
+	if c.Database.MaxBytes < 0 {
+		errs = append(errs, errors.New("database max bytes must not be negative"))
+	}
+	switch c.Database.EvictionPolicy {
+	case "reject", "lru", "lfu", "oldest":
+	default:
+		errs = append(errs, fmt.Errorf("database eviction policy must be one of reject, lru, lfu or oldest, got %q", c.Database.EvictionPolicy))
+	}
+	switch c.Database.DeletePolicy {
+	case "cascade", "restrict":
+	default:
+		errs = append(errs, fmt.Errorf("database delete policy must be one of cascade or restrict, got %q", c.Database.DeletePolicy))
+	}
+	if c.Middleware.RequestTimeout < 0 {
+		errs = append(errs, errors.New("middleware request timeout must not be negative"))
+	}
+	if c.Middleware.AccessLogSampleRate < 0 || c.Middleware.AccessLogSampleRate > 1 {
+		errs = append(errs, errors.New("middleware access log sample rate must be between 0 and 1"))
+	}
+	if c.Health.CheckTimeout <= 0 {
+		errs = append(errs, errors.New("health check timeout must be positive"))
+	}
+	if c.Health.ShutdownDelay < 0 {
+		errs = append(errs, errors.New("health shutdown delay must not be negative"))
+	}
+	switch c.Tracing.Exporter {
+	case "none", "stdout":
+	case "file":
+		if c.Tracing.File == "" {
+			errs = append(errs, errors.New("tracing file must be set when using the file exporter"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("tracing exporter must be one of none, stdout or file, got %q", c.Tracing.Exporter))
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, errors.New("tracing sample ratio must be between 0 and 1"))
+	}
+	if c.Log.Format != "json" && c.Log.Format != "text" {
+		errs = append(errs, fmt.Errorf("log format must be json or text, got %q", c.Log.Format))
+	}
+	if c.RateLimit.Enabled {
+		if c.RateLimit.ReadRate <= 0 || c.RateLimit.WriteRate <= 0 {
+			errs = append(errs, errors.New("rate limit rates must be positive"))
+		}
+		if c.RateLimit.ReadBurst < 1 || c.RateLimit.WriteBurst < 1 {
+			errs = append(errs, errors.New("rate limit bursts must be at least 1"))
+		}
+		if c.RateLimit.MaxClients < 1 {
+			errs = append(errs, errors.New("rate limit max clients must be at least 1"))
+		}
+		if c.RateLimit.MaxKeysPerIP < 1 {
+			errs = append(errs, errors.New("rate limit max keys per ip must be at least 1"))
+		}
+	}
+	if c.Idempotency.KeyTTL <= 0 {
+		errs = append(errs, errors.New("idempotency key ttl must be positive"))
+	}
+	if c.Idempotency.MaxKeys < 1 || c.Idempotency.MaxBytes < 1 {
+		errs = append(errs, errors.New("idempotency max keys and max bytes must be at least 1"))
+	}
+	switch c.Replication.Role {
+	case "standalone", "leader":
+	case "follower":
+		if u, err := url.Parse(c.Replication.Leader); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, errors.New("replication leader must be an http or https URL on followers"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("replication role must be one of standalone, leader or follower, got %q", c.Replication.Role))
+	}
+	if c.Replication.LogSize < 0 {
+		errs = append(errs, errors.New("replication log size must not be negative"))
+	}
+	if c.Cluster.Enabled {
+		if c.Cluster.NodeID == "" || c.Cluster.Addr == "" {
+			errs = append(errs, errors.New("cluster node id and addr must be set"))
+		}
+		if _, err := c.Cluster.Members(); err != nil {
+			errs = append(errs, err)
+		}
+		if c.Replication.Role != "standalone" {
+			errs = append(errs, errors.New("cluster members replicate through Raft, the replication role must be standalone"))
+		}
+		// the other policies depend on the reads of each member, which
+		// would make them diverge
+		if c.Database.EvictionPolicy != "reject" {
+			errs = append(errs, errors.New("cluster members must use the reject eviction policy"))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// Members parses Peers into addresses by node ID. It fails unless every
+// peer is id=host:port and NodeID is one of them.
+func (c Cluster) Members() (map[string]string, error) {
+	members := make(map[string]string, len(c.Peers))
+	for _, peer := range c.Peers {
+		id, addr, ok := strings.Cut(peer, "=")
+		if !ok || id == "" || addr == "" {
+			return nil, fmt.Errorf("cluster peer %q must be id=host:port", peer)
+		}
+		if _, exists := members[id]; exists {
+			return nil, fmt.Errorf("cluster peer %q is listed twice", id)
+		}
+		members[id] = addr
+	}
+
+	if _, ok := members[c.NodeID]; !ok {
+		return nil, fmt.Errorf("cluster peers must include the node %q", c.NodeID)
+	}
+
+	return members, nil
+}
+
+// LogValue lets the effective configuration be printed with slog.
+func (c Config) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Group("server",
+			"addr", c.Server.Addr,
+			"read_timeout", c.Server.ReadTimeout,
+			"write_timeout", c.Server.WriteTimeout,
+			"idle_timeout", c.Server.IdleTimeout,
+			"shutdown_timeout", c.Server.ShutdownTimeout,
+			"grpc_addr", c.Server.GRPCAddr,
+		),
+		slog.Group("database",
+			"initial_capacity", c.Database.InitialCapacity,
+			"max_users", c.Database.MaxUsers,
+			"max_bytes", c.Database.MaxBytes,
+			"eviction_policy", c.Database.EvictionPolicy,
+			"delete_policy", c.Database.DeletePolicy,
+		),
+		slog.Group("middleware",
+			"request_timeout", c.Middleware.RequestTimeout,
+			"log_requests", c.Middleware.LogRequests,
+			"access_log_level", c.Middleware.AccessLogLevel,
+			"access_log_sample_rate", c.Middleware.AccessLogSampleRate,
+			"compression_min_size", c.Middleware.CompressionMinSize,
+		),
+		slog.Group("health",
+			"check_timeout", c.Health.CheckTimeout,
+			"shutdown_delay", c.Health.ShutdownDelay,
+		),
+		slog.Group("tracing",
+			"exporter", c.Tracing.Exporter,
+			"file", c.Tracing.File,
+			"sample_ratio", c.Tracing.SampleRatio,
+		),
+		slog.Group("log",
+			"level", c.Log.Level,
+			"format", c.Log.Format,
+			"redacted_keys", c.Log.RedactedKeys,
+		),
+		slog.Group("rate_limit",
+			"enabled", c.RateLimit.Enabled,
+			"read_rate", c.RateLimit.ReadRate,
+			"read_burst", c.RateLimit.ReadBurst,
+			"write_rate", c.RateLimit.WriteRate,
+			"write_burst", c.RateLimit.WriteBurst,
+			"max_clients", c.RateLimit.MaxClients,
+			// the keys are secrets
+			"api_keys", len(c.RateLimit.APIKeys),
+			"tenants", c.RateLimit.Tenants,
+			"max_keys_per_ip", c.RateLimit.MaxKeysPerIP,
+		),
+		slog.Group("idempotency",
+			"key_ttl", c.Idempotency.KeyTTL,
+			"max_keys", c.Idempotency.MaxKeys,
+			"max_bytes", c.Idempotency.MaxBytes,
+		),
+		slog.Group("api",
+			"allow_upsert", c.API.AllowUpsert,
+		),
+		slog.Group("replication",
+			"role", c.Replication.Role,
+			"leader", c.Replication.Leader,
+			"log_size", c.Replication.LogSize,
+			"forward_writes", c.Replication.ForwardWrites,
+		),
+		slog.Group("cluster",
+			"enabled", c.Cluster.Enabled,
+			"node_id", c.Cluster.NodeID,
+			"addr", c.Cluster.Addr,
+			"peers", c.Cluster.Peers,
+		),
+	)
+}
+
+func newFlagSet(cfg *Config, path *string) *flag.FlagSet {
+	fs := flag.NewFlagSet("in-memory-crud", flag.ContinueOnError)
+
+	fs.StringVar(path, "config", *path, "path to a YAML or TOML config file")
+
+	fs.StringVar(&cfg.Server.Addr, "server-addr", cfg.Server.Addr, "address the HTTP server listens on")
+	fs.DurationVar(&cfg.Server.ReadTimeout, "server-read-timeout", cfg.Server.ReadTimeout, "maximum duration for reading a request")
+	fs.DurationVar(&cfg.Server.WriteTimeout, "server-write-timeout", cfg.Server.WriteTimeout, "maximum duration for writing a response")
+	fs.DurationVar(&cfg.Server.IdleTimeout, "server-idle-timeout", cfg.Server.IdleTimeout, "maximum time to wait for the next request on keep-alive connections")
+	fs.DurationVar(&cfg.Server.ShutdownTimeout, "server-shutdown-timeout", cfg.Server.ShutdownTimeout, "maximum time to wait for in-flight requests on shutdown")
+	fs.StringVar(&cfg.Server.GRPCAddr, "server-grpc-addr", cfg.Server.GRPCAddr, "address the gRPC server listens on, empty disables it")
+
+	fs.IntVar(&cfg.Database.InitialCapacity, "database-initial-capacity", cfg.Database.InitialCapacity, "deprecated, has no effect since the database grows as needed")
+	fs.IntVar(&cfg.Database.MaxUsers, "database-max-users", cfg.Database.MaxUsers, "maximum number of stored users, 0 means no limit")
+	fs.Int64Var(&cfg.Database.MaxBytes, "database-max-bytes", cfg.Database.MaxBytes, "maximum estimated memory taken by stored users, 0 means no limit")
+	fs.StringVar(&cfg.Database.EvictionPolicy, "database-eviction-policy", cfg.Database.EvictionPolicy, "what to do when the database is full: reject, lru, lfu or oldest")
+	fs.StringVar(&cfg.Database.DeletePolicy, "database-delete-policy", cfg.Database.DeletePolicy, "what to do with the follows of deleted users: cascade or restrict")
+
+	fs.DurationVar(&cfg.Middleware.RequestTimeout, "middleware-request-timeout", cfg.Middleware.RequestTimeout, "cancel requests running longer than this, 0 disables it")
+	fs.BoolVar(&cfg.Middleware.LogRequests, "middleware-log-requests", cfg.Middleware.LogRequests, "write an access log entry per request")
+	fs.TextVar(&cfg.Middleware.AccessLogLevel, "middleware-access-log-level", cfg.Middleware.AccessLogLevel, "level of access log entries for successful requests")
+	fs.Float64Var(&cfg.Middleware.AccessLogSampleRate, "middleware-access-log-sample-rate", cfg.Middleware.AccessLogSampleRate, "fraction of successful requests written to the access log")
+	fs.IntVar(&cfg.Middleware.CompressionMinSize, "middleware-compression-min-size", cfg.Middleware.CompressionMinSize, "smallest response body in bytes compressed with gzip, zstd or brotli, -1 disables compression")
+
+	fs.DurationVar(&cfg.Health.CheckTimeout, "health-check-timeout", cfg.Health.CheckTimeout, "maximum time a single health check may take")
+	fs.DurationVar(&cfg.Health.ShutdownDelay, "health-shutdown-delay", cfg.Health.ShutdownDelay, "time readiness reports failing before the server stops")
+
+	fs.StringVar(&cfg.Tracing.Exporter, "tracing-exporter", cfg.Tracing.Exporter, "where to export traces: none, stdout or file")
+	fs.StringVar(&cfg.Tracing.File, "tracing-file", cfg.Tracing.File, "file traces are appended to with the file exporter")
+	fs.Float64Var(&cfg.Tracing.SampleRatio, "tracing-sample-ratio", cfg.Tracing.SampleRatio, "fraction of new traces to sample")
+
+	fs.TextVar(&cfg.Log.Level, "log-level", cfg.Log.Level, "minimum level of log entries")
+	fs.StringVar(&cfg.Log.Format, "log-format", cfg.Log.Format, "log format: json or text")
+	fs.Var((*stringList)(&cfg.Log.RedactedKeys), "log-redacted-keys", "comma-separated attribute keys whose values are redacted")
+
+	fs.BoolVar(&cfg.RateLimit.Enabled, "rate-limit-enabled", cfg.RateLimit.Enabled, "limit how often each client can call the API")
+	fs.Float64Var(&cfg.RateLimit.ReadRate, "rate-limit-read-rate", cfg.RateLimit.ReadRate, "read requests per second allowed per client")
+	fs.IntVar(&cfg.RateLimit.ReadBurst, "rate-limit-read-burst", cfg.RateLimit.ReadBurst, "read requests a client can make at once")
+	fs.Float64Var(&cfg.RateLimit.WriteRate, "rate-limit-write-rate", cfg.RateLimit.WriteRate, "write requests per second allowed per client")
+	fs.IntVar(&cfg.RateLimit.WriteBurst, "rate-limit-write-burst", cfg.RateLimit.WriteBurst, "write requests a client can make at once")
+	fs.IntVar(&cfg.RateLimit.MaxClients, "rate-limit-max-clients", cfg.RateLimit.MaxClients, "maximum number of clients tracked at once")
+	fs.Var((*stringList)(&cfg.RateLimit.APIKeys), "rate-limit-api-keys", "comma-separated API keys limited on their own, other clients are limited by IP")
+	fs.Var((*stringList)(&cfg.RateLimit.Tenants), "rate-limit-tenants", "comma-separated tenants limited on their own, other clients are limited by IP")
+	fs.IntVar(&cfg.RateLimit.MaxKeysPerIP, "rate-limit-max-keys-per-ip", cfg.RateLimit.MaxKeysPerIP, "maximum number of API keys and tenants limited on their own per IP")
+
+	fs.DurationVar(&cfg.Idempotency.KeyTTL, "idempotency-key-ttl", cfg.Idempotency.KeyTTL, "how long responses are kept for requests with an Idempotency-Key")
+	fs.IntVar(&cfg.Idempotency.MaxKeys, "idempotency-max-keys", cfg.Idempotency.MaxKeys, "maximum number of responses kept for requests with an Idempotency-Key")
+	fs.Int64Var(&cfg.Idempotency.MaxBytes, "idempotency-max-bytes", cfg.Idempotency.MaxBytes, "maximum bytes of the responses kept for requests with an Idempotency-Key")
+
+	fs.BoolVar(&cfg.API.AllowUpsert, "api-allow-upsert", cfg.API.AllowUpsert, "let PUT /api/users/{id} create users that do not exist")
+
+	fs.StringVar(&cfg.Replication.Role, "replication-role", cfg.Replication.Role, "replication role: standalone, leader or follower")
+	fs.StringVar(&cfg.Replication.Leader, "replication-leader", cfg.Replication.Leader, "base URL of the leader a follower replicates")
+	fs.IntVar(&cfg.Replication.LogSize, "replication-log-size", cfg.Replication.LogSize, "changes a leader keeps for followers to catch up on without a snapshot")
+	fs.BoolVar(&cfg.Replication.ForwardWrites, "replication-forward-writes", cfg.Replication.ForwardWrites, "let followers proxy writes to the leader instead of rejecting them")
+
+	fs.BoolVar(&cfg.Cluster.Enabled, "cluster-enabled", cfg.Cluster.Enabled, "commit writes to a Raft cluster before applying them")
+	fs.StringVar(&cfg.Cluster.NodeID, "cluster-node-id", cfg.Cluster.NodeID, "ID of this member of the cluster")
+	fs.StringVar(&cfg.Cluster.Addr, "cluster-addr", cfg.Cluster.Addr, "host:port Raft listens on")
+	fs.Var((*stringList)(&cfg.Cluster.Peers), "cluster-peers", "comma-separated id=host:port of every member, this one included")
+
+	return fs
+}
+
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read the config file: %w", err)
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, cfg)
+	case ".toml":
+		err = toml.Unmarshal(data, cfg)
+	default:
+		return fmt.Errorf("unsupported config file extension %q", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("could not parse the config file: %w", err)
+	}
+
+	return nil
+}
+
+func envName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// stringList is a comma-separated flag value.
+type stringList []string
+
+func (l *stringList) String() string {
+	if l == nil {
+		return ""
+	}
+
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,200 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	noEnv := func(string) string { return "" }
+
+	t.Run("defaults", func(t *testing.T) {
+		cfg, err := Load(nil, noEnv)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(cfg, Default()) {
+			t.Fatalf("expected the config to be %+v, got %+v", Default(), cfg)
+		}
+	})
+
+	t.Run("yaml file", func(t *testing.T) {
+		path := writeFile(t, "config.yaml", `
+server:
+  addr: ":9090"
+  read_timeout: 3s
+database:
+  initial_capacity: 128
+middleware:
+  log_requests: false
+`)
+
+		cfg, err := Load([]string{"-config", path}, noEnv)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if cfg.Server.Addr != ":9090" {
+			t.Errorf("expected the address to be %q, got %q", ":9090", cfg.Server.Addr)
+		}
+		if cfg.Server.ReadTimeout != 3*time.Second {
+			t.Errorf("expected the read timeout to be %v, got %v", 3*time.Second, cfg.Server.ReadTimeout)
+		}
+		if cfg.Server.WriteTimeout != Default().Server.WriteTimeout {
+			t.Errorf("expected the write timeout to keep its default, got %v", cfg.Server.WriteTimeout)
+		}
+		if cfg.Database.InitialCapacity != 128 {
+			t.Errorf("expected the initial capacity to be %d, got %d", 128, cfg.Database.InitialCapacity)
+		}
+		if cfg.Middleware.LogRequests {
+			t.Errorf("expected request logging to be disabled")
+		}
+	})
+
+	t.Run("toml file", func(t *testing.T) {
+		path := writeFile(t, "config.toml", `
+[server]
+addr = ":9191"
+idle_timeout = "2m"
+`)
+
+		cfg, err := Load([]string{"-config", path}, noEnv)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if cfg.Server.Addr != ":9191" {
+			t.Errorf("expected the address to be %q, got %q", ":9191", cfg.Server.Addr)
+		}
+		if cfg.Server.IdleTimeout != 2*time.Minute {
+			t.Errorf("expected the idle timeout to be %v, got %v", 2*time.Minute, cfg.Server.IdleTimeout)
+		}
+	})
+
+	t.Run("env overrides file and flags override env", func(t *testing.T) {
+		path := writeFile(t, "config.yaml", `
+server:
+  addr: ":9090"
+  read_timeout: 3s
+  write_timeout: 4s
+`)
+
+		env := map[string]string{
+			"USERS_CONFIG":              path,
+			"USERS_SERVER_ADDR":         ":7070",
+			"USERS_SERVER_READ_TIMEOUT": "5s",
+		}
+
+		cfg, err := Load(
+			[]string{"-server-read-timeout", "6s"},
+			func(key string) string { return env[key] },
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if cfg.Server.Addr != ":7070" {
+			t.Errorf("expected the address to be %q, got %q", ":7070", cfg.Server.Addr)
+		}
+		if cfg.Server.ReadTimeout != 6*time.Second {
+			t.Errorf("expected the read timeout to be %v, got %v", 6*time.Second, cfg.Server.ReadTimeout)
+		}
+		if cfg.Server.WriteTimeout != 4*time.Second {
+			t.Errorf("expected the write timeout to be %v, got %v", 4*time.Second, cfg.Server.WriteTimeout)
+		}
+	})
+
+	t.Run("list values", func(t *testing.T) {
+		cfg, err := Load([]string{"-log-redacted-keys", "biography, password"}, noEnv)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []string{"biography", "password"}
+		if !reflect.DeepEqual(cfg.Log.RedactedKeys, want) {
+			t.Errorf("expected the redacted keys to be %v, got %v", want, cfg.Log.RedactedKeys)
+		}
+	})
+
+	t.Run("invalid env value", func(t *testing.T) {
+		_, err := Load(nil, func(key string) string {
+			if key == "USERS_SERVER_READ_TIMEOUT" {
+				return "soon"
+			}
+			return ""
+		})
+
+		if err == nil {
+			t.Fatalf("expected an error for an invalid duration")
+		}
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		_, err := Load([]string{"-server-addr", "", "-server-write-timeout", "0s"}, noEnv)
+
+		if !errors.Is(err, ErrInvalidConfig) {
+			t.Fatalf("expected the error to be %v, got %v", ErrInvalidConfig, err)
+		}
+	})
+
+	t.Run("followers need a leader", func(t *testing.T) {
+		_, err := Load([]string{"-replication-role", "follower"}, noEnv)
+		if !errors.Is(err, ErrInvalidConfig) {
+			t.Fatalf("expected the error to be %v, got %v", ErrInvalidConfig, err)
+		}
+
+		cfg, err := Load([]string{"-replication-role", "follower", "-replication-leader", "http://leader:8080"}, noEnv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Replication.Leader != "http://leader:8080" {
+			t.Fatalf("expected the leader to be set, got %q", cfg.Replication.Leader)
+		}
+	})
+
+	t.Run("cluster members", func(t *testing.T) {
+		args := []string{"-cluster-enabled", "-cluster-node-id", "a", "-cluster-addr", "10.0.0.1:7000"}
+
+		_, err := Load(append(args, "-cluster-peers", "b=10.0.0.2:7000"), noEnv)
+		if !errors.Is(err, ErrInvalidConfig) {
+			t.Fatalf("expected peers without the node to fail with %v, got %v", ErrInvalidConfig, err)
+		}
+
+		cfg, err := Load(append(args, "-cluster-peers", "a=10.0.0.1:7000,b=10.0.0.2:7000"), noEnv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		members, err := cfg.Cluster.Members()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]string{"a": "10.0.0.1:7000", "b": "10.0.0.2:7000"}
+		if !reflect.DeepEqual(members, want) {
+			t.Fatalf("expected the members to be %v, got %v", want, members)
+		}
+	})
+
+	t.Run("unsupported file extension", func(t *testing.T) {
+		path := writeFile(t, "config.json", `{}`)
+
+		if _, err := Load([]string{"-config", path}, noEnv); err == nil {
+			t.Fatalf("expected an error for an unsupported file")
+		}
+	})
+}
+
+func writeFile(t testing.TB, name string, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("could not write the config file: %v", err)
+	}
+
+	return path
+}
@@ -0,0 +1,116 @@
+package database
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrChangesTruncated = errors.New("the change log no longer holds the requested changes")
+
+type ChangeType string
+
+const (
+	ChangeCreated ChangeType = "created"
+	ChangeUpdated ChangeType = "updated"
+	ChangeDeleted ChangeType = "deleted"
+	ChangeEvicted ChangeType = "evicted"
+	// ChangeFollowed and ChangeUnfollowed carry a Follow instead of a user.
+	ChangeFollowed   ChangeType = "followed"
+	ChangeUnfollowed ChangeType = "unfollowed"
+)
+
+// Change describes a single write. Seq increases by one with every change,
+// so a gap tells a subscriber it missed some.
+type Change struct {
+	Seq    uint64     `json:"seq" yaml:"seq"`
+	Type   ChangeType `json:"type" yaml:"type"`
+	User   DBUser     `json:"user" yaml:"user"`
+	Follow *Follow    `json:"follow,omitempty" yaml:"follow,omitempty"`
+	Time   time.Time  `json:"time" yaml:"time"`
+}
+
+// changeBuffer is how many changes a subscriber can fall behind before it
+// is dropped, so a slow reader never blocks writers.
+const changeBuffer = 256
+
+type feed struct {
+	mu          sync.Mutex
+	seq         uint64
+	subscribers map[chan Change]struct{}
+	// log holds the latest changes, change n at index n % len(log).
+	log []Change
+}
+
+// Subscribe returns a channel receiving every change from now on, and a
+// function to stop receiving them. The channel is closed when the
+// subscriber falls too far behind or cancel is called.
+func (db *InMemoryDB) Subscribe() (<-chan Change, func()) {
+	ch := make(chan Change, changeBuffer)
+
+	db.feed.mu.Lock()
+	if db.feed.subscribers == nil {
+		db.feed.subscribers = make(map[chan Change]struct{})
+	}
+	db.feed.subscribers[ch] = struct{}{}
+	db.feed.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			db.feed.mu.Lock()
+			defer db.feed.mu.Unlock()
+
+			if _, ok := db.feed.subscribers[ch]; ok {
+				delete(db.feed.subscribers, ch)
+				close(ch)
+			}
+		})
+	}
+
+	return ch, cancel
+}
+
+// publish must be called with the write lock held so changes are sent in
+// the order they were applied.
+func (db *InMemoryDB) publish(change Change) {
+	db.feed.mu.Lock()
+	defer db.feed.mu.Unlock()
+
+	db.feed.seq++
+	change.Seq = db.feed.seq
+	change.Time = time.Now().UTC()
+
+	if len(db.feed.log) > 0 {
+		db.feed.log[change.Seq%uint64(len(db.feed.log))] = change
+	}
+
+	for ch := range db.feed.subscribers {
+		select {
+		case ch <- change:
+		default:
+			delete(db.feed.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// ChangesSince returns the changes following the one numbered after, in
+// order. It fails with ErrChangesTruncated when some of them are no longer
+// in the log kept with WithChangeLog, or when after was never reached.
+func (db *InMemoryDB) ChangesSince(after uint64) ([]Change, error) {
+	db.feed.mu.Lock()
+	defer db.feed.mu.Unlock()
+
+	size := uint64(len(db.feed.log))
+	if after > db.feed.seq || db.feed.seq-after > size {
+		return nil, ErrChangesTruncated
+	}
+
+	changes := make([]Change, 0, db.feed.seq-after)
+	for seq := after + 1; seq <= db.feed.seq; seq++ {
+		changes = append(changes, db.feed.log[seq%size])
+	}
+
+	return changes, nil
+}
@@ -0,0 +1,628 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrNotFound = errors.New("record does not exist")
+var ErrAlreadyExists = errors.New("record already exists")
+var ErrInvalidValue = errors.New("invalid value")
+var ErrUnknownIndex = errors.New("unknown index")
+
+// Record is a value stored in a collection under its ID.
+type Record[T any] struct {
+	ID    ID `json:"id" yaml:"id" xml:"id" swaggertype:"array,integer"`
+	Value T  `json:"value" yaml:"value" xml:"value"`
+}
+
+// Query selects records in insertion order.
+type Query[T any] struct {
+	// Cursor resumes listing after the last record of a previous page.
+	Cursor string
+	// Limit is the maximum number of records returned, zero means no limit.
+	Limit int
+	// Filter keeps only the records it returns true for, nil keeps them all.
+	Filter func(T) bool
+}
+
+type RecordPage[T any] struct {
+	Records []Record[T]
+	// Next is the cursor of the following page, empty on the last one.
+	Next string
+}
+
+// Collection stores values of type T under IDs. Writes are serialized by a
+// lock, while reads work on the latest immutable version of the records and
+// never wait for writers.
+type Collection[T any] struct {
+	name string
+	// mu serializes the writes, which publish a new version of data.
+	mu       sync.RWMutex
+	data     atomic.Pointer[version[T]]
+	bytes    int64
+	seq      uint64
+	limits   limits[T]
+	observer Observer
+	// modified is when a record was last created, changed or removed.
+	modified time.Time
+	// writes counts the records created, changed or removed, it versions
+	// the collection and its records.
+	writes uint64
+
+	validate func(T) error
+	newID    func() ID
+	indexes  map[string]func(T) string
+	// size estimates the memory taken by a value, for the byte limit.
+	size func(T) int64
+	// errNotFound and errExists let a collection return its own errors.
+	errNotFound error
+	errExists   error
+	// onChange is called for every change, with the write lock held.
+	onChange func(ChangeType, Record[T])
+	// beforeDelete can fail a Delete, with the write lock held.
+	beforeDelete func(ID) error
+}
+
+// version is what readers see of a collection: the records and the
+// indexes after a given write.
+type version[T any] struct {
+	records *trie[ID, *entry[T]]
+	// order maps the insertion order of the records to their IDs.
+	order *seqTree[ID]
+	// indexes map the keys of every index to the set of their records.
+	indexes map[string]*trie[indexKey, *trie[ID, struct{}]]
+}
+
+type CollectionOption[T any] func(*Collection[T])
+
+// WithValidation rejects the values fn fails for with ErrInvalidValue.
+func WithValidation[T any](fn func(T) error) CollectionOption[T] {
+	return func(c *Collection[T]) {
+		c.validate = fn
+	}
+}
+
+// WithIDs generates the IDs of inserted records with fn instead of random
+// UUIDs.
+func WithIDs[T any](fn func() ID) CollectionOption[T] {
+	return func(c *Collection[T]) {
+		c.newID = fn
+	}
+}
+
+// WithIndex keeps the records indexed by the key fn returns for their
+// value, for FindBy to look them up under name.
+func WithIndex[T any](name string, fn func(T) string) CollectionOption[T] {
+	return func(c *Collection[T]) {
+		c.indexes[name] = fn
+	}
+}
+
+// NewCollection returns an empty collection. Its name prefixes the names
+// of its spans.
+func NewCollection[T any](name string, opts ...CollectionOption[T]) *Collection[T] {
+	c := &Collection[T]{
+		name:        name,
+		newID:       func() ID { return ID(uuid.New()) },
+		indexes:     make(map[string]func(T) string),
+		size:        func(T) int64 { return entryOverhead },
+		errNotFound: ErrNotFound,
+		errExists:   ErrAlreadyExists,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	v := &version[T]{
+		records: &trie[ID, *entry[T]]{},
+		indexes: make(map[string]*trie[indexKey, *trie[ID, struct{}]], len(c.indexes)),
+	}
+	for name := range c.indexes {
+		v.indexes[name] = &trie[indexKey, *trie[ID, struct{}]]{}
+	}
+	c.data.Store(v)
+
+	return c
+}
+
+func (c *Collection[T]) Insert(ctx context.Context, value T) (Record[T], error) {
+	ctx, span := c.startSpan(ctx, OpInsert)
+	defer span.End()
+
+	if err := c.check(value); err != nil {
+		return Record[T]{}, recordError(span, err)
+	}
+
+	defer c.lock(ctx, OpInsert)()
+
+	id := c.newID()
+	if _, exists := c.data.Load().records.get(id); exists {
+		return Record[T]{}, recordError(span, c.errExists)
+	}
+
+	if err := c.makeRoom(ctx, 1, c.size(value), id); err != nil {
+		return Record[T]{}, recordError(span, err)
+	}
+
+	c.put(id, value)
+
+	return Record[T]{ID: id, Value: value}, nil
+}
+
+// InsertWithID stores value under a caller-provided ID, e.g. to preserve
+// IDs when migrating from another system.
+func (c *Collection[T]) InsertWithID(ctx context.Context, id string, value T) (Record[T], error) {
+	ctx, span := c.startSpan(ctx, OpInsert)
+	defer span.End()
+
+	parsedID, err := parseID(id)
+	if err != nil {
+		return Record[T]{}, recordError(span, err)
+	}
+
+	if err := c.check(value); err != nil {
+		return Record[T]{}, recordError(span, err)
+	}
+
+	defer c.lock(ctx, OpInsert)()
+
+	if _, exists := c.data.Load().records.get(parsedID); exists {
+		return Record[T]{}, recordError(span, c.errExists)
+	}
+
+	if err := c.makeRoom(ctx, 1, c.size(value), parsedID); err != nil {
+		return Record[T]{}, recordError(span, err)
+	}
+
+	c.put(parsedID, value)
+
+	return Record[T]{ID: parsedID, Value: value}, nil
+}
+
+// Upsert replaces the record stored under id, creating it if it does not
+// exist. created reports which of the two happened.
+func (c *Collection[T]) Upsert(ctx context.Context, id string, value T) (record Record[T], created bool, err error) {
+	ctx, span := c.startSpan(ctx, OpUpsert)
+	defer span.End()
+
+	parsedID, err := parseID(id)
+	if err != nil {
+		return Record[T]{}, false, recordError(span, err)
+	}
+
+	if err := c.check(value); err != nil {
+		return Record[T]{}, false, recordError(span, err)
+	}
+
+	defer c.lock(ctx, OpUpsert)()
+
+	addRecords, addBytes := 1, c.size(value)
+	if current, exists := c.data.Load().records.get(parsedID); exists {
+		addRecords, addBytes = 0, c.size(value)-c.size(current.value)
+	}
+
+	if err := c.makeRoom(ctx, addRecords, addBytes, parsedID); err != nil {
+		return Record[T]{}, false, recordError(span, err)
+	}
+
+	c.put(parsedID, value)
+
+	return Record[T]{ID: parsedID, Value: value}, addRecords == 1, nil
+}
+
+func (c *Collection[T]) Update(ctx context.Context, id string, value T) (Record[T], error) {
+	return c.update(ctx, id, func(T) (T, error) {
+		return value, nil
+	})
+}
+
+// UpdateFunc replaces the record stored under id with the result of fn,
+// holding the lock in between so concurrent writes are not lost. An error
+// returned by fn aborts the update and is returned as is.
+func (c *Collection[T]) UpdateFunc(ctx context.Context, id string, fn func(T) (T, error)) (Record[T], error) {
+	return c.update(ctx, id, fn)
+}
+
+func (c *Collection[T]) update(ctx context.Context, id string, fn func(T) (T, error)) (Record[T], error) {
+	ctx, span := c.startSpan(ctx, OpUpdate)
+	defer span.End()
+
+	parsedID, err := parseID(id)
+	if err != nil {
+		return Record[T]{}, recordError(span, err)
+	}
+
+	defer c.lock(ctx, OpUpdate)()
+
+	current, exists := c.data.Load().records.get(parsedID)
+	if !exists {
+		return Record[T]{}, recordError(span, c.errNotFound)
+	}
+
+	value, err := fn(current.value)
+	if err != nil {
+		return Record[T]{}, recordError(span, err)
+	}
+
+	if err := c.check(value); err != nil {
+		return Record[T]{}, recordError(span, err)
+	}
+
+	if err := c.makeRoom(ctx, 0, c.size(value)-c.size(current.value), parsedID); err != nil {
+		return Record[T]{}, recordError(span, err)
+	}
+
+	c.put(parsedID, value)
+
+	return Record[T]{ID: parsedID, Value: value}, nil
+}
+
+func (c *Collection[T]) Delete(ctx context.Context, id string) (Record[T], error) {
+	return c.delete(ctx, id, c.beforeDelete)
+}
+
+// delete is Delete checked by before instead of beforeDelete, if not nil.
+func (c *Collection[T]) delete(ctx context.Context, id string, before func(ID) error) (Record[T], error) {
+	ctx, span := c.startSpan(ctx, OpDelete)
+	defer span.End()
+
+	parsedID, err := parseID(id)
+	if err != nil {
+		return Record[T]{}, recordError(span, err)
+	}
+
+	defer c.lock(ctx, OpDelete)()
+
+	e, exists := c.data.Load().records.get(parsedID)
+	if !exists {
+		return Record[T]{}, recordError(span, c.errNotFound)
+	}
+
+	if before != nil {
+		if err := before(parsedID); err != nil {
+			return Record[T]{}, recordError(span, err)
+		}
+	}
+
+	c.remove(parsedID, ChangeDeleted)
+
+	return Record[T]{ID: parsedID, Value: e.value}, nil
+}
+
+// FindAll returns every record of the latest version, without waiting for
+// or blocking writers. Scan visits them without copying them all at once.
+func (c *Collection[T]) FindAll(ctx context.Context) []Record[T] {
+	ctx, span := c.startSpan(ctx, OpFindAll)
+	defer span.End()
+
+	records := c.read(ctx, OpFindAll).records
+
+	all := make([]Record[T], 0, records.len())
+	records.each(func(id ID, e *entry[T]) bool {
+		all = append(all, Record[T]{ID: id, Value: e.value})
+		return true
+	})
+
+	return all
+}
+
+func (c *Collection[T]) FindByID(ctx context.Context, id string) (Record[T], bool) {
+	ctx, span := c.startSpan(ctx, OpFindByID)
+	defer span.End()
+
+	parsedID, err := parseID(id)
+	if err != nil {
+		recordError(span, err)
+		return Record[T]{}, false
+	}
+
+	e, exists := c.read(ctx, OpFindByID).records.get(parsedID)
+	if !exists {
+		return Record[T]{ID: parsedID}, false
+	}
+
+	if c.limits.usage != nil {
+		c.limits.usage.use(parsedID)
+	}
+
+	return Record[T]{ID: parsedID, Value: e.value}, true
+}
+
+// FindBy returns the records whose key in the index is key, in insertion
+// order.
+func (c *Collection[T]) FindBy(ctx context.Context, index string, key string) ([]Record[T], error) {
+	ctx, span := c.startSpan(ctx, OpFindBy)
+	defer span.End()
+
+	v := c.read(ctx, OpFindBy)
+
+	keys, ok := v.indexes[index]
+	if !ok {
+		return nil, recordError(span, fmt.Errorf("%w %q", ErrUnknownIndex, index))
+	}
+
+	ids, _ := keys.get(indexKey(key))
+
+	entries := make([]*entry[T], 0, ids.len())
+	records := make([]Record[T], 0, ids.len())
+	ids.each(func(id ID, _ struct{}) bool {
+		e, _ := v.records.get(id)
+		entries = append(entries, e)
+		records = append(records, Record[T]{ID: id, Value: e.value})
+		return true
+	})
+
+	sort.Sort(bySeq[T]{entries: entries, records: records})
+
+	return records, nil
+}
+
+// Count returns the number of stored records.
+func (c *Collection[T]) Count() int {
+	return c.data.Load().records.len()
+}
+
+// Bytes returns the estimated memory taken by the stored records.
+func (c *Collection[T]) Bytes() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.bytes
+}
+
+// LastModified returns when a record was last created, changed or
+// removed, the zero time if the collection never changed.
+func (c *Collection[T]) LastModified() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.modified
+}
+
+// ModTime returns when the record stored under id was last created or
+// changed. It does not count as a use for the eviction policies.
+func (c *Collection[T]) ModTime(id string) (time.Time, bool) {
+	parsedID, err := parseID(id)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	e, exists := c.data.Load().records.get(parsedID)
+	if !exists {
+		return time.Time{}, false
+	}
+
+	return e.modified, true
+}
+
+// Version changes whenever a record is created, changed or removed. It is
+// zero if the collection never changed.
+func (c *Collection[T]) Version() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.writes
+}
+
+// RecordVersion changes whenever the record stored under id is created or
+// changed, unlike its ModTime which only has the resolution of the clock.
+// It does not count as a use for the eviction policies.
+func (c *Collection[T]) RecordVersion(id string) (uint64, bool) {
+	parsedID, err := parseID(id)
+	if err != nil {
+		return 0, false
+	}
+
+	e, exists := c.data.Load().records.get(parsedID)
+	if !exists {
+		return 0, false
+	}
+
+	return e.version, true
+}
+
+// Check reports whether the collection can be written to, failing if the
+// lock cannot be acquired before ctx is done.
+func (c *Collection[T]) Check(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for !c.mu.TryRLock() {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("could not acquire the database lock: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	c.mu.RUnlock()
+
+	return nil
+}
+
+// restore replaces every record with records, in order, ignoring the
+// limits and the validation. It must be called with the write lock held.
+func (c *Collection[T]) restore(records []Record[T]) {
+	keep := make(map[ID]bool, len(records))
+	for _, record := range records {
+		keep[record.ID] = true
+	}
+
+	c.data.Load().records.each(func(id ID, _ *entry[T]) bool {
+		if !keep[id] {
+			c.remove(id, ChangeDeleted)
+		}
+		return true
+	})
+
+	for _, record := range records {
+		if e, exists := c.data.Load().records.get(record.ID); !exists || !reflect.DeepEqual(e.value, record.Value) {
+			c.put(record.ID, record.Value)
+		}
+	}
+}
+
+func (c *Collection[T]) check(value T) error {
+	if c.validate == nil {
+		return nil
+	}
+
+	if err := c.validate(value); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidValue, err)
+	}
+
+	return nil
+}
+
+// put stores value under id, replacing any existing record. It must be
+// called with the write lock held.
+func (c *Collection[T]) put(id ID, value T) {
+	changeType := ChangeCreated
+
+	v := c.data.Load()
+	c.writes++
+	e := &entry[T]{value: value, modified: time.Now().UTC(), version: c.writes}
+	current, exists := v.records.get(id)
+	if exists {
+		e.seq = current.seq
+		c.bytes -= c.size(current.value)
+		changeType = ChangeUpdated
+	} else {
+		c.seq++
+		e.seq = c.seq
+	}
+
+	if c.limits.usage != nil {
+		c.limits.usage.add(id)
+	}
+	c.data.Store(v.put(id, current, e, c.indexes))
+	c.modified = e.modified
+	c.bytes += c.size(value)
+
+	c.changed(changeType, Record[T]{ID: id, Value: value})
+}
+
+// remove must be called with the write lock held.
+func (c *Collection[T]) remove(id ID, changeType ChangeType) {
+	v := c.data.Load()
+	if e, exists := v.records.get(id); exists {
+		c.bytes -= c.size(e.value)
+		c.data.Store(v.remove(id, e, c.indexes))
+		if c.limits.usage != nil {
+			c.limits.usage.remove(id)
+		}
+		c.modified = time.Now().UTC()
+		c.writes++
+
+		c.changed(changeType, Record[T]{ID: id, Value: e.value})
+	}
+}
+
+func (c *Collection[T]) changed(changeType ChangeType, record Record[T]) {
+	if c.onChange != nil {
+		c.onChange(changeType, record)
+	}
+}
+
+// lock acquires the write lock, reporting how long it waited for it, and
+// returns the function releasing it.
+func (c *Collection[T]) lock(ctx context.Context, op Operation) func() {
+	start := time.Now()
+	c.mu.Lock()
+	c.observe(ctx, op, time.Since(start))
+
+	return c.mu.Unlock
+}
+
+// read returns the latest version of the records, which stays the same
+// while writers publish new ones.
+func (c *Collection[T]) read(ctx context.Context, op Operation) *version[T] {
+	c.observe(ctx, op, 0)
+
+	return c.data.Load()
+}
+
+func (c *Collection[T]) observe(ctx context.Context, op Operation, lockWait time.Duration) {
+	recordLockWait(ctx, lockWait)
+
+	if c.observer != nil {
+		c.observer.ObserveOperation(op, lockWait)
+	}
+}
+
+// put returns the version with e stored under id in place of current, nil
+// when the record is new.
+func (v *version[T]) put(id ID, current, e *entry[T], indexes map[string]func(T) string) *version[T] {
+	next := &version[T]{records: v.records.set(id, e), order: v.order, indexes: v.indexes}
+	if current == nil {
+		next.order = v.order.set(e.seq, id)
+	}
+	if len(indexes) == 0 {
+		return next
+	}
+
+	next.indexes = make(map[string]*trie[indexKey, *trie[ID, struct{}]], len(v.indexes))
+	for name, keys := range v.indexes {
+		key := indexKey(indexes[name](e.value))
+		if current != nil {
+			old := indexKey(indexes[name](current.value))
+			if old == key {
+				next.indexes[name] = keys
+				continue
+			}
+			keys = unindex(keys, old, id)
+		}
+
+		ids, _ := keys.get(key)
+		next.indexes[name] = keys.set(key, ids.set(id, struct{}{}))
+	}
+
+	return next
+}
+
+// remove returns the version without the record e stored under id.
+func (v *version[T]) remove(id ID, e *entry[T], indexes map[string]func(T) string) *version[T] {
+	next := &version[T]{records: v.records.delete(id), order: v.order.delete(e.seq), indexes: v.indexes}
+	if len(indexes) == 0 {
+		return next
+	}
+
+	next.indexes = make(map[string]*trie[indexKey, *trie[ID, struct{}]], len(v.indexes))
+	for name, keys := range v.indexes {
+		next.indexes[name] = unindex(keys, indexKey(indexes[name](e.value)), id)
+	}
+
+	return next
+}
+
+// unindex returns keys without id under key, dropping keys left empty.
+func unindex(keys *trie[indexKey, *trie[ID, struct{}]], key indexKey, id ID) *trie[indexKey, *trie[ID, struct{}]] {
+	ids, _ := keys.get(key)
+	if ids = ids.delete(id); ids.len() == 0 {
+		return keys.delete(key)
+	}
+
+	return keys.set(key, ids)
+}
+
+// bySeq sorts records in insertion order along with their entries.
+type bySeq[T any] struct {
+	entries []*entry[T]
+	records []Record[T]
+}
+
+func (s bySeq[T]) Len() int           { return len(s.entries) }
+func (s bySeq[T]) Less(i, j int) bool { return s.entries[i].seq < s.entries[j].seq }
+func (s bySeq[T]) Swap(i, j int) {
+	s.entries[i], s.entries[j] = s.entries[j], s.entries[i]
+	s.records[i], s.records[j] = s.records[j], s.records[i]
+}
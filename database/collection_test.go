@@ -0,0 +1,145 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type team struct {
+	Name string
+	City string
+}
+
+func newTeams(opts ...CollectionOption[team]) *Collection[team] {
+	opts = append([]CollectionOption[team]{
+		WithValidation(func(t team) error {
+			if t.Name == "" {
+				return errors.New("the name is required")
+			}
+			return nil
+		}),
+		WithIndex("city", func(t team) string { return t.City }),
+	}, opts...)
+
+	return NewCollection("teams", opts...)
+}
+
+func TestCollection(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("store and find records", func(t *testing.T) {
+		teams := newTeams()
+
+		record, err := teams.Insert(ctx, team{Name: "Owls", City: "Lyon"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		found, exists := teams.FindByID(ctx, record.ID.String())
+		if !exists || found != record {
+			t.Fatalf("expected to find %v, got %v", record, found)
+		}
+
+		if _, err := teams.Update(ctx, record.ID.String(), team{Name: "Hawks", City: "Lyon"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := teams.Delete(ctx, record.ID.String()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := teams.Delete(ctx, record.ID.String()); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected %v, got %v", ErrNotFound, err)
+		}
+	})
+
+	t.Run("reject invalid values", func(t *testing.T) {
+		teams := newTeams()
+
+		if _, err := teams.Insert(ctx, team{City: "Lyon"}); !errors.Is(err, ErrInvalidValue) {
+			t.Fatalf("expected %v, got %v", ErrInvalidValue, err)
+		}
+
+		record, _ := teams.Insert(ctx, team{Name: "Owls"})
+		if _, err := teams.Update(ctx, record.ID.String(), team{}); !errors.Is(err, ErrInvalidValue) {
+			t.Fatalf("expected %v, got %v", ErrInvalidValue, err)
+		}
+		if teams.Count() != 1 {
+			t.Fatalf("expected 1 record, got %d", teams.Count())
+		}
+	})
+
+	t.Run("generate ids", func(t *testing.T) {
+		id := ID{}.NewID()
+		teams := newTeams(WithIDs[team](func() ID { return id }))
+
+		if record, _ := teams.Insert(ctx, team{Name: "Owls"}); record.ID != id {
+			t.Fatalf("expected id %s, got %s", id, record.ID)
+		}
+		if _, err := teams.Insert(ctx, team{Name: "Hawks"}); !errors.Is(err, ErrAlreadyExists) {
+			t.Fatalf("expected %v, got %v", ErrAlreadyExists, err)
+		}
+	})
+
+	t.Run("find records by index", func(t *testing.T) {
+		teams := newTeams()
+
+		owls, _ := teams.Insert(ctx, team{Name: "Owls", City: "Lyon"})
+		hawks, _ := teams.Insert(ctx, team{Name: "Hawks", City: "Nice"})
+		bears, _ := teams.Insert(ctx, team{Name: "Bears", City: "Lyon"})
+
+		assertNames := func(t *testing.T, city string, want ...string) {
+			t.Helper()
+
+			records, err := teams.FindBy(ctx, "city", city)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != len(want) {
+				t.Fatalf("expected %v in %s, got %v", want, city, records)
+			}
+			for i, record := range records {
+				if record.Value.Name != want[i] {
+					t.Fatalf("expected %v in %s, got %v", want, city, records)
+				}
+			}
+		}
+
+		assertNames(t, "Lyon", "Owls", "Bears")
+		assertNames(t, "Nice", "Hawks")
+
+		teams.Update(ctx, owls.ID.String(), team{Name: "Owls", City: "Nice"})
+		teams.Delete(ctx, hawks.ID.String())
+		teams.Update(ctx, bears.ID.String(), team{Name: "Grizzlies", City: "Lyon"})
+
+		assertNames(t, "Lyon", "Grizzlies")
+		assertNames(t, "Nice", "Owls")
+		assertNames(t, "Paris")
+
+		if _, err := teams.FindBy(ctx, "name", "Owls"); !errors.Is(err, ErrUnknownIndex) {
+			t.Fatalf("expected %v, got %v", ErrUnknownIndex, err)
+		}
+	})
+
+	t.Run("list records a page at a time", func(t *testing.T) {
+		teams := newTeams()
+		for _, name := range []string{"Owls", "Hawks", "Bears"} {
+			teams.Insert(ctx, team{Name: name})
+		}
+
+		page, err := teams.List(ctx, Query[team]{Limit: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Records) != 2 || page.Next == "" {
+			t.Fatalf("expected a first page of 2 records, got %v", page)
+		}
+
+		page, err = teams.List(ctx, Query[team]{Cursor: page.Next, Limit: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Records) != 1 || page.Records[0].Value.Name != "Bears" || page.Next != "" {
+			t.Fatalf("expected a last page with Bears, got %v", page)
+		}
+	})
+}
@@ -1,15 +1,25 @@
 package database
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
-	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 var ErrUserDoesNotExist = errors.New("user does not exist")
+var ErrUserAlreadyExists = errors.New("user already exists")
+var ErrInvalidID = errors.New("invalid id")
 
+// ID identifies a record. It is encoded as its UUID string, e.g.
+// "6ba7b810-9dad-11d1-80b4-00c04fd430c8", in every format but JSON, which
+// keeps the array of its 16 bytes existing clients decode. JSON input may
+// use either form.
 type ID uuid.UUID
 
 func (i ID) NewID() ID {
@@ -24,119 +34,268 @@ func (i ID) String() string {
 	return uuid.UUID(i).String()
 }
 
+func (i ID) MarshalText() ([]byte, error) {
+	return []byte(i.String()), nil
+}
+
+// UnmarshalText reads back any ID MarshalText wrote, the zero one of a
+// change without a user included. IDs sent by clients go through ParseID.
+func (i *ID) UnmarshalText(text []byte) error {
+	parsedID, err := uuid.Parse(string(text))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidID, err)
+	}
+
+	*i = ID(parsedID)
+	return nil
+}
+
+// MarshalJSON keeps the JSON wire format of IDs, an array of 16 bytes.
+func (i ID) MarshalJSON() ([]byte, error) {
+	return json.Marshal([16]byte(i))
+}
+
+// UnmarshalJSON reads an ID as an array of 16 bytes or as a UUID string.
+func (i *ID) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var text string
+		if err := json.Unmarshal(data, &text); err != nil {
+			return err
+		}
+
+		return i.UnmarshalText([]byte(text))
+	}
+
+	// a [16]byte would silently take arrays of any length
+	var bytes []int
+	if err := json.Unmarshal(data, &bytes); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidID, err)
+	}
+	if len(bytes) != len(i) {
+		return fmt.Errorf("%w: expected %d bytes, got %d", ErrInvalidID, len(i), len(bytes))
+	}
+
+	for n, b := range bytes {
+		if b < 0 || b > 255 {
+			return fmt.Errorf("%w: %d is not a byte", ErrInvalidID, b)
+		}
+		i[n] = byte(b)
+	}
+	return nil
+}
+
+// ParseID parses a record ID, rejecting malformed and nil UUIDs.
+func ParseID(id string) (ID, error) {
+	parsedID, err := uuid.Parse(id)
+	if err != nil {
+		return ID{}, fmt.Errorf("%w: %w", ErrInvalidID, err)
+	}
+
+	if parsedID == uuid.Nil {
+		return ID{}, fmt.Errorf("%w: the nil UUID is reserved", ErrInvalidID)
+	}
+
+	return ID(parsedID), nil
+}
+
 type User struct {
-	FirstName string `json:"first_name" validate:"required,min=2,max=20"`
-	LastName  string `json:"last_name" validate:"required,min=2,max=20"`
-	Biography string `json:"biography" validate:"required,min=20,max=450"`
+	FirstName string `json:"first_name" yaml:"first_name" xml:"first_name" validate:"required,min=2,max=20"`
+	LastName  string `json:"last_name" yaml:"last_name" xml:"last_name" validate:"required,min=2,max=20"`
+	Biography string `json:"biography" yaml:"biography" xml:"biography" validate:"required,min=20,max=450"`
+}
+
+// LogValue keeps every field under its JSON name so loggers can redact the
+// biography like any other attribute.
+func (u User) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("first_name", u.FirstName),
+		slog.String("last_name", u.LastName),
+		slog.String("biography", u.Biography),
+	)
 }
 
 type DBUser struct {
-	ID   ID   `json:"id"`
-	User User `json:"user"`
+	ID   ID   `json:"id" yaml:"id" xml:"id" swaggertype:"array,integer"`
+	User User `json:"user" yaml:"user" xml:"user"`
 }
 
 func (d DBUser) IsEmpty() bool {
 	return d.User == User{} && d.ID == ID(uuid.Nil)
 }
 
+// InMemoryDB stores the users in a Collection, along with the feed of
+// their changes and who follows whom.
 type InMemoryDB struct {
-	mu   sync.RWMutex
-	data map[ID]User
+	users *Collection[User]
+	feed  feed
+	// graph is replaced under the write lock of users, so follows always
+	// link stored users
+	graph        atomic.Pointer[followGraph]
+	deletePolicy DeletePolicy
 }
 
-func NewInMemoryDB() *InMemoryDB {
-	return &InMemoryDB{
-		data: make(map[ID]User),
+func NewInMemoryDB(opts ...Option) *InMemoryDB {
+	db := &InMemoryDB{users: NewCollection[User]("InMemoryDB")}
+	db.users.size = User.size
+	db.users.errNotFound = ErrUserDoesNotExist
+	db.users.errExists = ErrUserAlreadyExists
+	db.users.onChange = func(changeType ChangeType, record Record[User]) {
+		if changeType == ChangeDeleted || changeType == ChangeEvicted {
+			db.unlinkUser(record.ID)
+		}
+		db.publish(Change{Type: changeType, User: toDBUser(record)})
+	}
+	db.users.beforeDelete = db.checkDelete
+	db.graph.Store(&followGraph{})
+
+	for _, opt := range opts {
+		opt(db)
 	}
+
+	return db
 }
 
-func (db *InMemoryDB) Insert(value User) DBUser {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+func (db *InMemoryDB) Insert(ctx context.Context, value User) (DBUser, error) {
+	record, err := db.users.Insert(ctx, value)
+	return toDBUser(record), err
+}
 
-	id := ID(uuid.New())
-	db.data[id] = value
+// InsertWithID stores value under a caller-provided ID, e.g. to preserve
+// IDs when migrating from another system.
+func (db *InMemoryDB) InsertWithID(ctx context.Context, id string, value User) (DBUser, error) {
+	record, err := db.users.InsertWithID(ctx, id, value)
+	return toDBUser(record), err
+}
 
-	return DBUser{
-		ID:   id,
-		User: value,
-	}
+// Upsert replaces the user stored under id, creating it if it does not
+// exist. created reports which of the two happened.
+func (db *InMemoryDB) Upsert(ctx context.Context, id string, value User) (user DBUser, created bool, err error) {
+	record, created, err := db.users.Upsert(ctx, id, value)
+	return toDBUser(record), created, err
 }
 
-func (db *InMemoryDB) Update(id string, updatedUser User) (DBUser, error) {
-	parsedID, err := parseID(id)
-	if err != nil {
-		return DBUser{}, err
-	}
+func (db *InMemoryDB) Update(ctx context.Context, id string, updatedUser User) (DBUser, error) {
+	record, err := db.users.Update(ctx, id, updatedUser)
+	return toDBUser(record), err
+}
 
-	db.mu.Lock()
-	defer db.mu.Unlock()
+// UpdateFunc replaces the user stored under id with the result of fn,
+// holding the lock in between so concurrent writes are not lost. An error
+// returned by fn aborts the update and is returned as is.
+func (db *InMemoryDB) UpdateFunc(ctx context.Context, id string, fn func(User) (User, error)) (DBUser, error) {
+	record, err := db.users.UpdateFunc(ctx, id, fn)
+	return toDBUser(record), err
+}
 
-	if _, exists := db.data[parsedID]; !exists {
-		return DBUser{}, ErrUserDoesNotExist
-	}
+// Patch changes the fields set in patch on the user stored under id.
+func (db *InMemoryDB) Patch(ctx context.Context, id string, patch UserPatch) (DBUser, error) {
+	return db.UpdateFunc(ctx, id, func(user User) (User, error) {
+		return patch.Apply(user), nil
+	})
+}
 
-	db.data[parsedID] = updatedUser
+func (db *InMemoryDB) Delete(ctx context.Context, id string) (DBUser, error) {
+	record, err := db.users.Delete(ctx, id)
+	return toDBUser(record), err
+}
 
-	return DBUser{
-		ID:   parsedID,
-		User: updatedUser,
-	}, nil
+// ForceDelete is Delete ignoring the delete policy, the follows of the user
+// are removed with it. Replicas use it to apply the deletions their source
+// already allowed.
+func (db *InMemoryDB) ForceDelete(ctx context.Context, id string) (DBUser, error) {
+	record, err := db.users.delete(ctx, id, nil)
+	return toDBUser(record), err
 }
 
-func (db *InMemoryDB) Delete(id string) (DBUser, error) {
-	parsedID, err := parseID(id)
-	if err != nil {
-		return DBUser{}, err
+// FindAll returns every user of the latest version, without waiting for
+// or blocking writers. Scan visits them without copying them all at once.
+func (db *InMemoryDB) FindAll(ctx context.Context) []DBUser {
+	records := db.users.FindAll(ctx)
+
+	users := make([]DBUser, 0, len(records))
+	for _, record := range records {
+		users = append(users, toDBUser(record))
 	}
 
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	return users
+}
 
-	user, exists := db.data[parsedID]
-	if !exists {
-		return DBUser{}, ErrUserDoesNotExist
-	}
+func (db *InMemoryDB) FindByID(ctx context.Context, id string) (DBUser, bool) {
+	record, exists := db.users.FindByID(ctx, id)
+	return toDBUser(record), exists
+}
 
-	delete(db.data, parsedID)
+// Count returns the number of stored users.
+func (db *InMemoryDB) Count() int {
+	return db.users.Count()
+}
 
-	return DBUser{ID: parsedID, User: user}, nil
+// Bytes returns the estimated memory taken by the stored users.
+func (db *InMemoryDB) Bytes() int64 {
+	return db.users.Bytes()
 }
 
-func (db *InMemoryDB) FindAll() []DBUser {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
+// LastModified returns when a user was last created, changed or removed,
+// the zero time if the store never changed.
+func (db *InMemoryDB) LastModified() time.Time {
+	return db.users.LastModified()
+}
 
-	users := make([]DBUser, 0, len(db.data))
-	for id, user := range db.data {
-		users = append(users, DBUser{
-			ID:   id,
-			User: user,
-		})
-	}
+// ModTime returns when the user stored under id was last created or
+// changed. It does not count as a use for the eviction policies.
+func (db *InMemoryDB) ModTime(id string) (time.Time, bool) {
+	return db.users.ModTime(id)
+}
 
-	return users
+// Version changes whenever a user is created, changed or removed. It is
+// zero if the store never changed.
+func (db *InMemoryDB) Version() uint64 {
+	return db.users.Version()
 }
 
-func (db *InMemoryDB) FindByID(id string) (DBUser, bool) {
-	parsedID, err := parseID(id)
-	if err != nil {
-		return DBUser{}, false
+// UserVersion changes whenever the user stored under id is created or
+// changed. It does not count as a use for the eviction policies.
+func (db *InMemoryDB) UserVersion(id string) (uint64, bool) {
+	return db.users.RecordVersion(id)
+}
+
+type Stats struct {
+	Users int   `json:"users" yaml:"users" xml:"users"`
+	Bytes int64 `json:"bytes" yaml:"bytes" xml:"bytes"`
+	// Changes is the sequence number of the latest change.
+	Changes uint64 `json:"changes" yaml:"changes" xml:"changes"`
+}
+
+func (db *InMemoryDB) Stats() Stats {
+	db.users.mu.RLock()
+	defer db.users.mu.RUnlock()
+
+	db.feed.mu.Lock()
+	defer db.feed.mu.Unlock()
+
+	return Stats{
+		Users:   db.users.data.Load().records.len(),
+		Bytes:   db.users.bytes,
+		Changes: db.feed.seq,
 	}
+}
 
-	db.mu.RLock()
-	defer db.mu.RUnlock()
+// Check reports whether the database can be read from, failing if the lock
+// cannot be acquired before ctx is done.
+func (db *InMemoryDB) Check(ctx context.Context) error {
+	return db.users.Check(ctx)
+}
 
-	user, exists := db.data[parsedID]
-	return DBUser{ID: parsedID, User: user}, exists
+func toDBUser(record Record[User]) DBUser {
+	return DBUser{ID: record.ID, User: record.Value}
 }
 
 func parseID(id string) (ID, error) {
-	parsedID, err := uuid.Parse(id)
+	parsedID, err := ParseID(id)
 	if err != nil {
 		slog.Error("could not parse the id", "error", err)
 		return ID{}, err
 	}
 
-	return ID(parsedID), nil
+	return parsedID, nil
 }
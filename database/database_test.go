@@ -1,7 +1,11 @@
 package database
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"testing"
 )
@@ -25,9 +29,12 @@ func TestInMemoryDB(t *testing.T) {
 
 		user := users[0]
 
-		dbUser := db.Insert(user)
+		dbUser, err := db.Insert(context.Background(), user)
+		if err != nil {
+			t.Fatal(err)
+		}
 
-		got, exists := db.FindByID(dbUser.ID.String())
+		got, exists := db.FindByID(context.Background(), dbUser.ID.String())
 
 		if !exists {
 			t.Fatalf("expected the user to exist in the database")
@@ -42,10 +49,10 @@ func TestInMemoryDB(t *testing.T) {
 		db := NewInMemoryDB()
 
 		for _, user := range users {
-			db.Insert(user)
+			db.Insert(context.Background(), user)
 		}
 
-		got := db.FindAll()
+		got := db.FindAll(context.Background())
 
 		if len(got) != len(users) {
 			t.Fatalf("expected the number of users to be %d, got %d", len(users), len(got))
@@ -57,11 +64,14 @@ func TestInMemoryDB(t *testing.T) {
 
 		user := users[0]
 
-		dbUser := db.Insert(user)
+		dbUser, err := db.Insert(context.Background(), user)
+		if err != nil {
+			t.Fatal(err)
+		}
 
-		db.Delete(dbUser.ID.String())
+		db.Delete(context.Background(), dbUser.ID.String())
 
-		_, exists := db.FindByID(dbUser.ID.String())
+		_, exists := db.FindByID(context.Background(), dbUser.ID.String())
 
 		if exists {
 			t.Fatalf("expected the user to be deleted from the database")
@@ -71,7 +81,7 @@ func TestInMemoryDB(t *testing.T) {
 	t.Run("delete a user that doesn't exist", func(t *testing.T) {
 		db := NewInMemoryDB()
 
-		_, err := db.Delete(ID{}.NewID().String())
+		_, err := db.Delete(context.Background(), ID{}.NewID().String())
 
 		if err != ErrUserDoesNotExist {
 			t.Fatalf("expected the error to be %v, got %v", ErrUserDoesNotExist, err)
@@ -83,13 +93,16 @@ func TestInMemoryDB(t *testing.T) {
 
 		user := users[0]
 
-		dbUser := db.Insert(user)
+		dbUser, err := db.Insert(context.Background(), user)
+		if err != nil {
+			t.Fatal(err)
+		}
 
 		updatedUser := users[1]
 
-		db.Update(dbUser.ID.String(), updatedUser)
+		db.Update(context.Background(), dbUser.ID.String(), updatedUser)
 
-		got, exists := db.FindByID(dbUser.ID.String())
+		got, exists := db.FindByID(context.Background(), dbUser.ID.String())
 
 		if !exists {
 			t.Fatalf("expected the user to exist in the database")
@@ -110,12 +123,152 @@ func TestInMemoryDB(t *testing.T) {
 
 		updatedUser := users[1]
 
-		_, err := db.Update(ID{}.NewID().String(), updatedUser)
+		_, err := db.Update(context.Background(), ID{}.NewID().String(), updatedUser)
 
 		if err != ErrUserDoesNotExist {
 			t.Fatalf("expected the error to be %v, got %v", ErrUserDoesNotExist, err)
 		}
 	})
+
+	t.Run("insert a user with a given id", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		id := ID{}.NewID()
+
+		dbUser, err := db.InsertWithID(context.Background(), id.String(), users[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if dbUser.ID != id {
+			t.Fatalf("expected the id to be %v, got %v", id, dbUser.ID)
+		}
+
+		_, err = db.InsertWithID(context.Background(), id.String(), users[1])
+
+		if !errors.Is(err, ErrUserAlreadyExists) {
+			t.Fatalf("expected the error to be %v, got %v", ErrUserAlreadyExists, err)
+		}
+	})
+
+	t.Run("upsert creates then replaces a user", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		id := ID{}.NewID().String()
+
+		_, created, err := db.Upsert(context.Background(), id, users[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !created {
+			t.Fatalf("expected the user to be created")
+		}
+
+		_, created, err = db.Upsert(context.Background(), id, users[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if created {
+			t.Fatalf("expected the user to be replaced")
+		}
+
+		got, _ := db.FindByID(context.Background(), id)
+
+		if got.User != users[1] {
+			t.Fatalf("expected the user to be %v, got %v", users[1], got.User)
+		}
+
+		if db.Count() != 1 {
+			t.Fatalf("expected %d user, got %d", 1, db.Count())
+		}
+	})
+
+	t.Run("reject malformed ids", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		for _, id := range []string{"not-a-uuid", ID{}.String()} {
+			_, _, err := db.Upsert(context.Background(), id, users[0])
+
+			if !errors.Is(err, ErrInvalidID) {
+				t.Fatalf("expected the error for %q to be %v, got %v", id, ErrInvalidID, err)
+			}
+		}
+	})
+
+	t.Run("ids round trip through JSON", func(t *testing.T) {
+		id := ID{}.NewID()
+
+		data, err := json.Marshal(DBUser{ID: id, User: users[0]})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var decoded DBUser
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+
+		if decoded.ID != id {
+			t.Fatalf("expected the id to round trip as %q, got %s", id, data)
+		}
+	})
+}
+
+func TestID(t *testing.T) {
+	id, err := ParseID("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("encode as an array of bytes in JSON", func(t *testing.T) {
+		data, err := json.Marshal(DBUser{ID: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := `{"id":[107,167,184,16,157,173,17,209,128,180,0,192,79,212,48,200],"user":{"first_name":"","last_name":"","biography":""}}`
+		if string(data) != want {
+			t.Fatalf("expected %s, got %s", want, data)
+		}
+
+		var decoded DBUser
+		if err := json.Unmarshal(data, &decoded); err != nil || decoded.ID != id {
+			t.Fatalf("expected to decode %s, got %s and %v", id, decoded.ID, err)
+		}
+	})
+
+	t.Run("encode as a UUID string in text", func(t *testing.T) {
+		text, err := id.MarshalText()
+		if err != nil || string(text) != "6ba7b810-9dad-11d1-80b4-00c04fd430c8" {
+			t.Fatalf("expected the UUID string, got %s and %v", text, err)
+		}
+	})
+
+	t.Run("decode UUID strings from JSON", func(t *testing.T) {
+		var decoded DBUser
+		if err := json.Unmarshal([]byte(`{"id":"6ba7b810-9dad-11d1-80b4-00c04fd430c8"}`), &decoded); err != nil || decoded.ID != id {
+			t.Fatalf("expected to decode %s, got %s and %v", id, decoded.ID, err)
+		}
+	})
+
+	t.Run("reject arrays of other than 16 bytes", func(t *testing.T) {
+		for _, data := range []string{`[1,2,3]`, `[` + strings.Repeat("1,", 16) + `1]`, `[256` + strings.Repeat(",0", 15) + `]`} {
+			var decoded ID
+			if err := json.Unmarshal([]byte(data), &decoded); !errors.Is(err, ErrInvalidID) {
+				t.Fatalf("expected %s to be rejected, got %s and %v", data, decoded, err)
+			}
+		}
+	})
+
+	t.Run("decode the nil UUID clients cannot send", func(t *testing.T) {
+		var decoded ID
+		if err := decoded.UnmarshalText([]byte(ID{}.String())); err != nil || !decoded.IsEmpty() {
+			t.Fatalf("expected the nil UUID to decode, got %s and %v", decoded, err)
+		}
+		if _, err := ParseID(ID{}.String()); !errors.Is(err, ErrInvalidID) {
+			t.Fatalf("expected the error to be %v, got %v", ErrInvalidID, err)
+		}
+	})
 }
 
 func TestConcurrent(t *testing.T) {
@@ -129,7 +282,7 @@ func TestConcurrent(t *testing.T) {
 
 		go func(i int) {
 			defer wg.Done()
-			db.Insert(User{
+			db.Insert(context.Background(), User{
 				FirstName: fmt.Sprintf("John%d", i),
 				LastName:  "Doe",
 				Biography: "A simple guy who loves to write code and play games. He is a fan of technology and loves to read about new things.",
@@ -139,9 +292,315 @@ func TestConcurrent(t *testing.T) {
 
 	wg.Wait()
 
-	users := db.FindAll()
+	users := db.FindAll(context.Background())
 
 	if len(users) != numRoutines {
 		t.Fatalf("expected the number of users to be %d, got %d", numRoutines, len(users))
 	}
 }
+
+func newUser(name string) User {
+	return User{
+		FirstName: name,
+		LastName:  "Doe",
+		Biography: "A simple guy who loves to write code and play games.",
+	}
+}
+
+func insert(t testing.TB, db *InMemoryDB, name string) DBUser {
+	t.Helper()
+
+	user, err := db.Insert(context.Background(), newUser(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+func TestLimits(t *testing.T) {
+	t.Run("reject writes when full", func(t *testing.T) {
+		db := NewInMemoryDB(WithMaxUsers(1))
+
+		insert(t, db, "John")
+
+		_, err := db.Insert(context.Background(), newUser("Jane"))
+
+		if !errors.Is(err, ErrStorageFull) {
+			t.Fatalf("expected the error to be %v, got %v", ErrStorageFull, err)
+		}
+	})
+
+	t.Run("reject users larger than the byte limit", func(t *testing.T) {
+		db := NewInMemoryDB(WithMaxBytes(10), WithEvictionPolicy(EvictOldest))
+
+		_, err := db.Insert(context.Background(), newUser("John"))
+
+		if !errors.Is(err, ErrStorageFull) {
+			t.Fatalf("expected the error to be %v, got %v", ErrStorageFull, err)
+		}
+	})
+
+	t.Run("reject updates growing past the byte limit", func(t *testing.T) {
+		user := newUser("John")
+		db := NewInMemoryDB(WithMaxBytes(user.size()))
+
+		dbUser := insert(t, db, "John")
+
+		bigger := user
+		bigger.Biography += " He also likes to cook."
+
+		_, err := db.Update(context.Background(), dbUser.ID.String(), bigger)
+
+		if !errors.Is(err, ErrStorageFull) {
+			t.Fatalf("expected the error to be %v, got %v", ErrStorageFull, err)
+		}
+	})
+
+	t.Run("evict the oldest user", func(t *testing.T) {
+		var evicted []DBUser
+		db := NewInMemoryDB(
+			WithMaxUsers(2),
+			WithEvictionPolicy(EvictOldest),
+			WithEvictionHandler(func(user DBUser) { evicted = append(evicted, user) }),
+		)
+
+		first := insert(t, db, "John")
+		second := insert(t, db, "Jane")
+		db.FindByID(context.Background(), first.ID.String())
+		insert(t, db, "Jack")
+
+		if len(evicted) != 1 || evicted[0].ID != first.ID {
+			t.Fatalf("expected %v to be evicted, got %v", first, evicted)
+		}
+
+		if _, exists := db.FindByID(context.Background(), second.ID.String()); !exists {
+			t.Fatalf("expected %v to be kept", second)
+		}
+	})
+
+	t.Run("evict the least recently used user", func(t *testing.T) {
+		db := NewInMemoryDB(WithMaxUsers(2), WithEvictionPolicy(EvictLRU))
+
+		first := insert(t, db, "John")
+		second := insert(t, db, "Jane")
+		db.FindByID(context.Background(), first.ID.String())
+		insert(t, db, "Jack")
+
+		if _, exists := db.FindByID(context.Background(), second.ID.String()); exists {
+			t.Fatalf("expected %v to be evicted", second)
+		}
+
+		if _, exists := db.FindByID(context.Background(), first.ID.String()); !exists {
+			t.Fatalf("expected %v to be kept", first)
+		}
+	})
+
+	t.Run("evict the least frequently used user", func(t *testing.T) {
+		db := NewInMemoryDB(WithMaxUsers(2), WithEvictionPolicy(EvictLFU))
+
+		first := insert(t, db, "John")
+		second := insert(t, db, "Jane")
+		db.FindByID(context.Background(), first.ID.String())
+		db.FindByID(context.Background(), first.ID.String())
+		db.FindByID(context.Background(), second.ID.String())
+		insert(t, db, "Jack")
+
+		if _, exists := db.FindByID(context.Background(), second.ID.String()); exists {
+			t.Fatalf("expected %v to be evicted", second)
+		}
+	})
+
+	t.Run("track the stored bytes", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		user := insert(t, db, "John")
+
+		if db.Bytes() != newUser("John").size() {
+			t.Fatalf("expected %d bytes, got %d", newUser("John").size(), db.Bytes())
+		}
+
+		db.Delete(context.Background(), user.ID.String())
+
+		if db.Bytes() != 0 {
+			t.Fatalf("expected %d bytes, got %d", 0, db.Bytes())
+		}
+	})
+}
+
+func TestSubscribe(t *testing.T) {
+	t.Run("receive every change in order", func(t *testing.T) {
+		db := NewInMemoryDB(WithMaxUsers(1), WithEvictionPolicy(EvictOldest))
+		changes, cancel := db.Subscribe()
+		defer cancel()
+
+		first := insert(t, db, "John")
+		db.Update(context.Background(), first.ID.String(), newUser("Jack"))
+		second := insert(t, db, "Jane")
+		db.Delete(context.Background(), second.ID.String())
+
+		want := []struct {
+			changeType ChangeType
+			id         ID
+		}{
+			{ChangeCreated, first.ID},
+			{ChangeUpdated, first.ID},
+			{ChangeEvicted, first.ID},
+			{ChangeCreated, second.ID},
+			{ChangeDeleted, second.ID},
+		}
+
+		for i, w := range want {
+			change := <-changes
+			if change.Seq != uint64(i+1) || change.Type != w.changeType || change.User.ID != w.id {
+				t.Fatalf("expected change %d to be %s of %s, got %+v", i+1, w.changeType, w.id, change)
+			}
+		}
+
+		if db.Stats().Changes != uint64(len(want)) {
+			t.Fatalf("expected %d changes, got %d", len(want), db.Stats().Changes)
+		}
+	})
+
+	t.Run("drop slow subscribers", func(t *testing.T) {
+		db := NewInMemoryDB()
+		changes, cancel := db.Subscribe()
+		defer cancel()
+
+		for i := 0; i < changeBuffer+1; i++ {
+			insert(t, db, "John")
+		}
+
+		var received int
+		for range changes {
+			received++
+		}
+
+		if received != changeBuffer {
+			t.Fatalf("expected %d changes before being dropped, got %d", changeBuffer, received)
+		}
+	})
+}
+
+func TestModTime(t *testing.T) {
+	ctx := context.Background()
+	db := NewInMemoryDB()
+
+	if !db.LastModified().IsZero() {
+		t.Fatalf("expected a new store to never be modified, got %v", db.LastModified())
+	}
+
+	user := insert(t, db, "John")
+
+	created, ok := db.ModTime(user.ID.String())
+	if !ok || created.IsZero() || !db.LastModified().Equal(created) {
+		t.Fatalf("expected the user and the store to be modified at once, got %v and %v", created, db.LastModified())
+	}
+
+	db.Update(ctx, user.ID.String(), newUser("Jack"))
+
+	updated, _ := db.ModTime(user.ID.String())
+	if updated.Before(created) {
+		t.Fatalf("expected the update at %v to be after the insert at %v", updated, created)
+	}
+
+	db.Delete(ctx, user.ID.String())
+
+	if _, ok := db.ModTime(user.ID.String()); ok {
+		t.Fatalf("expected a deleted user to have no modification time")
+	}
+	if db.LastModified().Before(updated) {
+		t.Fatalf("expected the delete to modify the store")
+	}
+}
+
+func TestChangesSince(t *testing.T) {
+	t.Run("return the changes kept in the log", func(t *testing.T) {
+		db := NewInMemoryDB(WithChangeLog(3))
+
+		for i := 0; i < 5; i++ {
+			insert(t, db, "John")
+		}
+
+		changes, err := db.ChangesSince(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changes) != 3 || changes[0].Seq != 3 || changes[2].Seq != 5 {
+			t.Fatalf("expected changes 3 to 5, got %+v", changes)
+		}
+
+		if changes, err := db.ChangesSince(5); err != nil || len(changes) != 0 {
+			t.Fatalf("expected no changes after the latest one, got %+v and %v", changes, err)
+		}
+	})
+
+	t.Run("fail when the log was truncated", func(t *testing.T) {
+		db := NewInMemoryDB(WithChangeLog(3))
+
+		for i := 0; i < 5; i++ {
+			insert(t, db, "John")
+		}
+
+		if _, err := db.ChangesSince(1); !errors.Is(err, ErrChangesTruncated) {
+			t.Fatalf("expected %v, got %v", ErrChangesTruncated, err)
+		}
+		if _, err := db.ChangesSince(6); !errors.Is(err, ErrChangesTruncated) {
+			t.Fatalf("expected a future change to fail with %v, got %v", ErrChangesTruncated, err)
+		}
+	})
+
+	t.Run("keep no changes by default", func(t *testing.T) {
+		db := NewInMemoryDB()
+		insert(t, db, "John")
+
+		if _, err := db.ChangesSince(0); !errors.Is(err, ErrChangesTruncated) {
+			t.Fatalf("expected %v, got %v", ErrChangesTruncated, err)
+		}
+	})
+}
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+
+	db := NewInMemoryDB()
+	john := insert(t, db, "John")
+	jane := insert(t, db, "Jane")
+	db.Update(ctx, john.ID.String(), newUser("Jack"))
+	db.Follow(ctx, jane.ID.String(), john.ID.String())
+
+	snapshot := db.Snapshot(ctx)
+	if snapshot.Seq != 4 {
+		t.Fatalf("expected the snapshot to be at change 4, got %d", snapshot.Seq)
+	}
+	if len(snapshot.Follows) != 1 || snapshot.Follows[0] != (Follow{Follower: jane.ID, Followee: john.ID}) {
+		t.Fatalf("expected Jane to follow Jack, got %+v", snapshot.Follows)
+	}
+	if len(snapshot.Users) != 2 || snapshot.Users[0].ID != john.ID || snapshot.Users[0].User.FirstName != "Jack" || snapshot.Users[1].ID != jane.ID {
+		t.Fatalf("expected Jack then Jane, got %+v", snapshot.Users)
+	}
+
+	restored := NewInMemoryDB()
+	stale := insert(t, restored, "Joe")
+	restored.Restore(ctx, snapshot)
+
+	if _, exists := restored.FindByID(ctx, stale.ID.String()); exists {
+		t.Fatalf("expected users missing from the snapshot to be removed")
+	}
+
+	page, err := restored.List(ctx, ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Users) != 2 || page.Users[0] != snapshot.Users[0] || page.Users[1] != snapshot.Users[1] {
+		t.Fatalf("expected the users of the snapshot, got %+v", page.Users)
+	}
+
+	followers, err := restored.Followers(ctx, john.ID.String(), ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(followers.Users) != 1 || followers.Users[0].ID != jane.ID {
+		t.Fatalf("expected the follows of the snapshot, got %+v", followers.Users)
+	}
+}
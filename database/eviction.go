@@ -0,0 +1,334 @@
+package database
+
+import (
+	"container/heap"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var ErrStorageFull = errors.New("the database is full")
+
+// EvictionPolicy decides what happens to a write that would exceed the
+// configured limits.
+type EvictionPolicy string
+
+const (
+	// EvictReject fails the write with ErrStorageFull.
+	EvictReject EvictionPolicy = "reject"
+	// EvictLRU removes the least recently read or written records.
+	EvictLRU EvictionPolicy = "lru"
+	// EvictLFU removes the least frequently read or written records.
+	EvictLFU EvictionPolicy = "lfu"
+	// EvictOldest removes the earliest inserted records.
+	EvictOldest EvictionPolicy = "oldest"
+)
+
+func ParseEvictionPolicy(s string) (EvictionPolicy, error) {
+	switch policy := EvictionPolicy(s); policy {
+	case EvictReject, EvictLRU, EvictLFU, EvictOldest:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown eviction policy %q", s)
+	}
+}
+
+// entryOverhead approximates the bytes a user takes besides its strings:
+// the ID, the string headers and the bookkeeping of the entry.
+const entryOverhead = 16 + 3*16 + 3*8
+
+// entry is a record as stored in a version of a collection. Writes replace
+// it rather than change it.
+type entry[T any] struct {
+	value T
+	// seq is the insertion order, used by EvictOldest
+	seq      uint64
+	modified time.Time
+	// version is the write of the collection that stored the entry
+	version uint64
+}
+
+func (u User) size() int64 {
+	return int64(entryOverhead + len(u.FirstName) + len(u.LastName) + len(u.Biography))
+}
+
+type limits[T any] struct {
+	maxRecords int
+	maxBytes   int64
+	policy     EvictionPolicy
+	// usage orders the records for EvictLRU and EvictLFU, nil otherwise
+	usage   usage
+	onEvict func(Record[T])
+}
+
+// usage orders the records by how they are used, so that the record to
+// evict first is found without scanning them all. Readers update it too,
+// so it has a lock of its own.
+type usage interface {
+	// add tracks a record created or updated, which counts as a use.
+	add(id ID)
+	// use counts a read of id, unless it is not tracked anymore: the
+	// reader may have found it in a version that is already outdated.
+	use(id ID)
+	remove(id ID)
+	// victim returns the record to evict first besides keep.
+	victim(keep ID) (ID, bool)
+}
+
+func newUsage(policy EvictionPolicy) usage {
+	switch policy {
+	case EvictLRU:
+		return &recentUsage{elems: make(map[ID]*list.Element), recent: list.New()}
+	case EvictLFU:
+		return &frequentUsage{items: make(map[ID]*usageItem)}
+	default:
+		return nil
+	}
+}
+
+// recentUsage orders the records from most to least recently used.
+type recentUsage struct {
+	mu     sync.Mutex
+	elems  map[ID]*list.Element
+	recent *list.List
+}
+
+func (u *recentUsage) add(id ID) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if elem, ok := u.elems[id]; ok {
+		u.recent.MoveToFront(elem)
+		return
+	}
+	u.elems[id] = u.recent.PushFront(id)
+}
+
+func (u *recentUsage) use(id ID) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if elem, ok := u.elems[id]; ok {
+		u.recent.MoveToFront(elem)
+	}
+}
+
+func (u *recentUsage) remove(id ID) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if elem, ok := u.elems[id]; ok {
+		u.recent.Remove(elem)
+		delete(u.elems, id)
+	}
+}
+
+func (u *recentUsage) victim(keep ID) (ID, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	elem := u.recent.Back()
+	if elem != nil && elem.Value.(ID) == keep {
+		elem = elem.Prev()
+	}
+	if elem == nil {
+		return ID{}, false
+	}
+
+	return elem.Value.(ID), true
+}
+
+// frequentUsage keeps the records in a min-heap by uses, the least
+// recently used first among equals.
+type frequentUsage struct {
+	mu    sync.Mutex
+	items map[ID]*usageItem
+	heap  usageHeap
+	// clock orders the uses
+	clock uint64
+}
+
+type usageItem struct {
+	id       ID
+	uses     uint64
+	lastUsed uint64
+	// index is the position of the item in the heap
+	index int
+}
+
+func (u *frequentUsage) add(id ID) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if _, ok := u.items[id]; !ok {
+		item := &usageItem{id: id}
+		u.items[id] = item
+		heap.Push(&u.heap, item)
+	}
+	u.count(u.items[id])
+}
+
+func (u *frequentUsage) use(id ID) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if item, ok := u.items[id]; ok {
+		u.count(item)
+	}
+}
+
+// count must be called with the lock held.
+func (u *frequentUsage) count(item *usageItem) {
+	u.clock++
+	item.uses++
+	item.lastUsed = u.clock
+	heap.Fix(&u.heap, item.index)
+}
+
+func (u *frequentUsage) remove(id ID) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if item, ok := u.items[id]; ok {
+		heap.Remove(&u.heap, item.index)
+		delete(u.items, id)
+	}
+}
+
+// victim is the root of the heap, or the least of its children when the
+// root is keep.
+func (u *frequentUsage) victim(keep ID) (ID, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if len(u.heap) == 0 {
+		return ID{}, false
+	}
+	if root := u.heap[0]; root.id != keep {
+		return root.id, true
+	}
+
+	var best *usageItem
+	for i := 1; i <= 2 && i < len(u.heap); i++ {
+		if best == nil || u.heap.less(u.heap[i], best) {
+			best = u.heap[i]
+		}
+	}
+	if best == nil {
+		return ID{}, false
+	}
+
+	return best.id, true
+}
+
+// usageHeap implements heap.Interface.
+type usageHeap []*usageItem
+
+func (h usageHeap) Len() int           { return len(h) }
+func (h usageHeap) Less(i, j int) bool { return h.less(h[i], h[j]) }
+func (h usageHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h usageHeap) less(a, b *usageItem) bool {
+	if a.uses != b.uses {
+		return a.uses < b.uses
+	}
+
+	return a.lastUsed < b.lastUsed
+}
+
+func (h *usageHeap) Push(x any) {
+	item := x.(*usageItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *usageHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+
+	return item
+}
+
+// makeRoom evicts records until addRecords more records and addBytes more
+// bytes fit within the limits, never evicting keep. It must be called with
+// the write lock held.
+func (c *Collection[T]) makeRoom(ctx context.Context, addRecords int, addBytes int64, keep ID) error {
+	if c.limits.maxBytes > 0 && addBytes > c.limits.maxBytes {
+		return ErrStorageFull
+	}
+
+	for c.exceedsLimits(addRecords, addBytes) {
+		if c.limits.policy == EvictReject || c.limits.policy == "" {
+			return ErrStorageFull
+		}
+
+		id, ok := c.victim(keep)
+		if !ok {
+			return ErrStorageFull
+		}
+
+		c.evict(ctx, id)
+	}
+
+	return nil
+}
+
+func (c *Collection[T]) exceedsLimits(addRecords int, addBytes int64) bool {
+	if c.limits.maxRecords > 0 && c.data.Load().records.len()+addRecords > c.limits.maxRecords {
+		return true
+	}
+
+	return c.limits.maxBytes > 0 && c.bytes+addBytes > c.limits.maxBytes
+}
+
+// victim returns the record the policy evicts first: the head of the
+// insertion order for EvictOldest, the head of the usage otherwise.
+func (c *Collection[T]) victim(keep ID) (ID, bool) {
+	if c.limits.usage != nil {
+		return c.limits.usage.victim(keep)
+	}
+
+	var (
+		victim ID
+		found  bool
+	)
+	c.data.Load().order.ascend(0, func(_ uint64, id ID) bool {
+		if id == keep {
+			return true
+		}
+		victim, found = id, true
+		return false
+	})
+
+	return victim, found
+}
+
+func (c *Collection[T]) evict(ctx context.Context, id ID) {
+	e, _ := c.data.Load().records.get(id)
+	c.remove(id, ChangeEvicted)
+
+	trace.SpanFromContext(ctx).AddEvent("record evicted", trace.WithAttributes(
+		attribute.String("db.record_id", id.String()),
+		attribute.String("db.eviction_policy", string(c.limits.policy)),
+	))
+
+	if c.observer != nil {
+		c.observer.ObserveEviction(c.limits.policy)
+	}
+
+	if c.limits.onEvict != nil {
+		c.limits.onEvict(Record[T]{ID: id, Value: e.value})
+	}
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"context"
+	"strconv"
+	"testing"
+)
+
+func TestUsage(t *testing.T) {
+	ids := []ID{ID{}.NewID(), ID{}.NewID(), ID{}.NewID()}
+
+	t.Run("pick the least recently used record besides keep", func(t *testing.T) {
+		u := newUsage(EvictLRU)
+		for _, id := range ids {
+			u.add(id)
+		}
+		u.use(ids[0])
+
+		if victim, _ := u.victim(ID{}); victim != ids[1] {
+			t.Fatalf("expected %s to be evicted, got %s", ids[1], victim)
+		}
+		if victim, _ := u.victim(ids[1]); victim != ids[2] {
+			t.Fatalf("expected %s to be evicted, got %s", ids[2], victim)
+		}
+
+		u.remove(ids[1])
+		u.remove(ids[2])
+		if _, ok := u.victim(ids[0]); ok {
+			t.Fatalf("expected no record to evict besides keep")
+		}
+	})
+
+	t.Run("pick the least frequently used record besides keep", func(t *testing.T) {
+		u := newUsage(EvictLFU)
+		for _, id := range ids {
+			u.add(id)
+		}
+		u.use(ids[0])
+		u.use(ids[0])
+		u.use(ids[2])
+
+		if victim, _ := u.victim(ID{}); victim != ids[1] {
+			t.Fatalf("expected %s to be evicted, got %s", ids[1], victim)
+		}
+		if victim, _ := u.victim(ids[1]); victim != ids[2] {
+			t.Fatalf("expected %s to be evicted, got %s", ids[2], victim)
+		}
+
+		u.remove(ids[1])
+		u.remove(ids[2])
+		if _, ok := u.victim(ids[0]); ok {
+			t.Fatalf("expected no record to evict besides keep")
+		}
+	})
+
+	t.Run("ignore reads of removed records", func(t *testing.T) {
+		for _, policy := range []EvictionPolicy{EvictLRU, EvictLFU} {
+			u := newUsage(policy)
+			u.add(ids[0])
+			u.remove(ids[0])
+			u.use(ids[0])
+
+			if victim, ok := u.victim(ID{}); ok {
+				t.Fatalf("expected %s to track nothing, got %s", policy, victim)
+			}
+		}
+	})
+}
+
+// BenchmarkEvict measures inserts into full stores of growing sizes, each
+// evicting a user, which should take about the same time whatever the size.
+func BenchmarkEvict(b *testing.B) {
+	for _, policy := range []EvictionPolicy{EvictLRU, EvictLFU, EvictOldest} {
+		for _, n := range []int{1000, 10000, 100000} {
+			b.Run(string(policy)+"/"+strconv.Itoa(n), func(b *testing.B) {
+				ctx := context.Background()
+				db := NewInMemoryDB(WithMaxUsers(n), WithEvictionPolicy(policy))
+				for i := 0; i < n; i++ {
+					insert(b, db, "John")
+				}
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := db.Insert(ctx, newUser("Jane")); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,471 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var ErrSelfFollow = errors.New("a user cannot follow themselves")
+var ErrNotFollowing = errors.New("the user does not follow the other")
+var ErrUserHasFollows = errors.New("the user follows or is followed by other users")
+var ErrNoPath = errors.New("no path between the users")
+
+// DeletePolicy decides what happens to the follows of a deleted user.
+type DeletePolicy string
+
+const (
+	// DeleteCascade removes the follows of the user along with it.
+	DeleteCascade DeletePolicy = "cascade"
+	// DeleteRestrict fails the deletion of a user who follows or is
+	// followed by others with ErrUserHasFollows.
+	DeleteRestrict DeletePolicy = "restrict"
+)
+
+func ParseDeletePolicy(s string) (DeletePolicy, error) {
+	switch policy := DeletePolicy(s); policy {
+	case DeleteCascade, DeleteRestrict:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown delete policy %q", s)
+	}
+}
+
+// Follow is a user following another.
+type Follow struct {
+	Follower ID `json:"follower" yaml:"follower" swaggertype:"array,integer"`
+	Followee ID `json:"followee" yaml:"followee" swaggertype:"array,integer"`
+}
+
+// adjacency maps a user to the users they are linked to, each with the
+// sequence number of the follow, which orders the listings.
+type adjacency = trie[ID, *trie[ID, uint64]]
+
+// followGraph is a version of who follows whom. Like the users, it is
+// replaced rather than changed, under the write lock of the users.
+type followGraph struct {
+	following *adjacency
+	followers *adjacency
+	seq       uint64
+}
+
+func (g *followGraph) follows(follower, followee ID) bool {
+	_, ok := g.followingOf(follower).get(followee)
+	return ok
+}
+
+func (g *followGraph) followingOf(id ID) *trie[ID, uint64] {
+	links, _ := g.following.get(id)
+	return links
+}
+
+func (g *followGraph) followersOf(id ID) *trie[ID, uint64] {
+	links, _ := g.followers.get(id)
+	return links
+}
+
+// linked reports whether id follows or is followed by anyone.
+func (g *followGraph) linked(id ID) bool {
+	return g.followingOf(id).len() > 0 || g.followersOf(id).len() > 0
+}
+
+func (g *followGraph) add(follower, followee ID) *followGraph {
+	seq := g.seq + 1
+
+	return &followGraph{
+		following: link(g.following, follower, followee, seq),
+		followers: link(g.followers, followee, follower, seq),
+		seq:       seq,
+	}
+}
+
+func (g *followGraph) remove(follower, followee ID) *followGraph {
+	return &followGraph{
+		following: unlink(g.following, follower, followee),
+		followers: unlink(g.followers, followee, follower),
+		seq:       g.seq,
+	}
+}
+
+// removeUser returns the graph without any follow of id.
+func (g *followGraph) removeUser(id ID) *followGraph {
+	if !g.linked(id) {
+		return g
+	}
+
+	next := *g
+	g.followingOf(id).each(func(followee ID, _ uint64) bool {
+		next.followers = unlink(next.followers, followee, id)
+		return true
+	})
+	g.followersOf(id).each(func(follower ID, _ uint64) bool {
+		next.following = unlink(next.following, follower, id)
+		return true
+	})
+	next.following = next.following.delete(id)
+	next.followers = next.followers.delete(id)
+
+	return &next
+}
+
+// edges returns the follows of id, both ways, in the order they were made.
+func (g *followGraph) edges(id ID) []Follow {
+	var follows []Follow
+	var seqs []uint64
+	g.followingOf(id).each(func(followee ID, seq uint64) bool {
+		follows = append(follows, Follow{Follower: id, Followee: followee})
+		seqs = append(seqs, seq)
+		return true
+	})
+	g.followersOf(id).each(func(follower ID, seq uint64) bool {
+		follows = append(follows, Follow{Follower: follower, Followee: id})
+		seqs = append(seqs, seq)
+		return true
+	})
+
+	return sortFollows(follows, seqs)
+}
+
+// all returns every follow in the order they were made.
+func (g *followGraph) all() []Follow {
+	var follows []Follow
+	var seqs []uint64
+	g.following.each(func(follower ID, links *trie[ID, uint64]) bool {
+		links.each(func(followee ID, seq uint64) bool {
+			follows = append(follows, Follow{Follower: follower, Followee: followee})
+			seqs = append(seqs, seq)
+			return true
+		})
+		return true
+	})
+
+	return sortFollows(follows, seqs)
+}
+
+// sortFollows orders follows by seqs, the sequence numbers at the same
+// indexes.
+func sortFollows(follows []Follow, seqs []uint64) []Follow {
+	sort.Sort(followsBySeq{follows: follows, seqs: seqs})
+	return follows
+}
+
+type followsBySeq struct {
+	follows []Follow
+	seqs    []uint64
+}
+
+func (s followsBySeq) Len() int           { return len(s.follows) }
+func (s followsBySeq) Less(i, j int) bool { return s.seqs[i] < s.seqs[j] }
+func (s followsBySeq) Swap(i, j int) {
+	s.follows[i], s.follows[j] = s.follows[j], s.follows[i]
+	s.seqs[i], s.seqs[j] = s.seqs[j], s.seqs[i]
+}
+
+func link(a *adjacency, from, to ID, seq uint64) *adjacency {
+	links, _ := a.get(from)
+	return a.set(from, links.set(to, seq))
+}
+
+// unlink drops the users left without links, so they take no room.
+func unlink(a *adjacency, from, to ID) *adjacency {
+	links, _ := a.get(from)
+	if links = links.delete(to); links.len() == 0 {
+		return a.delete(from)
+	}
+
+	return a.set(from, links)
+}
+
+// Follow makes follower follow followee, who both have to be stored.
+// created is false when follower already followed followee.
+func (db *InMemoryDB) Follow(ctx context.Context, follower, followee string) (created bool, err error) {
+	ctx, span := db.users.startSpan(ctx, OpFollow)
+	defer span.End()
+
+	from, to, err := parseFollow(follower, followee)
+	if err != nil {
+		return false, recordError(span, err)
+	}
+
+	defer db.users.lock(ctx, OpFollow)()
+
+	users := db.users.data.Load().records
+	for _, id := range []ID{from, to} {
+		if _, exists := users.get(id); !exists {
+			return false, recordError(span, ErrUserDoesNotExist)
+		}
+	}
+
+	graph := db.graph.Load()
+	if graph.follows(from, to) {
+		return false, nil
+	}
+
+	db.graph.Store(graph.add(from, to))
+	db.publish(Change{Type: ChangeFollowed, Follow: &Follow{Follower: from, Followee: to}})
+
+	return true, nil
+}
+
+// Unfollow stops follower from following followee, failing with
+// ErrNotFollowing if it did not.
+func (db *InMemoryDB) Unfollow(ctx context.Context, follower, followee string) error {
+	ctx, span := db.users.startSpan(ctx, OpUnfollow)
+	defer span.End()
+
+	from, to, err := parseFollow(follower, followee)
+	if err != nil {
+		return recordError(span, err)
+	}
+
+	defer db.users.lock(ctx, OpUnfollow)()
+
+	graph := db.graph.Load()
+	if !graph.follows(from, to) {
+		return recordError(span, ErrNotFollowing)
+	}
+
+	db.graph.Store(graph.remove(from, to))
+	db.publish(Change{Type: ChangeUnfollowed, Follow: &Follow{Follower: from, Followee: to}})
+
+	return nil
+}
+
+// Followers returns the users following id in the order they followed
+// it, one page at a time. opts.Filter applies to the followers.
+func (db *InMemoryDB) Followers(ctx context.Context, id string, opts ListOptions) (Page, error) {
+	return db.listFollows(ctx, OpFollowers, id, opts, (*followGraph).followersOf, nil)
+}
+
+// Following returns the users id follows in the order it followed them,
+// one page at a time. opts.Filter applies to the followed users.
+func (db *InMemoryDB) Following(ctx context.Context, id string, opts ListOptions) (Page, error) {
+	return db.listFollows(ctx, OpFollowing, id, opts, (*followGraph).followingOf, nil)
+}
+
+// MutualFollows returns the users id follows who follow it back, in the
+// order id followed them, one page at a time.
+func (db *InMemoryDB) MutualFollows(ctx context.Context, id string, opts ListOptions) (Page, error) {
+	return db.listFollows(ctx, OpMutualFollows, id, opts, (*followGraph).followingOf, func(g *followGraph, id, other ID) bool {
+		return g.follows(other, id)
+	})
+}
+
+// listFollows pages through the users links returns for id, in the order
+// of the follows. keep, when not nil, skips the users it returns false
+// for.
+func (db *InMemoryDB) listFollows(ctx context.Context, op Operation, id string, opts ListOptions, links func(g *followGraph, id ID) *trie[ID, uint64], keep func(g *followGraph, id, other ID) bool) (Page, error) {
+	ctx, span := db.users.startSpan(ctx, op)
+	defer span.End()
+
+	parsedID, err := parseID(id)
+	if err != nil {
+		return Page{}, recordError(span, err)
+	}
+
+	after, err := ParseCursor(opts.Cursor)
+	if err != nil {
+		return Page{}, recordError(span, err)
+	}
+
+	if opts.Limit < 0 {
+		return Page{}, recordError(span, errors.New("limit must not be negative"))
+	}
+
+	users, graph := db.readGraph(ctx, op)
+	if _, exists := users.get(parsedID); !exists {
+		return Page{}, recordError(span, ErrUserDoesNotExist)
+	}
+
+	type ref struct {
+		id  ID
+		seq uint64
+	}
+
+	linked := links(graph, parsedID)
+	refs := make([]ref, 0, linked.len())
+	linked.each(func(other ID, seq uint64) bool {
+		if Cursor(seq) > after {
+			refs = append(refs, ref{id: other, seq: seq})
+		}
+		return true
+	})
+
+	sort.Slice(refs, func(i, j int) bool { return refs[i].seq < refs[j].seq })
+
+	page := Page{Users: make([]DBUser, 0, max(opts.Limit, 0))}
+	var last uint64
+	for _, r := range refs {
+		if keep != nil && !keep(graph, parsedID, r.id) {
+			continue
+		}
+
+		e, _ := users.get(r.id)
+		if opts.Filter != nil && !opts.Filter(e.value) {
+			continue
+		}
+
+		if opts.Limit > 0 && len(page.Users) == opts.Limit {
+			page.Next = Cursor(last).String()
+			break
+		}
+
+		page.Users = append(page.Users, DBUser{ID: r.id, User: e.value})
+		last = r.seq
+	}
+
+	return page, nil
+}
+
+// ShortestPath returns the fewest users leading from one user to another
+// by following, both included. It fails with ErrNoPath when to cannot be
+// reached from from.
+func (db *InMemoryDB) ShortestPath(ctx context.Context, from, to string) ([]DBUser, error) {
+	ctx, span := db.users.startSpan(ctx, OpShortestPath)
+	defer span.End()
+
+	src, err := parseID(from)
+	if err != nil {
+		return nil, recordError(span, err)
+	}
+
+	dst, err := parseID(to)
+	if err != nil {
+		return nil, recordError(span, err)
+	}
+
+	users, graph := db.readGraph(ctx, OpShortestPath)
+	for _, id := range []ID{src, dst} {
+		if _, exists := users.get(id); !exists {
+			return nil, recordError(span, ErrUserDoesNotExist)
+		}
+	}
+
+	// a breadth-first search, parents holding where each user was reached
+	// from
+	parents := map[ID]ID{src: src}
+	queue := []ID{src}
+	for i := 0; i < len(queue) && queue[i] != dst; i++ {
+		// long searches stop when the client goes away
+		if i%256 == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, recordError(span, err)
+			}
+		}
+
+		graph.followingOf(queue[i]).each(func(next ID, _ uint64) bool {
+			if _, seen := parents[next]; !seen {
+				parents[next] = queue[i]
+				queue = append(queue, next)
+			}
+			return true
+		})
+	}
+
+	if _, reached := parents[dst]; !reached {
+		return nil, recordError(span, ErrNoPath)
+	}
+
+	var path []DBUser
+	for id := dst; ; id = parents[id] {
+		e, _ := users.get(id)
+		path = append(path, DBUser{ID: id, User: e.value})
+		if id == src {
+			break
+		}
+	}
+
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+
+	return path, nil
+}
+
+// readGraph returns the latest versions of the users and of the graph,
+// which agree with each other. The lock is only held to pair them.
+func (db *InMemoryDB) readGraph(ctx context.Context, op Operation) (*trie[ID, *entry[User]], *followGraph) {
+	start := time.Now()
+	db.users.mu.RLock()
+	defer db.users.mu.RUnlock()
+
+	db.users.observe(ctx, op, time.Since(start))
+
+	return db.users.data.Load().records, db.graph.Load()
+}
+
+// unlinkUser removes the follows of the user removed under id. Each is
+// published before the removal, so replicas applying the changes in order
+// never delete a linked user. It must be called with the write lock held.
+func (db *InMemoryDB) unlinkUser(id ID) {
+	graph := db.graph.Load()
+	for _, follow := range graph.edges(id) {
+		db.publish(Change{Type: ChangeUnfollowed, Follow: &follow})
+	}
+
+	db.graph.Store(graph.removeUser(id))
+}
+
+// restoreFollows replaces the graph with follows, in order, publishing
+// what changed. Follows of users who are not stored are skipped. It must
+// be called with the write lock held.
+func (db *InMemoryDB) restoreFollows(follows []Follow) {
+	users := db.users.data.Load().records
+	current := db.graph.Load()
+
+	next := &followGraph{}
+	var added []Follow
+	for _, follow := range follows {
+		_, followerExists := users.get(follow.Follower)
+		_, followeeExists := users.get(follow.Followee)
+		if !followerExists || !followeeExists || follow.Follower == follow.Followee || next.follows(follow.Follower, follow.Followee) {
+			continue
+		}
+
+		next = next.add(follow.Follower, follow.Followee)
+		if !current.follows(follow.Follower, follow.Followee) {
+			added = append(added, follow)
+		}
+	}
+
+	for _, follow := range current.all() {
+		if !next.follows(follow.Follower, follow.Followee) {
+			db.publish(Change{Type: ChangeUnfollowed, Follow: &follow})
+		}
+	}
+	for _, follow := range added {
+		db.publish(Change{Type: ChangeFollowed, Follow: &follow})
+	}
+
+	db.graph.Store(next)
+}
+
+// checkDelete vetoes deleting id under DeleteRestrict while it has
+// follows. It must be called with the write lock held.
+func (db *InMemoryDB) checkDelete(id ID) error {
+	if db.deletePolicy == DeleteRestrict && db.graph.Load().linked(id) {
+		return ErrUserHasFollows
+	}
+
+	return nil
+}
+
+func parseFollow(follower, followee string) (ID, ID, error) {
+	from, err := parseID(follower)
+	if err != nil {
+		return ID{}, ID{}, err
+	}
+
+	to, err := parseID(followee)
+	if err != nil {
+		return ID{}, ID{}, err
+	}
+
+	if from == to {
+		return ID{}, ID{}, ErrSelfFollow
+	}
+
+	return from, to, nil
+}
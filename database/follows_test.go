@@ -0,0 +1,248 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestFollows(t *testing.T) {
+	ctx := context.Background()
+
+	follow := func(t *testing.T, db *InMemoryDB, follower, followee DBUser) {
+		t.Helper()
+
+		if _, err := db.Follow(ctx, follower.ID.String(), followee.ID.String()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assertNames := func(t *testing.T, page Page, want ...string) {
+		t.Helper()
+
+		if len(page.Users) != len(want) {
+			t.Fatalf("expected %v, got %v", want, page.Users)
+		}
+		for i, user := range page.Users {
+			if user.User.FirstName != want[i] {
+				t.Fatalf("expected %v, got %v", want, page.Users)
+			}
+		}
+	}
+
+	t.Run("list followers and following in order", func(t *testing.T) {
+		db := NewInMemoryDB()
+		john, jane, jack := insert(t, db, "John"), insert(t, db, "Jane"), insert(t, db, "Jack")
+
+		follow(t, db, jack, john)
+		follow(t, db, jane, john)
+		follow(t, db, john, jack)
+
+		followers, err := db.Followers(ctx, john.ID.String(), ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertNames(t, followers, "Jack", "Jane")
+
+		following, err := db.Following(ctx, john.ID.String(), ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertNames(t, following, "Jack")
+
+		if created, _ := db.Follow(ctx, jack.ID.String(), john.ID.String()); created {
+			t.Fatalf("expected following twice to change nothing")
+		}
+	})
+
+	t.Run("page through followers", func(t *testing.T) {
+		db := NewInMemoryDB()
+		john := insert(t, db, "John")
+		for _, name := range []string{"Jane", "Jack", "Joe"} {
+			follow(t, db, insert(t, db, name), john)
+		}
+
+		page, err := db.Followers(ctx, john.ID.String(), ListOptions{Limit: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertNames(t, page, "Jane", "Jack")
+
+		page, err = db.Followers(ctx, john.ID.String(), ListOptions{Cursor: page.Next, Limit: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertNames(t, page, "Joe")
+		if page.Next != "" {
+			t.Fatalf("expected the last page, got next %q", page.Next)
+		}
+	})
+
+	t.Run("list mutual follows", func(t *testing.T) {
+		db := NewInMemoryDB()
+		john, jane, jack := insert(t, db, "John"), insert(t, db, "Jane"), insert(t, db, "Jack")
+
+		follow(t, db, john, jane)
+		follow(t, db, john, jack)
+		follow(t, db, jack, john)
+
+		mutuals, err := db.MutualFollows(ctx, john.ID.String(), ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertNames(t, mutuals, "Jack")
+	})
+
+	t.Run("unfollow", func(t *testing.T) {
+		db := NewInMemoryDB()
+		john, jane := insert(t, db, "John"), insert(t, db, "Jane")
+		follow(t, db, john, jane)
+
+		if err := db.Unfollow(ctx, john.ID.String(), jane.ID.String()); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Unfollow(ctx, john.ID.String(), jane.ID.String()); !errors.Is(err, ErrNotFollowing) {
+			t.Fatalf("expected %v, got %v", ErrNotFollowing, err)
+		}
+	})
+
+	t.Run("only link stored users", func(t *testing.T) {
+		db := NewInMemoryDB()
+		john := insert(t, db, "John")
+
+		if _, err := db.Follow(ctx, john.ID.String(), ID{}.NewID().String()); !errors.Is(err, ErrUserDoesNotExist) {
+			t.Fatalf("expected %v, got %v", ErrUserDoesNotExist, err)
+		}
+		if _, err := db.Follow(ctx, john.ID.String(), john.ID.String()); !errors.Is(err, ErrSelfFollow) {
+			t.Fatalf("expected %v, got %v", ErrSelfFollow, err)
+		}
+	})
+
+	t.Run("remove the follows of deleted users", func(t *testing.T) {
+		db := NewInMemoryDB()
+		john, jane, jack := insert(t, db, "John"), insert(t, db, "Jane"), insert(t, db, "Jack")
+		follow(t, db, john, jane)
+		follow(t, db, jane, john)
+		follow(t, db, jack, jane)
+
+		if _, err := db.Delete(ctx, jane.ID.String()); err != nil {
+			t.Fatal(err)
+		}
+
+		following, _ := db.Following(ctx, john.ID.String(), ListOptions{})
+		followers, _ := db.Followers(ctx, john.ID.String(), ListOptions{})
+		assertNames(t, following)
+		assertNames(t, followers)
+
+		if _, err := db.Followers(ctx, jane.ID.String(), ListOptions{}); !errors.Is(err, ErrUserDoesNotExist) {
+			t.Fatalf("expected %v, got %v", ErrUserDoesNotExist, err)
+		}
+	})
+
+	t.Run("restrict deleting followed users", func(t *testing.T) {
+		db := NewInMemoryDB(WithDeletePolicy(DeleteRestrict))
+		john, jane := insert(t, db, "John"), insert(t, db, "Jane")
+		follow(t, db, john, jane)
+
+		if _, err := db.Delete(ctx, jane.ID.String()); !errors.Is(err, ErrUserHasFollows) {
+			t.Fatalf("expected %v, got %v", ErrUserHasFollows, err)
+		}
+
+		db.Unfollow(ctx, john.ID.String(), jane.ID.String())
+		if _, err := db.Delete(ctx, jane.ID.String()); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("publish follows and the unfollows of deleted users", func(t *testing.T) {
+		db := NewInMemoryDB()
+		john, jane := insert(t, db, "John"), insert(t, db, "Jane")
+
+		changes, cancel := db.Subscribe()
+		defer cancel()
+
+		follow(t, db, john, jane)
+		follow(t, db, jane, john)
+		db.Unfollow(ctx, john.ID.String(), jane.ID.String())
+		db.Delete(ctx, john.ID.String())
+
+		want := []struct {
+			changeType ChangeType
+			follow     *Follow
+		}{
+			{ChangeFollowed, &Follow{Follower: john.ID, Followee: jane.ID}},
+			{ChangeFollowed, &Follow{Follower: jane.ID, Followee: john.ID}},
+			{ChangeUnfollowed, &Follow{Follower: john.ID, Followee: jane.ID}},
+			{ChangeUnfollowed, &Follow{Follower: jane.ID, Followee: john.ID}},
+			{ChangeDeleted, nil},
+		}
+		for _, w := range want {
+			change := <-changes
+			if change.Type != w.changeType || (w.follow == nil) != (change.Follow == nil) || (w.follow != nil && *change.Follow != *w.follow) {
+				t.Fatalf("expected a %s change of %+v, got %+v", w.changeType, w.follow, change)
+			}
+		}
+	})
+
+	t.Run("remove the follows of evicted users", func(t *testing.T) {
+		db := NewInMemoryDB(WithMaxUsers(2), WithEvictionPolicy(EvictOldest), WithDeletePolicy(DeleteRestrict))
+		john, jane := insert(t, db, "John"), insert(t, db, "Jane")
+		follow(t, db, jane, john)
+
+		insert(t, db, "Jack")
+
+		following, _ := db.Following(ctx, jane.ID.String(), ListOptions{})
+		assertNames(t, following)
+	})
+}
+
+func TestShortestPath(t *testing.T) {
+	ctx := context.Background()
+
+	db := NewInMemoryDB()
+	users := make([]DBUser, 5)
+	for i, name := range []string{"A", "B", "C", "D", "E"} {
+		users[i] = insert(t, db, name)
+	}
+
+	// A → B → C → D and a shortcut A → C
+	for _, edge := range [][2]int{{0, 1}, {1, 2}, {2, 3}, {0, 2}} {
+		if _, err := db.Follow(ctx, users[edge[0]].ID.String(), users[edge[1]].ID.String()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("take the fewest follows", func(t *testing.T) {
+		path, err := db.ShortestPath(ctx, users[0].ID.String(), users[3].ID.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var names []string
+		for _, user := range path {
+			names = append(names, user.User.FirstName)
+		}
+		if len(names) != 3 || names[0] != "A" || names[1] != "C" || names[2] != "D" {
+			t.Fatalf("expected the path A, C, D, got %v", names)
+		}
+	})
+
+	t.Run("follow the direction of the follows", func(t *testing.T) {
+		if _, err := db.ShortestPath(ctx, users[3].ID.String(), users[0].ID.String()); !errors.Is(err, ErrNoPath) {
+			t.Fatalf("expected %v, got %v", ErrNoPath, err)
+		}
+		if _, err := db.ShortestPath(ctx, users[0].ID.String(), users[4].ID.String()); !errors.Is(err, ErrNoPath) {
+			t.Fatalf("expected %v, got %v", ErrNoPath, err)
+		}
+	})
+
+	t.Run("reach a user from itself", func(t *testing.T) {
+		path, err := db.ShortestPath(ctx, users[1].ID.String(), users[1].ID.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(path) != 1 || path[0] != users[1] {
+			t.Fatalf("expected a path of B alone, got %v", path)
+		}
+	})
+}
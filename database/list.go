@@ -0,0 +1,80 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ListOptions selects users, see Query.
+type ListOptions = Query[User]
+
+// UserFilter matches the names exactly and Contains anywhere in the names
+// or biography, ignoring case. Empty fields match every user.
+type UserFilter struct {
+	FirstName string
+	LastName  string
+	Contains  string
+}
+
+func (f UserFilter) Match(user User) bool {
+	if f.FirstName != "" && !strings.EqualFold(user.FirstName, f.FirstName) {
+		return false
+	}
+	if f.LastName != "" && !strings.EqualFold(user.LastName, f.LastName) {
+		return false
+	}
+	if f.Contains == "" {
+		return true
+	}
+
+	contains := strings.ToLower(f.Contains)
+	return strings.Contains(strings.ToLower(user.FirstName), contains) ||
+		strings.Contains(strings.ToLower(user.LastName), contains) ||
+		strings.Contains(strings.ToLower(user.Biography), contains)
+}
+
+type Page struct {
+	Users []DBUser
+	// Next is the cursor of the following page, empty on the last one.
+	Next string
+}
+
+// List returns records in insertion order, one page at a time, all from
+// the same version of the collection.
+func (c *Collection[T]) List(ctx context.Context, q Query[T]) (RecordPage[T], error) {
+	ctx, span := c.startSpan(ctx, OpList)
+	defer span.End()
+
+	c.observe(ctx, OpList, 0)
+
+	page := RecordPage[T]{Records: make([]Record[T], 0, max(q.Limit, 0))}
+	next, err := c.View().scanPage(ctx, q, func(record Record[T]) bool {
+		page.Records = append(page.Records, record)
+		return true
+	})
+	if err != nil {
+		return RecordPage[T]{}, recordError(span, err)
+	}
+	page.Next = next
+
+	return page, nil
+}
+
+// List returns users in insertion order, one page at a time, all from the
+// same version of the store.
+func (db *InMemoryDB) List(ctx context.Context, opts ListOptions) (Page, error) {
+	page, err := db.users.List(ctx, opts)
+	if err != nil {
+		return Page{}, err
+	}
+
+	users := make([]DBUser, 0, len(page.Records))
+	for _, record := range page.Records {
+		users = append(users, toDBUser(record))
+	}
+
+	return Page{Users: users, Next: page.Next}, nil
+}
@@ -0,0 +1,34 @@
+package database
+
+import "time"
+
+type Operation string
+
+const (
+	OpInsert   Operation = "insert"
+	OpUpdate   Operation = "update"
+	OpUpsert   Operation = "upsert"
+	OpDelete   Operation = "delete"
+	OpFindAll  Operation = "find_all"
+	OpList     Operation = "list"
+	OpScan     Operation = "scan"
+	OpFindByID Operation = "find_by_id"
+	OpFindBy   Operation = "find_by"
+	OpSnapshot Operation = "snapshot"
+	OpRestore  Operation = "restore"
+
+	OpFollow        Operation = "follow"
+	OpUnfollow      Operation = "unfollow"
+	OpFollowers     Operation = "followers"
+	OpFollowing     Operation = "following"
+	OpMutualFollows Operation = "mutual_follows"
+	OpShortestPath  Operation = "shortest_path"
+)
+
+// Observer is notified of every operation once it holds the database lock,
+// along with how long it waited for it, and of every eviction. Reads do
+// not take the lock and report no wait.
+type Observer interface {
+	ObserveOperation(op Operation, lockWait time.Duration)
+	ObserveEviction(policy EvictionPolicy)
+}
@@ -0,0 +1,68 @@
+package database
+
+type Option func(*InMemoryDB)
+
+// WithInitialCapacity used to preallocate room for n users.
+//
+// Deprecated: the users are kept in a trie growing as needed, there is
+// nothing to preallocate.
+func WithInitialCapacity(n int) Option {
+	return func(*InMemoryDB) {}
+}
+
+// WithObserver reports every operation to o, e.g. to export metrics.
+func WithObserver(o Observer) Option {
+	return func(db *InMemoryDB) {
+		db.users.observer = o
+	}
+}
+
+// WithMaxUsers caps how many users can be stored. Zero means no limit.
+func WithMaxUsers(n int) Option {
+	return func(db *InMemoryDB) {
+		db.users.limits.maxRecords = n
+	}
+}
+
+// WithMaxBytes caps the estimated memory taken by the stored users. Zero
+// means no limit.
+func WithMaxBytes(n int64) Option {
+	return func(db *InMemoryDB) {
+		db.users.limits.maxBytes = n
+	}
+}
+
+// WithEvictionPolicy sets what happens to writes exceeding the limits, it
+// defaults to EvictReject.
+func WithEvictionPolicy(policy EvictionPolicy) Option {
+	return func(db *InMemoryDB) {
+		db.users.limits.policy = policy
+		db.users.limits.usage = newUsage(policy)
+	}
+}
+
+// WithEvictionHandler calls fn for every evicted user. It runs with the
+// database locked so it must not call back into it.
+func WithEvictionHandler(fn func(DBUser)) Option {
+	return func(db *InMemoryDB) {
+		db.users.limits.onEvict = func(record Record[User]) {
+			fn(toDBUser(record))
+		}
+	}
+}
+
+// WithChangeLog keeps the latest n changes for ChangesSince.
+func WithChangeLog(n int) Option {
+	return func(db *InMemoryDB) {
+		db.feed.log = make([]Change, n)
+	}
+}
+
+// WithDeletePolicy sets what happens to the follows of deleted users, it
+// defaults to DeleteCascade. Users evicted or dropped by Restore always
+// lose their follows.
+func WithDeletePolicy(policy DeletePolicy) Option {
+	return func(db *InMemoryDB) {
+		db.deletePolicy = policy
+	}
+}
@@ -0,0 +1,23 @@
+package database
+
+// UserPatch holds the fields of a partial update, nil fields are left
+// unchanged.
+type UserPatch struct {
+	FirstName *string `json:"first_name,omitempty" xml:"first_name,omitempty" validate:"omitempty,min=2,max=20"`
+	LastName  *string `json:"last_name,omitempty" xml:"last_name,omitempty" validate:"omitempty,min=2,max=20"`
+	Biography *string `json:"biography,omitempty" xml:"biography,omitempty" validate:"omitempty,min=20,max=450"`
+}
+
+func (p UserPatch) Apply(user User) User {
+	if p.FirstName != nil {
+		user.FirstName = *p.FirstName
+	}
+	if p.LastName != nil {
+		user.LastName = *p.LastName
+	}
+	if p.Biography != nil {
+		user.Biography = *p.Biography
+	}
+
+	return user
+}
@@ -0,0 +1,10 @@
+package database
+
+import "context"
+
+// Reader makes the reads of a database consistent. A cluster node syncs by
+// waiting until its copy applied every write committed before the call, so
+// the reads that follow are linearizable. Writer is its counterpart.
+type Reader interface {
+	Sync(ctx context.Context) error
+}
@@ -0,0 +1,212 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strconv"
+)
+
+// Cursor is the position of a record in insertion order. Its string form
+// is what Query.Cursor and RecordPage.Next hold.
+type Cursor uint64
+
+func (c Cursor) String() string {
+	return strconv.FormatUint(uint64(c), 10)
+}
+
+// ParseCursor parses the string form of a cursor, the empty string being
+// the start of the store.
+func ParseCursor(s string) (Cursor, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	c, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+
+	return Cursor(c), nil
+}
+
+// CollectionView is a version of a collection that never changes, so
+// reads through it agree with each other whatever is written meanwhile.
+type CollectionView[T any] struct {
+	v *version[T]
+}
+
+// View returns the latest version of the collection.
+func (c *Collection[T]) View() CollectionView[T] {
+	return CollectionView[T]{v: c.data.Load()}
+}
+
+// Count returns the number of records in the view.
+func (v CollectionView[T]) Count() int {
+	return v.v.records.len()
+}
+
+// FindByID is like Collection.FindByID, but does not count as a use for
+// the eviction policies.
+func (v CollectionView[T]) FindByID(id string) (Record[T], bool) {
+	parsedID, err := ParseID(id)
+	if err != nil {
+		return Record[T]{}, false
+	}
+
+	e, exists := v.v.records.get(parsedID)
+	if !exists {
+		return Record[T]{ID: parsedID}, false
+	}
+
+	return Record[T]{ID: parsedID, Value: e.value}, true
+}
+
+// Scan calls fn with the records of the view in insertion order, along
+// with their cursors, until it returns false. It starts after q.Cursor,
+// visits at most q.Limit records when it is positive and skips the
+// records q.Filter rejects.
+//
+// The scan seeks to the cursor in the insertion order and walks forward
+// from there, so a page costs the same whatever the size of the
+// collection. Each record is copied just before fn is called with it.
+func (v CollectionView[T]) Scan(ctx context.Context, q Query[T], fn func(record Record[T], cursor Cursor) bool) error {
+	after, err := ParseCursor(q.Cursor)
+	if err != nil {
+		return err
+	}
+
+	if q.Limit < 0 {
+		return errors.New("limit must not be negative")
+	}
+
+	var (
+		visited, seen int
+		ctxErr        error
+	)
+	v.v.order.ascend(uint64(after), func(seq uint64, id ID) bool {
+		if q.Limit > 0 && visited == q.Limit {
+			return false
+		}
+
+		// a client going away stops long scans, checking now and then is
+		// enough
+		if seen%256 == 0 {
+			if ctxErr = ctx.Err(); ctxErr != nil {
+				return false
+			}
+		}
+		seen++
+
+		e, _ := v.v.records.get(id)
+		if q.Filter != nil && !q.Filter(e.value) {
+			return true
+		}
+
+		visited++
+		return fn(Record[T]{ID: id, Value: e.value}, Cursor(seq))
+	})
+
+	return ctxErr
+}
+
+// Scan is CollectionView.Scan on the latest version of the collection,
+// which writers never wait for.
+func (c *Collection[T]) Scan(ctx context.Context, q Query[T], fn func(record Record[T], cursor Cursor) bool) error {
+	ctx, span := c.startSpan(ctx, OpScan)
+	defer span.End()
+
+	c.observe(ctx, OpScan, 0)
+
+	if err := c.View().Scan(ctx, q, fn); err != nil {
+		return recordError(span, err)
+	}
+
+	return nil
+}
+
+// ScanPage calls fn with the records of the page List would return, as
+// they are scanned, and returns the cursor of the following page.
+func (c *Collection[T]) ScanPage(ctx context.Context, q Query[T], fn func(record Record[T]) bool) (next string, err error) {
+	ctx, span := c.startSpan(ctx, OpScan)
+	defer span.End()
+
+	c.observe(ctx, OpScan, 0)
+
+	next, err = c.View().scanPage(ctx, q, fn)
+	if err != nil {
+		return "", recordError(span, err)
+	}
+
+	return next, nil
+}
+
+func (v CollectionView[T]) scanPage(ctx context.Context, q Query[T], fn func(record Record[T]) bool) (next string, err error) {
+	limit := q.Limit
+	if limit > 0 {
+		// one more record than asked for tells whether there is a next page
+		q.Limit++
+	}
+
+	var (
+		visited int
+		last    Cursor
+	)
+	err = v.Scan(ctx, q, func(record Record[T], cursor Cursor) bool {
+		if limit > 0 && visited == limit {
+			next = last.String()
+			return false
+		}
+
+		visited++
+		last = cursor
+		return fn(record)
+	})
+
+	return next, err
+}
+
+// View is a version of the store that never changes, so reads through it
+// agree with each other whatever is written meanwhile.
+type View struct {
+	users CollectionView[User]
+}
+
+// View returns the latest version of the store.
+func (db *InMemoryDB) View() View {
+	return View{users: db.users.View()}
+}
+
+// Count returns the number of users in the view.
+func (v View) Count() int {
+	return v.users.Count()
+}
+
+// FindByID is like InMemoryDB.FindByID, but does not count as a use for
+// the eviction policies.
+func (v View) FindByID(id string) (DBUser, bool) {
+	record, exists := v.users.FindByID(id)
+	return toDBUser(record), exists
+}
+
+// Scan is CollectionView.Scan for users.
+func (v View) Scan(ctx context.Context, opts ListOptions, fn func(user DBUser, cursor Cursor) bool) error {
+	return v.users.Scan(ctx, opts, func(record Record[User], cursor Cursor) bool {
+		return fn(toDBUser(record), cursor)
+	})
+}
+
+// Scan is View.Scan on the latest version of the store, which writers
+// never wait for.
+func (db *InMemoryDB) Scan(ctx context.Context, opts ListOptions, fn func(user DBUser, cursor Cursor) bool) error {
+	return db.users.Scan(ctx, opts, func(record Record[User], cursor Cursor) bool {
+		return fn(toDBUser(record), cursor)
+	})
+}
+
+// ScanPage calls fn with the users of the page List would return, as they
+// are scanned, and returns the cursor of the following page.
+func (db *InMemoryDB) ScanPage(ctx context.Context, opts ListOptions, fn func(user DBUser) bool) (next string, err error) {
+	return db.users.ScanPage(ctx, opts, func(record Record[User]) bool {
+		return fn(toDBUser(record))
+	})
+}
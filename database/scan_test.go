@@ -0,0 +1,161 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	ctx := context.Background()
+
+	db := NewInMemoryDB()
+	var inserted []DBUser
+	for _, name := range []string{"John", "Jane", "Jack", "Jane", "Joe"} {
+		inserted = append(inserted, insert(t, db, name))
+	}
+
+	scan := func(t *testing.T, opts ListOptions, stopAfter int) ([]DBUser, []Cursor) {
+		t.Helper()
+
+		var users []DBUser
+		var cursors []Cursor
+		err := db.Scan(ctx, opts, func(user DBUser, cursor Cursor) bool {
+			users = append(users, user)
+			cursors = append(cursors, cursor)
+			return len(users) != stopAfter
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return users, cursors
+	}
+
+	t.Run("visit users in insertion order", func(t *testing.T) {
+		users, cursors := scan(t, ListOptions{}, 0)
+		if len(users) != len(inserted) {
+			t.Fatalf("expected %d users, got %d", len(inserted), len(users))
+		}
+		for i := range inserted {
+			if users[i] != inserted[i] || cursors[i] != Cursor(i+1) {
+				t.Fatalf("expected user %d to be %v at %d, got %v at %d", i, inserted[i], i+1, users[i], cursors[i])
+			}
+		}
+	})
+
+	t.Run("visit a range of the users", func(t *testing.T) {
+		users, _ := scan(t, ListOptions{Cursor: Cursor(1).String(), Limit: 2}, 0)
+		if len(users) != 2 || users[0] != inserted[1] || users[1] != inserted[2] {
+			t.Fatalf("expected the second and third users, got %v", users)
+		}
+	})
+
+	t.Run("visit the users matching the filter", func(t *testing.T) {
+		users, _ := scan(t, ListOptions{Filter: UserFilter{FirstName: "jane"}.Match, Limit: 1}, 0)
+		if len(users) != 1 || users[0] != inserted[1] {
+			t.Fatalf("expected the first Jane, got %v", users)
+		}
+	})
+
+	t.Run("stop when the callback returns false", func(t *testing.T) {
+		if users, _ := scan(t, ListOptions{}, 2); len(users) != 2 {
+			t.Fatalf("expected to stop after 2 users, got %d", len(users))
+		}
+	})
+
+	t.Run("reject invalid cursors", func(t *testing.T) {
+		err := db.Scan(ctx, ListOptions{Cursor: "nope"}, func(DBUser, Cursor) bool {
+			t.Fatalf("expected no user to be visited")
+			return false
+		})
+		if !errors.Is(err, ErrInvalidCursor) {
+			t.Fatalf("expected %v, got %v", ErrInvalidCursor, err)
+		}
+	})
+
+	t.Run("stop when the context is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		err := db.Scan(ctx, ListOptions{}, func(DBUser, Cursor) bool { return true })
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected %v, got %v", context.Canceled, err)
+		}
+	})
+
+	t.Run("scan pages like List", func(t *testing.T) {
+		var users []DBUser
+		next, err := db.ScanPage(ctx, ListOptions{Limit: 3}, func(user DBUser) bool {
+			users = append(users, user)
+			return true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		page, err := db.List(ctx, ListOptions{Limit: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next != page.Next || len(users) != len(page.Users) {
+			t.Fatalf("expected %d users and cursor %q, got %d and %q", len(page.Users), page.Next, len(users), next)
+		}
+	})
+}
+
+func TestView(t *testing.T) {
+	ctx := context.Background()
+
+	db := NewInMemoryDB()
+	john := insert(t, db, "John")
+
+	view := db.View()
+
+	db.Update(ctx, john.ID.String(), newUser("Jack"))
+	jane := insert(t, db, "Jane")
+
+	if got, _ := view.FindByID(john.ID.String()); got.User.FirstName != "John" {
+		t.Fatalf("expected the view to keep John, got %v", got)
+	}
+	if _, exists := view.FindByID(jane.ID.String()); exists {
+		t.Fatalf("expected the view not to see users inserted after it")
+	}
+
+	var scanned int
+	view.Scan(ctx, ListOptions{}, func(DBUser, Cursor) bool {
+		scanned++
+		return true
+	})
+	if scanned != 1 || view.Count() != 1 {
+		t.Fatalf("expected the view to hold 1 user, scanned %d and counted %d", scanned, view.Count())
+	}
+
+	if db.View().Count() != 2 {
+		t.Fatalf("expected a new view to see both users")
+	}
+}
+
+// BenchmarkScanPage measures a page of 10 users from the middle of stores
+// of growing sizes, which should take the same time whatever the size.
+func BenchmarkScanPage(b *testing.B) {
+	for _, n := range []int{1000, 10000, 100000} {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			ctx := context.Background()
+			db := NewInMemoryDB()
+			for i := 0; i < n; i++ {
+				insert(b, db, "John")
+			}
+			opts := ListOptions{Cursor: Cursor(n / 2).String(), Limit: 10}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := db.List(ctx, opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
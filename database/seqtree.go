@@ -0,0 +1,188 @@
+package database
+
+import "math/bits"
+
+// seqBits is how many bits of a key pick a child at each level.
+const seqBits = 5
+
+// seqTree is an immutable map ordered by its uint64 keys, a radix tree
+// growing in height as the keys do. Like trie, writes return a new version
+// sharing everything but the path to the changed key.
+//
+// It keeps the records in insertion order, so scans seek to a cursor and
+// walk forward instead of sorting the whole collection.
+type seqTree[V any] struct {
+	root *seqNode[V]
+	// height is the number of levels below the root.
+	height uint
+	size   int
+}
+
+// seqNode holds a child, or a value on the last level, for every bit set
+// in bitmap, at the index of the bit.
+type seqNode[V any] struct {
+	bitmap   uint32
+	children []*seqNode[V]
+	values   []V
+}
+
+func (t *seqTree[V]) len() int {
+	if t == nil {
+		return 0
+	}
+
+	return t.size
+}
+
+// set returns a version storing value under key.
+func (t *seqTree[V]) set(key uint64, value V) *seqTree[V] {
+	var next seqTree[V]
+	if t != nil {
+		next = *t
+	}
+
+	// shifts of 64 bits and more give 0, so the tree stops growing once
+	// every key fits
+	for key>>(seqBits*(next.height+1)) != 0 {
+		if next.root != nil {
+			root := &seqNode[V]{bitmap: 1, children: make([]*seqNode[V], 1<<seqBits)}
+			root.children[0] = next.root
+			next.root = root
+		}
+		next.height++
+	}
+
+	var added bool
+	next.root, added = next.root.set(key, next.height, value)
+	if added {
+		next.size++
+	}
+
+	return &next
+}
+
+// delete returns a version without key, t itself if it does not hold it.
+func (t *seqTree[V]) delete(key uint64) *seqTree[V] {
+	if t == nil || key>>(seqBits*(t.height+1)) != 0 {
+		return t
+	}
+
+	root, removed := t.root.delete(key, t.height)
+	if !removed {
+		return t
+	}
+
+	return &seqTree[V]{root: root, height: t.height, size: t.size - 1}
+}
+
+// ascend calls fn for every key above after, in order, until it returns
+// false. Seeking to after only costs a walk down the tree.
+func (t *seqTree[V]) ascend(after uint64, fn func(key uint64, value V) bool) {
+	if t != nil {
+		t.root.ascend(t.height, 0, after, fn)
+	}
+}
+
+func seqIndex(key uint64, level uint) (int, uint32) {
+	i := int(key>>(seqBits*level)) & (1<<seqBits - 1)
+	return i, uint32(1) << i
+}
+
+func (n *seqNode[V]) set(key uint64, level uint, value V) (*seqNode[V], bool) {
+	i, bit := seqIndex(key, level)
+
+	next := &seqNode[V]{}
+	if n != nil {
+		next.bitmap = n.bitmap
+	}
+	added := next.bitmap&bit == 0
+	next.bitmap |= bit
+
+	if level == 0 {
+		next.values = make([]V, 1<<seqBits)
+		if n != nil {
+			copy(next.values, n.values)
+		}
+		next.values[i] = value
+
+		return next, added
+	}
+
+	next.children = make([]*seqNode[V], 1<<seqBits)
+	var child *seqNode[V]
+	if n != nil {
+		copy(next.children, n.children)
+		child = n.children[i]
+	}
+	next.children[i], added = child.set(key, level-1, value)
+
+	return next, added
+}
+
+// delete returns nil for a node left empty, so walks skip it.
+func (n *seqNode[V]) delete(key uint64, level uint) (*seqNode[V], bool) {
+	if n == nil {
+		return nil, false
+	}
+
+	i, bit := seqIndex(key, level)
+	if n.bitmap&bit == 0 {
+		return n, false
+	}
+
+	next := &seqNode[V]{bitmap: n.bitmap}
+	if level == 0 {
+		next.values = make([]V, 1<<seqBits)
+		copy(next.values, n.values)
+
+		var zero V
+		next.values[i] = zero
+		next.bitmap &^= bit
+	} else {
+		child, removed := n.children[i].delete(key, level-1)
+		if !removed {
+			return n, false
+		}
+
+		next.children = make([]*seqNode[V], 1<<seqBits)
+		copy(next.children, n.children)
+		next.children[i] = child
+		if child == nil {
+			next.bitmap &^= bit
+		}
+	}
+
+	if next.bitmap == 0 {
+		return nil, true
+	}
+
+	return next, true
+}
+
+func (n *seqNode[V]) ascend(level uint, prefix, after uint64, fn func(uint64, V) bool) bool {
+	if n == nil {
+		return true
+	}
+
+	for b := n.bitmap; b != 0; b &= b - 1 {
+		i := bits.TrailingZeros32(b)
+		key := prefix | uint64(i)<<(seqBits*level)
+
+		if level == 0 {
+			if key > after && !fn(key, n.values[i]) {
+				return false
+			}
+			continue
+		}
+
+		// children holding only keys up to after are skipped whole
+		if last := key | (uint64(1)<<(seqBits*level) - 1); last <= after {
+			continue
+		}
+		if !n.children[i].ascend(level-1, key, after, fn) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,79 @@
+package database
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestSeqTree(t *testing.T) {
+	t.Run("behave like an ordered map", func(t *testing.T) {
+		rng := rand.New(rand.NewSource(1))
+
+		var tree *seqTree[int]
+		want := make(map[uint64]int)
+		for i := 0; i < 20000; i++ {
+			// keys of every magnitude make the tree grow level by level
+			key := uint64(rng.Intn(4000)) << (rng.Intn(4) * 16)
+			if rng.Intn(3) == 0 {
+				tree = tree.delete(key)
+				delete(want, key)
+			} else {
+				tree = tree.set(key, i)
+				want[key] = i
+			}
+		}
+
+		keys := make([]uint64, 0, len(want))
+		for key := range want {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+		if tree.len() != len(want) {
+			t.Fatalf("expected %d keys, got %d", len(want), tree.len())
+		}
+
+		after := keys[len(keys)/2]
+		var got []uint64
+		tree.ascend(after, func(key uint64, value int) bool {
+			if want[key] != value {
+				t.Fatalf("expected %d to hold %d, got %d", key, want[key], value)
+			}
+			got = append(got, key)
+			return true
+		})
+
+		rest := keys[len(keys)/2+1:]
+		if len(got) != len(rest) {
+			t.Fatalf("expected %d keys after %d, got %d", len(rest), after, len(got))
+		}
+		for i := range rest {
+			if got[i] != rest[i] {
+				t.Fatalf("expected key %d to be %d, got %d", i, rest[i], got[i])
+			}
+		}
+	})
+
+	t.Run("leave previous versions unchanged", func(t *testing.T) {
+		v1 := (*seqTree[string])(nil).set(1, "John")
+		v2 := v1.set(1<<40, "Jane")
+		v3 := v2.delete(1)
+
+		if v1.len() != 1 || v2.len() != 2 || v3.len() != 1 {
+			t.Fatalf("expected versions of 1, 2 and 1 keys, got %d, %d and %d", v1.len(), v2.len(), v3.len())
+		}
+
+		var keys []uint64
+		v2.ascend(0, func(key uint64, _ string) bool {
+			keys = append(keys, key)
+			return true
+		})
+		if len(keys) != 2 || keys[0] != 1 || keys[1] != 1<<40 {
+			t.Fatalf("expected the second version to hold 1 and 1<<40, got %v", keys)
+		}
+		if v3.delete(1) != v3 {
+			t.Fatalf("expected deleting a missing key to keep the version")
+		}
+	})
+}
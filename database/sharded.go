@@ -0,0 +1,475 @@
+package database
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+)
+
+// virtualNodes is how many points each shard has on the hash ring, which
+// evens out how many users each shard holds.
+const virtualNodes = 128
+
+// ShardedDB partitions the users by ID across shards, each with its own
+// lock, so writes to different shards do not wait for each other. Users
+// are assigned to shards by consistent hashing, so resizing only moves the
+// users of a fraction of the ring.
+//
+// It trades the limits, eviction and change feed of InMemoryDB for write
+// throughput.
+type ShardedDB struct {
+	// mu guards the layout: operations hold it for reading, Resize for
+	// writing while it moves the users of one shard.
+	mu     sync.RWMutex
+	shards []*shard
+	ring   ring
+	// next is the ring being resized to and moved the shards of ring whose
+	// users were already moved to it, nil outside of Resize.
+	next  *ring
+	moved map[int]bool
+
+	// resizing serializes calls to Resize.
+	resizing sync.Mutex
+	seq      atomic.Uint64
+}
+
+type shard struct {
+	mu   sync.RWMutex
+	data map[ID]*shardEntry
+}
+
+type shardEntry struct {
+	user User
+	// seq is the insertion order across every shard
+	seq uint64
+}
+
+// NewShardedDB returns a store with n shards, at least one.
+func NewShardedDB(n int) *ShardedDB {
+	n = max(n, 1)
+
+	db := &ShardedDB{ring: newRing(n)}
+	for i := 0; i < n; i++ {
+		db.shards = append(db.shards, newShard())
+	}
+
+	return db
+}
+
+func newShard() *shard {
+	return &shard{data: make(map[ID]*shardEntry)}
+}
+
+// Shards returns the number of shards, as of the last completed Resize.
+func (db *ShardedDB) Shards() int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.ring.size
+}
+
+// Resize spreads the users over n shards while the store keeps serving.
+// Operations only wait while the users of one shard are being moved, never
+// for the whole resize.
+func (db *ShardedDB) Resize(n int) error {
+	if n < 1 {
+		return errors.New("a sharded database needs at least one shard")
+	}
+
+	db.resizing.Lock()
+	defer db.resizing.Unlock()
+
+	// only Resize changes the ring, so it can be read without the lock
+	size := db.ring.size
+	if n == size {
+		return nil
+	}
+
+	next := newRing(n)
+
+	db.mu.Lock()
+	for len(db.shards) < n {
+		db.shards = append(db.shards, newShard())
+	}
+	db.next = &next
+	db.moved = make(map[int]bool, size)
+	db.mu.Unlock()
+
+	for i := 0; i < size; i++ {
+		db.mu.Lock()
+		db.moveShard(i, next)
+		db.mu.Unlock()
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.ring = next
+	db.next = nil
+	db.moved = nil
+	clear(db.shards[n:])
+	db.shards = db.shards[:n]
+
+	return nil
+}
+
+// moveShard moves the users of shard i that next assigns elsewhere. It
+// must be called with the write lock held, so no shard is locked.
+func (db *ShardedDB) moveShard(i int, next ring) {
+	src := db.shards[i]
+	for id, e := range src.data {
+		if dst := next.owner(id); dst != i {
+			db.shards[dst].data[id] = e
+			delete(src.data, id)
+		}
+	}
+
+	db.moved[i] = true
+}
+
+// shardFor returns the shard holding id. It must be called with the read
+// lock held.
+func (db *ShardedDB) shardFor(id ID) *shard {
+	i := db.ring.owner(id)
+	if db.next != nil && db.moved[i] {
+		i = db.next.owner(id)
+	}
+
+	return db.shards[i]
+}
+
+// lockShard locks the shard holding id for writing and returns the
+// function releasing both locks.
+func (db *ShardedDB) lockShard(id ID) (*shard, func()) {
+	db.mu.RLock()
+	s := db.shardFor(id)
+	s.mu.Lock()
+
+	return s, func() {
+		s.mu.Unlock()
+		db.mu.RUnlock()
+	}
+}
+
+func (db *ShardedDB) Insert(ctx context.Context, value User) (DBUser, error) {
+	id := ID(uuid.New())
+
+	s, unlock := db.lockShard(id)
+	defer unlock()
+
+	db.put(s, id, value)
+
+	return DBUser{ID: id, User: value}, nil
+}
+
+// InsertWithID stores value under a caller-provided ID, e.g. to preserve
+// IDs when migrating from another system.
+func (db *ShardedDB) InsertWithID(ctx context.Context, id string, value User) (DBUser, error) {
+	parsedID, err := parseID(id)
+	if err != nil {
+		return DBUser{}, err
+	}
+
+	s, unlock := db.lockShard(parsedID)
+	defer unlock()
+
+	if _, exists := s.data[parsedID]; exists {
+		return DBUser{}, ErrUserAlreadyExists
+	}
+
+	db.put(s, parsedID, value)
+
+	return DBUser{ID: parsedID, User: value}, nil
+}
+
+// Upsert replaces the user stored under id, creating it if it does not
+// exist. created reports which of the two happened.
+func (db *ShardedDB) Upsert(ctx context.Context, id string, value User) (user DBUser, created bool, err error) {
+	parsedID, err := parseID(id)
+	if err != nil {
+		return DBUser{}, false, err
+	}
+
+	s, unlock := db.lockShard(parsedID)
+	defer unlock()
+
+	created = db.put(s, parsedID, value)
+
+	return DBUser{ID: parsedID, User: value}, created, nil
+}
+
+func (db *ShardedDB) Update(ctx context.Context, id string, updatedUser User) (DBUser, error) {
+	return db.UpdateFunc(ctx, id, func(User) (User, error) {
+		return updatedUser, nil
+	})
+}
+
+// UpdateFunc replaces the user stored under id with the result of fn,
+// holding the lock of its shard in between so concurrent writes are not
+// lost. An error returned by fn aborts the update and is returned as is.
+func (db *ShardedDB) UpdateFunc(ctx context.Context, id string, fn func(User) (User, error)) (DBUser, error) {
+	parsedID, err := parseID(id)
+	if err != nil {
+		return DBUser{}, err
+	}
+
+	s, unlock := db.lockShard(parsedID)
+	defer unlock()
+
+	e, exists := s.data[parsedID]
+	if !exists {
+		return DBUser{}, ErrUserDoesNotExist
+	}
+
+	updatedUser, err := fn(e.user)
+	if err != nil {
+		return DBUser{}, err
+	}
+
+	e.user = updatedUser
+
+	return DBUser{ID: parsedID, User: updatedUser}, nil
+}
+
+func (db *ShardedDB) Delete(ctx context.Context, id string) (DBUser, error) {
+	parsedID, err := parseID(id)
+	if err != nil {
+		return DBUser{}, err
+	}
+
+	s, unlock := db.lockShard(parsedID)
+	defer unlock()
+
+	e, exists := s.data[parsedID]
+	if !exists {
+		return DBUser{}, ErrUserDoesNotExist
+	}
+
+	delete(s.data, parsedID)
+
+	return DBUser{ID: parsedID, User: e.user}, nil
+}
+
+func (db *ShardedDB) FindByID(ctx context.Context, id string) (DBUser, bool) {
+	parsedID, err := parseID(id)
+	if err != nil {
+		return DBUser{}, false
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	s := db.shardFor(parsedID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	e, exists := s.data[parsedID]
+	if !exists {
+		return DBUser{ID: parsedID}, false
+	}
+
+	return DBUser{ID: parsedID, User: e.user}, true
+}
+
+// FindAll returns every user in insertion order.
+func (db *ShardedDB) FindAll(ctx context.Context) []DBUser {
+	page, _ := db.List(ctx, ListOptions{})
+	return page.Users
+}
+
+// Count returns the number of stored users.
+func (db *ShardedDB) Count() int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var n int
+	for _, s := range db.shards {
+		s.mu.RLock()
+		n += len(s.data)
+		s.mu.RUnlock()
+	}
+
+	return n
+}
+
+// List returns users in insertion order, one page at a time, merging the
+// pages of every shard. Filter is called with the shards locked, it must
+// not call back into the store.
+func (db *ShardedDB) List(ctx context.Context, opts ListOptions) (Page, error) {
+	var after uint64
+	if opts.Cursor != "" {
+		var err error
+		if after, err = strconv.ParseUint(opts.Cursor, 10, 64); err != nil {
+			return Page{}, ErrInvalidCursor
+		}
+	}
+
+	if opts.Limit < 0 {
+		return Page{}, errors.New("limit must not be negative")
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	// holding every shard until the end keeps users inserted meanwhile
+	// after the ones listed, so the next page cannot skip them
+	for _, s := range db.shards {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+	}
+
+	// one more user than asked for tells whether there is a next page
+	limit := 0
+	if opts.Limit > 0 {
+		limit = opts.Limit + 1
+	}
+
+	runs := make([][]shardItem, 0, len(db.shards))
+	for _, s := range db.shards {
+		runs = append(runs, s.list(after, opts.Filter, limit))
+	}
+
+	items := mergeItems(runs, limit)
+
+	var page Page
+	if opts.Limit > 0 && len(items) > opts.Limit {
+		items = items[:opts.Limit]
+		page.Next = strconv.FormatUint(items[len(items)-1].seq, 10)
+	}
+
+	page.Users = make([]DBUser, 0, len(items))
+	for _, it := range items {
+		page.Users = append(page.Users, it.user)
+	}
+
+	return page, nil
+}
+
+// put stores user under id in s, whose write lock must be held, and
+// reports whether it was created.
+func (db *ShardedDB) put(s *shard, id ID, user User) bool {
+	if e, exists := s.data[id]; exists {
+		e.user = user
+		return false
+	}
+
+	// the sequence number is taken with the shard locked, so List never
+	// sees a user before one inserted earlier
+	s.data[id] = &shardEntry{user: user, seq: db.seq.Add(1)}
+	return true
+}
+
+type shardItem struct {
+	seq  uint64
+	user DBUser
+}
+
+// list returns the first limit users after the sequence number after,
+// zero meaning no limit. It must be called with the read lock held.
+func (s *shard) list(after uint64, filter func(User) bool, limit int) []shardItem {
+	items := make([]shardItem, 0, len(s.data))
+	for id, e := range s.data {
+		if e.seq > after && (filter == nil || filter(e.user)) {
+			items = append(items, shardItem{seq: e.seq, user: DBUser{ID: id, User: e.user}})
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i].seq < items[j].seq })
+
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+
+	return items
+}
+
+// mergeItems merges runs sorted by sequence number into the first limit
+// items, zero meaning no limit.
+func mergeItems(runs [][]shardItem, limit int) []shardItem {
+	var total int
+	for _, run := range runs {
+		total += len(run)
+	}
+	if limit > 0 {
+		total = min(total, limit)
+	}
+
+	merged := make([]shardItem, 0, total)
+	for len(merged) < total {
+		next := -1
+		for i, run := range runs {
+			if len(run) > 0 && (next < 0 || run[0].seq < runs[next][0].seq) {
+				next = i
+			}
+		}
+
+		merged = append(merged, runs[next][0])
+		runs[next] = runs[next][1:]
+	}
+
+	return merged
+}
+
+// ring assigns users to shards: each user belongs to the shard of the
+// first point at or after the hash of its ID.
+type ring struct {
+	size   int
+	points []ringPoint
+}
+
+type ringPoint struct {
+	hash  uint64
+	shard int
+}
+
+func newRing(n int) ring {
+	r := ring{size: n, points: make([]ringPoint, 0, n*virtualNodes)}
+
+	var key [16]byte
+	for i := 0; i < n; i++ {
+		for v := 0; v < virtualNodes; v++ {
+			binary.BigEndian.PutUint64(key[:8], uint64(i))
+			binary.BigEndian.PutUint64(key[8:], uint64(v))
+			r.points = append(r.points, ringPoint{hash: hash64(key[:]), shard: i})
+		}
+	}
+
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i].hash < r.points[j].hash })
+
+	return r
+}
+
+func (r ring) owner(id ID) int {
+	h := hash64(id[:])
+
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+
+	return r.points[i].shard
+}
+
+// hash64 is FNV-1a followed by the splitmix64 finalizer, which spreads
+// keys differing in a few bits, like the ring points, over the ring.
+func hash64[B []byte | string | indexKey](b B) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(b); i++ {
+		h ^= uint64(b[i])
+		h *= 1099511628211
+	}
+
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+
+	return h
+}
@@ -0,0 +1,269 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func newTestUser(i int) User {
+	return User{
+		FirstName: fmt.Sprint("John", i),
+		LastName:  "Doe",
+		Biography: "A simple guy who loves to write code and play games.",
+	}
+}
+
+// insertAll inserts n users and returns them in insertion order.
+func insertAll(t testing.TB, db *ShardedDB, n int) []DBUser {
+	t.Helper()
+
+	users := make([]DBUser, 0, n)
+	for i := 0; i < n; i++ {
+		user, err := db.Insert(context.Background(), newTestUser(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user)
+	}
+
+	return users
+}
+
+func assertUsers(t testing.TB, want, got []DBUser) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("expected %d users, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected user %d to be %v, got %v", i, want[i], got[i])
+		}
+	}
+}
+
+func TestShardedDB(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("save, update and delete users", func(t *testing.T) {
+		db := NewShardedDB(4)
+		user := insertAll(t, db, 1)[0]
+
+		got, exists := db.FindByID(ctx, user.ID.String())
+		if !exists || got != user {
+			t.Fatalf("expected to find %v, got %v", user, got)
+		}
+
+		if _, err := db.InsertWithID(ctx, user.ID.String(), user.User); !errors.Is(err, ErrUserAlreadyExists) {
+			t.Fatalf("expected %v, got %v", ErrUserAlreadyExists, err)
+		}
+
+		updated, err := db.Update(ctx, user.ID.String(), newTestUser(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := db.FindByID(ctx, user.ID.String()); got != updated {
+			t.Fatalf("expected the user to be updated to %v, got %v", updated, got)
+		}
+
+		if _, err := db.Delete(ctx, user.ID.String()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Delete(ctx, user.ID.String()); !errors.Is(err, ErrUserDoesNotExist) {
+			t.Fatalf("expected %v, got %v", ErrUserDoesNotExist, err)
+		}
+		if db.Count() != 0 {
+			t.Fatalf("expected no users left, got %d", db.Count())
+		}
+	})
+
+	t.Run("list users across shards in insertion order", func(t *testing.T) {
+		db := NewShardedDB(8)
+		users := insertAll(t, db, 100)
+
+		assertUsers(t, users, db.FindAll(ctx))
+
+		var got []DBUser
+		opts := ListOptions{Limit: 7}
+		for {
+			page, err := db.List(ctx, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, page.Users...)
+			if page.Next == "" {
+				break
+			}
+			opts.Cursor = page.Next
+		}
+		assertUsers(t, users, got)
+
+		page, err := db.List(ctx, ListOptions{Filter: UserFilter{FirstName: "John42"}.Match})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertUsers(t, users[42:43], page.Users)
+
+		if _, err := db.List(ctx, ListOptions{Cursor: "nope"}); !errors.Is(err, ErrInvalidCursor) {
+			t.Fatalf("expected %v, got %v", ErrInvalidCursor, err)
+		}
+	})
+
+	t.Run("spread users evenly over the shards", func(t *testing.T) {
+		db := NewShardedDB(8)
+		insertAll(t, db, 8000)
+
+		for i, s := range db.shards {
+			if n := len(s.data); n < 500 || n > 1500 {
+				t.Fatalf("expected shard %d to hold about 1000 users, got %d", i, n)
+			}
+		}
+	})
+
+	t.Run("move a fraction of the users when resizing", func(t *testing.T) {
+		db := NewShardedDB(4)
+		users := insertAll(t, db, 4000)
+
+		before := make(map[ID]int, len(users))
+		for _, user := range users {
+			before[user.ID] = db.ring.owner(user.ID)
+		}
+
+		if err := db.Resize(5); err != nil {
+			t.Fatal(err)
+		}
+
+		var moved int
+		for _, user := range users {
+			if db.ring.owner(user.ID) != before[user.ID] {
+				moved++
+			}
+		}
+		// a fifth of the users belong to the new shard
+		if moved > len(users)/3 {
+			t.Fatalf("expected about %d users to move, got %d", len(users)/5, moved)
+		}
+
+		for _, n := range []int{2, 1, 16} {
+			if err := db.Resize(n); err != nil {
+				t.Fatal(err)
+			}
+			if db.Shards() != n {
+				t.Fatalf("expected %d shards, got %d", n, db.Shards())
+			}
+			assertUsers(t, users, db.FindAll(ctx))
+		}
+
+		if err := db.Resize(0); err == nil {
+			t.Fatalf("expected resizing to no shards to fail")
+		}
+	})
+
+	t.Run("keep serving while resizing", func(t *testing.T) {
+		db := NewShardedDB(2)
+		users := insertAll(t, db, 1000)
+
+		var wg sync.WaitGroup
+		done := make(chan struct{})
+		for w := 0; w < 4; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; ; i++ {
+					select {
+					case <-done:
+						return
+					default:
+					}
+
+					user := users[i%len(users)]
+					if _, exists := db.FindByID(ctx, user.ID.String()); !exists {
+						t.Errorf("expected %s to be found while resizing", user.ID)
+						return
+					}
+					if _, err := db.Update(ctx, user.ID.String(), user.User); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}()
+		}
+
+		for _, n := range []int{8, 3, 12, 1} {
+			if err := db.Resize(n); err != nil {
+				t.Fatal(err)
+			}
+		}
+		close(done)
+		wg.Wait()
+
+		assertUsers(t, users, db.FindAll(ctx))
+	})
+}
+
+// store is what the benchmarks compare InMemoryDB and ShardedDB on.
+type store interface {
+	Insert(ctx context.Context, value User) (DBUser, error)
+	Update(ctx context.Context, id string, updatedUser User) (DBUser, error)
+	FindByID(ctx context.Context, id string) (DBUser, bool)
+}
+
+func benchmarkStores(b *testing.B, run func(b *testing.B, db store)) {
+	b.Run("InMemoryDB", func(b *testing.B) {
+		run(b, NewInMemoryDB())
+	})
+	for _, n := range []int{4, 16, 64} {
+		b.Run(fmt.Sprintf("ShardedDB/%d", n), func(b *testing.B) {
+			run(b, NewShardedDB(n))
+		})
+	}
+}
+
+func BenchmarkInsert(b *testing.B) {
+	benchmarkStores(b, func(b *testing.B, db store) {
+		user := newTestUser(0)
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := db.Insert(context.Background(), user); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+}
+
+// BenchmarkReadWrite updates one user out of ten it reads.
+func BenchmarkReadWrite(b *testing.B) {
+	benchmarkStores(b, func(b *testing.B, db store) {
+		ids := make([]string, 10000)
+		for i := range ids {
+			user, err := db.Insert(context.Background(), newTestUser(i))
+			if err != nil {
+				b.Fatal(err)
+			}
+			ids[i] = user.ID.String()
+		}
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			ctx := context.Background()
+			for i := 0; pb.Next(); i++ {
+				id := ids[i*7919%len(ids)]
+				if i%10 == 0 {
+					if _, err := db.Update(ctx, id, newTestUser(i)); err != nil {
+						b.Fatal(err)
+					}
+				} else if _, exists := db.FindByID(ctx, id); !exists {
+					b.Fatalf("expected %s to exist", id)
+				}
+			}
+		})
+	})
+}
@@ -0,0 +1,63 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// Snapshot is every stored user in insertion order, and every follow in
+// the order they were made, as of the change numbered Seq.
+type Snapshot struct {
+	Seq     uint64   `json:"seq" yaml:"seq"`
+	Users   []DBUser `json:"users" yaml:"users"`
+	Follows []Follow `json:"follows,omitempty" yaml:"follows,omitempty"`
+}
+
+// Snapshot returns a consistent copy of the store, which ChangesSince can
+// resume from.
+func (db *InMemoryDB) Snapshot(ctx context.Context) Snapshot {
+	c := db.users
+	ctx, span := c.startSpan(ctx, OpSnapshot)
+	defer span.End()
+
+	// the lock is only held to pair the version with its change, writers
+	// do not wait for the copy
+	start := time.Now()
+	c.mu.RLock()
+	c.observe(ctx, OpSnapshot, time.Since(start))
+
+	view := c.View()
+	graph := db.graph.Load()
+	db.feed.mu.Lock()
+	seq := db.feed.seq
+	db.feed.mu.Unlock()
+
+	c.mu.RUnlock()
+
+	snapshot := Snapshot{Seq: seq, Users: make([]DBUser, 0, view.Count()), Follows: graph.all()}
+	// the copy is made whatever happens to ctx, a snapshot is never partial
+	view.Scan(context.WithoutCancel(ctx), Query[User]{}, func(record Record[User], _ Cursor) bool {
+		snapshot.Users = append(snapshot.Users, toDBUser(record))
+		return true
+	})
+
+	return snapshot
+}
+
+// Restore replaces every stored user and follow with those of snapshot, in
+// order. It ignores the limits, so a follower always ends up with the same
+// users as its leader.
+func (db *InMemoryDB) Restore(ctx context.Context, snapshot Snapshot) {
+	ctx, span := db.users.startSpan(ctx, OpRestore)
+	defer span.End()
+
+	defer db.users.lock(ctx, OpRestore)()
+
+	records := make([]Record[User], 0, len(snapshot.Users))
+	for _, user := range snapshot.Users {
+		records = append(records, Record[User]{ID: user.ID, Value: user.User})
+	}
+
+	db.users.restore(records)
+	db.restoreFollows(snapshot.Follows)
+}
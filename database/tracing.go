@@ -0,0 +1,41 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("main/database")
+
+func (c *Collection[T]) startSpan(ctx context.Context, op Operation) (context.Context, trace.Span) {
+	return tracer.Start(
+		ctx,
+		c.name+"."+string(op),
+		trace.WithAttributes(attribute.String("db.operation", string(op))),
+	)
+}
+
+// recordLockWait adds an event to the current span once the lock is held,
+// so traces show how much of the operation was spent waiting on it.
+func recordLockWait(ctx context.Context, lockWait time.Duration) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+
+	span.AddEvent("lock acquired", trace.WithAttributes(
+		attribute.Int64("db.lock_wait_us", lockWait.Microseconds()),
+	))
+}
+
+func recordError(span trace.Span, err error) error {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+
+	return err
+}
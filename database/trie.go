@@ -0,0 +1,295 @@
+package database
+
+import "math/bits"
+
+// trieBits is how many bits of the hash pick a child at each level.
+const trieBits = 5
+
+// trieKey is a key of a trie, which hashes itself.
+type trieKey interface {
+	comparable
+	hash() uint64
+}
+
+func (i ID) hash() uint64 {
+	return hash64(i[:])
+}
+
+// indexKey is the key of a record in an index.
+type indexKey string
+
+func (k indexKey) hash() uint64 {
+	return hash64(k)
+}
+
+// trie is an immutable map, a hash array mapped trie. Writes return a new
+// version sharing everything but the path to the changed key, so readers
+// keep using the version they loaded while writers go on.
+type trie[K trieKey, V any] struct {
+	root *trieNode[K, V]
+	size int
+}
+
+// trieNode holds a child for every bit set in bitmap, in bit order. Each
+// child is either a *trieNode or a *trieBucket.
+type trieNode[K trieKey, V any] struct {
+	bitmap   uint32
+	children []any
+}
+
+// trieBucket holds the keys sharing a hash, almost always one.
+type trieBucket[K trieKey, V any] struct {
+	hash  uint64
+	items []trieItem[K, V]
+}
+
+type trieItem[K trieKey, V any] struct {
+	key   K
+	value V
+}
+
+func (t *trie[K, V]) len() int {
+	if t == nil {
+		return 0
+	}
+
+	return t.size
+}
+
+func (t *trie[K, V]) get(key K) (V, bool) {
+	var zero V
+	if t == nil {
+		return zero, false
+	}
+
+	h := key.hash()
+	n := t.root
+	for shift := uint(0); n != nil; shift += trieBits {
+		bit := uint32(1) << ((h >> shift) & (1<<trieBits - 1))
+		if n.bitmap&bit == 0 {
+			return zero, false
+		}
+
+		switch child := n.children[n.index(bit)].(type) {
+		case *trieNode[K, V]:
+			n = child
+		case *trieBucket[K, V]:
+			if child.hash == h {
+				for _, item := range child.items {
+					if item.key == key {
+						return item.value, true
+					}
+				}
+			}
+			return zero, false
+		}
+	}
+
+	return zero, false
+}
+
+// set returns a version storing value under key.
+func (t *trie[K, V]) set(key K, value V) *trie[K, V] {
+	var root *trieNode[K, V]
+	var size int
+	if t != nil {
+		root, size = t.root, t.size
+	}
+
+	root, added := root.set(key.hash(), 0, trieItem[K, V]{key: key, value: value})
+	if added {
+		size++
+	}
+
+	return &trie[K, V]{root: root, size: size}
+}
+
+// delete returns a version without key, t itself if it does not hold it.
+func (t *trie[K, V]) delete(key K) *trie[K, V] {
+	if t == nil {
+		return nil
+	}
+
+	root, removed := t.root.delete(key.hash(), 0, key)
+	if !removed {
+		return t
+	}
+
+	return &trie[K, V]{root: root, size: t.size - 1}
+}
+
+// each calls fn for every key, in no particular order, until it returns
+// false.
+func (t *trie[K, V]) each(fn func(K, V) bool) {
+	if t != nil {
+		t.root.each(fn)
+	}
+}
+
+func (n *trieNode[K, V]) index(bit uint32) int {
+	return bits.OnesCount32(n.bitmap & (bit - 1))
+}
+
+func (n *trieNode[K, V]) set(h uint64, shift uint, item trieItem[K, V]) (*trieNode[K, V], bool) {
+	if n == nil {
+		n = &trieNode[K, V]{}
+	}
+
+	bit := uint32(1) << ((h >> shift) & (1<<trieBits - 1))
+	i := n.index(bit)
+
+	if n.bitmap&bit == 0 {
+		children := make([]any, len(n.children)+1)
+		copy(children, n.children[:i])
+		children[i] = &trieBucket[K, V]{hash: h, items: []trieItem[K, V]{item}}
+		copy(children[i+1:], n.children[i:])
+
+		return &trieNode[K, V]{bitmap: n.bitmap | bit, children: children}, true
+	}
+
+	var child any
+	var added bool
+	switch c := n.children[i].(type) {
+	case *trieNode[K, V]:
+		child, added = c.set(h, shift+trieBits, item)
+	case *trieBucket[K, V]:
+		child, added = c.set(h, shift+trieBits, item)
+	}
+
+	return n.replace(i, child), added
+}
+
+func (n *trieNode[K, V]) delete(h uint64, shift uint, key K) (*trieNode[K, V], bool) {
+	if n == nil {
+		return nil, false
+	}
+
+	bit := uint32(1) << ((h >> shift) & (1<<trieBits - 1))
+	if n.bitmap&bit == 0 {
+		return n, false
+	}
+	i := n.index(bit)
+
+	var child any
+	var removed bool
+	switch c := n.children[i].(type) {
+	case *trieNode[K, V]:
+		var node *trieNode[K, V]
+		node, removed = c.delete(h, shift+trieBits, key)
+		// a node left with a single bucket is replaced by the bucket, so
+		// the trie stays as shallow as if the key was never added
+		if node != nil && len(node.children) == 1 {
+			if bucket, ok := node.children[0].(*trieBucket[K, V]); ok {
+				child = bucket
+				break
+			}
+		}
+		if node != nil {
+			child = node
+		}
+	case *trieBucket[K, V]:
+		var bucket *trieBucket[K, V]
+		bucket, removed = c.delete(h, key)
+		if bucket != nil {
+			child = bucket
+		}
+	}
+
+	if !removed {
+		return n, false
+	}
+
+	if child != nil {
+		return n.replace(i, child), true
+	}
+
+	if len(n.children) == 1 {
+		return nil, true
+	}
+
+	children := make([]any, len(n.children)-1)
+	copy(children, n.children[:i])
+	copy(children[i:], n.children[i+1:])
+
+	return &trieNode[K, V]{bitmap: n.bitmap &^ bit, children: children}, true
+}
+
+// replace returns a copy of n with child i replaced.
+func (n *trieNode[K, V]) replace(i int, child any) *trieNode[K, V] {
+	children := make([]any, len(n.children))
+	copy(children, n.children)
+	children[i] = child
+
+	return &trieNode[K, V]{bitmap: n.bitmap, children: children}
+}
+
+func (n *trieNode[K, V]) each(fn func(K, V) bool) bool {
+	if n == nil {
+		return true
+	}
+
+	for _, child := range n.children {
+		switch c := child.(type) {
+		case *trieNode[K, V]:
+			if !c.each(fn) {
+				return false
+			}
+		case *trieBucket[K, V]:
+			for _, item := range c.items {
+				if !fn(item.key, item.value) {
+					return false
+				}
+			}
+		}
+	}
+
+	return true
+}
+
+// set returns the bucket with item added or replaced, or a node holding
+// both b and a new bucket if the hashes differ.
+func (b *trieBucket[K, V]) set(h uint64, shift uint, item trieItem[K, V]) (any, bool) {
+	if b.hash != h {
+		// hashes of 64 bits always differ before the shift runs out
+		return newTrieNode(b, shift).set(h, shift, item)
+	}
+
+	items := make([]trieItem[K, V], len(b.items), len(b.items)+1)
+	copy(items, b.items)
+	for i := range items {
+		if items[i].key == item.key {
+			items[i] = item
+			return &trieBucket[K, V]{hash: h, items: items}, false
+		}
+	}
+
+	return &trieBucket[K, V]{hash: h, items: append(items, item)}, true
+}
+
+func (b *trieBucket[K, V]) delete(h uint64, key K) (*trieBucket[K, V], bool) {
+	if b.hash != h {
+		return b, false
+	}
+
+	for i, item := range b.items {
+		if item.key == key {
+			if len(b.items) == 1 {
+				return nil, true
+			}
+
+			items := make([]trieItem[K, V], 0, len(b.items)-1)
+			items = append(items, b.items[:i]...)
+			items = append(items, b.items[i+1:]...)
+
+			return &trieBucket[K, V]{hash: h, items: items}, true
+		}
+	}
+
+	return b, false
+}
+
+// newTrieNode returns a node at the level of shift holding b.
+func newTrieNode[K trieKey, V any](b *trieBucket[K, V], shift uint) *trieNode[K, V] {
+	bit := uint32(1) << ((b.hash >> shift) & (1<<trieBits - 1))
+	return &trieNode[K, V]{bitmap: bit, children: []any{b}}
+}
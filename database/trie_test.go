@@ -0,0 +1,220 @@
+package database
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestTrie(t *testing.T) {
+	t.Run("behave like a map", func(t *testing.T) {
+		rng := rand.New(rand.NewSource(1))
+		ids := make([]ID, 2000)
+		for i := range ids {
+			ids[i] = ID(uuid.New())
+		}
+
+		var data *trie[ID, *entry[User]]
+		want := make(map[ID]*entry[User])
+		for i := 0; i < 20000; i++ {
+			id := ids[rng.Intn(len(ids))]
+			if rng.Intn(3) == 0 {
+				data = data.delete(id)
+				delete(want, id)
+			} else {
+				e := &entry[User]{seq: uint64(i)}
+				data = data.set(id, e)
+				want[id] = e
+			}
+		}
+
+		if data.len() != len(want) {
+			t.Fatalf("expected %d users, got %d", len(want), data.len())
+		}
+		for _, id := range ids {
+			e, exists := data.get(id)
+			if e != want[id] || exists != (want[id] != nil) {
+				t.Fatalf("expected %s to hold %v, got %v", id, want[id], e)
+			}
+		}
+
+		var seen int
+		data.each(func(id ID, e *entry[User]) bool {
+			if want[id] != e {
+				t.Fatalf("expected %s to hold %v, got %v", id, want[id], e)
+			}
+			seen++
+			return true
+		})
+		if seen != len(want) {
+			t.Fatalf("expected to iterate over %d users, got %d", len(want), seen)
+		}
+	})
+
+	t.Run("leave previous versions unchanged", func(t *testing.T) {
+		first, second := ID(uuid.New()), ID(uuid.New())
+
+		v1 := (*trie[ID, *entry[User]])(nil).set(first, &entry[User]{seq: 1})
+		v2 := v1.set(second, &entry[User]{seq: 2})
+		v3 := v2.delete(first)
+
+		if _, exists := v1.get(second); exists || v1.len() != 1 {
+			t.Fatalf("expected the first version to only hold the first user")
+		}
+		if _, exists := v2.get(first); !exists || v2.len() != 2 {
+			t.Fatalf("expected the second version to hold both users")
+		}
+		if _, exists := v3.get(first); exists || v3.len() != 1 {
+			t.Fatalf("expected the third version to only hold the second user")
+		}
+		if v3.delete(first) != v3 {
+			t.Fatalf("expected deleting a missing user to keep the version")
+		}
+	})
+
+	t.Run("keep users whose hashes collide", func(t *testing.T) {
+		first, second := ID(uuid.New()), ID(uuid.New())
+
+		// both users share a single bucket, as if their hashes collided
+		root, _ := (*trieNode[ID, *entry[User]])(nil).set(42, 0, trieItem[ID, *entry[User]]{key: first, value: &entry[User]{seq: 1}})
+		root, _ = root.set(42, 0, trieItem[ID, *entry[User]]{key: second, value: &entry[User]{seq: 2}})
+		root, _ = root.set(43, 0, trieItem[ID, *entry[User]]{key: ID(uuid.New()), value: &entry[User]{seq: 3}})
+
+		root, removed := root.delete(42, 0, first)
+		if !removed {
+			t.Fatalf("expected the first user to be removed")
+		}
+
+		var seqs []uint64
+		root.each(func(_ ID, e *entry[User]) bool {
+			seqs = append(seqs, e.seq)
+			return true
+		})
+		if len(seqs) != 2 {
+			t.Fatalf("expected the other users to be kept, got %v", seqs)
+		}
+	})
+}
+
+func TestReadVersions(t *testing.T) {
+	ctx := context.Background()
+	db := NewInMemoryDB()
+	for i := 0; i < 100; i++ {
+		insert(t, db, "John")
+	}
+
+	// readers never block writers, and always see whole versions
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			db.Insert(ctx, newUser("Jane"))
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		page, err := db.List(ctx, ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j, user := range page.Users {
+			want := "John"
+			if j >= 100 {
+				want = "Jane"
+			}
+			if user.User.FirstName != want {
+				t.Fatalf("expected user %d to be %s, got %+v", j, want, user)
+			}
+		}
+		if n := len(db.FindAll(ctx)); n < 100 || n > 200 {
+			t.Fatalf("expected between 100 and 200 users, got %d", n)
+		}
+	}
+	wg.Wait()
+}
+
+// BenchmarkWriteWhileReading measures writes while other goroutines copy
+// every user, which no longer makes writers wait.
+func BenchmarkWriteWhileReading(b *testing.B) {
+	ctx := context.Background()
+	db := NewInMemoryDB()
+	ids := make([]string, 100000)
+	for i := range ids {
+		ids[i] = insert(b, db, "John").ID.String()
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					db.FindAll(ctx)
+				}
+			}
+		}()
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			if _, err := db.Update(ctx, ids[i*7919%len(ids)], newUser("Jack")); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.StopTimer()
+
+	close(done)
+	wg.Wait()
+}
+
+// BenchmarkFindByIDWhileWriting measures reads while another goroutine
+// keeps writing.
+func BenchmarkFindByIDWhileWriting(b *testing.B) {
+	ctx := context.Background()
+	db := NewInMemoryDB()
+	ids := make([]string, 100000)
+	for i := range ids {
+		ids[i] = insert(b, db, "John").ID.String()
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+				db.Update(ctx, ids[i%len(ids)], newUser("Jack"))
+			}
+		}
+	}()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			if _, exists := db.FindByID(ctx, ids[i*7919%len(ids)]); !exists {
+				b.Fatal("expected the user to exist")
+			}
+		}
+	})
+	b.StopTimer()
+
+	close(done)
+	wg.Wait()
+}
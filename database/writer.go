@@ -0,0 +1,26 @@
+package database
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrUnavailable is returned by writers which cannot take writes at the
+// moment, e.g. a cluster node that does not lead the cluster. The write
+// can be retried, later or elsewhere.
+var ErrUnavailable = errors.New("writes are unavailable at the moment")
+
+// Writer changes the users. InMemoryDB writes to itself, a cluster node
+// commits the writes to every member before applying them.
+type Writer interface {
+	Insert(ctx context.Context, value User) (DBUser, error)
+	InsertWithID(ctx context.Context, id string, value User) (DBUser, error)
+	Upsert(ctx context.Context, id string, value User) (DBUser, bool, error)
+	Update(ctx context.Context, id string, value User) (DBUser, error)
+	Patch(ctx context.Context, id string, patch UserPatch) (DBUser, error)
+	Delete(ctx context.Context, id string) (DBUser, error)
+	Follow(ctx context.Context, follower, followee string) (bool, error)
+	Unfollow(ctx context.Context, follower, followee string) error
+}
+
+var _ Writer = (*InMemoryDB)(nil)
@@ -0,0 +1,370 @@
+// Package graphqlapi serves the users over GraphQL, next to the REST API of
+// package api and backed by the same database.
+package graphqlapi
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"main/api"
+	"main/database"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/graph-gophers/graphql-go"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+	"github.com/graph-gophers/graphql-go/trace/otel"
+)
+
+//go:embed schema.graphql
+var schema string
+
+const (
+	// maxBodySize bounds the request body, batches included.
+	maxBodySize = 1 << 20
+	// maxBatchSize bounds how many operations a single request can carry.
+	maxBatchSize = 20
+	// maxDepth rejects queries nesting selections deeper than the schema.
+	maxDepth = 10
+	// heartbeatInterval keeps idle subscriptions from being closed by proxies.
+	heartbeatInterval = 15 * time.Second
+)
+
+// missingSubscriptionTransport is what graphql-go answers to subscriptions
+// sent to Exec, it is replaced by a hint at the transport we support.
+const missingSubscriptionTransport = "graphql-ws protocol header is missing"
+
+type request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+type readOnlyKey struct{}
+
+// followerKey marks the operations run by a follower, with the flag
+// mutations set to have the request forwarded to the leader.
+type followerKey struct{}
+
+// writable fails mutations sent with GET, which must not change the store,
+// and mutations sent to followers, which only change through replication.
+func writable(ctx context.Context) error {
+	if ctx.Value(readOnlyKey{}) != nil {
+		return resolverError{codeMethodNotAllowed, "mutations must be sent with POST"}
+	}
+
+	if mutated, ok := ctx.Value(followerKey{}).(*atomic.Bool); ok {
+		mutated.Store(true)
+		return resolverError{codeFailedPrecondition, api.ErrReadOnlyFollower.Error()}
+	}
+
+	return nil
+}
+
+type handler struct {
+	schema *graphql.Schema
+	// shutdown ends the subscriptions once closed
+	shutdown <-chan struct{}
+	// follower is set on replication followers, which send the requests
+	// with mutations to forward, or fail them if it is nil
+	follower bool
+	forward  http.Handler
+}
+
+type Option func(*options)
+
+type options struct {
+	writer   database.Writer
+	reader   database.Reader
+	shutdown <-chan struct{}
+	leader   *url.URL
+	forward  bool
+}
+
+// WithWriter makes the mutations change the users through writer instead
+// of the database, e.g. a cluster node committing them to its cluster.
+func WithWriter(writer database.Writer) Option {
+	return func(o *options) {
+		o.writer = writer
+	}
+}
+
+// WithReader syncs reader before the user and users queries, e.g. a
+// cluster node making the reads of its database linearizable.
+func WithReader(reader database.Reader) Option {
+	return func(o *options) {
+		o.reader = reader
+	}
+}
+
+// WithShutdown ends the subscriptions once done is closed. They may never
+// end on their own, so http.Server.Shutdown would wait for them until its
+// deadline, see http.Server.RegisterOnShutdown.
+func WithShutdown(done <-chan struct{}) Option {
+	return func(o *options) {
+		o.shutdown = done
+	}
+}
+
+// WithFollower makes the handler serve a read-only follower of leader.
+// Queries run against the local database, while requests with mutations
+// are proxied to leader when forward is true, and fail otherwise.
+func WithFollower(leader *url.URL, forward bool) Option {
+	return func(o *options) {
+		o.leader = leader
+		o.forward = forward
+	}
+}
+
+// NewHandler returns the GraphQL endpoint. Queries and mutations are sent
+// as JSON with POST, or in the query string with GET for queries only. A
+// JSON array of operations is run as a batch. Clients accepting
+// text/event-stream get the results as server-sent events, which is how
+// subscriptions are delivered.
+func NewHandler(db *database.InMemoryDB, opts ...Option) http.Handler {
+	o := options{writer: db}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	h := &handler{
+		schema: graphql.MustParseSchema(
+			schema,
+			&resolver{db: db, writer: o.writer, reader: o.reader},
+			graphql.UseStringDescriptions(),
+			graphql.MaxDepth(maxDepth),
+			graphql.Tracer(otel.DefaultTracer()),
+		),
+		shutdown: o.shutdown,
+		follower: o.leader != nil,
+	}
+	if o.leader != nil && o.forward {
+		h.forward = api.LeaderProxy(o.leader)
+	}
+
+	return h
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var (
+		requests []request
+		batch    bool
+		err      error
+	)
+
+	ctx := r.Context()
+	switch r.Method {
+	case http.MethodGet:
+		var req request
+		req, err = requestFromQuery(r)
+		requests = []request{req}
+		ctx = context.WithValue(ctx, readOnlyKey{}, true)
+	case http.MethodPost:
+		requests, batch, err = requestsFromBody(r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		sendError(w, "please send GraphQL requests with GET or POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var mutated atomic.Bool
+	if h.follower {
+		ctx = context.WithValue(ctx, followerKey{}, &mutated)
+	}
+
+	if !batch && strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		h.stream(w, r.WithContext(ctx), requests[0])
+		return
+	}
+
+	responses := make([]*graphql.Response, 0, len(requests))
+	for _, req := range requests {
+		responses = append(responses, h.exec(ctx, req))
+	}
+
+	// the mutations failed before changing anything, so the leader can
+	// run the whole request again
+	if mutated.Load() && h.forward != nil {
+		h.forward.ServeHTTP(w, r)
+		return
+	}
+
+	if batch {
+		send(w, responses)
+	} else {
+		send(w, responses[0])
+	}
+}
+
+func (h *handler) exec(ctx context.Context, req request) *graphql.Response {
+	response := h.schema.Exec(ctx, req.Query, req.OperationName, req.Variables)
+
+	for _, err := range response.Errors {
+		if err.Message == missingSubscriptionTransport {
+			err.Message = "subscriptions must be sent with Accept: text/event-stream"
+		}
+	}
+
+	return response
+}
+
+// stream sends each result as a next event and a complete event once the
+// operation is over, following the distinct connections mode of the
+// GraphQL over server-sent events protocol.
+func (h *handler) stream(w http.ResponseWriter, r *http.Request, req request) {
+	rc := http.NewResponseController(w)
+
+	// the stream outlives the server write timeout by design
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && err != http.ErrNotSupported {
+		slog.Error("could not disable the write deadline", "error", err)
+	}
+
+	results, err := h.schema.Subscribe(r.Context(), req.Query, req.OperationName, req.Variables)
+	if err != nil {
+		sendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		slog.Error("could not flush the GraphQL stream", "error", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-h.shutdown:
+			// without a complete event, the client knows to subscribe again
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case result, ok := <-results:
+			if !ok {
+				fmt.Fprint(w, "event: complete\ndata:\n\n")
+				rc.Flush()
+				return
+			}
+
+			data, err := json.Marshal(result)
+			if err != nil {
+				slog.Error("could not marshal the GraphQL result", "error", err)
+				return
+			}
+
+			if _, err := fmt.Fprintf(w, "event: next\ndata: %s\n\n", data); err != nil {
+				return
+			}
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func requestFromQuery(r *http.Request) (request, error) {
+	query := r.URL.Query()
+
+	req := request{
+		Query:         query.Get("query"),
+		OperationName: query.Get("operationName"),
+	}
+
+	if variables := query.Get("variables"); variables != "" {
+		if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+			return request{}, fmt.Errorf("please provide the variables as a JSON object: %w", err)
+		}
+	}
+
+	if req.Query == "" {
+		return request{}, errors.New("please provide a query")
+	}
+
+	return req, nil
+}
+
+// requestsFromBody reads a single operation, or a batch of them when the
+// body is a JSON array.
+func requestsFromBody(r *http.Request) ([]request, bool, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	if err != nil {
+		return nil, false, fmt.Errorf("could not read the body: %w", err)
+	}
+	if len(body) > maxBodySize {
+		return nil, false, fmt.Errorf("please send at most %d bytes", maxBodySize)
+	}
+	// followers may forward the body to the leader
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var requests []request
+		if err := json.Unmarshal(body, &requests); err != nil {
+			return nil, false, fmt.Errorf("please provide a JSON array of GraphQL requests: %w", err)
+		}
+
+		if len(requests) == 0 || len(requests) > maxBatchSize {
+			return nil, false, fmt.Errorf("please batch between 1 and %d requests", maxBatchSize)
+		}
+
+		for _, req := range requests {
+			if req.Query == "" {
+				return nil, false, errors.New("please provide a query in every request")
+			}
+		}
+
+		return requests, true, nil
+	}
+
+	var req request
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, false, fmt.Errorf("please provide a JSON GraphQL request: %w", err)
+	}
+
+	if req.Query == "" {
+		return nil, false, errors.New("please provide a query")
+	}
+
+	return []request{req}, false, nil
+}
+
+// send always answers 200, errors are reported in the GraphQL response.
+func send(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("could not write the GraphQL response", "error", err)
+	}
+}
+
+func sendError(w http.ResponseWriter, message string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	response := graphql.Response{Errors: []*gqlerrors.QueryError{{Message: message}}}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("could not write the GraphQL response", "error", err)
+	}
+}
@@ -0,0 +1,353 @@
+package graphqlapi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"main/database"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+var user = map[string]any{
+	"firstName": "John",
+	"lastName":  "Doe",
+	"biography": "A simple guy who loves to write code and play games.",
+}
+
+type gqlError struct {
+	Message    string         `json:"message"`
+	Extensions map[string]any `json:"extensions"`
+}
+
+type response struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []gqlError      `json:"errors"`
+}
+
+type dbUser struct {
+	ID   string `json:"id"`
+	User struct {
+		FirstName string `json:"firstName"`
+		LastName  string `json:"lastName"`
+		Biography string `json:"biography"`
+	} `json:"user"`
+}
+
+func newServer(t testing.TB) (*httptest.Server, *database.InMemoryDB) {
+	t.Helper()
+
+	db := database.NewInMemoryDB()
+	server := httptest.NewServer(NewHandler(db))
+	t.Cleanup(server.Close)
+
+	return server, db
+}
+
+func post(t testing.TB, server *httptest.Server, body any, v any) {
+	t.Helper()
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.Post(server.URL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected the status to be 200, got %d", res.StatusCode)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func do(t testing.TB, server *httptest.Server, query string, variables map[string]any) response {
+	t.Helper()
+
+	var resp response
+	post(t, server, request{Query: query, Variables: variables}, &resp)
+
+	return resp
+}
+
+func assertCode(t testing.TB, want string, resp response) {
+	t.Helper()
+
+	if len(resp.Errors) != 1 {
+		t.Fatalf("expected one error, got %+v", resp.Errors)
+	}
+	if got := resp.Errors[0].Extensions["code"]; got != want {
+		t.Fatalf("expected the code to be %s, got %v (%s)", want, got, resp.Errors[0].Message)
+	}
+}
+
+const createUser = `mutation($user: UserInput!) { createUser(user: $user) { id user { firstName lastName biography } } }`
+
+func TestHandler(t *testing.T) {
+	t.Run("create, get, update and delete a user", func(t *testing.T) {
+		server, db := newServer(t)
+
+		resp := do(t, server, createUser, map[string]any{"user": user})
+		var created struct{ CreateUser dbUser }
+		if err := json.Unmarshal(resp.Data, &created); err != nil {
+			t.Fatal(err)
+		}
+		id := created.CreateUser.ID
+		if _, exists := db.FindByID(context.Background(), id); !exists {
+			t.Fatalf("expected %s to be stored", id)
+		}
+
+		resp = do(t, server, `query($id: ID!) { user(id: $id) { user { firstName } } }`, map[string]any{"id": id})
+		if got := string(resp.Data); got != `{"user":{"user":{"firstName":"John"}}}` {
+			t.Fatalf("expected only the first name, got %s", got)
+		}
+
+		updated := map[string]any{"firstName": "Jane", "lastName": "Doe", "biography": user["biography"]}
+		resp = do(t, server, `mutation($id: ID!, $user: UserInput!) { updateUser(id: $id, user: $user) { user { firstName } } }`,
+			map[string]any{"id": id, "user": updated})
+		if got := string(resp.Data); got != `{"updateUser":{"user":{"firstName":"Jane"}}}` {
+			t.Fatalf("expected the user to be updated, got %s", got)
+		}
+
+		resp = do(t, server, `mutation($id: ID!) { deleteUser(id: $id) { id } }`, map[string]any{"id": id})
+		if len(resp.Errors) != 0 {
+			t.Fatalf("expected no errors, got %+v", resp.Errors)
+		}
+
+		resp = do(t, server, `query($id: ID!) { user(id: $id) { id } }`, map[string]any{"id": id})
+		if got := string(resp.Data); got != `{"user":null}` {
+			t.Fatalf("expected the deleted user to be null, got %s", got)
+		}
+	})
+
+	t.Run("errors carry the REST messages and a code", func(t *testing.T) {
+		server, _ := newServer(t)
+
+		invalid := map[string]any{"firstName": "J", "lastName": "Doe", "biography": "short"}
+		assertCode(t, codeBadUserInput, do(t, server, createUser, map[string]any{"user": invalid}))
+
+		assertCode(t, codeBadUserInput, do(t, server, `{ user(id: "nope") { id } }`, nil))
+
+		missing := database.ID{}.NewID().String()
+		resp := do(t, server, `mutation($id: ID!, $user: UserInput!) { updateUser(id: $id, user: $user) { id } }`,
+			map[string]any{"id": missing, "user": user})
+		assertCode(t, codeNotFound, resp)
+
+		assertCode(t, codeBadUserInput, do(t, server, `{ users(cursor: "nope") { next } }`, nil))
+	})
+
+	t.Run("refuse deleting followed users when restricted", func(t *testing.T) {
+		ctx := context.Background()
+		db := database.NewInMemoryDB(database.WithDeletePolicy(database.DeleteRestrict))
+		server := httptest.NewServer(NewHandler(db))
+		defer server.Close()
+
+		john, _ := db.Insert(ctx, database.User{FirstName: "John"})
+		jane, _ := db.Insert(ctx, database.User{FirstName: "Jane"})
+		db.Follow(ctx, john.ID.String(), jane.ID.String())
+
+		resp := do(t, server, `mutation($id: ID!) { deleteUser(id: $id) { id } }`, map[string]any{"id": jane.ID.String()})
+		assertCode(t, codeFailedPrecondition, resp)
+	})
+
+	t.Run("list users with a filter, page by page", func(t *testing.T) {
+		server, db := newServer(t)
+
+		for _, name := range []string{"John", "Jane", "John"} {
+			if _, err := db.Insert(context.Background(), database.User{FirstName: name, LastName: "Doe", Biography: "bio"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		const query = `query($cursor: String) {
+			users(filter: {firstName: "john"}, limit: 1, cursor: $cursor) { users { user { firstName } } next }
+		}`
+
+		var cursor any
+		seen := 0
+		for {
+			resp := do(t, server, query, map[string]any{"cursor": cursor})
+			var page struct {
+				Users struct {
+					Users []dbUser
+					Next  *string
+				}
+			}
+			if err := json.Unmarshal(resp.Data, &page); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, u := range page.Users.Users {
+				if u.User.FirstName != "John" {
+					t.Fatalf("expected only Johns, got %s", u.User.FirstName)
+				}
+				seen++
+			}
+
+			if page.Users.Next == nil {
+				break
+			}
+			cursor = *page.Users.Next
+		}
+
+		if seen != 2 {
+			t.Fatalf("expected 2 users, got %d", seen)
+		}
+	})
+
+	t.Run("batch several operations", func(t *testing.T) {
+		server, _ := newServer(t)
+
+		var responses []response
+		post(t, server, []request{
+			{Query: createUser, Variables: map[string]any{"user": user}},
+			{Query: `{ users { users { id } } }`},
+		}, &responses)
+
+		if len(responses) != 2 {
+			t.Fatalf("expected 2 responses, got %d", len(responses))
+		}
+		if got := strings.Count(string(responses[1].Data), `"id"`); got != 1 {
+			t.Fatalf("expected the second operation to see the created user, got %s", responses[1].Data)
+		}
+	})
+
+	t.Run("reject mutations sent with GET", func(t *testing.T) {
+		server, db := newServer(t)
+
+		query := url.Values{
+			"query":     {createUser},
+			"variables": {`{"user":{"firstName":"John","lastName":"Doe","biography":"A simple guy who loves to write code."}}`},
+		}
+		res, err := http.Get(server.URL + "?" + query.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		var resp response
+		if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		assertCode(t, codeMethodNotAllowed, resp)
+		if n := db.Count(); n != 0 {
+			t.Fatalf("expected no user to be stored, got %d", n)
+		}
+	})
+
+	t.Run("reject malformed requests", func(t *testing.T) {
+		server, _ := newServer(t)
+
+		res, err := http.Post(server.URL, "application/json", strings.NewReader(`{"query":`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected the status to be 400, got %d", res.StatusCode)
+		}
+	})
+
+	t.Run("subscribe to user changes", func(t *testing.T) {
+		server, db := newServer(t)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		query := url.Values{"query": {`subscription { userChanged { type user { user { firstName } } } }`}}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"?"+query.Encode(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", "text/event-stream")
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		if got := res.Header.Get("Content-Type"); got != "text/event-stream" {
+			t.Fatalf("expected an event stream, got %q", got)
+		}
+
+		// the subscription is active once the headers arrived
+		if _, err := db.Insert(context.Background(), database.User{FirstName: "John", LastName: "Doe", Biography: "bio"}); err != nil {
+			t.Fatal(err)
+		}
+
+		scanner := bufio.NewScanner(res.Body)
+		var event string
+		for scanner.Scan() {
+			line := scanner.Text()
+			if name, ok := strings.CutPrefix(line, "event: "); ok {
+				event = name
+			}
+			if data, ok := strings.CutPrefix(line, "data: "); ok {
+				if event != "next" {
+					t.Fatalf("expected a next event, got %q", event)
+				}
+
+				want := `{"data":{"userChanged":{"type":"CREATED","user":{"user":{"firstName":"John"}}}}}`
+				if data != want {
+					t.Fatalf("expected %s, got %s", want, data)
+				}
+				return
+			}
+		}
+
+		t.Fatalf("the stream ended without a change: %v", scanner.Err())
+	})
+
+	t.Run("end subscriptions on shutdown", func(t *testing.T) {
+		shutdown := make(chan struct{})
+		server := httptest.NewServer(NewHandler(database.NewInMemoryDB(), WithShutdown(shutdown)))
+		defer server.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		query := url.Values{"query": {`subscription { userChanged { type } }`}}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"?"+query.Encode(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", "text/event-stream")
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		close(shutdown)
+
+		// the body ends before the request times out
+		if _, err := io.Copy(io.Discard, res.Body); err != nil {
+			t.Fatalf("expected the stream to end, got %v", err)
+		}
+	})
+
+	t.Run("subscriptions need an event stream", func(t *testing.T) {
+		server, _ := newServer(t)
+
+		resp := do(t, server, `subscription { userChanged { seq } }`, nil)
+		if len(resp.Errors) != 1 || !strings.Contains(resp.Errors[0].Message, "text/event-stream") {
+			t.Fatalf("expected a hint at the event stream, got %+v", resp.Errors)
+		}
+	})
+}
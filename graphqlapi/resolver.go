@@ -0,0 +1,351 @@
+package graphqlapi
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"main/api"
+	"main/database"
+	"strconv"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/graph-gophers/graphql-go"
+)
+
+// WithRequiredStructEnabled: opt-in to new behavior that will become the default behavior in v11+
+var validate = validator.New(validator.WithRequiredStructEnabled())
+
+// Error codes reported under extensions.code, next to the REST messages.
+const (
+	codeBadUserInput       = "BAD_USER_INPUT"
+	codeNotFound           = "NOT_FOUND"
+	codeAlreadyExists      = "ALREADY_EXISTS"
+	codeStorageFull        = "STORAGE_FULL"
+	codeFailedPrecondition = "FAILED_PRECONDITION"
+	codeUnavailable        = "UNAVAILABLE"
+	codeInternal           = "INTERNAL_SERVER_ERROR"
+	codeMethodNotAllowed   = "METHOD_NOT_ALLOWED"
+)
+
+type resolverError struct {
+	code    string
+	message string
+}
+
+func (e resolverError) Error() string {
+	return e.message
+}
+
+func (e resolverError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": e.code}
+}
+
+// toError maps database errors to GraphQL errors, with the messages of the
+// REST API.
+func toError(err error) error {
+	switch {
+	case errors.Is(err, database.ErrUserDoesNotExist):
+		return resolverError{codeNotFound, api.ErrUserNotFound.Error()}
+	case errors.Is(err, database.ErrInvalidID):
+		return resolverError{codeBadUserInput, api.ErrInvalidUserID.Error()}
+	case errors.Is(err, database.ErrUserAlreadyExists):
+		return resolverError{codeAlreadyExists, err.Error()}
+	case errors.Is(err, database.ErrStorageFull):
+		return resolverError{codeStorageFull, api.ErrInsufficientStorage.Error()}
+	case errors.Is(err, database.ErrInvalidCursor):
+		return resolverError{codeBadUserInput, api.ErrInvalidPagination.Error()}
+	case errors.Is(err, database.ErrUserHasFollows):
+		return resolverError{codeFailedPrecondition, api.ErrUserHasFollows.Error()}
+	case errors.Is(err, database.ErrUnavailable):
+		return resolverError{codeUnavailable, api.ErrWritesUnavailable.Error()}
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return err
+	default:
+		slog.Error("unexpected database error", "error", err)
+		return resolverError{codeInternal, "internal server error"}
+	}
+}
+
+type resolver struct {
+	db     *database.InMemoryDB
+	writer database.Writer
+	// reader is synced before the queries, when set
+	reader database.Reader
+}
+
+// sync makes the queries that follow read the database as of their
+// arrival at least, see WithReader.
+func (r *resolver) sync(ctx context.Context) error {
+	if r.reader == nil {
+		return nil
+	}
+
+	err := r.reader.Sync(ctx)
+	if errors.Is(err, database.ErrUnavailable) {
+		return resolverError{codeUnavailable, api.ErrReadsUnavailable.Error()}
+	}
+	if err != nil {
+		return toError(err)
+	}
+
+	return nil
+}
+
+func (r *resolver) User(ctx context.Context, args struct{ ID graphql.ID }) (*dbUserResolver, error) {
+	if _, err := database.ParseID(string(args.ID)); err != nil {
+		return nil, toError(err)
+	}
+
+	if err := r.sync(ctx); err != nil {
+		return nil, err
+	}
+
+	user, exists := r.db.FindByID(ctx, string(args.ID))
+	if !exists {
+		return nil, nil
+	}
+
+	return &dbUserResolver{user}, nil
+}
+
+type userFilterInput struct {
+	FirstName *string
+	LastName  *string
+	Query     *string
+}
+
+func (r *resolver) Users(ctx context.Context, args struct {
+	Filter *userFilterInput
+	Limit  *int32
+	Cursor *string
+}) (*pageResolver, error) {
+	var opts database.ListOptions
+
+	if args.Filter != nil {
+		filter := database.UserFilter{
+			FirstName: deref(args.Filter.FirstName),
+			LastName:  deref(args.Filter.LastName),
+			Contains:  deref(args.Filter.Query),
+		}
+		opts.Filter = filter.Match
+	}
+
+	if args.Limit != nil {
+		if *args.Limit < 1 {
+			return nil, resolverError{codeBadUserInput, api.ErrInvalidPagination.Error()}
+		}
+		opts.Limit = int(*args.Limit)
+	}
+	opts.Cursor = deref(args.Cursor)
+
+	if err := r.sync(ctx); err != nil {
+		return nil, err
+	}
+
+	page, err := r.db.List(ctx, opts)
+	if err != nil {
+		return nil, toError(err)
+	}
+
+	return &pageResolver{page}, nil
+}
+
+type userInput struct {
+	FirstName string
+	LastName  string
+	Biography string
+}
+
+// toUser converts and validates a user with the rules of the REST API.
+func (in userInput) toUser(ctx context.Context) (database.User, error) {
+	user := database.User{
+		FirstName: in.FirstName,
+		LastName:  in.LastName,
+		Biography: in.Biography,
+	}
+
+	if err := validate.StructCtx(ctx, &user); err != nil {
+		return database.User{}, resolverError{codeBadUserInput, api.ErrInvalidUserParams.Error()}
+	}
+
+	return user, nil
+}
+
+func (r *resolver) CreateUser(ctx context.Context, args struct{ User userInput }) (*dbUserResolver, error) {
+	if err := writable(ctx); err != nil {
+		return nil, err
+	}
+
+	user, err := args.User.toUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	dbUser, err := r.writer.Insert(ctx, user)
+	if err != nil {
+		return nil, toError(err)
+	}
+
+	return &dbUserResolver{dbUser}, nil
+}
+
+func (r *resolver) UpdateUser(ctx context.Context, args struct {
+	ID   graphql.ID
+	User userInput
+}) (*dbUserResolver, error) {
+	if err := writable(ctx); err != nil {
+		return nil, err
+	}
+
+	if _, err := database.ParseID(string(args.ID)); err != nil {
+		return nil, toError(err)
+	}
+
+	user, err := args.User.toUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	dbUser, err := r.writer.Update(ctx, string(args.ID), user)
+	if err != nil {
+		return nil, toError(err)
+	}
+
+	return &dbUserResolver{dbUser}, nil
+}
+
+func (r *resolver) DeleteUser(ctx context.Context, args struct{ ID graphql.ID }) (*dbUserResolver, error) {
+	if err := writable(ctx); err != nil {
+		return nil, err
+	}
+
+	if _, err := database.ParseID(string(args.ID)); err != nil {
+		return nil, toError(err)
+	}
+
+	user, err := r.writer.Delete(ctx, string(args.ID))
+	if err != nil {
+		return nil, toError(err)
+	}
+
+	return &dbUserResolver{user}, nil
+}
+
+// UserChanged forwards the store changes until the subscriber falls behind
+// or goes away.
+func (r *resolver) UserChanged(ctx context.Context) <-chan *changeResolver {
+	changes, cancel := r.db.Subscribe()
+
+	out := make(chan *changeResolver)
+	go func() {
+		defer close(out)
+		defer cancel()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case change, ok := <-changes:
+				if !ok {
+					return
+				}
+				// the schema only describes changes of users
+				if change.Follow != nil {
+					continue
+				}
+
+				select {
+				case out <- &changeResolver{change}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+type dbUserResolver struct {
+	user database.DBUser
+}
+
+func (r *dbUserResolver) ID() graphql.ID {
+	return graphql.ID(r.user.ID.String())
+}
+
+func (r *dbUserResolver) User() *userResolver {
+	return &userResolver{r.user.User}
+}
+
+type userResolver struct {
+	user database.User
+}
+
+func (r *userResolver) FirstName() string {
+	return r.user.FirstName
+}
+
+func (r *userResolver) LastName() string {
+	return r.user.LastName
+}
+
+func (r *userResolver) Biography() string {
+	return r.user.Biography
+}
+
+type pageResolver struct {
+	page database.Page
+}
+
+func (r *pageResolver) Users() []*dbUserResolver {
+	users := make([]*dbUserResolver, 0, len(r.page.Users))
+	for _, user := range r.page.Users {
+		users = append(users, &dbUserResolver{user})
+	}
+
+	return users
+}
+
+func (r *pageResolver) Next() *string {
+	if r.page.Next == "" {
+		return nil
+	}
+
+	return &r.page.Next
+}
+
+var changeTypes = map[database.ChangeType]string{
+	database.ChangeCreated: "CREATED",
+	database.ChangeUpdated: "UPDATED",
+	database.ChangeDeleted: "DELETED",
+	database.ChangeEvicted: "EVICTED",
+}
+
+type changeResolver struct {
+	change database.Change
+}
+
+func (r *changeResolver) Seq() graphql.ID {
+	return graphql.ID(strconv.FormatUint(r.change.Seq, 10))
+}
+
+func (r *changeResolver) Type() string {
+	return changeTypes[r.change.Type]
+}
+
+func (r *changeResolver) User() *dbUserResolver {
+	return &dbUserResolver{r.change.User}
+}
+
+func (r *changeResolver) Time() graphql.Time {
+	return graphql.Time{Time: r.change.Time}
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+
+	return *s
+}
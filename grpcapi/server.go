@@ -0,0 +1,271 @@
+// Package grpcapi serves the users over gRPC, next to the REST API of
+// package api and backed by the same database.
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"main/api"
+	"main/database"
+	usersv1 "main/proto/users/v1"
+
+	"github.com/go-playground/validator/v10"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// WithRequiredStructEnabled: opt-in to new behavior that will become the default behavior in v11+
+var validate = validator.New(validator.WithRequiredStructEnabled())
+
+type server struct {
+	usersv1.UnimplementedUserServiceServer
+	db     *database.InMemoryDB
+	writer database.Writer
+}
+
+// NewServer returns a gRPC server with the UserService registered.
+func NewServer(db *database.InMemoryDB, opts ...grpc.ServerOption) *grpc.Server {
+	return NewServerWithWriter(db, db, opts...)
+}
+
+// NewServerWithWriter is NewServer changing the users through writer
+// instead of db, e.g. a cluster node committing them to its cluster.
+func NewServerWithWriter(db *database.InMemoryDB, writer database.Writer, opts ...grpc.ServerOption) *grpc.Server {
+	s := grpc.NewServer(opts...)
+	usersv1.RegisterUserServiceServer(s, &server{db: db, writer: writer})
+
+	return s
+}
+
+// ReadOnly rejects the calls changing users, for followers which only
+// change through replication.
+func ReadOnly() grpc.ServerOption {
+	return grpc.ChainUnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		switch info.FullMethod {
+		case usersv1.UserService_Create_FullMethodName,
+			usersv1.UserService_Update_FullMethodName,
+			usersv1.UserService_Delete_FullMethodName:
+			return nil, status.Error(codes.FailedPrecondition, api.ErrReadOnlyFollower.Error())
+		}
+
+		return handler(ctx, req)
+	})
+}
+
+// SyncReads syncs reader before the Get and List calls, e.g. a cluster
+// node making the reads of its database linearizable.
+func SyncReads(reader database.Reader) []grpc.ServerOption {
+	sync := func(ctx context.Context) error {
+		err := reader.Sync(ctx)
+		if errors.Is(err, database.ErrUnavailable) {
+			return status.Error(codes.Unavailable, api.ErrReadsUnavailable.Error())
+		}
+		if err != nil {
+			return toStatus(err)
+		}
+
+		return nil
+	}
+
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			if info.FullMethod == usersv1.UserService_Get_FullMethodName {
+				if err := sync(ctx); err != nil {
+					return nil, err
+				}
+			}
+
+			return handler(ctx, req)
+		}),
+		grpc.ChainStreamInterceptor(func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if info.FullMethod == usersv1.UserService_List_FullMethodName {
+				if err := sync(stream.Context()); err != nil {
+					return err
+				}
+			}
+
+			return handler(srv, stream)
+		}),
+	}
+}
+
+func (s *server) Create(ctx context.Context, req *usersv1.CreateRequest) (*usersv1.DBUser, error) {
+	user, err := fromProto(ctx, req.GetUser())
+	if err != nil {
+		return nil, err
+	}
+
+	dbUser, err := s.writer.Insert(ctx, user)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return toProto(dbUser), nil
+}
+
+func (s *server) Get(ctx context.Context, req *usersv1.GetRequest) (*usersv1.DBUser, error) {
+	if _, err := database.ParseID(req.GetId()); err != nil {
+		return nil, toStatus(err)
+	}
+
+	user, exists := s.db.FindByID(ctx, req.GetId())
+	if !exists {
+		return nil, toStatus(database.ErrUserDoesNotExist)
+	}
+
+	return toProto(user), nil
+}
+
+func (s *server) List(req *usersv1.ListRequest, stream grpc.ServerStreamingServer[usersv1.DBUser]) error {
+	filter := database.UserFilter{
+		FirstName: req.GetFirstName(),
+		LastName:  req.GetLastName(),
+		Contains:  req.GetQuery(),
+	}
+
+	// a single scan of one version never holds the lock nor every user
+	var sendErr error
+	err := s.db.Scan(stream.Context(), database.ListOptions{Filter: filter.Match}, func(user database.DBUser, _ database.Cursor) bool {
+		sendErr = stream.Send(toProto(user))
+		return sendErr == nil
+	})
+	if err != nil {
+		return toStatus(err)
+	}
+
+	return sendErr
+}
+
+func (s *server) Update(ctx context.Context, req *usersv1.UpdateRequest) (*usersv1.DBUser, error) {
+	if _, err := database.ParseID(req.GetId()); err != nil {
+		return nil, toStatus(err)
+	}
+
+	user, err := fromProto(ctx, req.GetUser())
+	if err != nil {
+		return nil, err
+	}
+
+	dbUser, err := s.writer.Update(ctx, req.GetId(), user)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return toProto(dbUser), nil
+}
+
+func (s *server) Delete(ctx context.Context, req *usersv1.DeleteRequest) (*usersv1.DBUser, error) {
+	if _, err := database.ParseID(req.GetId()); err != nil {
+		return nil, toStatus(err)
+	}
+
+	user, err := s.writer.Delete(ctx, req.GetId())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return toProto(user), nil
+}
+
+func (s *server) Watch(req *usersv1.WatchRequest, stream grpc.ServerStreamingServer[usersv1.Change]) error {
+	changes, cancel := s.db.Subscribe()
+	defer cancel()
+
+	// the client knows the subscription is active once the headers arrive
+	if err := stream.SendHeader(nil); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case change, ok := <-changes:
+			if !ok {
+				return status.Error(codes.Unavailable, "the watcher fell behind, please watch again")
+			}
+
+			// the proto only describes changes of users
+			if change.Follow != nil {
+				continue
+			}
+
+			if err := stream.Send(changeToProto(change)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// fromProto converts and validates a user with the rules of the REST API.
+func fromProto(ctx context.Context, user *usersv1.User) (database.User, error) {
+	converted := database.User{
+		FirstName: user.GetFirstName(),
+		LastName:  user.GetLastName(),
+		Biography: user.GetBiography(),
+	}
+
+	if err := validate.StructCtx(ctx, &converted); err != nil {
+		return database.User{}, status.Error(codes.InvalidArgument, api.ErrInvalidUserParams.Error())
+	}
+
+	return converted, nil
+}
+
+func toProto(user database.DBUser) *usersv1.DBUser {
+	return &usersv1.DBUser{
+		Id: user.ID.String(),
+		User: &usersv1.User{
+			FirstName: user.User.FirstName,
+			LastName:  user.User.LastName,
+			Biography: user.User.Biography,
+		},
+	}
+}
+
+var changeTypes = map[database.ChangeType]usersv1.ChangeType{
+	database.ChangeCreated: usersv1.ChangeType_CHANGE_TYPE_CREATED,
+	database.ChangeUpdated: usersv1.ChangeType_CHANGE_TYPE_UPDATED,
+	database.ChangeDeleted: usersv1.ChangeType_CHANGE_TYPE_DELETED,
+	database.ChangeEvicted: usersv1.ChangeType_CHANGE_TYPE_EVICTED,
+}
+
+func changeToProto(change database.Change) *usersv1.Change {
+	return &usersv1.Change{
+		Seq:  change.Seq,
+		Type: changeTypes[change.Type],
+		User: toProto(change.User),
+		Time: timestamppb.New(change.Time),
+	}
+}
+
+// toStatus maps database errors to gRPC status codes, with the messages
+// of the REST API.
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, database.ErrUserDoesNotExist):
+		return status.Error(codes.NotFound, api.ErrUserNotFound.Error())
+	case errors.Is(err, database.ErrInvalidID):
+		return status.Error(codes.InvalidArgument, api.ErrInvalidUserID.Error())
+	case errors.Is(err, database.ErrUserAlreadyExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, database.ErrStorageFull):
+		return status.Error(codes.ResourceExhausted, api.ErrInsufficientStorage.Error())
+	case errors.Is(err, database.ErrInvalidCursor):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, database.ErrUserHasFollows):
+		return status.Error(codes.FailedPrecondition, api.ErrUserHasFollows.Error())
+	case errors.Is(err, database.ErrUnavailable):
+		return status.Error(codes.Unavailable, api.ErrWritesUnavailable.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	default:
+		slog.Error("unexpected database error", "error", err)
+		return status.Error(codes.Internal, "internal server error")
+	}
+}
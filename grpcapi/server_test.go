@@ -0,0 +1,229 @@
+package grpcapi
+
+import (
+	"context"
+	"io"
+	"main/database"
+	usersv1 "main/proto/users/v1"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+var user = &usersv1.User{
+	FirstName: "John",
+	LastName:  "Doe",
+	Biography: "A simple guy who loves to write code and play games.",
+}
+
+func newClient(t testing.TB, opts ...grpc.ServerOption) (usersv1.UserServiceClient, *database.InMemoryDB) {
+	t.Helper()
+
+	db := database.NewInMemoryDB()
+	return dial(t, db, opts...), db
+}
+
+// dial serves db and returns a client of the server.
+func dial(t testing.TB, db *database.InMemoryDB, opts ...grpc.ServerOption) usersv1.UserServiceClient {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+
+	server := NewServer(db, opts...)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return usersv1.NewUserServiceClient(conn)
+}
+
+func assertCode(t testing.TB, want codes.Code, err error) {
+	t.Helper()
+
+	if got := status.Code(err); got != want {
+		t.Fatalf("expected the code to be %v, got %v (%v)", want, got, err)
+	}
+}
+
+func TestUserService(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("create, get, update and delete a user", func(t *testing.T) {
+		client, db := newClient(t)
+
+		created, err := client.Create(ctx, &usersv1.CreateRequest{User: user})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, exists := db.FindByID(ctx, created.GetId()); !exists {
+			t.Fatalf("expected %s to be stored", created.GetId())
+		}
+
+		got, err := client.Get(ctx, &usersv1.GetRequest{Id: created.GetId()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.GetUser().GetFirstName() != user.GetFirstName() {
+			t.Fatalf("expected the user to be %v, got %v", user, got.GetUser())
+		}
+
+		updated, err := client.Update(ctx, &usersv1.UpdateRequest{
+			Id:   created.GetId(),
+			User: &usersv1.User{FirstName: "Jack", LastName: "Doe", Biography: user.GetBiography()},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if updated.GetUser().GetFirstName() != "Jack" {
+			t.Fatalf("expected the first name to be %q, got %q", "Jack", updated.GetUser().GetFirstName())
+		}
+
+		if _, err := client.Delete(ctx, &usersv1.DeleteRequest{Id: created.GetId()}); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = client.Get(ctx, &usersv1.GetRequest{Id: created.GetId()})
+		assertCode(t, codes.NotFound, err)
+	})
+
+	t.Run("map errors to status codes", func(t *testing.T) {
+		client, _ := newClient(t)
+		missing := database.ID{}.NewID().String()
+
+		_, err := client.Create(ctx, &usersv1.CreateRequest{User: &usersv1.User{FirstName: "J"}})
+		assertCode(t, codes.InvalidArgument, err)
+
+		_, err = client.Create(ctx, &usersv1.CreateRequest{})
+		assertCode(t, codes.InvalidArgument, err)
+
+		_, err = client.Get(ctx, &usersv1.GetRequest{Id: "not-an-id"})
+		assertCode(t, codes.InvalidArgument, err)
+
+		_, err = client.Update(ctx, &usersv1.UpdateRequest{Id: missing, User: user})
+		assertCode(t, codes.NotFound, err)
+
+		_, err = client.Delete(ctx, &usersv1.DeleteRequest{Id: missing})
+		assertCode(t, codes.NotFound, err)
+	})
+
+	t.Run("refuse deleting followed users when restricted", func(t *testing.T) {
+		db := database.NewInMemoryDB(database.WithDeletePolicy(database.DeleteRestrict))
+		client := dial(t, db)
+		john, _ := db.Insert(ctx, database.User{FirstName: "John"})
+		jane, _ := db.Insert(ctx, database.User{FirstName: "Jane"})
+		db.Follow(ctx, john.ID.String(), jane.ID.String())
+
+		_, err := client.Delete(ctx, &usersv1.DeleteRequest{Id: jane.ID.String()})
+		assertCode(t, codes.FailedPrecondition, err)
+	})
+
+	t.Run("reject writes when read-only", func(t *testing.T) {
+		client, db := newClient(t, ReadOnly())
+
+		_, err := client.Create(ctx, &usersv1.CreateRequest{User: user})
+		assertCode(t, codes.FailedPrecondition, err)
+
+		stored, err := db.Insert(ctx, database.User{FirstName: "John", LastName: "Doe", Biography: "bio"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = client.Delete(ctx, &usersv1.DeleteRequest{Id: stored.ID.String()})
+		assertCode(t, codes.FailedPrecondition, err)
+
+		if _, err := client.Get(ctx, &usersv1.GetRequest{Id: stored.ID.String()}); err != nil {
+			t.Fatalf("expected reads to be served, got %v", err)
+		}
+	})
+
+	t.Run("list streams matching users", func(t *testing.T) {
+		client, db := newClient(t)
+		const stored = 510
+		for i := 0; i < stored; i++ {
+			u := database.User{FirstName: "John", LastName: "Doe", Biography: user.GetBiography()}
+			if i%2 == 0 {
+				u.FirstName = "Jane"
+			}
+			if _, err := db.Insert(ctx, u); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		count := func(req *usersv1.ListRequest) int {
+			stream, err := client.List(ctx, req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var n int
+			for {
+				_, err := stream.Recv()
+				if err == io.EOF {
+					return n
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				n++
+			}
+		}
+
+		if n := count(&usersv1.ListRequest{}); n != stored {
+			t.Fatalf("expected %d users, got %d", stored, n)
+		}
+		if n := count(&usersv1.ListRequest{FirstName: "jane"}); n != (stored)/2 {
+			t.Fatalf("expected %d users, got %d", (stored)/2, n)
+		}
+	})
+
+	t.Run("watch changes", func(t *testing.T) {
+		client, db := newClient(t)
+
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		stream, err := client.Watch(ctx, &usersv1.WatchRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the headers are sent once the subscription is active
+		if _, err := stream.Header(); err != nil {
+			t.Fatal(err)
+		}
+
+		created, err := db.Insert(ctx, database.User{FirstName: "John", LastName: "Doe", Biography: user.GetBiography()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Delete(ctx, created.ID.String()); err != nil {
+			t.Fatal(err)
+		}
+
+		want := []usersv1.ChangeType{usersv1.ChangeType_CHANGE_TYPE_CREATED, usersv1.ChangeType_CHANGE_TYPE_DELETED}
+		for i, changeType := range want {
+			change, err := stream.Recv()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if change.GetType() != changeType || change.GetUser().GetId() != created.ID.String() || change.GetSeq() != uint64(i+1) {
+				t.Fatalf("expected change %d to be %v of %s, got %v", i+1, changeType, created.ID, change)
+			}
+		}
+	})
+}
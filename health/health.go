@@ -0,0 +1,178 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrShuttingDown = errors.New("the service is shutting down")
+
+type Status string
+
+const (
+	StatusOK      Status = "ok"
+	StatusFailing Status = "failing"
+)
+
+// Check reports whether a component is healthy. It should return promptly
+// once ctx is done.
+type Check func(ctx context.Context) error
+
+type ComponentStatus struct {
+	Status Status `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type Report struct {
+	Status     Status                     `json:"status"`
+	Components map[string]ComponentStatus `json:"components,omitempty"`
+}
+
+type Registry struct {
+	timeout time.Duration
+
+	mu           sync.RWMutex
+	liveness     map[string]Check
+	readiness    map[string]Check
+	notReady     map[string]string
+	shuttingDown bool
+}
+
+// NewRegistry returns an empty registry that gives every check at most
+// timeout to complete.
+func NewRegistry(timeout time.Duration) *Registry {
+	return &Registry{
+		timeout:   timeout,
+		liveness:  make(map[string]Check),
+		readiness: make(map[string]Check),
+		notReady:  make(map[string]string),
+	}
+}
+
+// RegisterLiveness adds a check that makes the process unhealthy when it
+// fails, meaning it should be restarted.
+func (r *Registry) RegisterLiveness(name string, check Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.liveness[name] = check
+}
+
+// RegisterReadiness adds a check that takes the process out of rotation
+// while it fails.
+func (r *Registry) RegisterReadiness(name string, check Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.readiness[name] = check
+}
+
+// SetNotReady marks a component as not ready, e.g. while it replays state
+// on startup, until SetReady is called with the same name.
+func (r *Registry) SetNotReady(name string, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.notReady[name] = reason
+}
+
+func (r *Registry) SetReady(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.notReady, name)
+}
+
+// Shutdown makes readiness fail so traffic is drained before the server
+// stops accepting connections. Liveness is not affected.
+func (r *Registry) Shutdown() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.shuttingDown = true
+}
+
+func (r *Registry) Liveness(ctx context.Context) Report {
+	r.mu.RLock()
+	checks := copyChecks(r.liveness)
+	r.mu.RUnlock()
+
+	return r.run(ctx, checks, nil)
+}
+
+func (r *Registry) Readiness(ctx context.Context) Report {
+	r.mu.RLock()
+	checks := copyChecks(r.readiness)
+
+	fixed := make(map[string]ComponentStatus, len(r.notReady)+1)
+	for name, reason := range r.notReady {
+		fixed[name] = ComponentStatus{Status: StatusFailing, Error: reason}
+	}
+	if r.shuttingDown {
+		fixed["server"] = ComponentStatus{Status: StatusFailing, Error: ErrShuttingDown.Error()}
+	}
+	r.mu.RUnlock()
+
+	return r.run(ctx, checks, fixed)
+}
+
+func (r *Registry) run(ctx context.Context, checks map[string]Check, fixed map[string]ComponentStatus) Report {
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
+	report := Report{
+		Status:     StatusOK,
+		Components: make(map[string]ComponentStatus, len(checks)+len(fixed)),
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+
+	for name, check := range checks {
+		wg.Add(1)
+
+		go func(name string, check Check) {
+			defer wg.Done()
+
+			status := ComponentStatus{Status: StatusOK}
+			if err := check(ctx); err != nil {
+				status = ComponentStatus{Status: StatusFailing, Error: err.Error()}
+			}
+
+			mu.Lock()
+			report.Components[name] = status
+			mu.Unlock()
+		}(name, check)
+	}
+
+	wg.Wait()
+
+	for name, status := range fixed {
+		report.Components[name] = status
+	}
+
+	for _, status := range report.Components {
+		if status.Status != StatusOK {
+			report.Status = StatusFailing
+			break
+		}
+	}
+
+	return report
+}
+
+func copyChecks(checks map[string]Check) map[string]Check {
+	copied := make(map[string]Check, len(checks))
+	for name, check := range checks {
+		copied[name] = check
+	}
+
+	return copied
+}
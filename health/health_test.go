@@ -0,0 +1,84 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	healthy := func(context.Context) error { return nil }
+	failing := func(context.Context) error { return errors.New("boom") }
+
+	t.Run("ready when every check passes", func(t *testing.T) {
+		registry := NewRegistry(time.Second)
+		registry.RegisterReadiness("database", healthy)
+		registry.RegisterReadiness("worker", healthy)
+
+		report := registry.Readiness(context.Background())
+
+		assertStatus(t, StatusOK, report.Status)
+		if len(report.Components) != 2 {
+			t.Fatalf("expected %d components, got %d", 2, len(report.Components))
+		}
+	})
+
+	t.Run("not ready when a check fails", func(t *testing.T) {
+		registry := NewRegistry(time.Second)
+		registry.RegisterReadiness("database", healthy)
+		registry.RegisterReadiness("worker", failing)
+
+		report := registry.Readiness(context.Background())
+
+		assertStatus(t, StatusFailing, report.Status)
+		assertStatus(t, StatusOK, report.Components["database"].Status)
+		assertStatus(t, StatusFailing, report.Components["worker"].Status)
+
+		if report.Components["worker"].Error != "boom" {
+			t.Errorf("expected the error to be %q, got %q", "boom", report.Components["worker"].Error)
+		}
+	})
+
+	t.Run("checks are cancelled after the timeout", func(t *testing.T) {
+		registry := NewRegistry(10 * time.Millisecond)
+		registry.RegisterReadiness("slow", func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+
+		report := registry.Readiness(context.Background())
+
+		assertStatus(t, StatusFailing, report.Status)
+	})
+
+	t.Run("not ready until the component is marked ready", func(t *testing.T) {
+		registry := NewRegistry(time.Second)
+		registry.SetNotReady("replay", "replaying the log")
+
+		assertStatus(t, StatusFailing, registry.Readiness(context.Background()).Status)
+
+		registry.SetReady("replay")
+
+		assertStatus(t, StatusOK, registry.Readiness(context.Background()).Status)
+	})
+
+	t.Run("shutdown fails readiness but not liveness", func(t *testing.T) {
+		registry := NewRegistry(time.Second)
+		registry.RegisterLiveness("process", healthy)
+		registry.RegisterReadiness("database", healthy)
+
+		registry.Shutdown()
+
+		assertStatus(t, StatusFailing, registry.Readiness(context.Background()).Status)
+		assertStatus(t, StatusOK, registry.Liveness(context.Background()).Status)
+	})
+}
+
+func assertStatus(t testing.TB, want Status, got Status) {
+	t.Helper()
+
+	if got != want {
+		t.Errorf("expected status %q, got %q", want, got)
+	}
+}
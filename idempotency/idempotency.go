@@ -0,0 +1,225 @@
+package idempotency
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type State int
+
+const (
+	// StateNew means the caller owns the key and must call Complete or
+	// Release once the request has been handled.
+	StateNew State = iota
+	// StateReplay means the key was used before with the same request and
+	// its response should be sent again.
+	StateReplay
+	// StateMismatch means the key was used before with a different request.
+	StateMismatch
+	// StateInProgress means a request with the same key is being handled.
+	StateInProgress
+)
+
+type Fingerprint [sha256.Size]byte
+
+// NewFingerprint identifies a request by its method, path and body.
+func NewFingerprint(method string, path string, body []byte) Fingerprint {
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write([]byte(path))
+	h.Write([]byte{0})
+	h.Write(body)
+
+	var fp Fingerprint
+	copy(fp[:], h.Sum(nil))
+
+	return fp
+}
+
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// size approximates the bytes a response takes.
+func (r Response) size() int64 {
+	size := int64(len(r.Body))
+	for name, values := range r.Header {
+		size += int64(len(name))
+		for _, value := range values {
+			size += int64(len(value))
+		}
+	}
+
+	return size
+}
+
+type record struct {
+	key         string
+	fingerprint Fingerprint
+	response    *Response
+	expires     time.Time
+	// elem is the place of completed records in Store.recent
+	elem *list.Element
+	size int64
+}
+
+type Store struct {
+	ttl      time.Duration
+	now      func() time.Time
+	maxKeys  int
+	maxBytes int64
+
+	mu      sync.Mutex
+	records map[string]*record
+	// recent orders the completed records from most to least recently used
+	recent    *list.List
+	bytes     int64
+	lastSweep time.Time
+}
+
+type Option func(*Store)
+
+// WithMaxKeys bounds how many responses are kept, the least recently used
+// is forgotten past it. Zero means no limit.
+func WithMaxKeys(n int) Option {
+	return func(s *Store) {
+		s.maxKeys = n
+	}
+}
+
+// WithMaxBytes bounds the bytes taken by the kept responses, the least
+// recently used are forgotten past it and larger ones are not kept at
+// all. Zero means no limit.
+func WithMaxBytes(n int64) Option {
+	return func(s *Store) {
+		s.maxBytes = n
+	}
+}
+
+// NewStore keeps responses for ttl after the request completed.
+func NewStore(ttl time.Duration, opts ...Option) *Store {
+	s := &Store{
+		ttl:     ttl,
+		now:     time.Now,
+		records: make(map[string]*record),
+		recent:  list.New(),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Begin claims key for a request with the given fingerprint. The stored
+// response is returned with StateReplay.
+func (s *Store) Begin(key string, fp Fingerprint) (State, *Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	rec, exists := s.records[key]
+	if exists && rec.response != nil && !now.Before(rec.expires) {
+		s.drop(rec)
+		exists = false
+	}
+
+	switch {
+	case !exists:
+		s.records[key] = &record{key: key, fingerprint: fp}
+		return StateNew, nil
+	case rec.fingerprint != fp:
+		return StateMismatch, nil
+	case rec.response == nil:
+		return StateInProgress, nil
+	default:
+		s.recent.MoveToFront(rec.elem)
+		return StateReplay, rec.response
+	}
+}
+
+// Complete stores the response of the request that claimed key. A
+// response larger than the byte limit is not kept, the key is released
+// instead.
+func (s *Store) Complete(key string, resp Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, exists := s.records[key]
+	if !exists || rec.response != nil {
+		return
+	}
+
+	rec.size = resp.size()
+	if s.maxBytes > 0 && rec.size > s.maxBytes {
+		delete(s.records, key)
+		return
+	}
+
+	rec.response = &resp
+	rec.expires = s.now().Add(s.ttl)
+	rec.elem = s.recent.PushFront(rec)
+	s.bytes += rec.size
+
+	for (s.maxKeys > 0 && s.recent.Len() > s.maxKeys) || (s.maxBytes > 0 && s.bytes > s.maxBytes) {
+		s.drop(s.recent.Back().Value.(*record))
+	}
+}
+
+// Release forgets key so the request can be retried, e.g. after it failed
+// with a server error.
+func (s *Store) Release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rec, exists := s.records[key]; exists && rec.response == nil {
+		delete(s.records, key)
+	}
+}
+
+// Bytes returns the bytes taken by the kept responses.
+func (s *Store) Bytes() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.bytes
+}
+
+// Len returns the number of tracked keys.
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.records)
+}
+
+// sweep drops expired records, at most once per half ttl so Begin stays
+// cheap. It must be called with the lock held.
+func (s *Store) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < s.ttl/2 {
+		return
+	}
+	s.lastSweep = now
+
+	for _, rec := range s.records {
+		if rec.response != nil && !now.Before(rec.expires) {
+			s.drop(rec)
+		}
+	}
+}
+
+// drop forgets a completed record. It must be called with the lock held.
+func (s *Store) drop(rec *record) {
+	delete(s.records, rec.key)
+	s.recent.Remove(rec.elem)
+	s.bytes -= rec.size
+}
@@ -0,0 +1,129 @@
+package idempotency
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	newStore := func(opts ...Option) (*Store, *time.Time) {
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+		store := NewStore(time.Hour, opts...)
+		store.now = func() time.Time { return now }
+
+		return store, &now
+	}
+
+	fp := NewFingerprint(http.MethodPost, "/api/users", []byte(`{"first_name":"John"}`))
+	otherFP := NewFingerprint(http.MethodPost, "/api/users", []byte(`{"first_name":"Jane"}`))
+	response := Response{Status: http.StatusCreated, Body: []byte("created")}
+
+	t.Run("replay the stored response", func(t *testing.T) {
+		store, _ := newStore()
+
+		assertState(t, StateNew, store, "key", fp)
+		assertState(t, StateInProgress, store, "key", fp)
+
+		store.Complete("key", response)
+
+		state, got := store.Begin("key", fp)
+		if state != StateReplay {
+			t.Fatalf("expected state %d, got %d", StateReplay, state)
+		}
+		if got.Status != response.Status || string(got.Body) != string(response.Body) {
+			t.Fatalf("expected the response to be %+v, got %+v", response, got)
+		}
+	})
+
+	t.Run("reject a different request", func(t *testing.T) {
+		store, _ := newStore()
+
+		store.Begin("key", fp)
+		store.Complete("key", response)
+
+		assertState(t, StateMismatch, store, "key", otherFP)
+	})
+
+	t.Run("keys expire", func(t *testing.T) {
+		store, now := newStore()
+
+		store.Begin("key", fp)
+		store.Complete("key", response)
+
+		*now = now.Add(time.Hour)
+
+		assertState(t, StateNew, store, "key", otherFP)
+	})
+
+	t.Run("expired keys are swept", func(t *testing.T) {
+		store, now := newStore()
+
+		store.Begin("first", fp)
+		store.Complete("first", response)
+
+		*now = now.Add(2 * time.Hour)
+		store.Begin("second", fp)
+
+		if store.Len() != 1 {
+			t.Fatalf("expected %d keys, got %d", 1, store.Len())
+		}
+	})
+
+	t.Run("released keys can be retried", func(t *testing.T) {
+		store, _ := newStore()
+
+		store.Begin("key", fp)
+		store.Release("key")
+
+		assertState(t, StateNew, store, "key", fp)
+	})
+
+	t.Run("forget the least recently used keys past the limit", func(t *testing.T) {
+		store, _ := newStore(WithMaxKeys(2))
+
+		for _, key := range []string{"first", "second"} {
+			store.Begin(key, fp)
+			store.Complete(key, response)
+		}
+		assertState(t, StateReplay, store, "first", fp)
+
+		store.Begin("third", fp)
+		store.Complete("third", response)
+
+		if store.Len() != 2 {
+			t.Fatalf("expected %d keys, got %d", 2, store.Len())
+		}
+		assertState(t, StateReplay, store, "first", fp)
+		assertState(t, StateNew, store, "second", otherFP)
+	})
+
+	t.Run("forget responses past the byte limit", func(t *testing.T) {
+		size := response.size()
+		store, _ := newStore(WithMaxBytes(2 * size))
+
+		for _, key := range []string{"first", "second", "third"} {
+			store.Begin(key, fp)
+			store.Complete(key, response)
+		}
+
+		if store.Bytes() != 2*size {
+			t.Fatalf("expected %d bytes, got %d", 2*size, store.Bytes())
+		}
+		assertState(t, StateNew, store, "first", otherFP)
+
+		// a response larger than the limit releases its key
+		store.Begin("large", fp)
+		store.Complete("large", Response{Status: http.StatusCreated, Body: make([]byte, 3*size)})
+		assertState(t, StateNew, store, "large", otherFP)
+	})
+}
+
+func assertState(t testing.TB, want State, store *Store, key string, fp Fingerprint) {
+	t.Helper()
+
+	if got, _ := store.Begin(key, fp); got != want {
+		t.Fatalf("expected state %d, got %d", want, got)
+	}
+}
@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+type AccessLogOptions struct {
+	// Level is used for successful requests, client errors are logged as
+	// warnings and server errors as errors regardless of it.
+	Level slog.Level
+	// SuccessSampleRate is the fraction of successful requests that are
+	// logged, failed requests are always logged.
+	SuccessSampleRate float64
+}
+
+type requestInfoKey struct{}
+
+type requestInfo struct {
+	principal string
+}
+
+// SetPrincipal records who made the request so the access log can report
+// it. It is a no-op outside of AccessLog.
+func SetPrincipal(ctx context.Context, principal string) {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		info.principal = principal
+	}
+}
+
+// AccessLog logs one structured entry per request. It must run after
+// middleware.RequestID.
+func AccessLog(logger *slog.Logger, opts AccessLogOptions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			info := &requestInfo{}
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)))
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			level := opts.Level
+			switch {
+			case status >= http.StatusInternalServerError:
+				level = slog.LevelError
+			case status >= http.StatusBadRequest:
+				level = slog.LevelWarn
+			case opts.SuccessSampleRate < 1 && rand.Float64() >= opts.SuccessSampleRate:
+				return
+			}
+
+			route := r.URL.Path
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				route = rctx.RoutePattern()
+			}
+
+			logger.LogAttrs(
+				r.Context(),
+				level,
+				"request completed",
+				slog.String("method", r.Method),
+				slog.String("route", route),
+				slog.Int("status", status),
+				slog.Int("bytes", ww.BytesWritten()),
+				slog.Duration("duration", time.Since(start)),
+				slog.String("request_id", middleware.GetReqID(r.Context())),
+				slog.String("remote_addr", r.RemoteAddr),
+				slog.String("principal", info.principal),
+			)
+		})
+	}
+}
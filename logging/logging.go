@@ -0,0 +1,46 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+type Options struct {
+	Level  slog.Level
+	Format string
+	// RedactedKeys are matched case-insensitively against attribute keys at
+	// any nesting level.
+	RedactedKeys []string
+}
+
+// NewLogger returns a logger writing JSON, or text when Format is "text",
+// that replaces the value of every redacted key.
+func NewLogger(w io.Writer, opts Options) (*slog.Logger, error) {
+	redact := make(map[string]struct{}, len(opts.RedactedKeys))
+	for _, key := range opts.RedactedKeys {
+		redact[strings.ToLower(key)] = struct{}{}
+	}
+
+	handlerOpts := &slog.HandlerOptions{
+		Level: opts.Level,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if _, ok := redact[strings.ToLower(attr.Key)]; ok {
+				return slog.String(attr.Key, redacted)
+			}
+			return attr
+		},
+	}
+
+	switch opts.Format {
+	case "json", "":
+		return slog.New(slog.NewJSONHandler(w, handlerOpts)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, handlerOpts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", opts.Format)
+	}
+}
@@ -0,0 +1,114 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"main/database"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+func TestNewLogger(t *testing.T) {
+	t.Run("redacts sensitive keys at any level", func(t *testing.T) {
+		var buf bytes.Buffer
+
+		logger, err := NewLogger(&buf, Options{RedactedKeys: []string{"Biography"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		logger.Info("created user", "user", database.User{
+			FirstName: "John",
+			LastName:  "Doe",
+			Biography: "A simple guy who loves to write code and play games.",
+		})
+
+		if strings.Contains(buf.String(), "loves to write code") {
+			t.Fatalf("expected the biography to be redacted, got %s", buf.String())
+		}
+
+		if !strings.Contains(buf.String(), `"first_name":"John"`) {
+			t.Fatalf("expected the first name to be logged, got %s", buf.String())
+		}
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		if _, err := NewLogger(&bytes.Buffer{}, Options{Format: "xml"}); err == nil {
+			t.Fatalf("expected an error for an unknown format")
+		}
+	})
+}
+
+func TestAccessLog(t *testing.T) {
+	newRouter := func(buf *bytes.Buffer, opts AccessLogOptions) http.Handler {
+		logger, err := NewLogger(buf, Options{Level: slog.LevelDebug})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		router := chi.NewRouter()
+		router.Use(middleware.RequestID)
+		router.Use(AccessLog(logger, opts))
+		router.Get("/api/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+			SetPrincipal(r.Context(), "key-1")
+			w.Write([]byte("hello"))
+		})
+		router.Get("/broken", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		})
+
+		return router
+	}
+
+	t.Run("logs the request", func(t *testing.T) {
+		var buf bytes.Buffer
+		router := newRouter(&buf, AccessLogOptions{Level: slog.LevelInfo, SuccessSampleRate: 1})
+
+		req := httptest.NewRequest(http.MethodGet, "/api/users/42", nil)
+		req.Header.Set(middleware.RequestIDHeader, "request-1")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+
+		var entry map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+			t.Fatalf("could not decode the log entry: %v", err)
+		}
+
+		want := map[string]any{
+			"level":      "INFO",
+			"method":     "GET",
+			"route":      "/api/users/{id}",
+			"status":     float64(200),
+			"bytes":      float64(5),
+			"request_id": "request-1",
+			"principal":  "key-1",
+		}
+		for key, value := range want {
+			if entry[key] != value {
+				t.Errorf("expected %s to be %v, got %v", key, value, entry[key])
+			}
+		}
+	})
+
+	t.Run("sampling skips successful requests only", func(t *testing.T) {
+		var buf bytes.Buffer
+		router := newRouter(&buf, AccessLogOptions{Level: slog.LevelInfo, SuccessSampleRate: 0})
+
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/users/42", nil))
+
+		if buf.Len() != 0 {
+			t.Fatalf("expected the successful request not to be logged, got %s", buf.String())
+		}
+
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/broken", nil))
+
+		if !strings.Contains(buf.String(), `"level":"ERROR"`) {
+			t.Fatalf("expected the failed request to be logged as an error, got %s", buf.String())
+		}
+	})
+}
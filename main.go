@@ -1,43 +1,325 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log/slog"
 	"main/api"
+	"main/cluster"
+	"main/config"
 	"main/database"
+	"main/graphqlapi"
+	"main/grpcapi"
+	"main/health"
+	"main/idempotency"
+	"main/logging"
+	"main/metrics"
+	"main/ratelimit"
+	"main/replication"
+	"main/rpcapi"
+	"main/tracing"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/hashicorp/raft"
+	"google.golang.org/grpc"
+
 	_ "main/docs"
 )
 
 //	@title			In Memory CRUD
 //	@version		1.0
-//	@description	A simple CRUD API for managing users in memory
+//	@description	A simple CRUD API for managing users in memory.
+//	@description	IDs are UUID strings in paths, XML and CSV, and arrays of their 16 bytes in JSON, which also accepts UUID strings.
 
 // @host		localhost:8080
 // @BasePath	/api
 func main() {
-	if err := run(); err != nil {
+	cfg, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		slog.Error("failed to load the configuration", "error", err)
+		os.Exit(2)
+	}
+
+	logger, err := logging.NewLogger(os.Stderr, logging.Options{
+		Level:        cfg.Log.Level,
+		Format:       cfg.Log.Format,
+		RedactedKeys: cfg.Log.RedactedKeys,
+	})
+	if err != nil {
+		slog.Error("failed to create the logger", "error", err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
+
+	if err := run(cfg); err != nil {
 		slog.Error("failed to run the code", "error", err)
+		os.Exit(1)
 	}
 	slog.Info("code ran successfully")
 }
 
-func run() error {
-	db := database.NewInMemoryDB()
-	handler := api.NewHandler(db)
+func run(cfg config.Config) error {
+	slog.Info("effective configuration", "config", cfg)
+
+	shutdownTracing, err := tracing.Setup(tracing.Config{
+		Exporter:    cfg.Tracing.Exporter,
+		File:        cfg.Tracing.File,
+		SampleRatio: cfg.Tracing.SampleRatio,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			slog.Error("could not flush the traces", "error", err)
+		}
+	}()
+
+	m := metrics.New()
+
+	policy, err := database.ParseEvictionPolicy(cfg.Database.EvictionPolicy)
+	if err != nil {
+		return err
+	}
+
+	deletePolicy, err := database.ParseDeletePolicy(cfg.Database.DeletePolicy)
+	if err != nil {
+		return err
+	}
+
+	dbOpts := []database.Option{
+		database.WithObserver(m),
+		database.WithMaxUsers(cfg.Database.MaxUsers),
+		database.WithMaxBytes(cfg.Database.MaxBytes),
+		database.WithEvictionPolicy(policy),
+		database.WithDeletePolicy(deletePolicy),
+		database.WithEvictionHandler(func(user database.DBUser) {
+			slog.Info("evicted user", "id", user.ID, "policy", policy)
+		}),
+	}
+	if cfg.Replication.Role == "leader" {
+		dbOpts = append(dbOpts, database.WithChangeLog(cfg.Replication.LogSize))
+	}
+
+	db := database.NewInMemoryDB(dbOpts...)
+	m.RegisterStore(db)
+
+	registry := health.NewRegistry(cfg.Health.CheckTimeout)
+	registry.RegisterReadiness("database", db.Check)
+
+	var (
+		writer database.Writer = db
+		reader database.Reader
+		admin  http.Handler
+	)
+	if cfg.Cluster.Enabled {
+		node, err := startCluster(cfg.Cluster, db, registry)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := node.Shutdown(); err != nil {
+				slog.Error("could not stop the cluster node", "error", err)
+			}
+		}()
+
+		registry.RegisterReadiness("cluster", node.Check)
+		writer = node
+		reader = node
+		admin = node.Handler()
+	}
+
+	// the change streams never end on their own, they are closed when the
+	// server shuts down so it does not wait for them
+	shutdown := make(chan struct{})
+
+	opts := []api.Option{
+		api.WithHealth(registry),
+		api.WithMetrics(m),
+		api.WithIdempotency(idempotency.NewStore(
+			cfg.Idempotency.KeyTTL,
+			idempotency.WithMaxKeys(cfg.Idempotency.MaxKeys),
+			idempotency.WithMaxBytes(cfg.Idempotency.MaxBytes),
+		)),
+		api.WithUpsert(cfg.API.AllowUpsert),
+		api.WithRequestTimeout(cfg.Middleware.RequestTimeout),
+		api.WithRequestLogging(cfg.Middleware.LogRequests),
+		api.WithCompression(cfg.Middleware.CompressionMinSize),
+		api.WithWriter(writer),
+		api.WithReader(reader),
+		api.WithCluster(admin),
+		api.WithShutdown(shutdown),
+		api.WithAccessLog(slog.Default(), logging.AccessLogOptions{
+			Level:             cfg.Middleware.AccessLogLevel,
+			SuccessSampleRate: cfg.Middleware.AccessLogSampleRate,
+		}),
+	}
+
+	var (
+		follower       *replication.Follower
+		grpcOptions    []grpc.ServerOption
+		graphqlOptions = []graphqlapi.Option{graphqlapi.WithWriter(writer), graphqlapi.WithReader(reader), graphqlapi.WithShutdown(shutdown)}
+		rpcOptions     = []rpcapi.Option{rpcapi.WithWriter(writer), rpcapi.WithReader(reader)}
+	)
+	if reader != nil {
+		grpcOptions = append(grpcOptions, grpcapi.SyncReads(reader)...)
+	}
+	switch cfg.Replication.Role {
+	case "leader":
+		opts = append(opts, api.WithReplication(replication.NewLeader(db, replication.WithShutdown(shutdown))))
+	case "follower":
+		leader, err := url.Parse(cfg.Replication.Leader)
+		if err != nil {
+			return err
+		}
+
+		follower = replication.NewFollower(db, cfg.Replication.Leader, replication.WithHealth(registry))
+		registry.RegisterReadiness("replication", follower.Check)
+		opts = append(opts, api.WithFollower(leader, cfg.Replication.ForwardWrites))
+		grpcOptions = append(grpcOptions, grpcapi.ReadOnly())
+		graphqlOptions = append(graphqlOptions, graphqlapi.WithFollower(leader, cfg.Replication.ForwardWrites))
+		rpcOptions = append(rpcOptions, rpcapi.WithFollower(leader, cfg.Replication.ForwardWrites))
+	}
+	opts = append(opts,
+		api.WithGraphQL(graphqlapi.NewHandler(db, graphqlOptions...)),
+		api.WithRPC(rpcapi.NewHandler(db, rpcOptions...)),
+	)
+
+	if cfg.RateLimit.Enabled {
+		opts = append(opts,
+			api.WithRateLimit(ratelimit.New(ratelimit.Config{
+				Read:           ratelimit.Limit{Rate: cfg.RateLimit.ReadRate, Burst: cfg.RateLimit.ReadBurst},
+				Write:          ratelimit.Limit{Rate: cfg.RateLimit.WriteRate, Burst: cfg.RateLimit.WriteBurst},
+				MaxClients:     cfg.RateLimit.MaxClients,
+				MaxKeysPerAddr: cfg.RateLimit.MaxKeysPerIP,
+			})),
+			api.WithRateLimitClients(cfg.RateLimit.APIKeys, cfg.RateLimit.Tenants),
+		)
+	}
+
+	handler := api.NewHandler(db, opts...)
 
 	server := http.Server{
-		Addr:         ":8080",
+		Addr:         cfg.Server.Addr,
 		Handler:      handler,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  time.Minute,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
 	}
+	server.RegisterOnShutdown(func() { close(shutdown) })
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	if err := server.ListenAndServe(); err != nil {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.ListenAndServe()
+	}()
+
+	if follower != nil {
+		go follower.Run(ctx)
+	}
+
+	var grpcServer *grpc.Server
+	grpcErrCh := make(chan error, 1)
+	if cfg.Server.GRPCAddr != "" {
+		listener, err := net.Listen("tcp", cfg.Server.GRPCAddr)
+		if err != nil {
+			return err
+		}
+
+		grpcServer = grpcapi.NewServerWithWriter(db, writer, grpcOptions...)
+		go func() {
+			grpcErrCh <- grpcServer.Serve(listener)
+		}()
+	}
+
+	select {
+	case err := <-errCh:
+		return err
+	case err := <-grpcErrCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down the server")
+
+	// fail readiness first so the orchestrator stops routing new traffic
+	// while in-flight requests are still being served
+	registry.Shutdown()
+	time.Sleep(cfg.Health.ShutdownDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	if grpcServer != nil {
+		go func() {
+			// watchers never finish on their own, so stop them at the deadline
+			<-shutdownCtx.Done()
+			grpcServer.Stop()
+		}()
+	}
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+
+	if grpcServer != nil {
+		grpcServer.GracefulStop()
+	}
+
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
 
 	return nil
 }
+
+// startCluster makes db the copy of a member of the cluster cfg describes.
+// Every member bootstraps the cluster with the same peers, which only the
+// first start of a member does.
+func startCluster(cfg config.Cluster, db *database.InMemoryDB, registry *health.Registry) (*cluster.Node, error) {
+	members, err := cfg.Members()
+	if err != nil {
+		return nil, err
+	}
+
+	advertise, err := net.ResolveTCPAddr("tcp", members[cfg.NodeID])
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve the cluster address: %w", err)
+	}
+
+	transport, err := raft.NewTCPTransport(cfg.Addr, advertise, 3, 10*time.Second, os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("could not listen for the cluster: %w", err)
+	}
+
+	node, err := cluster.NewNode(cfg.NodeID, transport, db, cluster.WithHealth(registry))
+	if err != nil {
+		return nil, err
+	}
+
+	servers := make([]raft.Server, 0, len(members))
+	for id, addr := range members {
+		servers = append(servers, raft.Server{ID: raft.ServerID(id), Address: raft.ServerAddress(addr)})
+	}
+	if err := node.Bootstrap(servers...); err != nil && !errors.Is(err, raft.ErrCantBootstrap) {
+		node.Shutdown()
+		return nil, fmt.Errorf("could not bootstrap the cluster: %w", err)
+	}
+
+	slog.Info("joined the cluster", "node_id", cfg.NodeID, "members", len(members))
+
+	return node, nil
+}
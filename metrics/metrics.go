@@ -0,0 +1,146 @@
+package metrics
+
+import (
+	"main/database"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "users"
+
+// unmatchedRoute labels requests that did not match any route, so scanners
+// probing random paths cannot blow up the label cardinality.
+const unmatchedRoute = "unmatched"
+
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	operations      *prometheus.CounterVec
+	lockWait        *prometheus.HistogramVec
+	evictions       *prometheus.CounterVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "Number of HTTP requests by method, route pattern and status class.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of HTTP requests by method and route pattern.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "store",
+			Name:      "operations_total",
+			Help:      "Number of store operations by type.",
+		}, []string{"operation"}),
+		lockWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "store",
+			Name:      "lock_wait_seconds",
+			Help:      "Time store operations waited to acquire the lock.",
+			Buckets:   []float64{.00001, .00005, .0001, .0005, .001, .005, .01, .05, .1, .5, 1},
+		}, []string{"operation"}),
+		evictions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "store",
+			Name:      "evictions_total",
+			Help:      "Number of users evicted to stay within the store limits.",
+		}, []string{"policy"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.operations,
+		m.lockWait,
+		m.evictions,
+	)
+
+	return m
+}
+
+// ObserveOperation implements database.Observer.
+func (m *Metrics) ObserveOperation(op database.Operation, lockWait time.Duration) {
+	m.operations.WithLabelValues(string(op)).Inc()
+	m.lockWait.WithLabelValues(string(op)).Observe(lockWait.Seconds())
+}
+
+// ObserveEviction implements database.Observer.
+func (m *Metrics) ObserveEviction(policy database.EvictionPolicy) {
+	m.evictions.WithLabelValues(string(policy)).Inc()
+}
+
+// RegisterStore exports the number of users held by db and their size.
+func (m *Metrics) RegisterStore(db *database.InMemoryDB) {
+	m.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "store",
+			Name:      "users",
+			Help:      "Number of users currently stored.",
+		}, func() float64 {
+			return float64(db.Count())
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "store",
+			Name:      "bytes",
+			Help:      "Estimated memory taken by the stored users.",
+		}, func() float64 {
+			return float64(db.Bytes())
+		}),
+	)
+}
+
+// Middleware records every request under its chi route pattern rather than
+// the raw path, so /api/users/{id} is a single series.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		route := unmatchedRoute
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		m.requests.WithLabelValues(r.Method, route, statusClass(ww.Status())).Inc()
+		m.requestDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+func statusClass(status int) string {
+	// handlers that never call WriteHeader implicitly respond with 200
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	return strconv.Itoa(status/100) + "xx"
+}
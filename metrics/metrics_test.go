@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"main/database"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Run("requests are labelled by route pattern", func(t *testing.T) {
+		m := New()
+
+		router := chi.NewRouter()
+		router.Use(m.Middleware)
+		router.Get("/api/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		})
+
+		for _, id := range []string{"1", "2", "3"} {
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/users/"+id, nil))
+		}
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/nope", nil))
+
+		body := scrape(t, m)
+
+		assertContains(t, body, `users_http_requests_total{method="GET",route="/api/users/{id}",status="4xx"} 3`)
+		assertContains(t, body, `users_http_requests_total{method="GET",route="unmatched",status="4xx"} 1`)
+		assertContains(t, body, `users_http_request_duration_seconds_count{method="GET",route="/api/users/{id}"} 3`)
+	})
+
+	t.Run("store operations and size", func(t *testing.T) {
+		m := New()
+		db := database.NewInMemoryDB(database.WithObserver(m))
+		m.RegisterStore(db)
+
+		user, err := db.Insert(context.Background(), database.User{FirstName: "John", LastName: "Doe"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.Insert(context.Background(), database.User{FirstName: "Jane", LastName: "Doe"})
+		db.FindByID(context.Background(), user.ID.String())
+
+		body := scrape(t, m)
+
+		assertContains(t, body, `users_store_users 2`)
+		assertContains(t, body, `users_store_operations_total{operation="insert"} 2`)
+		assertContains(t, body, `users_store_operations_total{operation="find_by_id"} 1`)
+		assertContains(t, body, `users_store_lock_wait_seconds_count{operation="insert"} 2`)
+	})
+}
+
+func scrape(t testing.TB, m *Metrics) string {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body, err := io.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatalf("could not read the metrics: %v", err)
+	}
+
+	return string(body)
+}
+
+func assertContains(t testing.TB, body string, want string) {
+	t.Helper()
+
+	if !strings.Contains(body, want) {
+		t.Errorf("expected the metrics to contain %q", want)
+	}
+}
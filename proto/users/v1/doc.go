@@ -0,0 +1,4 @@
+// Package usersv1 holds the code generated from users.proto.
+package usersv1
+
+//go:generate protoc --proto_path=../../.. --go_out=../../.. --go_opt=paths=source_relative --go-grpc_out=../../.. --go-grpc_opt=paths=source_relative proto/users/v1/users.proto
@@ -0,0 +1,190 @@
+package ratelimit
+
+import (
+	"container/list"
+	"math"
+	"sync"
+	"time"
+)
+
+type Class string
+
+const (
+	ClassRead  Class = "read"
+	ClassWrite Class = "write"
+)
+
+// Limit lets a client make Burst requests at once and refills Rate
+// requests per second after that.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+type Config struct {
+	Read  Limit
+	Write Limit
+	// MaxClients bounds how many client buckets are tracked, the least
+	// recently seen client is forgotten when it is exceeded.
+	MaxClients int
+	// MaxKeysPerAddr bounds how many keys a single address can be limited
+	// under, see Limiter.Client.
+	MaxKeysPerAddr int
+}
+
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until the next request would be allowed, it
+	// is zero when Allowed is true.
+	RetryAfter time.Duration
+}
+
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+type Limiter struct {
+	cfg Config
+	now func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*list.Element
+	// recent orders the buckets from most to least recently used
+	recent *list.List
+	// sources holds the keys presented by each address, ordered from most
+	// to least recently seen in sourcesRecent
+	sources       map[string]*list.Element
+	sourcesRecent *list.List
+}
+
+type source struct {
+	addr string
+	keys map[string]struct{}
+}
+
+func New(cfg Config) *Limiter {
+	return &Limiter{
+		cfg:           cfg,
+		now:           time.Now,
+		buckets:       make(map[string]*list.Element),
+		recent:        list.New(),
+		sources:       make(map[string]*list.Element),
+		sourcesRecent: list.New(),
+	}
+}
+
+// Client returns the client to limit a request from addr under: key, or
+// addr once more than MaxKeysPerAddr keys came from it, so rotating keys
+// does not get around the limit.
+func (l *Limiter) Client(addr, key string) string {
+	if l.cfg.MaxKeysPerAddr <= 0 {
+		return key
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s := l.source(addr)
+	if _, ok := s.keys[key]; ok {
+		return key
+	}
+	if len(s.keys) >= l.cfg.MaxKeysPerAddr {
+		return addr
+	}
+	s.keys[key] = struct{}{}
+
+	return key
+}
+
+// Allow takes a token from the bucket of client for the given class of
+// request.
+func (l *Limiter) Allow(client string, class Class) Result {
+	limit := l.cfg.Read
+	if class == ClassWrite {
+		limit = l.cfg.Write
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b := l.bucket(string(class)+":"+client, limit, now)
+
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+
+	result := Result{Limit: limit.Burst}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = durationFor(1-b.tokens, limit.Rate)
+	}
+
+	result.Remaining = int(b.tokens)
+	result.Reset = durationFor(float64(limit.Burst)-b.tokens, limit.Rate)
+
+	return result
+}
+
+// Clients returns the number of tracked client buckets.
+func (l *Limiter) Clients() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.buckets)
+}
+
+func (l *Limiter) bucket(key string, limit Limit, now time.Time) *bucket {
+	if elem, ok := l.buckets[key]; ok {
+		l.recent.MoveToFront(elem)
+		return elem.Value.(*bucket)
+	}
+
+	if l.cfg.MaxClients > 0 && len(l.buckets) >= l.cfg.MaxClients {
+		oldest := l.recent.Back()
+		l.recent.Remove(oldest)
+		delete(l.buckets, oldest.Value.(*bucket).key)
+	}
+
+	b := &bucket{key: key, tokens: float64(limit.Burst), last: now}
+	l.buckets[key] = l.recent.PushFront(b)
+
+	return b
+}
+
+func (l *Limiter) source(addr string) *source {
+	if elem, ok := l.sources[addr]; ok {
+		l.sourcesRecent.MoveToFront(elem)
+		return elem.Value.(*source)
+	}
+
+	if l.cfg.MaxClients > 0 && len(l.sources) >= l.cfg.MaxClients {
+		oldest := l.sourcesRecent.Back()
+		l.sourcesRecent.Remove(oldest)
+		delete(l.sources, oldest.Value.(*source).addr)
+	}
+
+	s := &source{addr: addr, keys: make(map[string]struct{})}
+	l.sources[addr] = l.sourcesRecent.PushFront(s)
+
+	return s
+}
+
+func durationFor(tokens float64, rate float64) time.Duration {
+	if tokens <= 0 {
+		return 0
+	}
+	if rate <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+
+	return time.Duration(tokens / rate * float64(time.Second))
+}
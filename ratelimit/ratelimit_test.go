@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	newLimiter := func(cfg Config) (*Limiter, *time.Time) {
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+		limiter := New(cfg)
+		limiter.now = func() time.Time { return now }
+
+		return limiter, &now
+	}
+
+	cfg := Config{
+		Read:       Limit{Rate: 1, Burst: 2},
+		Write:      Limit{Rate: 0.5, Burst: 1},
+		MaxClients: 10,
+	}
+
+	t.Run("allows up to the burst then refills", func(t *testing.T) {
+		limiter, now := newLimiter(cfg)
+
+		for i := 0; i < 2; i++ {
+			if !limiter.Allow("client", ClassRead).Allowed {
+				t.Fatalf("expected request %d to be allowed", i)
+			}
+		}
+
+		result := limiter.Allow("client", ClassRead)
+		if result.Allowed {
+			t.Fatalf("expected the request to be rejected")
+		}
+		if result.RetryAfter != time.Second {
+			t.Errorf("expected to retry after %v, got %v", time.Second, result.RetryAfter)
+		}
+
+		*now = now.Add(time.Second)
+
+		if !limiter.Allow("client", ClassRead).Allowed {
+			t.Fatalf("expected the request to be allowed after the refill")
+		}
+	})
+
+	t.Run("reads and writes have separate buckets", func(t *testing.T) {
+		limiter, _ := newLimiter(cfg)
+
+		if !limiter.Allow("client", ClassWrite).Allowed {
+			t.Fatalf("expected the write to be allowed")
+		}
+		if limiter.Allow("client", ClassWrite).Allowed {
+			t.Fatalf("expected the second write to be rejected")
+		}
+		if !limiter.Allow("client", ClassRead).Allowed {
+			t.Fatalf("expected the read to be allowed")
+		}
+	})
+
+	t.Run("clients have separate buckets", func(t *testing.T) {
+		limiter, _ := newLimiter(cfg)
+
+		limiter.Allow("a", ClassWrite)
+
+		if !limiter.Allow("b", ClassWrite).Allowed {
+			t.Fatalf("expected another client to be allowed")
+		}
+	})
+
+	t.Run("remaining and reset", func(t *testing.T) {
+		limiter, _ := newLimiter(cfg)
+
+		result := limiter.Allow("client", ClassRead)
+
+		if result.Limit != 2 || result.Remaining != 1 || result.Reset != time.Second {
+			t.Errorf("expected limit 2, remaining 1 and reset 1s, got %+v", result)
+		}
+	})
+
+	t.Run("tracked clients are bounded", func(t *testing.T) {
+		limiter, _ := newLimiter(Config{Read: cfg.Read, Write: cfg.Write, MaxClients: 2})
+
+		limiter.Allow("a", ClassWrite)
+		limiter.Allow("b", ClassWrite)
+		limiter.Allow("a", ClassWrite)
+		limiter.Allow("c", ClassWrite)
+
+		if limiter.Clients() != 2 {
+			t.Fatalf("expected %d clients, got %d", 2, limiter.Clients())
+		}
+
+		// b was the least recently used so it starts over with a full bucket
+		if !limiter.Allow("b", ClassWrite).Allowed {
+			t.Fatalf("expected the evicted client to be allowed")
+		}
+	})
+
+	t.Run("keys per address are bounded", func(t *testing.T) {
+		limiter, _ := newLimiter(Config{Read: cfg.Read, Write: cfg.Write, MaxClients: 10, MaxKeysPerAddr: 2})
+
+		for _, key := range []string{"a", "b", "a"} {
+			if got := limiter.Client("ip", key); got != key {
+				t.Fatalf("expected the client to be %q, got %q", key, got)
+			}
+		}
+		if got := limiter.Client("ip", "c"); got != "ip" {
+			t.Fatalf("expected the client to be the address, got %q", got)
+		}
+		if got := limiter.Client("other", "c"); got != "c" {
+			t.Fatalf("expected the client to be %q, got %q", "c", got)
+		}
+	})
+}
@@ -0,0 +1,231 @@
+package replication
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"main/database"
+	"main/health"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var ErrNotSynced = errors.New("the follower has not copied the leader yet")
+
+// errResync tells the follower to start over from a snapshot.
+var errResync = errors.New("the follower has to start over from a snapshot")
+
+// retryInterval is how long a follower waits before reconnecting.
+const retryInterval = time.Second
+
+// Follower keeps a database in sync with a leader.
+type Follower struct {
+	db     *database.InMemoryDB
+	leader string
+	client *http.Client
+	// epoch and applied identify the last change applied, they are only
+	// written by Run.
+	epoch   string
+	applied atomic.Uint64
+	synced  atomic.Bool
+	// health is told the follower is not ready while it restores a
+	// snapshot, when set
+	health *health.Registry
+}
+
+type FollowerOption func(*Follower)
+
+// WithHealth marks the follower not ready in registry while it restores a
+// snapshot of the leader, under the name "replication_snapshot".
+func WithHealth(registry *health.Registry) FollowerOption {
+	return func(f *Follower) {
+		f.health = registry
+	}
+}
+
+// NewFollower returns a follower of the leader serving the replication
+// endpoints under the base URL leader.
+func NewFollower(db *database.InMemoryDB, leader string, opts ...FollowerOption) *Follower {
+	f := &Follower{
+		db:     db,
+		leader: strings.TrimSuffix(leader, "/"),
+		client: &http.Client{},
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	return f
+}
+
+// Run replicates the leader until ctx is done, reconnecting whenever the
+// stream breaks.
+func (f *Follower) Run(ctx context.Context) {
+	for {
+		err := f.follow(ctx)
+		if errors.Is(err, errResync) {
+			err = f.resync(ctx)
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			slog.Warn("replication interrupted", "leader", f.leader, "error", err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retryInterval):
+			}
+		}
+	}
+}
+
+// Applied returns the sequence number of the last change applied, as
+// numbered by the leader.
+func (f *Follower) Applied() uint64 {
+	return f.applied.Load()
+}
+
+// Check fails until the follower copied the leader once, so it only gets
+// traffic once it has the users.
+func (f *Follower) Check(context.Context) error {
+	if !f.synced.Load() {
+		return ErrNotSynced
+	}
+
+	return nil
+}
+
+func (f *Follower) resync(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.leader+SnapshotPath, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not fetch the snapshot: %s", res.Status)
+	}
+
+	if f.health != nil {
+		f.health.SetNotReady("replication_snapshot", "restoring a snapshot of the leader")
+		defer f.health.SetReady("replication_snapshot")
+	}
+
+	var s snapshot
+	if err := json.NewDecoder(res.Body).Decode(&s); err != nil {
+		return fmt.Errorf("could not decode the snapshot: %w", err)
+	}
+
+	f.db.Restore(ctx, s.Snapshot)
+	f.epoch = s.Epoch
+	f.applied.Store(s.Seq)
+	f.synced.Store(true)
+
+	slog.Info("restored a snapshot of the leader", "users", len(s.Users), "follows", len(s.Follows), "seq", s.Seq)
+
+	return nil
+}
+
+// follow applies the changes streamed by the leader until the stream ends.
+func (f *Follower) follow(ctx context.Context) error {
+	if f.epoch == "" {
+		return errResync
+	}
+
+	query := url.Values{
+		"epoch": {f.epoch},
+		"after": {strconv.FormatUint(f.applied.Load(), 10)},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.leader+ChangesPath+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusGone:
+		return errResync
+	default:
+		return fmt.Errorf("could not follow the changes: %s", res.Status)
+	}
+
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		// heartbeat
+		if len(line) == 0 {
+			continue
+		}
+
+		var change database.Change
+		if err := json.Unmarshal(line, &change); err != nil {
+			return fmt.Errorf("could not decode the change: %w", err)
+		}
+
+		if change.Seq != f.applied.Load()+1 {
+			return errResync
+		}
+
+		f.apply(ctx, change)
+		f.applied.Store(change.Seq)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return errors.New("the leader closed the stream")
+}
+
+// apply logs the changes it cannot apply rather than stopping replication,
+// e.g. when the follower has lower limits than its leader.
+func (f *Follower) apply(ctx context.Context, change database.Change) {
+	id := change.User.ID.String()
+
+	var err error
+	switch change.Type {
+	case database.ChangeCreated, database.ChangeUpdated:
+		_, _, err = f.db.Upsert(ctx, id, change.User.User)
+	case database.ChangeDeleted, database.ChangeEvicted:
+		// the leader applied its own delete policy already
+		_, err = f.db.ForceDelete(ctx, id)
+		if errors.Is(err, database.ErrUserDoesNotExist) {
+			err = nil
+		}
+	case database.ChangeFollowed:
+		_, err = f.db.Follow(ctx, change.Follow.Follower.String(), change.Follow.Followee.String())
+	case database.ChangeUnfollowed:
+		err = f.db.Unfollow(ctx, change.Follow.Follower.String(), change.Follow.Followee.String())
+		if errors.Is(err, database.ErrNotFollowing) {
+			err = nil
+		}
+	}
+
+	if err != nil {
+		slog.Error("could not apply the change", "seq", change.Seq, "type", change.Type, "id", id, "error", err)
+	}
+}
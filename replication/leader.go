@@ -0,0 +1,169 @@
+// Package replication copies the users of a leader to read-only followers.
+// The leader streams its ordered changes over HTTP, each follower applies
+// them to its own database and starts over from a snapshot when it falls
+// behind further than the change log of the leader goes.
+package replication
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"main/database"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	SnapshotPath = "/replication/snapshot"
+	ChangesPath  = "/replication/changes"
+)
+
+// heartbeatInterval keeps idle change streams from being closed by proxies.
+const heartbeatInterval = 15 * time.Second
+
+// snapshot is a database snapshot tagged with the epoch of the leader, the
+// changes of another epoch cannot be applied on top of it.
+type snapshot struct {
+	Epoch string `json:"epoch"`
+	database.Snapshot
+}
+
+// Leader serves the snapshots and change streams followers replicate from.
+type Leader struct {
+	db *database.InMemoryDB
+	// epoch changes with every process, so followers of a restarted leader
+	// know its sequence numbers started over.
+	epoch string
+	mux   *http.ServeMux
+	// shutdown ends the change streams once closed
+	shutdown <-chan struct{}
+}
+
+type LeaderOption func(*Leader)
+
+// WithShutdown ends the change streams once done is closed, followers then
+// resume from where they stopped. The streams never end on their own, so
+// http.Server.Shutdown would wait for them until its deadline, see
+// http.Server.RegisterOnShutdown.
+func WithShutdown(done <-chan struct{}) LeaderOption {
+	return func(l *Leader) {
+		l.shutdown = done
+	}
+}
+
+// NewLeader returns the replication endpoints of db, which keeps as many
+// changes as followers can catch up on without a snapshot, see
+// database.WithChangeLog.
+func NewLeader(db *database.InMemoryDB, opts ...LeaderOption) *Leader {
+	l := &Leader{
+		db:    db,
+		epoch: uuid.NewString(),
+		mux:   http.NewServeMux(),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	l.mux.HandleFunc("GET "+SnapshotPath, l.handleSnapshot)
+	l.mux.HandleFunc("GET "+ChangesPath, l.handleChanges)
+
+	return l
+}
+
+func (l *Leader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.mux.ServeHTTP(w, r)
+}
+
+func (l *Leader) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	err := json.NewEncoder(w).Encode(snapshot{
+		Epoch:    l.epoch,
+		Snapshot: l.db.Snapshot(r.Context()),
+	})
+	if err != nil {
+		slog.Error("could not write the snapshot", "error", err)
+	}
+}
+
+// handleChanges streams the changes following ?after= as newline-delimited
+// JSON, answering 410 when the follower has to start over from a snapshot.
+func (l *Leader) handleChanges(w http.ResponseWriter, r *http.Request) {
+	after, err := strconv.ParseUint(r.URL.Query().Get("after"), 10, 64)
+	if err != nil {
+		http.Error(w, "please provide the sequence number of the last applied change", http.StatusBadRequest)
+		return
+	}
+
+	// subscribe first so no change falls between the log and the stream
+	changes, cancel := l.db.Subscribe()
+	defer cancel()
+
+	backlog, err := l.db.ChangesSince(after)
+	if r.URL.Query().Get("epoch") != l.epoch || errors.Is(err, database.ErrChangesTruncated) {
+		http.Error(w, "please start over from a snapshot", http.StatusGone)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+
+	// the stream outlives the server write timeout by design
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && err != http.ErrNotSupported {
+		slog.Error("could not disable the write deadline", "error", err)
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	for _, change := range backlog {
+		if err := encoder.Encode(change); err != nil {
+			return
+		}
+		after = change.Seq
+	}
+	if err := rc.Flush(); err != nil {
+		slog.Error("could not flush the change stream", "error", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-l.shutdown:
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprintln(w); err != nil {
+				return
+			}
+		case change, ok := <-changes:
+			if !ok {
+				// the follower fell behind, it resumes from the log
+				return
+			}
+
+			// already sent from the log
+			if change.Seq <= after {
+				continue
+			}
+
+			if err := encoder.Encode(change); err != nil {
+				return
+			}
+			after = change.Seq
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
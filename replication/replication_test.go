@@ -0,0 +1,415 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"main/api"
+	"main/database"
+	"main/graphqlapi"
+	"main/health"
+	"main/rpcapi"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newUser(name string) database.User {
+	return database.User{
+		FirstName: name,
+		LastName:  "Doe",
+		Biography: "A simple guy who loves to write code and play games.",
+	}
+}
+
+func newLeader(t testing.TB, logSize int) (*database.InMemoryDB, *httptest.Server) {
+	t.Helper()
+
+	db := database.NewInMemoryDB(database.WithChangeLog(logSize))
+	server := httptest.NewServer(api.NewHandler(db,
+		api.WithRequestLogging(false),
+		api.WithReplication(NewLeader(db)),
+		api.WithGraphQL(graphqlapi.NewHandler(db)),
+		api.WithRPC(rpcapi.NewHandler(db)),
+	))
+	t.Cleanup(server.Close)
+
+	return db, server
+}
+
+// follow runs a follower of leader until the test ends.
+func follow(t testing.TB, leader *httptest.Server, opts ...FollowerOption) (*Follower, *database.InMemoryDB) {
+	t.Helper()
+
+	db := database.NewInMemoryDB()
+	follower := NewFollower(db, leader.URL, opts...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		follower.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return follower, db
+}
+
+func waitFor(t testing.TB, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitInSync waits until the follower applied every change of the leader
+// and checks they hold the same users.
+func waitInSync(t testing.TB, leader, follower *database.InMemoryDB, f *Follower) {
+	t.Helper()
+
+	waitFor(t, "the follower to catch up", func() bool {
+		return f.Applied() == leader.Stats().Changes
+	})
+
+	want := leader.Snapshot(context.Background())
+	got := follower.Snapshot(context.Background())
+	if len(got.Users) != len(want.Users) {
+		t.Fatalf("expected the follower to hold %d users, got %d", len(want.Users), len(got.Users))
+	}
+	for i := range want.Users {
+		if got.Users[i] != want.Users[i] {
+			t.Fatalf("expected user %d to be %+v, got %+v", i, want.Users[i], got.Users[i])
+		}
+	}
+	if len(got.Follows) != len(want.Follows) {
+		t.Fatalf("expected the follower to hold %d follows, got %d", len(want.Follows), len(got.Follows))
+	}
+	for i := range want.Follows {
+		if got.Follows[i] != want.Follows[i] {
+			t.Fatalf("expected follow %d to be %+v, got %+v", i, want.Follows[i], got.Follows[i])
+		}
+	}
+}
+
+func insert(t testing.TB, db *database.InMemoryDB, name string) database.DBUser {
+	t.Helper()
+
+	user, err := db.Insert(context.Background(), newUser(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+func TestReplication(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("replicate the writes of the leader", func(t *testing.T) {
+		leaderDB, leader := newLeader(t, 100)
+		john := insert(t, leaderDB, "John")
+
+		if err := NewFollower(database.NewInMemoryDB(), leader.URL).Check(ctx); !errors.Is(err, ErrNotSynced) {
+			t.Fatalf("expected a new follower to fail with %v, got %v", ErrNotSynced, err)
+		}
+
+		f, followerDB := follow(t, leader)
+		waitInSync(t, leaderDB, followerDB, f)
+		if err := f.Check(ctx); err != nil {
+			t.Fatalf("expected a synced follower to be ready, got %v", err)
+		}
+
+		jane := insert(t, leaderDB, "Jane")
+		if _, err := leaderDB.Update(ctx, john.ID.String(), newUser("Jack")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := leaderDB.Delete(ctx, jane.ID.String()); err != nil {
+			t.Fatal(err)
+		}
+		insert(t, leaderDB, "Joe")
+
+		waitInSync(t, leaderDB, followerDB, f)
+	})
+
+	t.Run("catch up from a snapshot after falling behind", func(t *testing.T) {
+		leaderDB, leader := newLeader(t, 2)
+		insert(t, leaderDB, "John")
+
+		followerDB := database.NewInMemoryDB()
+		f := NewFollower(followerDB, leader.URL)
+
+		run := func() (stop func()) {
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				f.Run(ctx)
+			}()
+
+			return func() {
+				cancel()
+				<-done
+			}
+		}
+
+		stop := run()
+		waitInSync(t, leaderDB, followerDB, f)
+		stop()
+
+		for _, name := range []string{"Jane", "Jack", "Joe", "Jim"} {
+			insert(t, leaderDB, name)
+		}
+
+		if _, err := leaderDB.ChangesSince(f.Applied()); !errors.Is(err, database.ErrChangesTruncated) {
+			t.Fatalf("expected the follower to be behind the log, got %v", err)
+		}
+
+		stop = run()
+		defer stop()
+		waitInSync(t, leaderDB, followerDB, f)
+	})
+
+	t.Run("replicate the follows of the leader", func(t *testing.T) {
+		leaderDB, leader := newLeader(t, 100)
+		john, jane := insert(t, leaderDB, "John"), insert(t, leaderDB, "Jane")
+		if _, err := leaderDB.Follow(ctx, john.ID.String(), jane.ID.String()); err != nil {
+			t.Fatal(err)
+		}
+
+		followerDB := database.NewInMemoryDB(database.WithDeletePolicy(database.DeleteRestrict))
+		f := NewFollower(followerDB, leader.URL)
+		runCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			f.Run(runCtx)
+		}()
+		defer func() {
+			cancel()
+			<-done
+		}()
+		waitInSync(t, leaderDB, followerDB, f)
+
+		jack := insert(t, leaderDB, "Jack")
+		leaderDB.Follow(ctx, jack.ID.String(), john.ID.String())
+		leaderDB.Follow(ctx, jane.ID.String(), jack.ID.String())
+		if err := leaderDB.Unfollow(ctx, john.ID.String(), jane.ID.String()); err != nil {
+			t.Fatal(err)
+		}
+		waitInSync(t, leaderDB, followerDB, f)
+
+		// the leader cascades, the follower only deletes unlinked users
+		if _, err := leaderDB.Delete(ctx, jack.ID.String()); err != nil {
+			t.Fatal(err)
+		}
+		waitInSync(t, leaderDB, followerDB, f)
+		if _, exists := followerDB.FindByID(ctx, jack.ID.String()); exists {
+			t.Fatalf("expected the follower to delete %s along with their follows", jack.ID)
+		}
+	})
+
+	t.Run("apply the deletions of the leader whatever the delete policy", func(t *testing.T) {
+		db := database.NewInMemoryDB(database.WithDeletePolicy(database.DeleteRestrict))
+		john := insert(t, db, "John")
+		jane := insert(t, db, "Jane")
+		if _, err := db.Follow(ctx, jane.ID.String(), john.ID.String()); err != nil {
+			t.Fatal(err)
+		}
+
+		// the leader cascades, or sends the deletion before the unfollow
+		NewFollower(db, "http://leader").apply(ctx, database.Change{Type: database.ChangeDeleted, User: john})
+
+		if _, exists := db.FindByID(ctx, john.ID.String()); exists {
+			t.Fatalf("expected the deletion to be applied")
+		}
+		if n := len(db.Snapshot(ctx).Follows); n != 0 {
+			t.Fatalf("expected the follows of the deleted user to go with it, got %d", n)
+		}
+	})
+
+	t.Run("forward writes sent to a follower", func(t *testing.T) {
+		leaderDB, leader := newLeader(t, 100)
+		f, followerDB := follow(t, leader)
+
+		leaderURL, _ := url.Parse(leader.URL)
+		server := httptest.NewServer(api.NewHandler(followerDB,
+			api.WithRequestLogging(false),
+			api.WithFollower(leaderURL, true),
+		))
+		defer server.Close()
+
+		res, err := http.Post(server.URL+"/api/users", "application/json",
+			strings.NewReader(`{"first_name":"John","last_name":"Doe","biography":"A simple guy who loves to write code and play games."}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusCreated {
+			t.Fatalf("expected the status to be 201, got %d", res.StatusCode)
+		}
+		if leaderDB.Count() != 1 {
+			t.Fatalf("expected the user to be created on the leader, got %d users", leaderDB.Count())
+		}
+
+		waitInSync(t, leaderDB, followerDB, f)
+	})
+
+	t.Run("reject writes sent to a follower", func(t *testing.T) {
+		_, leader := newLeader(t, 100)
+		_, followerDB := follow(t, leader)
+
+		leaderURL, _ := url.Parse(leader.URL)
+		server := httptest.NewServer(api.NewHandler(followerDB,
+			api.WithRequestLogging(false),
+			api.WithFollower(leaderURL, false),
+		))
+		defer server.Close()
+
+		res, err := http.Post(server.URL+"/api/users", "application/json", strings.NewReader(`{}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusMisdirectedRequest {
+			t.Fatalf("expected the status to be 421, got %d", res.StatusCode)
+		}
+
+		res, err = http.Get(server.URL + "/api/users")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("expected reads to be served, got %d", res.StatusCode)
+		}
+	})
+	// newFollowerServer serves followerDB as a follower of leader, GraphQL
+	// and JSON-RPC included.
+	newFollowerServer := func(t *testing.T, followerDB *database.InMemoryDB, leader *httptest.Server, forward bool) *httptest.Server {
+		leaderURL, _ := url.Parse(leader.URL)
+		server := httptest.NewServer(api.NewHandler(followerDB,
+			api.WithRequestLogging(false),
+			api.WithFollower(leaderURL, forward),
+			api.WithGraphQL(graphqlapi.NewHandler(followerDB, graphqlapi.WithFollower(leaderURL, forward))),
+			api.WithRPC(rpcapi.NewHandler(followerDB, rpcapi.WithFollower(leaderURL, forward))),
+		))
+		t.Cleanup(server.Close)
+
+		return server
+	}
+
+	post := func(t *testing.T, url string, body string) string {
+		t.Helper()
+
+		res, err := http.Post(url, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		var data json.RawMessage
+		if err := json.NewDecoder(res.Body).Decode(&data); err != nil {
+			t.Fatal(err)
+		}
+
+		return string(data)
+	}
+
+	const (
+		graphqlMutation = `{"query":"mutation { createUser(user: {firstName: \"John\", lastName: \"Doe\", biography: \"A simple guy who loves to write code and play games.\"}) { id } }"}`
+		rpcCreate       = `{"jsonrpc":"2.0","id":1,"method":"users.create","params":{"user":{"first_name":"John","last_name":"Doe","biography":"A simple guy who loves to write code and play games."}}}`
+	)
+
+	t.Run("report not ready while restoring a snapshot", func(t *testing.T) {
+		release := make(chan struct{})
+		leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != SnapshotPath {
+				http.NotFound(w, r)
+				return
+			}
+
+			// the follower restores the snapshot once it is fully sent
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+			json.NewEncoder(w).Encode(snapshot{Epoch: "epoch"})
+		}))
+		t.Cleanup(leader.Close)
+
+		registry := health.NewRegistry(time.Second)
+		follow(t, leader, WithHealth(registry))
+
+		waitFor(t, "the follower to report the restore", func() bool {
+			return registry.Readiness(context.Background()).Components["replication_snapshot"].Status == health.StatusFailing
+		})
+		close(release)
+		waitFor(t, "the follower to be ready", func() bool {
+			return registry.Readiness(context.Background()).Status == health.StatusOK
+		})
+	})
+
+	t.Run("serve GraphQL and JSON-RPC reads on a follower", func(t *testing.T) {
+		leaderDB, leader := newLeader(t, 100)
+		f, followerDB := follow(t, leader)
+		server := newFollowerServer(t, followerDB, leader, false)
+
+		user := insert(t, leaderDB, "John")
+		waitInSync(t, leaderDB, followerDB, f)
+
+		got := post(t, server.URL+"/graphql", `{"query":"{ users { users { id } } }"}`)
+		if !strings.Contains(got, user.ID.String()) {
+			t.Fatalf("expected the GraphQL query to list %s, got %s", user.ID, got)
+		}
+
+		id, _ := json.Marshal(user.ID)
+		got = post(t, server.URL+"/rpc", `{"jsonrpc":"2.0","id":1,"method":"users.get","params":{"id":"`+user.ID.String()+`"}}`)
+		if !strings.Contains(got, string(id)) {
+			t.Fatalf("expected the JSON-RPC call to get %s, got %s", user.ID, got)
+		}
+
+		if got := post(t, server.URL+"/graphql", graphqlMutation); !strings.Contains(got, api.ErrReadOnlyFollower.Error()) {
+			t.Fatalf("expected the mutation to be rejected, got %s", got)
+		}
+		if got := post(t, server.URL+"/rpc", rpcCreate); !strings.Contains(got, api.ErrReadOnlyFollower.Error()) {
+			t.Fatalf("expected the call to be rejected, got %s", got)
+		}
+		if leaderDB.Count() != 1 || followerDB.Count() != 1 {
+			t.Fatalf("expected no user to be created, got %d and %d", leaderDB.Count(), followerDB.Count())
+		}
+	})
+
+	t.Run("forward GraphQL and JSON-RPC writes sent to a follower", func(t *testing.T) {
+		leaderDB, leader := newLeader(t, 100)
+		f, followerDB := follow(t, leader)
+		server := newFollowerServer(t, followerDB, leader, true)
+
+		if got := post(t, server.URL+"/graphql", graphqlMutation); strings.Contains(got, "errors") {
+			t.Fatalf("expected the mutation to be forwarded, got %s", got)
+		}
+		if got := post(t, server.URL+"/rpc", rpcCreate); strings.Contains(got, "error") {
+			t.Fatalf("expected the call to be forwarded, got %s", got)
+		}
+		if leaderDB.Count() != 2 {
+			t.Fatalf("expected the users to be created on the leader, got %d users", leaderDB.Count())
+		}
+
+		waitInSync(t, leaderDB, followerDB, f)
+	})
+}
@@ -0,0 +1,329 @@
+// Package rpcapi serves the users over JSON-RPC 2.0, next to the REST API of
+// package api and backed by the same database.
+package rpcapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"main/api"
+	"main/database"
+	"net/http"
+	"net/url"
+)
+
+const (
+	// maxBodySize bounds the request body, batches included.
+	maxBodySize = 1 << 20
+	// maxBatchSize bounds how many calls a single request can carry.
+	maxBatchSize = 100
+)
+
+// Error codes defined by the specification, the server errors use the
+// range it reserves for implementations.
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternalError  = -32603
+
+	codeUserNotFound      = -32001
+	codeUserAlreadyExists = -32002
+	codeStorageFull       = -32003
+	codeUserHasFollows    = -32004
+	codeUnavailable       = -32005
+	codeReadOnlyFollower  = -32006
+)
+
+// writeMethods change the users, followers forward or reject them.
+var writeMethods = map[string]bool{
+	"users.create": true,
+	"users.update": true,
+	"users.delete": true,
+}
+
+// rpcError is a JSON-RPC error object.
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	// ID is nil for notifications, and the JSON null for requests with a
+	// null id, which are answered.
+	ID json.RawMessage `json:"id"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+var null = json.RawMessage("null")
+
+type method func(ctx context.Context, params json.RawMessage) (any, error)
+
+type handler struct {
+	methods map[string]method
+	// forward sends the requests with writes to the leader, on followers
+	// forwarding them
+	forward http.Handler
+}
+
+// NewHandler returns the JSON-RPC endpoint, which takes POSTed calls to
+// the users.create, users.get, users.list, users.update and users.delete
+// methods, alone or in batches.
+func NewHandler(db *database.InMemoryDB, opts ...Option) http.Handler {
+	o := options{writer: db}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	h := &handler{methods: methods(db, o.writer)}
+	if o.reader != nil {
+		for _, name := range []string{"users.get", "users.list"} {
+			h.methods[name] = synced(o.reader, h.methods[name])
+		}
+	}
+	if o.leader != nil {
+		if o.forward {
+			h.forward = api.LeaderProxy(o.leader)
+		}
+		// forwarded requests never reach them
+		for name := range writeMethods {
+			h.methods[name] = func(context.Context, json.RawMessage) (any, error) {
+				return nil, &rpcError{Code: codeReadOnlyFollower, Message: api.ErrReadOnlyFollower.Error()}
+			}
+		}
+	}
+
+	return h
+}
+
+type Option func(*options)
+
+type options struct {
+	writer  database.Writer
+	reader  database.Reader
+	leader  *url.URL
+	forward bool
+}
+
+// WithFollower makes the handler serve a read-only follower of leader.
+// Reads run against the local database, while requests calling
+// users.create, users.update or users.delete are proxied to leader when
+// forward is true, and those calls fail otherwise.
+func WithFollower(leader *url.URL, forward bool) Option {
+	return func(o *options) {
+		o.leader = leader
+		o.forward = forward
+	}
+}
+
+// WithReader syncs reader before users.get and users.list, e.g. a cluster
+// node making the reads of its database linearizable.
+func WithReader(reader database.Reader) Option {
+	return func(o *options) {
+		o.reader = reader
+	}
+}
+
+// WithWriter makes the methods change the users through writer instead of
+// the database, e.g. a cluster node committing them to its cluster.
+func WithWriter(writer database.Writer) Option {
+	return func(o *options) {
+		o.writer = writer
+	}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	if err != nil || len(body) > maxBodySize {
+		send(w, response{
+			JSONRPC: "2.0",
+			Error:   &rpcError{Code: codeInvalidRequest, Message: fmt.Sprintf("please send at most %d bytes", maxBodySize)},
+			ID:      null,
+		})
+		return
+	}
+
+	if h.forward != nil && writes(body) {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		h.forward.ServeHTTP(w, r)
+		return
+	}
+
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '[' {
+		if resp, ok := h.call(r.Context(), body); ok {
+			send(w, resp)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+
+	var batch []json.RawMessage
+	if err := json.Unmarshal(body, &batch); err != nil {
+		send(w, response{JSONRPC: "2.0", Error: &rpcError{Code: codeParseError, Message: "parse error"}, ID: null})
+		return
+	}
+
+	if len(batch) == 0 || len(batch) > maxBatchSize {
+		send(w, response{
+			JSONRPC: "2.0",
+			Error:   &rpcError{Code: codeInvalidRequest, Message: fmt.Sprintf("please batch between 1 and %d calls", maxBatchSize)},
+			ID:      null,
+		})
+		return
+	}
+
+	responses := make([]response, 0, len(batch))
+	for _, raw := range batch {
+		if resp, ok := h.call(r.Context(), raw); ok {
+			responses = append(responses, resp)
+		}
+	}
+
+	// a batch of notifications gets no response at all
+	if len(responses) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	send(w, responses)
+}
+
+// synced syncs reader before running m.
+func synced(reader database.Reader, m method) method {
+	return func(ctx context.Context, params json.RawMessage) (any, error) {
+		err := reader.Sync(ctx)
+		if errors.Is(err, database.ErrUnavailable) {
+			return nil, &rpcError{Code: codeUnavailable, Message: api.ErrReadsUnavailable.Error()}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return m(ctx, params)
+	}
+}
+
+// writes reports whether body calls any of the writeMethods, alone or in a
+// batch. Bodies it cannot read are left to ServeHTTP to answer.
+func writes(body []byte) bool {
+	type call struct {
+		Method string `json:"method"`
+	}
+
+	var calls []call
+	if body = bytes.TrimSpace(body); len(body) > 0 && body[0] == '[' {
+		if err := json.Unmarshal(body, &calls); err != nil {
+			return false
+		}
+	} else {
+		calls = make([]call, 1)
+		if err := json.Unmarshal(body, &calls[0]); err != nil {
+			return false
+		}
+	}
+
+	for _, call := range calls {
+		if writeMethods[call.Method] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// call runs a single call, ok is false for notifications, which are never
+// answered, even when they fail.
+func (h *handler) call(ctx context.Context, raw json.RawMessage) (resp response, ok bool) {
+	resp = response{JSONRPC: "2.0", ID: null}
+
+	var req request
+	if err := json.Unmarshal(raw, &req); err != nil {
+		if json.Valid(raw) {
+			resp.Error = &rpcError{Code: codeInvalidRequest, Message: "invalid request"}
+		} else {
+			resp.Error = &rpcError{Code: codeParseError, Message: "parse error"}
+		}
+		return resp, true
+	}
+
+	if req.JSONRPC != "2.0" || req.Method == "" || !validID(req.ID) {
+		resp.Error = &rpcError{Code: codeInvalidRequest, Message: "invalid request"}
+		return resp, true
+	}
+
+	notification := req.ID == nil
+	if !notification {
+		resp.ID = req.ID
+	}
+
+	m, exists := h.methods[req.Method]
+	if !exists {
+		resp.Error = &rpcError{Code: codeMethodNotFound, Message: "method not found"}
+		return resp, !notification
+	}
+
+	result, err := m(ctx, req.Params)
+	if err != nil {
+		resp.Error = toError(err)
+	} else {
+		resp.Result = result
+	}
+
+	return resp, !notification
+}
+
+// validID accepts the strings, numbers and null the specification allows,
+// and a missing id for notifications.
+func validID(id json.RawMessage) bool {
+	if id == nil {
+		return true
+	}
+
+	var v any
+	if err := json.Unmarshal(id, &v); err != nil {
+		return false
+	}
+
+	switch v.(type) {
+	case nil, string, float64:
+		return true
+	default:
+		return false
+	}
+}
+
+// send always answers 200, failed calls are reported in the response.
+func send(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("could not write the JSON-RPC response", "error", err)
+	}
+}
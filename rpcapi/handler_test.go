@@ -0,0 +1,259 @@
+package rpcapi
+
+import (
+	"context"
+	"encoding/json"
+	"main/database"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const user = `{"first_name":"John","last_name":"Doe","biography":"A simple guy who loves to write code and play games."}`
+
+type result struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result"`
+	Error   *rpcError       `json:"error"`
+	ID      json.RawMessage `json:"id"`
+}
+
+func newServer(t testing.TB) (*httptest.Server, *database.InMemoryDB) {
+	t.Helper()
+
+	db := database.NewInMemoryDB()
+	server := httptest.NewServer(NewHandler(db))
+	t.Cleanup(server.Close)
+
+	return server, db
+}
+
+// post sends body and decodes the response into v, unless the server
+// answered 204.
+func post(t testing.TB, server *httptest.Server, body string, v any) int {
+	t.Helper()
+
+	res, err := http.Post(server.URL, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return res.StatusCode
+}
+
+func call(t testing.TB, server *httptest.Server, body string) result {
+	t.Helper()
+
+	var res result
+	if status := post(t, server, body, &res); status != http.StatusOK {
+		t.Fatalf("expected the status to be 200, got %d", status)
+	}
+	if res.JSONRPC != "2.0" {
+		t.Fatalf("expected a JSON-RPC 2.0 response, got %q", res.JSONRPC)
+	}
+
+	return res
+}
+
+func assertError(t testing.TB, want int, res result) {
+	t.Helper()
+
+	if res.Error == nil {
+		t.Fatalf("expected the error %d, got the result %s", want, res.Result)
+	}
+	if res.Error.Code != want {
+		t.Fatalf("expected the error %d, got %d (%s)", want, res.Error.Code, res.Error.Message)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	t.Run("create, get, update and delete a user", func(t *testing.T) {
+		server, db := newServer(t)
+
+		res := call(t, server, `{"jsonrpc":"2.0","method":"users.create","params":{"user":`+user+`},"id":1}`)
+		if res.Error != nil {
+			t.Fatalf("expected no error, got %+v", res.Error)
+		}
+		if string(res.ID) != "1" {
+			t.Fatalf("expected the id to be echoed, got %s", res.ID)
+		}
+
+		var created database.DBUser
+		if err := json.Unmarshal(res.Result, &created); err != nil {
+			t.Fatal(err)
+		}
+		id := created.ID.String()
+		if _, exists := db.FindByID(context.Background(), id); !exists {
+			t.Fatalf("expected %s to be stored", id)
+		}
+
+		res = call(t, server, `{"jsonrpc":"2.0","method":"users.get","params":{"id":"`+id+`"},"id":"get"}`)
+		if res.Error != nil || string(res.ID) != `"get"` {
+			t.Fatalf("expected the user with the id \"get\", got %+v", res)
+		}
+
+		updated := strings.Replace(user, "John", "Jane", 1)
+		res = call(t, server, `{"jsonrpc":"2.0","method":"users.update","params":{"id":"`+id+`","user":`+updated+`},"id":2}`)
+		var got database.DBUser
+		if err := json.Unmarshal(res.Result, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.User.FirstName != "Jane" {
+			t.Fatalf("expected the user to be updated, got %+v", got)
+		}
+
+		res = call(t, server, `{"jsonrpc":"2.0","method":"users.delete","params":{"id":"`+id+`"},"id":3}`)
+		if res.Error != nil {
+			t.Fatalf("expected no error, got %+v", res.Error)
+		}
+
+		res = call(t, server, `{"jsonrpc":"2.0","method":"users.get","params":{"id":"`+id+`"},"id":4}`)
+		assertError(t, codeUserNotFound, res)
+	})
+
+	t.Run("refuse deleting followed users when restricted", func(t *testing.T) {
+		ctx := context.Background()
+		db := database.NewInMemoryDB(database.WithDeletePolicy(database.DeleteRestrict))
+		server := httptest.NewServer(NewHandler(db))
+		defer server.Close()
+
+		john, _ := db.Insert(ctx, database.User{FirstName: "John"})
+		jane, _ := db.Insert(ctx, database.User{FirstName: "Jane"})
+		db.Follow(ctx, john.ID.String(), jane.ID.String())
+
+		res := call(t, server, `{"jsonrpc":"2.0","method":"users.delete","params":{"id":"`+jane.ID.String()+`"},"id":1}`)
+		assertError(t, codeUserHasFollows, res)
+	})
+
+	t.Run("fail reads while the reader cannot sync", func(t *testing.T) {
+		db := database.NewInMemoryDB()
+		server := httptest.NewServer(NewHandler(db, WithReader(unavailableReader{})))
+		defer server.Close()
+
+		res := call(t, server, `{"jsonrpc":"2.0","method":"users.list","id":1}`)
+		assertError(t, codeUnavailable, res)
+
+		res = call(t, server, `{"jsonrpc":"2.0","method":"users.create","params":{"user":`+user+`},"id":2}`)
+		if res.Error != nil {
+			t.Fatalf("expected writes to be served, got %s", res.Error.Message)
+		}
+	})
+
+	t.Run("list users page by page", func(t *testing.T) {
+		server, db := newServer(t)
+
+		for _, name := range []string{"John", "Jane", "John"} {
+			if _, err := db.Insert(context.Background(), database.User{FirstName: name, LastName: "Doe", Biography: "bio"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var page listResult
+		res := call(t, server, `{"jsonrpc":"2.0","method":"users.list","params":{"first_name":"john","limit":1},"id":1}`)
+		if err := json.Unmarshal(res.Result, &page); err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Users) != 1 || page.Next == "" {
+			t.Fatalf("expected 1 user and a cursor, got %+v", page)
+		}
+
+		res = call(t, server, `{"jsonrpc":"2.0","method":"users.list","params":{"first_name":"john","cursor":"`+page.Next+`"},"id":2}`)
+		page = listResult{}
+		if err := json.Unmarshal(res.Result, &page); err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Users) != 1 || page.Next != "" || page.Users[0].User.FirstName != "John" {
+			t.Fatalf("expected the last John and no cursor, got %+v", page)
+		}
+
+		res = call(t, server, `{"jsonrpc":"2.0","method":"users.list","id":3}`)
+		page = listResult{}
+		if err := json.Unmarshal(res.Result, &page); err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Users) != 3 {
+			t.Fatalf("expected every user without params, got %d", len(page.Users))
+		}
+	})
+
+	t.Run("report spec errors", func(t *testing.T) {
+		server, _ := newServer(t)
+
+		tests := []struct {
+			name string
+			body string
+			code int
+		}{
+			{"parse error", `{"jsonrpc":"2.0","method":`, codeParseError},
+			{"wrong version", `{"jsonrpc":"1.0","method":"users.list","id":1}`, codeInvalidRequest},
+			{"not an object", `1`, codeInvalidRequest},
+			{"object id", `{"jsonrpc":"2.0","method":"users.list","id":{}}`, codeInvalidRequest},
+			{"unknown method", `{"jsonrpc":"2.0","method":"users.purge","id":1}`, codeMethodNotFound},
+			{"positional params", `{"jsonrpc":"2.0","method":"users.get","params":["x"],"id":1}`, codeInvalidParams},
+			{"invalid id", `{"jsonrpc":"2.0","method":"users.get","params":{"id":"nope"},"id":1}`, codeInvalidParams},
+			{"invalid user", `{"jsonrpc":"2.0","method":"users.create","params":{"user":{"first_name":"J"}},"id":1}`, codeInvalidParams},
+			{"invalid cursor", `{"jsonrpc":"2.0","method":"users.list","params":{"cursor":"nope"},"id":1}`, codeInvalidParams},
+			{"empty batch", `[]`, codeInvalidRequest},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				assertError(t, tt.code, call(t, server, tt.body))
+			})
+		}
+	})
+
+	t.Run("answer batches without the notifications", func(t *testing.T) {
+		server, db := newServer(t)
+
+		var responses []result
+		status := post(t, server, `[
+			{"jsonrpc":"2.0","method":"users.create","params":{"user":`+user+`}},
+			{"jsonrpc":"2.0","method":"users.list","id":1},
+			{"jsonrpc":"2.0","method":"users.purge"},
+			{"foo":"bar"}
+		]`, &responses)
+		if status != http.StatusOK {
+			t.Fatalf("expected the status to be 200, got %d", status)
+		}
+
+		if len(responses) != 2 {
+			t.Fatalf("expected 2 responses, got %d", len(responses))
+		}
+		if responses[0].Error != nil || string(responses[0].ID) != "1" {
+			t.Fatalf("expected the list result first, got %+v", responses[0])
+		}
+		assertError(t, codeInvalidRequest, responses[1])
+		if string(responses[1].ID) != "null" {
+			t.Fatalf("expected a null id for the invalid request, got %s", responses[1].ID)
+		}
+
+		if n := db.Count(); n != 1 {
+			t.Fatalf("expected the notification to create a user, got %d users", n)
+		}
+	})
+
+	t.Run("send nothing back for notifications only", func(t *testing.T) {
+		server, _ := newServer(t)
+
+		status := post(t, server, `[{"jsonrpc":"2.0","method":"users.list"},{"jsonrpc":"2.0","method":"users.get"}]`, nil)
+		if status != http.StatusNoContent {
+			t.Fatalf("expected the status to be 204, got %d", status)
+		}
+	})
+}
+
+// unavailableReader never syncs, like a cluster node that is not the leader.
+type unavailableReader struct{}
+
+func (unavailableReader) Sync(context.Context) error {
+	return database.ErrUnavailable
+}
@@ -0,0 +1,180 @@
+package rpcapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"main/api"
+	"main/database"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// WithRequiredStructEnabled: opt-in to new behavior that will become the default behavior in v11+
+var validate = validator.New(validator.WithRequiredStructEnabled())
+
+// toError maps database errors to JSON-RPC errors, with the messages of
+// the REST API.
+func toError(err error) *rpcError {
+	var rpcErr *rpcError
+	switch {
+	case errors.As(err, &rpcErr):
+		return rpcErr
+	case errors.Is(err, database.ErrUserDoesNotExist):
+		return &rpcError{Code: codeUserNotFound, Message: api.ErrUserNotFound.Error()}
+	case errors.Is(err, database.ErrInvalidID):
+		return &rpcError{Code: codeInvalidParams, Message: api.ErrInvalidUserID.Error()}
+	case errors.Is(err, database.ErrUserAlreadyExists):
+		return &rpcError{Code: codeUserAlreadyExists, Message: err.Error()}
+	case errors.Is(err, database.ErrStorageFull):
+		return &rpcError{Code: codeStorageFull, Message: api.ErrInsufficientStorage.Error()}
+	case errors.Is(err, database.ErrInvalidCursor):
+		return &rpcError{Code: codeInvalidParams, Message: api.ErrInvalidPagination.Error()}
+	case errors.Is(err, database.ErrUserHasFollows):
+		return &rpcError{Code: codeUserHasFollows, Message: api.ErrUserHasFollows.Error()}
+	case errors.Is(err, database.ErrUnavailable):
+		return &rpcError{Code: codeUnavailable, Message: api.ErrWritesUnavailable.Error()}
+	default:
+		slog.Error("unexpected database error", "error", err)
+		return &rpcError{Code: codeInternalError, Message: "internal error"}
+	}
+}
+
+// decodeParams reads named params into v, rejecting positional ones.
+func decodeParams(params json.RawMessage, v any) error {
+	params = bytes.TrimSpace(params)
+	if len(params) == 0 || params[0] != '{' {
+		return &rpcError{Code: codeInvalidParams, Message: "please pass the params as an object"}
+	}
+
+	if err := json.Unmarshal(params, v); err != nil {
+		return &rpcError{Code: codeInvalidParams, Message: "invalid params"}
+	}
+
+	return nil
+}
+
+type idParams struct {
+	ID string `json:"id"`
+}
+
+type userParams struct {
+	ID   string        `json:"id"`
+	User database.User `json:"user"`
+}
+
+type listParams struct {
+	Cursor    string `json:"cursor"`
+	Limit     int    `json:"limit"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Query     string `json:"q"`
+}
+
+type listResult struct {
+	Users []database.DBUser `json:"users"`
+	Next  string            `json:"next,omitempty"`
+}
+
+func methods(db *database.InMemoryDB, writer database.Writer) map[string]method {
+	return map[string]method{
+		"users.create": func(ctx context.Context, raw json.RawMessage) (any, error) {
+			var params userParams
+			if err := decodeParams(raw, &params); err != nil {
+				return nil, err
+			}
+
+			if err := validateUser(ctx, params.User); err != nil {
+				return nil, err
+			}
+
+			return writer.Insert(ctx, params.User)
+		},
+		"users.get": func(ctx context.Context, raw json.RawMessage) (any, error) {
+			var params idParams
+			if err := decodeParams(raw, &params); err != nil {
+				return nil, err
+			}
+
+			if _, err := database.ParseID(params.ID); err != nil {
+				return nil, err
+			}
+
+			user, exists := db.FindByID(ctx, params.ID)
+			if !exists {
+				return nil, database.ErrUserDoesNotExist
+			}
+
+			return user, nil
+		},
+		"users.list": func(ctx context.Context, raw json.RawMessage) (any, error) {
+			var params listParams
+			// every param is optional
+			if raw != nil {
+				if err := decodeParams(raw, &params); err != nil {
+					return nil, err
+				}
+			}
+
+			if params.Limit < 0 {
+				return nil, &rpcError{Code: codeInvalidParams, Message: api.ErrInvalidPagination.Error()}
+			}
+
+			filter := database.UserFilter{
+				FirstName: params.FirstName,
+				LastName:  params.LastName,
+				Contains:  params.Query,
+			}
+
+			page, err := db.List(ctx, database.ListOptions{
+				Cursor: params.Cursor,
+				Limit:  params.Limit,
+				Filter: filter.Match,
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			return listResult{Users: page.Users, Next: page.Next}, nil
+		},
+		"users.update": func(ctx context.Context, raw json.RawMessage) (any, error) {
+			var params userParams
+			if err := decodeParams(raw, &params); err != nil {
+				return nil, err
+			}
+
+			if _, err := database.ParseID(params.ID); err != nil {
+				return nil, err
+			}
+
+			if err := validateUser(ctx, params.User); err != nil {
+				return nil, err
+			}
+
+			return writer.Update(ctx, params.ID, params.User)
+		},
+		"users.delete": func(ctx context.Context, raw json.RawMessage) (any, error) {
+			var params idParams
+			if err := decodeParams(raw, &params); err != nil {
+				return nil, err
+			}
+
+			if _, err := database.ParseID(params.ID); err != nil {
+				return nil, err
+			}
+
+			return writer.Delete(ctx, params.ID)
+		},
+	}
+}
+
+// validateUser applies the rules of the REST API.
+func validateUser(ctx context.Context, user database.User) error {
+	if err := validate.StructCtx(ctx, &user); err != nil {
+		return &rpcError{Code: codeInvalidParams, Message: api.ErrInvalidUserParams.Error()}
+	}
+
+	return nil
+}
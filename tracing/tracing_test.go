@@ -0,0 +1,91 @@
+package tracing
+
+import (
+	"context"
+	"main/database"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestMiddleware(t *testing.T) {
+	db := database.NewInMemoryDB()
+	user, err := db.Insert(context.Background(), database.User{FirstName: "John", LastName: "Doe"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	if _, err := Setup(Config{Exporter: ExporterNone}); err != nil {
+		t.Fatal(err)
+	}
+
+	router := chi.NewRouter()
+	router.Use(middleware.RequestID)
+	router.Use(Middleware)
+	router.Get("/api/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		db.FindByID(r.Context(), chi.URLParam(r, "id"))
+	})
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	req := httptest.NewRequest(http.MethodGet, "/api/users/"+user.ID.String(), nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	req.Header.Set(middleware.RequestIDHeader, "request-1")
+
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected %d spans, got %d", 2, len(spans))
+	}
+
+	dbSpan, serverSpan := spans[0], spans[1]
+
+	if serverSpan.Name() != "GET /api/users/{id}" {
+		t.Errorf("expected the span name to be %q, got %q", "GET /api/users/{id}", serverSpan.Name())
+	}
+
+	if serverSpan.SpanContext().TraceID().String() != traceID {
+		t.Errorf("expected the trace ID to be %q, got %q", traceID, serverSpan.SpanContext().TraceID())
+	}
+
+	if !hasAttribute(serverSpan.Attributes(), attribute.String("request.id", "request-1")) {
+		t.Errorf("expected the span to carry the request ID")
+	}
+
+	if dbSpan.Parent().SpanID() != serverSpan.SpanContext().SpanID() {
+		t.Errorf("expected the database span to be a child of the server span")
+	}
+
+	if len(dbSpan.Events()) != 1 || dbSpan.Events()[0].Name != "lock acquired" {
+		t.Errorf("expected the database span to record the lock wait")
+	}
+}
+
+func TestSetup(t *testing.T) {
+	t.Run("unknown exporter", func(t *testing.T) {
+		if _, err := Setup(Config{Exporter: "jaeger"}); err == nil {
+			t.Fatalf("expected an error for an unknown exporter")
+		}
+	})
+}
+
+func hasAttribute(attrs []attribute.KeyValue, want attribute.KeyValue) bool {
+	for _, attr := range attrs {
+		if attr == want {
+			return true
+		}
+	}
+
+	return false
+}